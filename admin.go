@@ -0,0 +1,521 @@
+// admin.go provides a JSON API for managing the users and routes of
+// an access file over HTTP.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// AdminAPI exposes the webaccess operations as a JSON API below
+// Prefix, for staff without shell access to the server. The prefix
+// must be protected by an access route and only the usernames in
+// Admins may use it.
+//
+//	GET    {prefix}users                      list usernames
+//	POST   {prefix}users                      add a user, {"username": ..., "password": ...}
+//	GET    {prefix}users/{username}           show a user, see UserInfo
+//	DELETE {prefix}users/{username}           remove a user
+//	PUT    {prefix}users/{username}/password  change a password, {"password": ...}
+//	POST   {prefix}users/{username}/rename    rename a user, {"username": ...}
+//	POST   {prefix}users/{username}/disable   disable a user
+//	POST   {prefix}users/{username}/enable    enable a user
+//	PUT    {prefix}users/{username}/expires   set or clear, {"expires_at": "2024-07-01"}
+//	POST   {prefix}prune                      remove the expired users
+//	POST   {prefix}users/{username}/grants    grant a route, "@group" or "*", {"route": ...}
+//	DELETE {prefix}users/{username}/grants/{route...}  revoke a route, "@group" or "*"
+//	GET    {prefix}routes                     list protected routes
+//	POST   {prefix}routes                     add a route, {"route": ...}
+//	DELETE {prefix}routes/{route...}          remove a route
+//	GET    {prefix}logins                     login counts by user and route, see LoginMetrics
+//	POST   {prefix}trace                      trace the next requests, see TraceFilter
+//	GET    {prefix}trace                      the traces captured, see TraceReport
+//	DELETE {prefix}trace                      stop tracing
+//	POST   {prefix}har                        start a HAR recording, see HARRecorder
+//	GET    {prefix}har                        the recording's state, see HARStatus
+//	DELETE {prefix}har                        stop recording, writing the HAR file
+//
+// Changes are saved to the access file, when there is one, and
+// recorded in the access audit log with the admin as the actor.
+// POST and PUT requests must send "Content-Type: application/json"
+// (so browsers preflight them) and changes from other sites (by
+// Sec-Fetch-Site or Origin) are refused, browsers resend cached
+// Basic credentials with cross-site requests.
+type AdminAPI struct {
+	// Prefix is the URL path prefix, e.g. "/admin/".
+	Prefix string `json:"prefix" toml:"prefix"`
+	// Admins lists the usernames allowed to use the API.
+	Admins []string `json:"admins" toml:"admins"`
+
+	// mu serializes changes so the access file is written in order.
+	mu sync.Mutex
+	// tracer is the WebService's request tracer, see Tracer.
+	tracer *Tracer
+	// har is the WebService's HARRecorder, if it has one.
+	har *HARRecorder
+}
+
+// AdminUser is the request body for adding a user or changing a
+// password.
+type AdminUser struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password"`
+}
+
+// AdminExpires is the request body for setting when a user
+// expires, see ParseExpires.
+type AdminExpires struct {
+	ExpiresAt string `json:"expires_at"`
+}
+
+// AdminRoute is the request body for adding a route.
+type AdminRoute struct {
+	Route string `json:"route"`
+}
+
+// isAdmin reports if username may use the API.
+func (adm *AdminAPI) isAdmin(username string) bool {
+	for _, admin := range adm.Admins {
+		if admin == username {
+			return true
+		}
+	}
+	return false
+}
+
+// crossSite reports if the request came from another site, by its
+// Sec-Fetch-Site header or, for older browsers, its Origin.
+func crossSite(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	case "":
+	default:
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+// jsonContent reports if the request body is declared as JSON.
+func jsonContent(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// readBody decodes the JSON request body into v.
+func readBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body, %s", err)
+	}
+	return nil
+}
+
+// save writes the access file, if any, and the audit event.
+func (adm *AdminAPI) save(a *Access, fName string, ev *AuditEvent) error {
+	if fName != "" {
+		if err := a.DumpAccess(fName); err != nil {
+			return err
+		}
+	}
+	a.audit(ev)
+	return nil
+}
+
+// adminEvent returns an audit event for a change made by the requesting
+// admin.
+func adminEvent(event string, username string, r *http.Request) *AuditEvent {
+	ev := NewAuditEvent(event, username, r)
+	ev.Actor = AuthenticatedUser(r)
+	ev.Detail = "admin api"
+	return ev
+}
+
+// routes returns the API's Router.
+func (adm *AdminAPI) routes(a *Access, fName string, prefix string) *Router {
+	rt := NewRouter()
+	rt.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		JSONError(w, r, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+	})
+	rt.Get(prefix+"users", func(w http.ResponseWriter, r *http.Request) {
+		usernames, err := a.store().List()
+		if err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		JSONResponse(w, r, http.StatusOK, map[string][]string{"users": usernames})
+	})
+	rt.Post(prefix+"users", func(w http.ResponseWriter, r *http.Request) {
+		u := new(AdminUser)
+		if err := readBody(w, r, u); err != nil {
+			JSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if u.Username == "" || u.Password == "" {
+			JSONError(w, r, http.StatusBadRequest, fmt.Errorf("username and password are required"))
+			return
+		}
+		adm.mu.Lock()
+		defer adm.mu.Unlock()
+		if _, err := a.store().Lookup(u.Username); err == nil {
+			JSONError(w, r, http.StatusConflict, fmt.Errorf("%q already exists", u.Username))
+			return
+		}
+		if err := a.UpdateUser(u.Username, u.Password); err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if err := adm.save(a, fName, adminEvent(AuditUserUpdate, u.Username, r)); err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		JSONResponse(w, r, http.StatusCreated, map[string]string{"username": u.Username})
+	})
+	rt.Get(prefix+"users/{username}", func(w http.ResponseWriter, r *http.Request) {
+		info, err := a.UserInfo(PathParam(r, "username"))
+		if err != nil {
+			JSONError(w, r, http.StatusNotFound, err)
+			return
+		}
+		JSONResponse(w, r, http.StatusOK, info)
+	})
+	rt.Delete(prefix+"users/{username}", func(w http.ResponseWriter, r *http.Request) {
+		username := PathParam(r, "username")
+		adm.mu.Lock()
+		defer adm.mu.Unlock()
+		if err := a.RemoveUser(username); err != nil {
+			JSONError(w, r, http.StatusNotFound, err)
+			return
+		}
+		if err := adm.save(a, fName, adminEvent(AuditUserRemove, username, r)); err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rt.Put(prefix+"users/{username}/password", func(w http.ResponseWriter, r *http.Request) {
+		username := PathParam(r, "username")
+		u := new(AdminUser)
+		if err := readBody(w, r, u); err != nil {
+			JSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if u.Password == "" {
+			JSONError(w, r, http.StatusBadRequest, fmt.Errorf("password is required"))
+			return
+		}
+		adm.mu.Lock()
+		defer adm.mu.Unlock()
+		if _, err := a.store().Lookup(username); err != nil {
+			JSONError(w, r, http.StatusNotFound, err)
+			return
+		}
+		if err := a.UpdateUser(username, u.Password); err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if err := adm.save(a, fName, adminEvent(AuditUserUpdate, username, r)); err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rt.Post(prefix+"users/{username}/rename", func(w http.ResponseWriter, r *http.Request) {
+		username := PathParam(r, "username")
+		u := new(AdminUser)
+		if err := readBody(w, r, u); err != nil {
+			JSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		adm.mu.Lock()
+		defer adm.mu.Unlock()
+		if err := a.RenameUser(username, u.Username); err != nil {
+			status := http.StatusConflict
+			if errors.Is(err, ErrUnknownUser) {
+				status = http.StatusNotFound
+			}
+			JSONError(w, r, status, err)
+			return
+		}
+		ev := adminEvent(AuditUserRename, u.Username, r)
+		ev.Detail = "renamed from " + username
+		if err := adm.save(a, fName, ev); err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		JSONResponse(w, r, http.StatusOK, map[string]string{"username": u.Username})
+	})
+	for _, action := range []string{"disable", "enable"} {
+		disabled, event := action == "disable", AuditUserEnable
+		if disabled {
+			event = AuditUserDisable
+		}
+		rt.Post(prefix+"users/{username}/"+action, func(w http.ResponseWriter, r *http.Request) {
+			username := PathParam(r, "username")
+			adm.mu.Lock()
+			defer adm.mu.Unlock()
+			if err := a.SetDisabled(username, disabled); err != nil {
+				JSONError(w, r, http.StatusNotFound, err)
+				return
+			}
+			if err := adm.save(a, fName, adminEvent(event, username, r)); err != nil {
+				JSONError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	rt.Put(prefix+"users/{username}/expires", func(w http.ResponseWriter, r *http.Request) {
+		username := PathParam(r, "username")
+		body := new(AdminExpires)
+		if err := readBody(w, r, body); err != nil {
+			JSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		expires, err := ParseExpires(body.ExpiresAt)
+		if err != nil {
+			JSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		adm.mu.Lock()
+		defer adm.mu.Unlock()
+		if err := a.SetExpires(username, expires); err != nil {
+			JSONError(w, r, http.StatusNotFound, err)
+			return
+		}
+		ev := adminEvent(AuditUserExpire, username, r)
+		ev.Detail = body.ExpiresAt
+		if err := adm.save(a, fName, ev); err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rt.Post(prefix+"prune", func(w http.ResponseWriter, r *http.Request) {
+		adm.mu.Lock()
+		defer adm.mu.Unlock()
+		removed, err := a.Prune()
+		for _, username := range removed {
+			ev := adminEvent(AuditUserRemove, username, r)
+			ev.Detail = "expired"
+			a.audit(ev)
+		}
+		if len(removed) > 0 && fName != "" {
+			if err := a.DumpAccess(fName); err != nil {
+				JSONError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		if err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		JSONResponse(w, r, http.StatusOK, map[string][]string{"removed": removed})
+	})
+	rt.Post(prefix+"users/{username}/grants", func(w http.ResponseWriter, r *http.Request) {
+		username := PathParam(r, "username")
+		route := new(AdminRoute)
+		if err := readBody(w, r, route); err != nil {
+			JSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		adm.mu.Lock()
+		defer adm.mu.Unlock()
+		if err := a.Grant(username, route.Route); err != nil {
+			JSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		ev := adminEvent(AuditGrant, username, r)
+		ev.Detail = route.Route
+		if err := adm.save(a, fName, ev); err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rt.Delete(prefix+"users/{username}/grants/{route...}", func(w http.ResponseWriter, r *http.Request) {
+		username, route := PathParam(r, "username"), PathParam(r, "route")
+		if strings.HasPrefix(route, "@") == false && route != AllRoutes {
+			route = "/" + route
+		}
+		adm.mu.Lock()
+		defer adm.mu.Unlock()
+		if err := a.Revoke(username, route); err != nil {
+			JSONError(w, r, http.StatusNotFound, err)
+			return
+		}
+		ev := adminEvent(AuditRevoke, username, r)
+		ev.Detail = route
+		if err := adm.save(a, fName, ev); err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rt.Get(prefix+"routes", func(w http.ResponseWriter, r *http.Request) {
+		JSONResponse(w, r, http.StatusOK, map[string][]string{"routes": a.ListRoutes()})
+	})
+	rt.Post(prefix+"routes", func(w http.ResponseWriter, r *http.Request) {
+		route := new(AdminRoute)
+		if err := readBody(w, r, route); err != nil {
+			JSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if strings.Trim(route.Route, "/") == "" {
+			JSONError(w, r, http.StatusBadRequest, fmt.Errorf("route is required"))
+			return
+		}
+		adm.mu.Lock()
+		defer adm.mu.Unlock()
+		if err := a.AddRoute(route.Route); err != nil {
+			JSONError(w, r, http.StatusConflict, err)
+			return
+		}
+		ev := adminEvent(AuditRouteAdd, "", r)
+		ev.Detail = route.Route
+		if err := adm.save(a, fName, ev); err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		JSONResponse(w, r, http.StatusCreated, map[string][]string{"routes": a.ListRoutes()})
+	})
+	rt.Delete(prefix+"routes/{route...}", func(w http.ResponseWriter, r *http.Request) {
+		route := PathParam(r, "route")
+		if strings.HasPrefix(prefix, "/"+strings.Trim(route, "/")+"/") {
+			JSONError(w, r, http.StatusConflict, fmt.Errorf("%q protects %s", route, prefix))
+			return
+		}
+		adm.mu.Lock()
+		defer adm.mu.Unlock()
+		if err := a.RemoveRoute(route); err != nil {
+			JSONError(w, r, http.StatusNotFound, err)
+			return
+		}
+		ev := adminEvent(AuditRouteRemove, "", r)
+		ev.Detail = "/" + route
+		if err := adm.save(a, fName, ev); err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	if adm.har != nil {
+		rt.Post(prefix+"har", func(w http.ResponseWriter, r *http.Request) {
+			adm.har.Start()
+			JSONResponse(w, r, http.StatusAccepted, adm.har.Status())
+		})
+		rt.Get(prefix+"har", func(w http.ResponseWriter, r *http.Request) {
+			JSONResponse(w, r, http.StatusOK, adm.har.Status())
+		})
+		rt.Delete(prefix+"har", func(w http.ResponseWriter, r *http.Request) {
+			if err := adm.har.Stop(); err != nil {
+				JSONError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	rt.Get(prefix+"logins", func(w http.ResponseWriter, r *http.Request) {
+		JSONResponse(w, r, http.StatusOK, a.LoginMetrics())
+	})
+	if adm.tracer != nil {
+		rt.Post(prefix+"trace", func(w http.ResponseWriter, r *http.Request) {
+			filter := new(TraceFilter)
+			if err := readBody(w, r, filter); err != nil {
+				JSONError(w, r, http.StatusBadRequest, err)
+				return
+			}
+			if err := adm.tracer.Start(filter); err != nil {
+				JSONError(w, r, http.StatusBadRequest, err)
+				return
+			}
+			JSONResponse(w, r, http.StatusAccepted, filter)
+		})
+		rt.Get(prefix+"trace", func(w http.ResponseWriter, r *http.Request) {
+			JSONResponse(w, r, http.StatusOK, adm.tracer.Report())
+		})
+		rt.Delete(prefix+"trace", func(w http.ResponseWriter, r *http.Request) {
+			adm.tracer.Stop()
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	return rt
+}
+
+// Handler returns the API's handler for a. Changes are saved to
+// fName when it isn't empty, otherwise they last until the server
+// restarts.
+func (adm *AdminAPI) Handler(a *Access, fName string) (http.Handler, error) {
+	if adm.Prefix == "" || len(adm.Admins) == 0 {
+		return nil, fmt.Errorf("admin requires a prefix and admins")
+	}
+	prefix := "/" + strings.Trim(adm.Prefix, "/") + "/"
+	if a == nil || a.isAccessRoute(prefix) == false {
+		return nil, fmt.Errorf("admin prefix %q must be protected by an access route", prefix)
+	}
+	rt := adm.routes(a, fName, prefix)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only the user the access route verified counts, not
+		// credentials sent along with a signed URL.
+		username := AuthenticatedUser(r)
+		if username == "" || a.isAccessRoute(r.URL.Path) == false || adm.isAdmin(username) == false {
+			JSONError(w, r, http.StatusForbidden, fmt.Errorf("%q is not an admin", username))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if crossSite(r) {
+				JSONError(w, r, http.StatusForbidden, fmt.Errorf("cross-site changes are not allowed"))
+				return
+			}
+			if (r.Method == http.MethodPost || r.Method == http.MethodPut) && jsonContent(r) == false {
+				JSONError(w, r, http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type must be application/json"))
+				return
+			}
+		}
+		rt.ServeHTTP(w, r)
+	}), nil
+}
+
+// accessFile returns the file a's users were loaded from, the
+// WebService's or a virtual host's access file.
+func (w *WebService) accessFile(a *Access) string {
+	for _, vh := range w.Hosts {
+		if vh.Access == a && vh.AccessFile != "" {
+			return vh.AccessFile
+		}
+	}
+	if a == w.Access {
+		return w.AccessFile
+	}
+	return ""
+}
@@ -0,0 +1,178 @@
+// admin_test.go tests the JSON API for managing users and routes.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminAPI(t *testing.T) {
+	dir := t.TempDir()
+	fName := filepath.Join(dir, "access.toml")
+	a := &Access{AuthType: "basic", AuthName: "test", Routes: []string{"/admin/"}, AuditLog: filepath.Join(dir, "audit.log")}
+	for _, username := range []string{"Jane.Doe", "Bob"} {
+		if err := a.UpdateUser(username, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	adm := &AdminAPI{Prefix: "/admin/", Admins: []string{"Jane.Doe"}}
+	h, err := adm.Handler(a, fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h = AccessHandler(h, a)
+
+	tests := []struct {
+		username, method, path, body string
+		status                       int
+		contains                     string
+	}{
+		{"Bob", "GET", "/admin/users", "", http.StatusForbidden, ""},
+		{"Jane.Doe", "GET", "/admin/users", "", http.StatusOK, `"Bob"`},
+		{"Jane.Doe", "POST", "/admin/users", `{"username": "Millie", "password": "s3cret"}`, http.StatusCreated, ""},
+		{"Jane.Doe", "POST", "/admin/users", `{"username": "Millie", "password": "again"}`, http.StatusConflict, ""},
+		{"Jane.Doe", "POST", "/admin/users", `{"username": "Millie"}`, http.StatusBadRequest, ""},
+		{"Jane.Doe", "PUT", "/admin/users/Bob/password", `{"password": "changed"}`, http.StatusNoContent, ""},
+		{"Jane.Doe", "PUT", "/admin/users/Nobody/password", `{"password": "changed"}`, http.StatusNotFound, ""},
+		{"Jane.Doe", "DELETE", "/admin/users/Millie", "", http.StatusNoContent, ""},
+		{"Jane.Doe", "POST", "/admin/users/Bob/disable", "", http.StatusNoContent, ""},
+		{"Jane.Doe", "POST", "/admin/users/Bob/enable", "", http.StatusNoContent, ""},
+		{"Jane.Doe", "POST", "/admin/routes", `{"route": "private"}`, http.StatusCreated, `"/private/"`},
+		{"Jane.Doe", "POST", "/admin/routes", `{"route": "/private/reports/"}`, http.StatusConflict, ""},
+		{"Jane.Doe", "DELETE", "/admin/routes/admin/", "", http.StatusConflict, ""},
+		{"Jane.Doe", "GET", "/admin/routes", "", http.StatusOK, `"/admin/"`},
+		{"Jane.Doe", "PATCH", "/admin/routes", "", http.StatusMethodNotAllowed, ""},
+		{"Jane.Doe", "GET", "/admin/logins", "", http.StatusOK, `"Bob"`},
+		{"Jane.Doe", "POST", "/admin/users/Bob/grants", `{"route": "@staff"}`, http.StatusNoContent, ""},
+		{"Jane.Doe", "DELETE", "/admin/users/Bob/grants/@staff", "", http.StatusNoContent, ""},
+		{"Jane.Doe", "GET", "/admin/users/Bob", "", http.StatusOK, `"restricted": true`},
+		{"Jane.Doe", "POST", "/admin/users/Bob/grants", `{"route": "*"}`, http.StatusNoContent, ""},
+		{"Jane.Doe", "DELETE", "/admin/users/Bob/grants/*", "", http.StatusNoContent, ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		req.SetBasicAuth(test.username, "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s %s as %s expected %d, got %d %s", test.method, test.path, test.username, test.status, rec.Code, rec.Body)
+		}
+		if strings.Contains(rec.Body.String(), test.contains) == false {
+			t.Errorf("%s %s expected %s in %s", test.method, test.path, test.contains, rec.Body)
+		}
+	}
+
+	// The changes were saved to the access file.
+	saved, err := LoadAccess(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saved.VerifyLogin("Bob", "changed"); err != nil {
+		t.Errorf("expected Bob's new password to be saved, %s", err)
+	}
+	if _, err := saved.Lookup("Millie"); err == nil {
+		t.Errorf("expected Millie to be removed")
+	}
+	if strings.Join(saved.Routes, " ") != "/admin/ /private/" {
+		t.Errorf("unexpected routes %q", saved.Routes)
+	}
+
+	// and audited with the admin as the actor.
+	fp, err := os.Open(a.AuditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	events, err := ReadAuditLog(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := 0
+	for _, ev := range events {
+		if ev.Actor == "Jane.Doe" {
+			changes++
+		}
+	}
+	if changes != 10 {
+		t.Errorf("expected 10 audited changes, got %d", changes)
+	}
+
+	// Forged cross-site changes are refused.
+	for _, test := range []struct {
+		method, path, contentType string
+		headers                   map[string]string
+		status                    int
+	}{
+		{"POST", "/admin/users", "text/plain", nil, http.StatusUnsupportedMediaType},
+		{"POST", "/admin/users/Bob/disable", "", nil, http.StatusUnsupportedMediaType},
+		{"POST", "/admin/users", "application/json", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"DELETE", "/admin/users/Bob", "", map[string]string{"Origin": "https://evil.example.com"}, http.StatusForbidden},
+		{"DELETE", "/admin/users/Bob", "", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"GET", "/admin/users", "", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+	} {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(`{"username": "Mallory", "password": "s3cret"}`))
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		req.SetBasicAuth("Jane.Doe", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s %s %+v expected %d, got %d", test.method, test.path, test.headers, test.status, rec.Code)
+		}
+	}
+	if _, err := a.Lookup("Mallory"); err == nil {
+		t.Errorf("expected no cross-site user")
+	}
+	// Same origin changes are allowed.
+	req := httptest.NewRequest("POST", "https://library.example.edu/admin/users/Bob/disable", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "https://library.example.edu")
+	req.SetBasicAuth("Jane.Doe", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected a same origin change, got %d %s", rec.Code, rec.Body)
+	}
+
+	// A signed URL skips the credential check, an unverified Basic
+	// auth username along with it isn't an admin.
+	req = httptest.NewRequest("GET", "/admin/users", nil)
+	req.SetBasicAuth("Jane.Doe", "wrong")
+	req = req.WithContext(context.WithValue(req.Context(), signedKey{}, true))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected an unverified admin refused, got %d", rec.Code)
+	}
+
+	// The prefix must be protected.
+	if _, err := adm.Handler(&Access{AuthType: "basic"}, ""); err == nil {
+		t.Errorf("expected an error for an unprotected prefix")
+	}
+}
@@ -0,0 +1,259 @@
+// assetcache.go keeps small, frequently requested files in memory and can
+// warm the cache at startup so the first requests after a deploy
+// aren't slow.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAssetCacheSeconds is how long a cached file is used
+	// before checking it hasn't changed.
+	DefaultAssetCacheSeconds = 10
+	// DefaultAssetCacheFileSize is the largest file cached.
+	DefaultAssetCacheFileSize = 1 << 20
+	// DefaultAssetCacheSize limits the memory used by the cache.
+	DefaultAssetCacheSize = 64 << 20
+)
+
+// AssetCache holds the content of files read from the document root
+// in memory. Warm lists paths (files or directories) read into the
+// cache at startup, WarmRecent adds that many of the most recently
+// modified files. Once MaxSize is reached no more files are added.
+type AssetCache struct {
+	Warm        []string `json:"warm,omitempty" toml:"warm,omitempty"`
+	WarmRecent  int      `json:"warm_recent,omitempty" toml:"warm_recent,omitempty"`
+	Seconds     int      `json:"seconds,omitempty" toml:"seconds,omitempty"`
+	MaxFileSize int64    `json:"max_file_size,omitempty" toml:"max_file_size,omitempty"`
+	MaxSize     int64    `json:"max_size,omitempty" toml:"max_size,omitempty"`
+}
+
+// cachedAsset is a file held in memory.
+type cachedAsset struct {
+	info    fs.FileInfo
+	data    []byte
+	checked time.Time
+}
+
+// assetFile is an http.File reading a cachedAsset.
+type assetFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+// Close is a no-op, the data is held in memory.
+func (f *assetFile) Close() error { return nil }
+
+// Stat returns the cached file's info.
+func (f *assetFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// Readdir fails, only files are cached.
+func (f *assetFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, fmt.Errorf("%s is not a directory", f.info.Name())
+}
+
+// assetFileSystem is an http.FileSystem caching files read from fs.
+type assetFileSystem struct {
+	fs          http.FileSystem
+	ttl         time.Duration
+	maxFileSize int64
+	maxSize     int64
+
+	mu     sync.Mutex
+	assets map[string]*cachedAsset
+	size   int64
+}
+
+// FileSystem returns an http.FileSystem caching the files read
+// from fs.
+func (ac *AssetCache) FileSystem(fs http.FileSystem) http.FileSystem {
+	afs := &assetFileSystem{
+		fs:          fs,
+		ttl:         time.Duration(ac.Seconds) * time.Second,
+		maxFileSize: ac.MaxFileSize,
+		maxSize:     ac.MaxSize,
+		assets:      map[string]*cachedAsset{},
+	}
+	if ac.Seconds <= 0 {
+		afs.ttl = DefaultAssetCacheSeconds * time.Second
+	}
+	if ac.MaxFileSize <= 0 {
+		afs.maxFileSize = DefaultAssetCacheFileSize
+	}
+	if ac.MaxSize <= 0 {
+		afs.maxSize = DefaultAssetCacheSize
+	}
+	return afs
+}
+
+// cached returns a fresh cached asset for name.
+func (afs *assetFileSystem) cached(name string) (*cachedAsset, bool) {
+	afs.mu.Lock()
+	a, ok := afs.assets[name]
+	afs.mu.Unlock()
+	if ok == false {
+		return nil, false
+	}
+	if time.Since(a.checked) < afs.ttl {
+		return a, true
+	}
+	// Revalidate, a stat is cheaper than reading the file again.
+	f, err := afs.fs.Open(name)
+	if err == nil {
+		info, err := f.Stat()
+		f.Close()
+		if err == nil && info.Size() == a.info.Size() && info.ModTime().Equal(a.info.ModTime()) {
+			afs.mu.Lock()
+			a.checked = time.Now()
+			afs.mu.Unlock()
+			return a, true
+		}
+	}
+	afs.mu.Lock()
+	if afs.assets[name] == a {
+		delete(afs.assets, name)
+		afs.size -= int64(len(a.data))
+	}
+	afs.mu.Unlock()
+	return nil, false
+}
+
+// Open returns the cached file, reading small files into the cache.
+func (afs *assetFileSystem) Open(name string) (http.File, error) {
+	name = path.Clean("/" + name)
+	if a, ok := afs.cached(name); ok {
+		return &assetFile{Reader: bytes.NewReader(a.data), info: a.info}, nil
+	}
+	f, err := afs.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.IsDir() || info.Size() > afs.maxFileSize {
+		return f, nil
+	}
+	afs.mu.Lock()
+	full := afs.size+info.Size() > afs.maxSize
+	afs.mu.Unlock()
+	if full {
+		return f, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(f, afs.maxFileSize+1))
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != info.Size() {
+		// Changed while reading, serve it without caching.
+		return &assetFile{Reader: bytes.NewReader(data), info: info}, nil
+	}
+	afs.mu.Lock()
+	if old, ok := afs.assets[name]; ok {
+		afs.size -= int64(len(old.data))
+	}
+	afs.assets[name] = &cachedAsset{info: info, data: data, checked: time.Now()}
+	afs.size += int64(len(data))
+	afs.mu.Unlock()
+	return &assetFile{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+// walkFiles calls fn for each file below p.
+func walkFiles(fs http.FileSystem, p string, fn func(p string, info fs.FileInfo)) {
+	f, err := fs.Open(p)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+	if info.IsDir() == false {
+		fn(p, info)
+		return
+	}
+	entries, _ := f.Readdir(-1)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") == false {
+			walkFiles(fs, path.Join(p, entry.Name()), fn)
+		}
+	}
+}
+
+// warm reads the Warm paths and WarmRecent files into the cache
+// returning the number of files read.
+func (ac *AssetCache) warm(cache http.FileSystem) int {
+	afs, ok := cache.(*assetFileSystem)
+	if ok == false {
+		return 0
+	}
+	names := []string{}
+	for _, p := range ac.Warm {
+		walkFiles(afs.fs, path.Clean("/"+p), func(p string, info fs.FileInfo) {
+			names = append(names, p)
+		})
+	}
+	if ac.WarmRecent > 0 {
+		type recent struct {
+			name    string
+			modTime time.Time
+		}
+		files := []recent{}
+		walkFiles(afs.fs, "/", func(p string, info fs.FileInfo) {
+			if info.Size() <= afs.maxFileSize {
+				files = append(files, recent{p, info.ModTime()})
+			}
+		})
+		sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+		for i := 0; i < len(files) && i < ac.WarmRecent; i++ {
+			names = append(names, files[i].name)
+		}
+	}
+	for _, name := range names {
+		if f, err := afs.Open(name); err == nil {
+			f.Close()
+		}
+	}
+	afs.mu.Lock()
+	defer afs.mu.Unlock()
+	return len(afs.assets)
+}
+
+// WarmUp reads the Warm paths and WarmRecent files into cache, a
+// FileSystem returned by AssetCache.FileSystem, logging how long
+// it took.
+func (ac *AssetCache) WarmUp(cache http.FileSystem) {
+	if len(ac.Warm) == 0 && ac.WarmRecent <= 0 {
+		return
+	}
+	start := time.Now()
+	n := ac.warm(cache)
+	log.Printf("Asset cache warmed with %d files in %s", n, time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,79 @@
+// assetcache_test.go tests the in memory asset cache.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAssetCache(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "css"), 0755)
+	write := func(name string, src string, modTime time.Time) {
+		fName := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.WriteFile(fName, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(fName, modTime, modTime)
+	}
+	old := time.Now().Add(-time.Hour)
+	write("index.html", "home", old)
+	write("css/site.css", "body {}", old)
+	write("big.bin", "0123456789", old)
+	write("news.html", "news", time.Now())
+
+	ac := &AssetCache{Warm: []string{"/css/"}, WarmRecent: 1, MaxFileSize: 8}
+	cache := ac.FileSystem(http.Dir(root))
+	if n := ac.warm(cache); n != 2 {
+		t.Errorf("expected css/site.css and news.html warmed, got %d files", n)
+	}
+	read := func(name string) string {
+		f, err := cache.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		src, _ := io.ReadAll(f)
+		return string(src)
+	}
+	// Cached files are served from memory until they are checked.
+	os.Remove(filepath.Join(root, "css", "site.css"))
+	if s := read("/css/site.css"); s != "body {}" {
+		t.Errorf("expected cached css, got %q", s)
+	}
+	if s := read("/big.bin"); s != "0123456789" {
+		t.Errorf("expected large file read from disk, got %q", s)
+	}
+	if _, ok := cache.(*assetFileSystem).assets["/big.bin"]; ok {
+		t.Errorf("expected large file not to be cached")
+	}
+	// Changes are seen once the entry is stale.
+	cache.(*assetFileSystem).ttl = 0
+	write("news.html", "more news", time.Now().Add(time.Second))
+	if s := read("/news.html"); s != "more news" {
+		t.Errorf("expected changed file, got %q", s)
+	}
+	if _, err := cache.Open("/css/site.css"); err == nil {
+		t.Errorf("expected removed file to be dropped from the cache")
+	}
+}
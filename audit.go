@@ -0,0 +1,124 @@
+// audit.go records authentication and administrative events to an
+// append only JSON lines audit log.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Audit event names.
+const (
+	AuditLogin        = "login"
+	AuditLoginFailure = "login_failure"
+	AuditLogout       = "logout"
+	AuditUserUpdate   = "user_update"
+	AuditUserRemove   = "user_remove"
+	AuditUserDisable  = "user_disable"
+	AuditUserEnable   = "user_enable"
+	AuditUserExpire   = "user_expire"
+	AuditUserRename   = "user_rename"
+	AuditRehash       = "rehash"
+	AuditEncryption   = "set_encryption"
+	AuditReload       = "reload"
+	AuditGrant        = "grant"
+	AuditRevoke       = "revoke"
+	AuditRouteAdd     = "route_add"
+	AuditRouteRemove  = "route_remove"
+)
+
+// AuditEvent is one entry in the audit log. Entries are written one
+// JSON object per line.
+type AuditEvent struct {
+	// Time the event happened, set by Audit if zero.
+	Time time.Time `json:"time"`
+	// Event is the kind of event, e.g. AuditLogin.
+	Event string `json:"event"`
+	// Username the event applies to.
+	Username string `json:"username,omitempty"`
+	// Actor is who caused the event when not Username, e.g. the
+	// account running webaccess.
+	Actor string `json:"actor,omitempty"`
+	// IP is the client address for events from requests.
+	IP string `json:"ip,omitempty"`
+	// Path is the request path for events from requests.
+	Path string `json:"path,omitempty"`
+	// Detail holds any additional information.
+	Detail string `json:"detail,omitempty"`
+}
+
+// NewAuditEvent returns an AuditEvent for event, filling in IP and
+// Path from r if it isn't nil.
+func NewAuditEvent(event string, username string, r *http.Request) *AuditEvent {
+	ev := &AuditEvent{Time: time.Now().UTC(), Event: event, Username: username}
+	if r != nil {
+		ev.IP = clientIP(r)
+		ev.Path = r.URL.Path
+	}
+	return ev
+}
+
+// Audit appends ev to the AuditLog file. It does nothing when
+// AuditLog isn't set.
+func (a *Access) Audit(ev *AuditEvent) error {
+	a.mu.RLock()
+	fName := a.AuditLog
+	a.mu.RUnlock()
+	if fName == "" {
+		return nil
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	src, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return a.auditLog.write(fName, append(src, '\n'))
+}
+
+// audit is Audit logging rather than returning errors, used from
+// request handling.
+func (a *Access) audit(ev *AuditEvent) {
+	if err := a.Audit(ev); err != nil {
+		log.Printf("audit log, %s", err)
+	}
+}
+
+// ReadAuditLog reads the entries of an audit log.
+func ReadAuditLog(r io.Reader) ([]*AuditEvent, error) {
+	events := []*AuditEvent{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		ev := new(AuditEvent)
+		if err := json.Unmarshal(scanner.Bytes(), ev); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
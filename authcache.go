@@ -0,0 +1,179 @@
+// authcache.go remembers recently verified Basic auth credentials so the
+// password hash (e.g. argon2id) runs once per session instead of on
+// every request.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSessionSeconds is how long verified credentials are
+	// remembered when Access.SessionSeconds isn't set.
+	DefaultSessionSeconds = 300
+	// maxAuthCacheEntries bounds the memory used by the cache.
+	maxAuthCacheEntries = 10000
+)
+
+// authSession is a remembered login.
+type authSession struct {
+	username string
+	expires  time.Time
+}
+
+// authCache maps a keyed hash of username and password to the
+// session. The key is random per process so the digests can't be
+// used to recover or test passwords.
+type authCache struct {
+	mu       sync.Mutex
+	key      []byte
+	sessions map[[sha256.Size]byte]*authSession
+}
+
+// newAuthCache returns an empty cache with a fresh key.
+func newAuthCache() *authCache {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &authCache{key: key, sessions: map[[sha256.Size]byte]*authSession{}}
+}
+
+// digest computes the cache key for a set of credentials.
+func (c *authCache) digest(username string, password string) [sha256.Size]byte {
+	h := hmac.New(sha256.New, c.key)
+	h.Write([]byte(username))
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	var d [sha256.Size]byte
+	copy(d[:], h.Sum(nil))
+	return d
+}
+
+// valid reports if the credentials were verified and haven't expired.
+func (c *authCache) valid(username string, password string) bool {
+	d := c.digest(username, password)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.sessions[d]
+	if ok == false {
+		return false
+	}
+	if time.Now().After(s.expires) {
+		delete(c.sessions, d)
+		return false
+	}
+	return s.username == username
+}
+
+// remember records verified credentials for ttl.
+func (c *authCache) remember(username string, password string, ttl time.Duration) {
+	d := c.digest(username, password)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.sessions) >= maxAuthCacheEntries {
+		now := time.Now()
+		for k, s := range c.sessions {
+			if now.After(s.expires) {
+				delete(c.sessions, k)
+			}
+		}
+		if len(c.sessions) >= maxAuthCacheEntries {
+			c.sessions = map[[sha256.Size]byte]*authSession{}
+		}
+	}
+	c.sessions[d] = &authSession{username: username, expires: time.Now().Add(ttl)}
+}
+
+// forget drops every session for username, e.g. after a password change.
+func (c *authCache) forget(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, s := range c.sessions {
+		if s.username == username {
+			delete(c.sessions, k)
+		}
+	}
+}
+
+// sessionTTL returns how long verified credentials are remembered,
+// zero when caching is turned off.
+func (a *Access) sessionTTL() time.Duration {
+	switch {
+	case a.SessionSeconds < 0:
+		return 0
+	case a.SessionSeconds == 0:
+		return DefaultSessionSeconds * time.Second
+	default:
+		return time.Duration(a.SessionSeconds) * time.Second
+	}
+}
+
+// authenticate checks credentials using the session cache before
+// falling back to Login.
+func (a *Access) authenticate(username string, password string) bool {
+	ok, _ := a.verify(username, password)
+	return ok
+}
+
+// verify is authenticate also reporting if the credentials were
+// found in the session cache.
+func (a *Access) verify(username string, password string) (bool, bool) {
+	ttl := a.sessionTTL()
+	if ttl == 0 {
+		return a.Login(username, password), false
+	}
+	if a.sessions().valid(username, password) {
+		return true, true
+	}
+	if a.Login(username, password) == false {
+		return false, false
+	}
+	// Don't remember a login past the account's expiration.
+	if secret, err := a.store().Lookup(username); err == nil && secret.ExpiresAt != nil {
+		if d := time.Until(*secret.ExpiresAt); d < ttl {
+			ttl = d
+		}
+	}
+	a.sessions().remember(username, password, ttl)
+	return true, false
+}
+
+// sessions returns the session cache, creating it on first use.
+func (a *Access) sessions() *authCache {
+	a.cacheOnce.Do(func() {
+		a.cache = newAuthCache()
+	})
+	return a.cache
+}
+
+// ClearSessions forgets remembered logins for username, or for every
+// user when username is empty.
+func (a *Access) ClearSessions(username string) {
+	cache := a.sessions()
+	if username == "" {
+		cache.mu.Lock()
+		cache.sessions = map[[sha256.Size]byte]*authSession{}
+		cache.mu.Unlock()
+		return
+	}
+	cache.forget(username)
+}
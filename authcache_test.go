@@ -0,0 +1,74 @@
+// authcache_test.go test routines for authcache.go
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAuthCache(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "argon2id"}
+	if a.UpdateAccess("Jane.Doe", "secret") == false {
+		t.Fatal("failed to add user")
+	}
+	if a.authenticate("Jane.Doe", "secret") == false {
+		t.Fatal("expected login to succeed")
+	}
+	if a.authenticate("Jane.Doe", "wrong") {
+		t.Fatal("expected wrong password to fail")
+	}
+	// Swap the stored key behind the cache's back, the remembered
+	// session should still be honored until cleared.
+	a.Map["Jane.Doe"].Key = []byte("changed")
+	if a.authenticate("Jane.Doe", "secret") == false {
+		t.Errorf("expected cached session to be used")
+	}
+	a.ClearSessions("Jane.Doe")
+	if a.authenticate("Jane.Doe", "secret") {
+		t.Errorf("expected login to fail after sessions were cleared")
+	}
+
+	a.SessionSeconds = -1
+	a.UpdateAccess("Jane.Doe", "secret")
+	if a.authenticate("Jane.Doe", "secret") == false {
+		t.Errorf("expected login to succeed without caching")
+	}
+}
+
+func TestClearSessionsFirstLogin(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "md5"}
+	a.UpdateAccess("Jane.Doe", "secret")
+	// An admin change during the first logins doesn't race the
+	// cache being created.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		a.authenticate("Jane.Doe", "secret")
+	}()
+	go func() {
+		defer wg.Done()
+		a.ClearSessions("Jane.Doe")
+	}()
+	wg.Wait()
+	a.ClearSessions("")
+	if a.authenticate("Jane.Doe", "secret") == false {
+		t.Errorf("expected the login to succeed")
+	}
+}
@@ -0,0 +1,76 @@
+// authcmd.go checks passwords by running an external command, e.g. a
+// PAM or Kerberos helper, instead of the access file secrets.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultAuthCommandSeconds limits how long an AuthCommand may run
+// when AuthCommandSeconds isn't set.
+const DefaultAuthCommandSeconds = 10
+
+// validCommandUsername rejects usernames the command could mistake
+// for options or that hold control characters.
+func validCommandUsername(username string) bool {
+	if username == "" || strings.HasPrefix(username, "-") {
+		return false
+	}
+	for _, r := range username {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// runAuthCommand runs AuthCommand with username as its last argument
+// and password on standard input. An exit code of zero accepts the
+// login, any other exit code returns ErrBadPassword.
+func (a *Access) runAuthCommand(username string, password string) error {
+	a.mu.RLock()
+	command := append([]string{}, a.AuthCommand...)
+	seconds := a.AuthCommandSeconds
+	a.mu.RUnlock()
+	if validCommandUsername(username) == false {
+		return fmt.Errorf("%w %q", ErrUnknownUser, username)
+	}
+	if seconds <= 0 {
+		seconds = DefaultAuthCommandSeconds
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(seconds)*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], append(command[1:], username)...)
+	cmd.Stdin = strings.NewReader(password + "\n")
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		return ErrBadPassword
+	}
+	return fmt.Errorf("auth command %s, %s", command[0], err)
+}
@@ -0,0 +1,48 @@
+// authcmd_test.go tests the external authentication command.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestAuthCommand(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh available")
+	}
+	// The script sees the username as $1 and reads the password.
+	script := `read pw; [ "$1" = "Jane.Doe" ] && [ "$pw" = "secret" ]`
+	a := &Access{AuthType: "basic", AuthCommand: []string{sh, "-c", script, "check"}, SessionSeconds: -1}
+	if err := a.VerifyLogin("Jane.Doe", "secret"); err != nil {
+		t.Errorf("expected login, got %v", err)
+	}
+	if err := a.VerifyLogin("Jane.Doe", "wrong"); errors.Is(err, ErrBadPassword) == false {
+		t.Errorf("expected ErrBadPassword, got %v", err)
+	}
+	if err := a.VerifyLogin("-x", "secret"); errors.Is(err, ErrUnknownUser) == false {
+		t.Errorf("expected option like username to be refused, got %v", err)
+	}
+	a.AuthCommand = []string{sh, "-c", "sleep 5", "check"}
+	a.AuthCommandSeconds = 1
+	if err := a.VerifyLogin("Jane.Doe", "secret"); err == nil || errors.Is(err, ErrBadPassword) {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+// authlog.go logs authentication failures in a stable format
+// suitable for fail2ban.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuthFailureFormat is the layout of an authentication failure log
+// line. The fields are an RFC 3339 UTC timestamp, the client IP
+// address, the quoted username and the quoted request path, e.g.
+//
+//	2023-01-05T17:04:05Z wsfn: authentication failure; ip=192.0.2.7 user="Jane.Doe" path="/private/"
+//
+// A fail2ban filter matching it is
+//
+//	[Definition]
+//	failregex = ^\S+ wsfn: authentication failure; ip=<HOST> user=
+//	datepattern = ^%%Y-%%m-%%dT%%H:%%M:%%SZ
+const AuthFailureFormat = "%s wsfn: authentication failure; ip=%s user=%q path=%q\n"
+
+// appendLog appends lines to a file, reopening it if the file
+// name changes (e.g. after Reload).
+type appendLog struct {
+	mu sync.Mutex
+	fp *os.File
+}
+
+// write appends src to fName.
+func (l *appendLog) write(fName string, src []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fp == nil || l.fp.Name() != fName {
+		if l.fp != nil {
+			l.fp.Close()
+			l.fp = nil
+		}
+		fp, err := os.OpenFile(fName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return err
+		}
+		l.fp = fp
+	}
+	_, err := l.fp.Write(src)
+	return err
+}
+
+// clientIP returns the IP address of the client making the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// logAuthFailure records a failed login for username. It is written
+// to FailureLog if set, otherwise to the standard log.
+func (a *Access) logAuthFailure(r *http.Request, username string) {
+	a.authLockout(r, username)
+	line := fmt.Sprintf(AuthFailureFormat, time.Now().UTC().Format(time.RFC3339), clientIP(r), username, r.URL.Path)
+	a.mu.RLock()
+	fName := a.FailureLog
+	a.mu.RUnlock()
+	if fName == "" {
+		log.Print(line)
+		return
+	}
+	if err := a.failLog.write(fName, []byte(line)); err != nil {
+		log.Printf("can't write %s, %s", fName, err)
+		log.Print(line)
+	}
+}
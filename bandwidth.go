@@ -0,0 +1,196 @@
+// bandwidth.go limits how fast responses are sent per request or per
+// client so bulk downloads don't starve interactive traffic.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bandwidth limits the rate responses below Prefix are sent, in
+// bytes per second. PerRequest applies to each response, PerClient
+// is shared by all of a client address's responses below Prefix.
+type Bandwidth struct {
+	Prefix     string `json:"prefix" toml:"prefix"`
+	PerRequest int64  `json:"per_request,omitempty" toml:"per_request,omitempty"`
+	PerClient  int64  `json:"per_client,omitempty" toml:"per_client,omitempty"`
+
+	// once sets up clients, shared by handlers built from the
+	// Bandwidth so rebuilding them keeps the clients' limits.
+	once    sync.Once
+	clients *clientLimiters
+}
+
+// byteLimiter paces writes to an average rate, it may be shared by
+// several writers.
+type byteLimiter struct {
+	mu   sync.Mutex
+	rate int64
+	next time.Time
+}
+
+func newByteLimiter(bytesPerSecond int64) *byteLimiter {
+	return &byteLimiter{rate: bytesPerSecond}
+}
+
+// reserve books n bytes returning how long to wait before sending.
+func (l *byteLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	return wait
+}
+
+// clientLimiters holds the shared limiter of each client address
+// with responses in flight.
+type clientLimiters struct {
+	mu      sync.Mutex
+	rate    int64
+	clients map[string]*clientLimiter
+}
+
+type clientLimiter struct {
+	*byteLimiter
+	active int
+}
+
+// acquire returns the client's limiter, call release when done.
+func (cl *clientLimiters) acquire(ip string) *byteLimiter {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	c, ok := cl.clients[ip]
+	if ok == false {
+		c = &clientLimiter{byteLimiter: newByteLimiter(cl.rate)}
+		cl.clients[ip] = c
+	}
+	c.active++
+	return c.byteLimiter
+}
+
+// release forgets the client's limiter once it is idle.
+func (cl *clientLimiters) release(ip string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if c, ok := cl.clients[ip]; ok {
+		if c.active--; c.active <= 0 {
+			delete(cl.clients, ip)
+		}
+	}
+}
+
+// throttleWriter limits the rate a response body is written.
+type throttleWriter struct {
+	*statusWriter
+	limiters []*byteLimiter
+	chunk    int
+}
+
+func newThrottleWriter(w http.ResponseWriter, limiters ...*byteLimiter) *throttleWriter {
+	// Write at most a tenth of a second's worth at a time.
+	chunk := 0
+	for _, l := range limiters {
+		if n := int(l.rate / 10); chunk == 0 || n < chunk {
+			chunk = n
+		}
+	}
+	if chunk < 1 {
+		chunk = 1
+	}
+	return &throttleWriter{statusWriter: newStatusWriter(w), limiters: limiters, chunk: chunk}
+}
+
+// Write sends p in chunks, waiting on each limiter so the average
+// rate stays within the limits.
+func (tw *throttleWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := tw.chunk
+		if n > len(p) {
+			n = len(p)
+		}
+		wait := time.Duration(0)
+		for _, l := range tw.limiters {
+			if d := l.reserve(n); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			tw.Flush()
+			time.Sleep(wait)
+		}
+		m, err := tw.statusWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// ReadFrom copies through Write so the limits apply.
+func (tw *throttleWriter) ReadFrom(src io.Reader) (int64, error) {
+	return copyPooled(tw, src)
+}
+
+// BandwidthHandler throttles responses below the first matching
+// Bandwidth's Prefix before calling next.
+func BandwidthHandler(limits []*Bandwidth, next http.Handler) (http.Handler, error) {
+	for _, b := range limits {
+		if b.Prefix == "" {
+			return nil, fmt.Errorf("bandwidth limits require a prefix")
+		}
+		if b.PerRequest < 0 || b.PerClient < 0 || (b.PerRequest == 0 && b.PerClient == 0) {
+			return nil, fmt.Errorf("bandwidth %q requires a positive per_request or per_client", b.Prefix)
+		}
+		b.once.Do(func() {
+			if b.PerClient > 0 {
+				b.clients = &clientLimiters{rate: b.PerClient, clients: map[string]*clientLimiter{}}
+			}
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, b := range limits {
+			if strings.HasPrefix(r.URL.Path, b.Prefix) == false {
+				continue
+			}
+			limiters := []*byteLimiter{}
+			if b.PerRequest > 0 {
+				limiters = append(limiters, newByteLimiter(b.PerRequest))
+			}
+			if b.clients != nil {
+				ip := clientIP(r)
+				limiters = append(limiters, b.clients.acquire(ip))
+				defer b.clients.release(ip)
+			}
+			next.ServeHTTP(newThrottleWriter(w, limiters...), r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,83 @@
+// bandwidth_test.go tests per request and per client throttling.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBandwidthHandler(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 1000)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	})
+	h, err := BandwidthHandler([]*Bandwidth{
+		{Prefix: "/request/", PerRequest: 4000},
+		{Prefix: "/client/", PerClient: 4000},
+	}, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// serve makes concurrent requests from the given addresses
+	// returning how long they took in total.
+	serve := func(p string, addrs ...string) time.Duration {
+		start := time.Now()
+		var wg sync.WaitGroup
+		for _, addr := range addrs {
+			wg.Add(1)
+			go func(addr string) {
+				defer wg.Done()
+				req := httptest.NewRequest("GET", p, nil)
+				req.RemoteAddr = addr
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				if rec.Body.Len() != len(body) {
+					t.Errorf("expected %d bytes, got %d", len(body), rec.Body.Len())
+				}
+			}(addr)
+		}
+		wg.Wait()
+		return time.Since(start)
+	}
+	if d := serve("/request/a", "10.0.0.1:1000", "10.0.0.1:1001"); d < 200*time.Millisecond || d > 350*time.Millisecond {
+		t.Errorf("expected requests throttled separately (~225ms), took %s", d)
+	}
+	limits := []*Bandwidth{{Prefix: "/client/", PerClient: 4000}}
+	BandwidthHandler(limits, next)
+	clients := limits[0].clients
+	if _, err := BandwidthHandler(limits, next); err != nil || limits[0].clients != clients {
+		t.Errorf("expected rebuilt handlers to share the client limits, %v", err)
+	}
+	if d := serve("/client/a", "10.0.0.1:1000", "10.0.0.1:1001"); d < 450*time.Millisecond {
+		t.Errorf("expected one client's requests to share a limit (~475ms), took %s", d)
+	}
+	if d := serve("/client/a", "10.0.0.1:1000", "10.0.0.2:1000"); d > 350*time.Millisecond {
+		t.Errorf("expected clients throttled separately (~225ms), took %s", d)
+	}
+	if d := serve("/other/a", "10.0.0.1:1000"); d > 50*time.Millisecond {
+		t.Errorf("expected other paths unthrottled, took %s", d)
+	}
+	if _, err := BandwidthHandler([]*Bandwidth{{Prefix: "/"}}, next); err == nil {
+		t.Errorf("expected an error without a limit")
+	}
+}
@@ -0,0 +1,73 @@
+// browser.go reports the URLs a service is listening on and opens them
+// in the default web browser.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// ListenURLs returns the URLs a service bound to addr can be reached
+// at. A service listening on all interfaces (e.g. host "0.0.0.0") is
+// reported as localhost followed by each LAN address.
+func ListenURLs(scheme string, host string, addr net.Addr) []string {
+	if scheme == "" {
+		scheme = "http"
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if ok == false {
+		return []string{scheme + "://" + addr.String() + "/"}
+	}
+	port := strconv.Itoa(tcp.Port)
+	if tcp.IP != nil && tcp.IP.IsUnspecified() == false {
+		if host == "" {
+			host = tcp.IP.String()
+		}
+		return []string{scheme + "://" + net.JoinHostPort(host, port) + "/"}
+	}
+	urls := []string{scheme + "://" + net.JoinHostPort("localhost", port) + "/"}
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.IsLoopback() == false && ipNet.IP.To4() != nil {
+			urls = append(urls, scheme+"://"+net.JoinHostPort(ipNet.IP.String(), port)+"/")
+		}
+	}
+	return urls
+}
+
+// OpenBrowser opens u in the default web browser.
+func OpenBrowser(u string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Don't leave a zombie process behind.
+	go cmd.Wait()
+	return nil
+}
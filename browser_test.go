@@ -0,0 +1,58 @@
+// browser_test.go tests reporting the URLs a service listens on.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenURLs(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8000}
+	if urls := ListenURLs("http", "localhost", addr); len(urls) != 1 || urls[0] != "http://localhost:8000/" {
+		t.Errorf("expected http://localhost:8000/, got %v", urls)
+	}
+	if urls := ListenURLs("https", "", addr); len(urls) != 1 || urls[0] != "https://127.0.0.1:8000/" {
+		t.Errorf("expected https://127.0.0.1:8000/, got %v", urls)
+	}
+	addr = &net.TCPAddr{IP: net.IPv4zero, Port: 8443}
+	urls := ListenURLs("https", "0.0.0.0", addr)
+	if len(urls) == 0 || urls[0] != "https://localhost:8443/" {
+		t.Errorf("expected https://localhost:8443/ first, got %v", urls)
+	}
+	for _, u := range urls[1:] {
+		if u == "https://0.0.0.0:8443/" {
+			t.Errorf("unexpected unspecified address in %v", urls)
+		}
+	}
+}
+
+func TestListenAutoPort(t *testing.T) {
+	for _, port := range []string{"0", "auto"} {
+		s := &Service{Scheme: "http", Host: "localhost", Port: port}
+		ln, err := s.Listen()
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls := ListenURLs(s.Scheme, s.Host, ln.Addr())
+		ln.Close()
+		if ln.Addr().(*net.TCPAddr).Port == 0 || len(urls) != 1 || urls[0] == "http://localhost:0/" {
+			t.Errorf("expected a free port for %q, got %s %v", port, ln.Addr(), urls)
+		}
+	}
+}
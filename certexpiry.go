@@ -0,0 +1,149 @@
+// certexpiry.go watches when the https and virtual host certificates
+// expire, warning before they do.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// CertExpiryWarning is how long before a certificate expires
+	// warnings are logged and EventCertExpiry is sent, unless the
+	// WebService sets CertExpiryDays.
+	CertExpiryWarning = 30 * 24 * time.Hour
+	// CertCheckInterval is how often Run checks the certificates.
+	CertCheckInterval = 12 * time.Hour
+)
+
+// CertStatus reports when the certificate served for Hosts expires.
+type CertStatus struct {
+	Hosts         []string  `json:"hosts"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"`
+}
+
+// servedCert is a certificate loaded for the https service and the
+// host names it is served for.
+type servedCert struct {
+	hosts []string
+	cert  *tls.Certificate
+}
+
+// certLeaf returns the parsed certificate of cert.
+func certLeaf(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+// certHosts names the hosts the https service's cert is served for,
+// its Host or the names in the certificate.
+func (s *Service) certHosts(cert *tls.Certificate) []string {
+	if s.Host != "" {
+		return []string{s.Host}
+	}
+	if leaf, err := certLeaf(cert); err == nil {
+		if len(leaf.DNSNames) > 0 {
+			return leaf.DNSNames
+		}
+		if leaf.Subject.CommonName != "" {
+			return []string{leaf.Subject.CommonName}
+		}
+	}
+	return []string{"*"}
+}
+
+// certExpiryWarning returns how long before expiry to warn.
+func (w *WebService) certExpiryWarning() time.Duration {
+	if w.CertExpiryDays > 0 {
+		return time.Duration(w.CertExpiryDays) * 24 * time.Hour
+	}
+	return CertExpiryWarning
+}
+
+// checkCertExpiry checks the certificates the https service loaded
+// (see tlsConfig), recording their CertStatus. Those expiring within
+// the warning window are logged and sent as EventCertExpiry.
+func (w *WebService) checkCertExpiry() {
+	w.certMu.Lock()
+	served := w.served
+	w.certMu.Unlock()
+	statuses := []*CertStatus{}
+	for _, sc := range served {
+		names := strings.Join(sc.hosts, ", ")
+		leaf, err := certLeaf(sc.cert)
+		if err != nil {
+			log.Printf("certificate for %s, %s", names, err)
+			continue
+		}
+		remaining := time.Until(leaf.NotAfter)
+		statuses = append(statuses, &CertStatus{
+			Hosts:         sc.hosts,
+			NotAfter:      leaf.NotAfter,
+			DaysRemaining: int(remaining.Hours() / 24),
+		})
+		if remaining >= w.certExpiryWarning() {
+			continue
+		}
+		message := fmt.Sprintf("certificate for %s expires %s", names, leaf.NotAfter.Format(time.RFC3339))
+		if remaining <= 0 {
+			message = fmt.Sprintf("certificate for %s expired %s", names, leaf.NotAfter.Format(time.RFC3339))
+		}
+		log.Printf("WARNING %s", message)
+		w.Notify(EventCertExpiry, message)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Hosts[0] < statuses[j].Hosts[0]
+	})
+	w.certMu.Lock()
+	w.certs = statuses
+	w.certMu.Unlock()
+}
+
+// certStatus returns the results of the last certificate check.
+func (w *WebService) certStatus() []*CertStatus {
+	w.certMu.Lock()
+	defer w.certMu.Unlock()
+	return w.certs
+}
+
+// watchCertExpiry checks the certificates every CertCheckInterval
+// until done is closed.
+func (w *WebService) watchCertExpiry(done <-chan struct{}) {
+	ticker := time.NewTicker(CertCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.checkCertExpiry()
+		case <-done:
+			return
+		}
+	}
+}
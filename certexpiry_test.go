@@ -0,0 +1,106 @@
+// certexpiry_test.go tests certificate expiry monitoring.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCertExpiry(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []*WebhookEvent
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev := new(WebhookEvent)
+		json.NewDecoder(r.Body).Decode(ev)
+		mu.Lock()
+		received = append(received, ev)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	certPEM, keyPEM := writeTestCert(t, dir, "library.example.edu")
+	hostCert, hostKey := writeTestCert(t, dir, "archives.example.edu")
+	ws := &WebService{
+		DocRoot:  dir,
+		Https:    &Service{Scheme: "https", CertPEM: certPEM, KeyPEM: keyPEM},
+		Hosts:    []*VirtualHost{{Host: "archives.example.edu", CertPEM: hostCert, KeyPEM: hostKey}},
+		Webhooks: []*Webhook{{URL: srv.URL, Events: []string{EventCertExpiry}}},
+	}
+	if _, err := ws.tlsConfig(); err != nil {
+		t.Fatal(err)
+	}
+	// The certificates served are checked, not the files.
+	os.Remove(certPEM)
+
+	// Outside the warning window nothing is sent.
+	warning := CertExpiryWarning
+	CertExpiryWarning = time.Minute
+	ws.checkCertExpiry()
+	CertExpiryWarning = warning
+	ws.waitNotify()
+	if len(received) != 0 {
+		t.Errorf("expected no webhooks, got %d", len(received))
+	}
+	certs := ws.Status().Certificates
+	if len(certs) != 2 || certs[0].Hosts[0] != "archives.example.edu" || certs[1].Hosts[0] != "library.example.edu" {
+		t.Fatalf("expected both certificates in the status by host, got %+v", certs)
+	}
+	for _, cert := range certs {
+		if cert.DaysRemaining != 0 || time.Until(cert.NotAfter) > time.Hour {
+			t.Errorf("expected the certificate to expire within the hour, got %+v", cert)
+		}
+	}
+
+	// The checks repeat, sending each certificate within the window.
+	ws.CertExpiryDays = 1
+	interval := CertCheckInterval
+	CertCheckInterval = 10 * time.Millisecond
+	defer func() {
+		CertCheckInterval = interval
+	}()
+	done := make(chan struct{})
+	go ws.watchCertExpiry(done)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		n := len(received)
+		mu.Unlock()
+		if n >= 4 {
+			break
+		}
+	}
+	close(done)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) < 4 {
+		t.Errorf("expected both certificates sent on each check, got %d webhooks", len(received))
+	}
+	for _, ev := range received {
+		if ev.Event != EventCertExpiry {
+			t.Errorf("expected %s, got %+v", EventCertExpiry, ev)
+		}
+	}
+}
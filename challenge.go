@@ -0,0 +1,79 @@
+// challenge.go builds the WWW-Authenticate challenge sent with 401
+// responses.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// quoteRealm returns realm as an RFC 7230 quoted string, escaping
+// backslashes and quotes and dropping control characters so the
+// realm can't break out of the header.
+func quoteRealm(realm string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range realm {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			continue
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// basicChallenge returns a WWW-Authenticate value for realm asking
+// browsers to send UTF-8 credentials (RFC 7617).
+func basicChallenge(realm string) string {
+	return fmt.Sprintf(`Basic realm=%s, charset="UTF-8"`, quoteRealm(realm))
+}
+
+// Challenge returns the WWW-Authenticate value for the Basic realm
+// AuthName.
+func (a *Access) Challenge() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return basicChallenge(a.AuthName)
+}
+
+// isNoChallengeRoute reports if p is below one of NoChallengeRoutes.
+func (a *Access) isNoChallengeRoute(p string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return hasPathPrefix(p, a.NoChallengeRoutes)
+}
+
+// unauthorized writes a 401 response for the path p. Routes in
+// NoChallengeRoutes get a JSON error without WWW-Authenticate so
+// browsers don't show a login dialog, others get the Basic challenge.
+func (a *Access) unauthorized(res http.ResponseWriter, req *http.Request, p string) {
+	if a.isNoChallengeRoute(p) {
+		JSONError(res, req, http.StatusUnauthorized, nil)
+		return
+	}
+	res.Header().Set("WWW-Authenticate", a.Challenge())
+	httpError(res, req, http.StatusUnauthorized, nil)
+}
@@ -0,0 +1,67 @@
+// challenge_test.go tests the WWW-Authenticate challenges sent with 401s.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChallenge(t *testing.T) {
+	a := &Access{AuthType: "basic", AuthName: `Staff "only" \ area` + "\r\nX-Evil: 1", Encryption: "md5",
+		Routes: []string{"/private/", "/api/"}, NoChallengeRoutes: []string{"/api/"}}
+	if err := a.UpdateUser("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	expected := `Basic realm="Staff \"only\" \\ areaX-Evil: 1", charset="UTF-8"`
+	if got := a.Challenge(); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		path      string
+		login     bool
+		status    int
+		challenge bool
+	}{
+		{"/private/", false, http.StatusUnauthorized, true},
+		{"/private/", true, http.StatusOK, false},
+		{"/api/items", false, http.StatusUnauthorized, false},
+		{"/api/items", true, http.StatusOK, false},
+		{"/public/", false, http.StatusOK, false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.login {
+			req.SetBasicAuth("Jane.Doe", "secret")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%+v, got status %d", test, rec.Code)
+		}
+		if got := rec.Header().Get("WWW-Authenticate") != ""; got != test.challenge {
+			t.Errorf("%+v, got WWW-Authenticate %q", test, rec.Header().Get("WWW-Authenticate"))
+		}
+		if test.path == "/api/items" && test.status == http.StatusUnauthorized && strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") == false {
+			t.Errorf("%+v, expected a JSON 401, got %q", test, rec.Header().Get("Content-Type"))
+		}
+	}
+}
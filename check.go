@@ -0,0 +1,141 @@
+// check.go reports weak hashes and inconsistent state in an access
+// configuration.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Access issue kinds reported by Check.
+const (
+	IssueWeakHash          = "weak_hash"
+	IssueUnsupportedScheme = "unsupported_scheme"
+	IssueEmptySalt         = "empty_salt"
+	IssueMissingKey        = "missing_key"
+	IssueDuplicateUsername = "duplicate_username"
+	IssueRouteCollision    = "route_collision"
+	IssueExpired           = "expired"
+	IssuePasswordAge       = "password_age"
+	IssueDormant           = "dormant"
+)
+
+// AccessIssue is a problem found by Check.
+type AccessIssue struct {
+	// Kind is the kind of problem, e.g. IssueWeakHash.
+	Kind string `json:"kind"`
+	// Username the problem applies to, if any.
+	Username string `json:"username,omitempty"`
+	// Route the problem applies to, if any.
+	Route string `json:"route,omitempty"`
+	// Detail describes the problem.
+	Detail string `json:"detail"`
+}
+
+// String formats the issue as a line of text.
+func (issue *AccessIssue) String() string {
+	subject := issue.Username
+	if issue.Route != "" {
+		subject = issue.Route
+	}
+	return fmt.Sprintf("%s\t%s\t%s", issue.Kind, subject, issue.Detail)
+}
+
+// timeOrUnknown formats an optional time for an issue's detail.
+func timeOrUnknown(t *time.Time) string {
+	if t == nil {
+		return "unknown"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// Check reports users stored with weak or unknown hash schemes,
+// empty salts or missing keys, usernames differing only by case,
+// expired accounts and colliding routes. When PasswordMaxAgeDays or
+// DormantDays are set it also reports old passwords and users who
+// haven't logged in recently.
+func (a *Access) Check() ([]*AccessIssue, error) {
+	usernames, err := a.store().List()
+	if err != nil {
+		return nil, err
+	}
+	a.mu.RLock()
+	encryption := a.Encryption
+	routes := append([]string{}, a.Routes...)
+	maxAge := time.Duration(a.PasswordMaxAgeDays) * 24 * time.Hour
+	dormant := time.Duration(a.DormantDays) * 24 * time.Hour
+	a.mu.RUnlock()
+	issues := []*AccessIssue{}
+	folded := map[string]string{}
+	now := time.Now()
+	for _, username := range usernames {
+		secret, err := a.store().Lookup(username)
+		if err != nil {
+			return nil, err
+		}
+		scheme := encryption
+		if secret.Encryption != "" {
+			scheme = secret.Encryption
+		}
+		switch scheme {
+		case "md5", "sha512":
+			issues = append(issues, &AccessIssue{Kind: IssueWeakHash, Username: username, Detail: fmt.Sprintf("stored with %s", scheme)})
+		case "argon2id", "pbkdf2":
+			if len(secret.Salt) == 0 {
+				issues = append(issues, &AccessIssue{Kind: IssueEmptySalt, Username: username, Detail: "no salt"})
+			}
+		default:
+			issues = append(issues, &AccessIssue{Kind: IssueUnsupportedScheme, Username: username, Detail: fmt.Sprintf("encryption %q", scheme)})
+		}
+		if len(secret.Key) == 0 {
+			issues = append(issues, &AccessIssue{Kind: IssueMissingKey, Username: username, Detail: "no password hash"})
+		}
+		if secret.expired(now) {
+			issues = append(issues, &AccessIssue{Kind: IssueExpired, Username: username, Detail: fmt.Sprintf("expired %s", secret.ExpiresAt.Format(time.RFC3339))})
+		}
+		if maxAge > 0 && (secret.UpdatedAt == nil || now.Sub(*secret.UpdatedAt) > maxAge) {
+			issues = append(issues, &AccessIssue{Kind: IssuePasswordAge, Username: username, Detail: "password last set " + timeOrUnknown(secret.UpdatedAt)})
+		}
+		if dormant > 0 {
+			last := secret.LastLoginAt
+			if last == nil {
+				last = secret.CreatedAt
+			}
+			if last == nil || now.Sub(*last) > dormant {
+				issues = append(issues, &AccessIssue{Kind: IssueDormant, Username: username, Detail: "last login " + timeOrUnknown(secret.LastLoginAt)})
+			}
+		}
+		if other, ok := folded[strings.ToLower(username)]; ok {
+			issues = append(issues, &AccessIssue{Kind: IssueDuplicateUsername, Username: username, Detail: fmt.Sprintf("differs from %q only by case", other)})
+		} else {
+			folded[strings.ToLower(username)] = username
+		}
+	}
+	sort.Strings(routes)
+	for i, route := range routes {
+		for _, other := range routes[i+1:] {
+			if strings.HasPrefix(other, route) {
+				issues = append(issues, &AccessIssue{Kind: IssueRouteCollision, Route: other, Detail: fmt.Sprintf("collides with %q", route)})
+			}
+		}
+	}
+	return issues, nil
+}
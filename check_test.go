@@ -0,0 +1,121 @@
+// check_test.go tests the access file checks for weak hashes and stale accounts.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	a := &Access{
+		AuthType:   "basic",
+		Encryption: "argon2id",
+		Routes:     []string{"/private/", "/private/reports/", "/api/"},
+		Map: map[string]*Secrets{
+			"Jane.Doe": {Salt: []byte("salt"), Key: []byte("key")},
+			"jane.doe": {Salt: []byte("salt"), Key: []byte("key")},
+			"Nosalt":   {Key: []byte("key")},
+			"Nokey":    {Salt: []byte("salt")},
+			"Guest":    {Salt: []byte("salt"), Key: []byte("key"), ExpiresAt: &expired},
+		},
+	}
+	issues, err := a.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]string{}
+	for _, issue := range issues {
+		found[issue.Kind] = issue.Username + issue.Route
+	}
+	expected := map[string]string{
+		IssueEmptySalt:         "Nosalt",
+		IssueMissingKey:        "Nokey",
+		IssueExpired:           "Guest",
+		IssueDuplicateUsername: "jane.doe",
+		IssueRouteCollision:    "/private/reports/",
+	}
+	for kind, subject := range expected {
+		if found[kind] != subject {
+			t.Errorf("expected %s for %q, got %q", kind, subject, found[kind])
+		}
+	}
+	if len(issues) != len(expected) {
+		t.Errorf("expected %d issues, got %d %s", len(expected), len(issues), issues)
+	}
+
+	a.Encryption = "md5"
+	issues, _ = a.Check()
+	weak := 0
+	for _, issue := range issues {
+		if issue.Kind == IssueWeakHash {
+			weak++
+		}
+	}
+	if weak != len(a.Map) {
+		t.Errorf("expected %d weak hashes, got %d", len(a.Map), weak)
+	}
+}
+
+func TestCheckAges(t *testing.T) {
+	old := time.Now().Add(-100 * 24 * time.Hour)
+	recent := time.Now().Add(-time.Hour)
+	a := &Access{
+		AuthType:           "basic",
+		Encryption:         "argon2id",
+		PasswordMaxAgeDays: 90,
+		DormantDays:        30,
+		Map: map[string]*Secrets{
+			"Jane.Doe": {Salt: []byte("salt"), Key: []byte("key"), CreatedAt: &old, UpdatedAt: &old, LastLoginAt: &recent},
+			"John.Doe": {Salt: []byte("salt"), Key: []byte("key"), CreatedAt: &recent, UpdatedAt: &recent},
+			"Millie":   {Salt: []byte("salt"), Key: []byte("key"), CreatedAt: &old, UpdatedAt: &recent},
+		},
+	}
+	issues, err := a.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := []string{}
+	for _, issue := range issues {
+		found = append(found, issue.Kind+" "+issue.Username)
+	}
+	expected := []string{IssuePasswordAge + " Jane.Doe", IssueDormant + " Millie"}
+	if fmt.Sprintf("%q", found) != fmt.Sprintf("%q", expected) {
+		t.Errorf("expected %q, got %q", expected, found)
+	}
+}
+
+func TestRecordLogin(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "md5"}
+	if err := a.UpdateUser("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyLogin("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := a.Lookup("Jane.Doe")
+	if secret.LastLoginAt == nil || time.Since(*secret.LastLoginAt) > time.Minute {
+		t.Errorf("expected the last login to be recorded")
+	}
+	a.VerifyLogin("Jane.Doe", "wrong")
+	if again, _ := a.Lookup("Jane.Doe"); again.LastLoginAt != secret.LastLoginAt {
+		t.Errorf("expected a failed login not to be recorded")
+	}
+}
@@ -0,0 +1,235 @@
+// client.go is an HTTP client for calling other services with timeouts
+// and retries of idempotent requests.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultClientTimeout limits a Client request, including
+	// reading the response body.
+	DefaultClientTimeout = 30 * time.Second
+	// DefaultClientRetries is how many times a Client retries a
+	// failed idempotent request.
+	DefaultClientRetries = 3
+	// DefaultClientBackoff is the delay before a Client's first retry.
+	DefaultClientBackoff = 250 * time.Millisecond
+	// DefaultClientMaxBackoff caps the delay between retries.
+	DefaultClientMaxBackoff = 10 * time.Second
+)
+
+// ClientAttempt describes one try of a Client request.
+type ClientAttempt struct {
+	Request *http.Request
+	// Attempt is 1 for the first try.
+	Attempt int
+	// Status is the response status, 0 if there was no response.
+	Status   int
+	Err      error
+	Duration time.Duration
+	// Retry is true when another attempt follows.
+	Retry bool
+}
+
+// Client calls other services. Requests that are idempotent (GET,
+// HEAD, OPTIONS, PUT, DELETE or with an Idempotency-Key header) are
+// retried with an exponential backoff when they fail to connect or
+// get a 429, 502, 503 or 504 response, honoring Retry-After. The
+// request's context cancels waiting.
+//
+//	client := wsfn.NewClient()
+//	client.Log = wsfn.LogClientAttempt
+//	people := []*Person{}
+//	err := client.GetJSON(ctx, "https://directory.example.edu/api/people", &people)
+type Client struct {
+	// HTTP sends the requests, NewClient sets one with a
+	// DefaultClientTimeout.
+	HTTP *http.Client
+	// Retries is how many times to retry, none if zero.
+	Retries int
+	// Backoff is the delay before the first retry, doubling for each
+	// retry up to MaxBackoff, with jitter.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Log if set is called after each attempt.
+	Log func(a *ClientAttempt)
+}
+
+// NewClient returns a *Client using the defaults.
+func NewClient() *Client {
+	return &Client{
+		HTTP:       &http.Client{Timeout: DefaultClientTimeout},
+		Retries:    DefaultClientRetries,
+		Backoff:    DefaultClientBackoff,
+		MaxBackoff: DefaultClientMaxBackoff,
+	}
+}
+
+// LogClientAttempt logs attempts with the standard logger, e.g. as
+// Client.Log.
+func LogClientAttempt(a *ClientAttempt) {
+	result := strconv.Itoa(a.Status)
+	if a.Err != nil {
+		result = a.Err.Error()
+	}
+	retry := ""
+	if a.Retry {
+		retry = ", retrying"
+	}
+	log.Printf("client %s %s attempt %d, %s in %s%s", a.Request.Method, a.Request.URL, a.Attempt, result, a.Duration, retry)
+}
+
+// idempotent reports if req may be sent again.
+func idempotent(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// retryable reports if an attempt's result is worth retrying.
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return errors.Is(err, context.Canceled) == false && errors.Is(err, context.DeadlineExceeded) == false
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// delay returns how long to wait before retry (1 for the first),
+// the response's Retry-After seconds if given.
+func (c *Client) delay(retry int, res *http.Response) time.Duration {
+	maxBackoff := c.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultClientMaxBackoff
+	}
+	if res != nil {
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			if d := time.Duration(seconds) * time.Second; d < maxBackoff {
+				return d
+			}
+			return maxBackoff
+		}
+	}
+	d := c.Backoff
+	for i := 1; i < retry && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	// Jitter keeps clients that failed together from retrying together.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Do sends req, retrying idempotent requests that fail. The last
+// response or error is returned.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	retries := c.Retries
+	if idempotent(req) == false {
+		retries = 0
+	}
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		start := time.Now()
+		res, err := client.Do(req)
+		retry := attempt <= retries && retryable(res, err) && req.Context().Err() == nil
+		if c.Log != nil {
+			a := &ClientAttempt{Request: req, Attempt: attempt, Err: err, Duration: time.Since(start), Retry: retry}
+			if res != nil {
+				a.Status = res.StatusCode
+			}
+			c.Log(a)
+		}
+		if retry == false {
+			return res, err
+		}
+		wait := c.delay(attempt, res)
+		if res != nil {
+			// Drain the body so the connection can be reused.
+			io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+			res.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// Get fetches u.
+func (c *Client) Get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// GetJSON fetches u decoding the JSON response into data. Statuses
+// other than 200 are an error.
+func (c *Client) GetJSON(ctx context.Context, u string, data interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s, %s", u, res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(data); err != nil {
+		return fmt.Errorf("%s, %s", u, err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// client_test.go tests the Client used to call other services.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/flaky":
+			// Fails twice then answers.
+			if n < 3 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			JSONResponse(w, r, http.StatusOK, map[string]string{"name": "Jane.Doe"})
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			if n < 2 {
+				http.Error(w, "busy", http.StatusBadGateway)
+				return
+			}
+			w.Write(body)
+		case "/down":
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		case "/busy":
+			http.Error(w, "busy", http.StatusTooManyRequests)
+		case "/missing":
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	attempts := []*ClientAttempt{}
+	c := NewClient()
+	c.Backoff = time.Millisecond
+	c.Log = func(a *ClientAttempt) {
+		attempts = append(attempts, a)
+	}
+	reset := func() {
+		atomic.StoreInt32(&calls, 0)
+		attempts = attempts[:0]
+	}
+
+	person := map[string]string{}
+	if err := c.GetJSON(context.Background(), srv.URL+"/flaky", &person); err != nil || person["name"] != "Jane.Doe" {
+		t.Fatalf("expected Jane.Doe after retrying, got %v, %v", person, err)
+	}
+	if len(attempts) != 3 || attempts[0].Status != 503 || attempts[0].Retry == false || attempts[2].Status != 200 || attempts[2].Retry {
+		t.Errorf("unexpected attempts %+v", attempts)
+	}
+
+	// Bodies are sent again for PUT, POST is never retried.
+	for _, test := range []struct {
+		method string
+		status int
+		calls  int32
+	}{
+		{http.MethodPut, http.StatusOK, 2},
+		{http.MethodPost, http.StatusBadGateway, 1},
+	} {
+		reset()
+		req, _ := http.NewRequest(test.method, srv.URL+"/echo", strings.NewReader("record"))
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != test.status || calls != test.calls {
+			t.Errorf("%s expected %d after %d calls, got %d after %d", test.method, test.status, test.calls, res.StatusCode, calls)
+		}
+		if test.status == http.StatusOK && string(body) != "record" {
+			t.Errorf("%s expected the body resent, got %q", test.method, body)
+		}
+	}
+
+	// Retries stop, returning the last response.
+	reset()
+	res, err := c.Get(context.Background(), srv.URL+"/down")
+	if err != nil || res.StatusCode != http.StatusServiceUnavailable || calls != DefaultClientRetries+1 {
+		t.Errorf("expected %d calls ending in 503, got %d, %v", DefaultClientRetries+1, calls, err)
+	}
+	res.Body.Close()
+
+	// Errors other than overload aren't retried.
+	reset()
+	if err := c.GetJSON(context.Background(), srv.URL+"/missing", &person); err == nil || calls != 1 {
+		t.Errorf("expected one call and an error, got %d, %v", calls, err)
+	}
+
+	// Cancelling the context stops waiting for a retry.
+	reset()
+	c.Backoff = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Get(ctx, srv.URL+"/busy"); err != context.DeadlineExceeded || time.Since(start) > 5*time.Second {
+		t.Errorf("expected the deadline to stop retries, got %v after %s", err, time.Since(start))
+	}
+}
+
+func TestClientDelay(t *testing.T) {
+	c := &Client{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for retry, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 6: time.Second} {
+		if d := c.delay(retry, nil); d < max/2 || d > max {
+			t.Errorf("retry %d expected between %s and %s, got %s", retry, max/2, max, d)
+		}
+	}
+	res := &http.Response{Header: http.Header{"Retry-After": []string{"120"}}}
+	if d := c.delay(1, res); d != time.Second {
+		t.Errorf("expected Retry-After capped at %s, got %s", time.Second, d)
+	}
+}
@@ -23,11 +23,15 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/user"
 	"path"
-	"sort"
+	"strconv"
 	"strings"
 
 	// X packages
@@ -74,6 +78,13 @@ user access to web services built on wsfn.
 -o
 : write output to filename
 
+-json
+: write the audit report or user details as JSON
+
+-gen-password[=LENGTH]
+: update a user with a generated password (24 characters unless
+LENGTH is given), printing it once
+
 
 # CONFIG_FILE
 
@@ -81,6 +92,54 @@ user access to web services built on wsfn.
 an access file. It provides the ability to 
 setup users as well as protected routes.
 
+The cost of the password hashes can be tuned by adding an
+"[argon2]" (time, memory in KiB, threads, key_len) or "[pbkdf2]"
+(iterations, hash, key_len) table to the access file. The
+parameters are recorded with each password so they can be raised
+later without breaking existing passwords.
+
+Failed logins are logged with the client IP address, username
+and path. Setting "failure_log" in the access file writes them
+to a dedicated file in a stable format fail2ban can match, e.g.
+
+~~~
+2023-01-05T17:04:05Z wsfn: authentication failure; ip=192.0.2.7 user="Jane.Doe" path="/private/"
+~~~
+
+A fail2ban filter for it is
+
+~~~
+[Definition]
+failregex = ^\S+ wsfn: authentication failure; ip=<HOST> user=
+datepattern = ^%%Y-%%m-%%dT%%H:%%M:%%SZ
+~~~
+
+Setting "audit_log" in the access file appends logins, login
+failures, user updates and removals (including those made with
+{app_name}) and reloads to that file as JSON lines.
+
+When single sign on is handled by an upstream proxy (e.g. Apache
+with a Shibboleth SP) set "auth_type" to "remote_user". Requests
+to the routes must then come from one of "remote_user_proxies"
+with the username in "remote_user_header" (default Remote-User).
+If users are listed only they are allowed.
+
+Setting "auth_command" (e.g. [ "/usr/local/bin/check-pam" ]) checks
+passwords by running the command with the username as its last
+argument and the password on standard input. An exit code of zero
+accepts the login. "auth_command_seconds" limits how long it runs.
+
+# BACKENDS
+
+Instead of an access file CONFIG_FILE may be the URL of a
+credential backend, e.g. "sqlite://users.db" or
+"ldap://ldap.example.edu/ou=people,dc=example,dc=edu", for the
+user verbs (update, remove, list, test, show, disable, enable,
+expire, prune, rename, grant, revoke and audit). Routes, groups and
+set-encryption need an access file. Backends are registered with
+wsfn.RegisterAccessStore by the packages implementing them, this
+build has none unless they are added to it.
+
 # EXAMPLES
 
 Create an empty "access.toml" file.
@@ -102,12 +161,96 @@ Remove "Jane.Doe" from access.toml.
 {app_name} remove access.toml Jane.Doe
 ~~~
 
+Add the service account "harvester" with a generated 32
+character password. The password is printed once, only its hash
+is kept.
+
+~~~
+{app_name} -gen-password=32 update access.toml harvester
+~~~
+
+Rename "Jane.Doe" to "Jane.Smith" keeping the password, grants
+and settings.
+
+~~~
+{app_name} rename access.toml Jane.Doe Jane.Smith
+~~~
+
+Disable "Jane.Doe" while on leave, keeping the password and
+settings, then enable the account again on return.
+
+~~~
+{app_name} disable access.toml Jane.Doe
+{app_name} enable access.toml Jane.Doe
+~~~
+
+Expire the guest account "Guest.Researcher" at the start of
+July 1st (UTC), an RFC 3339 time can be given instead of a date
+and "never" removes the expiration. Expired accounts can't log
+in, prune removes them listing their usernames.
+
+~~~
+{app_name} expire access.toml Guest.Researcher 2024-07-01
+{app_name} prune access.toml
+~~~
+
+Users may use every protected route unless they are granted
+routes or groups, then they are limited to those. Limit "Jane.Doe"
+to "/reports/", and members of the "staff" group (a name starting
+with "@") to "/intranet/", then add Jane.Doe to staff.
+
+~~~
+{app_name} grant access.toml Jane.Doe /reports/
+{app_name} grant access.toml @staff /intranet/
+{app_name} grant access.toml Jane.Doe @staff
+~~~
+
+Revoke takes the same parameters. Jane.Doe stays limited to the
+routes left, none once the last is revoked. Grant or revoke "*" to
+give a user every protected route again or to take them all away.
+
+~~~
+{app_name} revoke access.toml Jane.Doe /reports/
+{app_name} grant access.toml Jane.Doe "*"
+~~~
+
+Report users stored with weak (md5, sha512) or unknown hashes,
+empty salts or missing keys, usernames differing only by case,
+expired accounts and colliding routes. Setting
+"password_max_age_days" or "dormant_days" in the access file also
+reports old passwords and users who haven't logged in within that
+many days (last logins are recorded to within an hour). Each line
+holds the kind of issue, the user or route and a description, use
+-json for a JSON list. The exit code is 1 when issues are found.
+
+~~~
+{app_name} audit access.toml
+{app_name} -json audit access.toml
+~~~
+
+Change the hashing scheme to argon2id. Existing passwords keep
+working and are rehashed the next time each user logs in. To
+rehash at once give a CSV file of username,password rows.
+
+~~~
+{app_name} set-encryption access.toml argon2id
+{app_name} set-encryption access.toml argon2id passwords.csv
+~~~
+
 List users defined in access.toml.
 
 ~~~
 {app_name} list access.toml 
 ~~~
 
+Show the details of Jane.Doe's account, the hash scheme, salt
+length, when it was created and updated, groups and granted routes
+(everything but the password hash).
+
+~~~
+{app_name} show access.toml Jane.Doe
+~~~
+
 Test a login for Jane.Doe (will prompt for password)
 
 ~~~
@@ -134,8 +277,49 @@ Routes follow a similar pattern of update, list, remove.
 	showExamples     bool
 	outputFName      string
 	quiet            bool
+
+	// App options
+	jsonOutput  bool
+	genPassword passwordLength
 )
 
+// passwordLength implements "-gen-password[=length]", given
+// without a value it uses wsfn.DefaultPasswordLength.
+type passwordLength int
+
+func (n *passwordLength) String() string {
+	return strconv.Itoa(int(*n))
+}
+
+func (n *passwordLength) Set(s string) error {
+	switch s {
+	case "true":
+		*n = wsfn.DefaultPasswordLength
+	case "false":
+		*n = 0
+	default:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a length", s)
+		}
+		*n = passwordLength(i)
+	}
+	return nil
+}
+
+func (n *passwordLength) IsBoolFlag() bool {
+	return true
+}
+
+// saveAccess writes the access file, backends save their own
+// changes.
+func saveAccess(a *wsfn.Access, fName string) error {
+	if a.Store != nil {
+		return nil
+	}
+	return a.DumpAccess(fName)
+}
+
 func initAccess(fName string) error {
 	if fName == "" {
 		fName = "access.toml"
@@ -150,25 +334,231 @@ func initAccess(fName string) error {
 }
 
 func updateAccess(fName, username, password string) error {
-	a, err := wsfn.LoadAccess(fName)
+	a, err := wsfn.OpenAccess(fName)
 	if err != nil {
 		return err
 	}
-	if a.UpdateAccess(username, password) == false {
-		return fmt.Errorf("Failed to update %s", username)
+	if err := a.UpdateUser(username, password); err != nil {
+		return fmt.Errorf("Failed to update %s, %s", username, err)
 	}
-	return a.DumpAccess(fName)
+	if err := saveAccess(a, fName); err != nil {
+		return err
+	}
+	return a.Audit(auditEvent(wsfn.AuditUserUpdate, username))
 }
 
 func removeAccess(fName, username string) error {
+	a, err := wsfn.OpenAccess(fName)
+	if err != nil {
+		return err
+	}
+	if err := a.RemoveUser(username); err != nil {
+		return fmt.Errorf("Failed to remove %s, %s", username, err)
+	}
+	if err := saveAccess(a, fName); err != nil {
+		return err
+	}
+	return a.Audit(auditEvent(wsfn.AuditUserRemove, username))
+}
+
+// auditEvent returns an audit entry for a change made with this
+// program by the current OS account.
+func disableAccess(fName, username string, disabled bool) error {
+	a, err := wsfn.OpenAccess(fName)
+	if err != nil {
+		return err
+	}
+	if err := a.SetDisabled(username, disabled); err != nil {
+		return err
+	}
+	if err := saveAccess(a, fName); err != nil {
+		return err
+	}
+	if disabled {
+		return a.Audit(auditEvent(wsfn.AuditUserDisable, username))
+	}
+	return a.Audit(auditEvent(wsfn.AuditUserEnable, username))
+}
+
+func grantAccess(fName, name, target string, grant bool) error {
+	a, err := wsfn.OpenAccess(fName)
+	if err != nil {
+		return err
+	}
+	if a.Store != nil && strings.HasPrefix(name, "@") {
+		return fmt.Errorf("groups are kept in an access file")
+	}
+	event := wsfn.AuditGrant
+	if grant {
+		err = a.Grant(name, target)
+	} else {
+		err, event = a.Revoke(name, target), wsfn.AuditRevoke
+	}
+	if err != nil {
+		return err
+	}
+	if err := saveAccess(a, fName); err != nil {
+		return err
+	}
+	ev := auditEvent(event, name)
+	ev.Detail = target
+	return a.Audit(ev)
+}
+
+func expireAccess(fName, username, date string) error {
+	expires, err := wsfn.ParseExpires(date)
+	if err != nil {
+		return err
+	}
+	a, err := wsfn.OpenAccess(fName)
+	if err != nil {
+		return err
+	}
+	if err := a.SetExpires(username, expires); err != nil {
+		return err
+	}
+	if err := saveAccess(a, fName); err != nil {
+		return err
+	}
+	ev := auditEvent(wsfn.AuditUserExpire, username)
+	ev.Detail = date
+	return a.Audit(ev)
+}
+
+func pruneAccess(fName string) error {
+	a, err := wsfn.OpenAccess(fName)
+	if err != nil {
+		return err
+	}
+	removed, err := a.Prune()
+	if len(removed) > 0 {
+		if err := saveAccess(a, fName); err != nil {
+			return err
+		}
+	}
+	for _, username := range removed {
+		fmt.Fprintf(os.Stdout, "%s\n", username)
+		ev := auditEvent(wsfn.AuditUserRemove, username)
+		ev.Detail = "expired"
+		if err := a.Audit(ev); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func renameAccess(fName, oldname, newname string) error {
+	a, err := wsfn.OpenAccess(fName)
+	if err != nil {
+		return err
+	}
+	if err := a.RenameUser(oldname, newname); err != nil {
+		return err
+	}
+	if err := saveAccess(a, fName); err != nil {
+		return err
+	}
+	ev := auditEvent(wsfn.AuditUserRename, newname)
+	ev.Detail = "renamed from " + oldname
+	return a.Audit(ev)
+}
+
+func auditAccess(out io.Writer, fName string) (int, error) {
+	a, err := wsfn.OpenAccess(fName)
+	if err != nil {
+		return 0, err
+	}
+	issues, err := a.Check()
+	if err != nil {
+		return 0, err
+	}
+	if jsonOutput {
+		src, err := json.MarshalIndent(issues, "", "    ")
+		if err != nil {
+			return 0, err
+		}
+		fmt.Fprintf(out, "%s\n", src)
+		return len(issues), nil
+	}
+	for _, issue := range issues {
+		fmt.Fprintf(out, "%s\n", issue)
+	}
+	return len(issues), nil
+}
+
+func setEncryption(fName, encryption, csvName string) error {
 	a, err := wsfn.LoadAccess(fName)
 	if err != nil {
 		return err
 	}
-	if a.RemoveAccess(username) == false {
-		return fmt.Errorf("Failed to find %s", username)
+	if err := a.SetEncryption(encryption); err != nil {
+		return err
 	}
-	return a.DumpAccess(fName)
+	if err := a.DumpAccess(fName); err != nil {
+		return err
+	}
+	ev := auditEvent(wsfn.AuditEncryption, "")
+	ev.Detail = encryption
+	if err := a.Audit(ev); err != nil {
+		return err
+	}
+	if csvName != "" {
+		// Verifying a login rehashes the password.
+		fp, err := os.Open(csvName)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		rows, err := csv.NewReader(fp).ReadAll()
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if len(row) < 2 || row[0] == "username" {
+				continue
+			}
+			if err := a.VerifyLogin(row[0], row[1]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s not rehashed, %s\n", row[0], err)
+			}
+		}
+	}
+	pending, err := a.PendingRehash()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		fmt.Fprintf(os.Stdout, "%d users will be rehashed at their next login\n", len(pending))
+	}
+	return nil
+}
+
+func showAccess(out io.Writer, fName, username string) error {
+	a, err := wsfn.OpenAccess(fName)
+	if err != nil {
+		return err
+	}
+	info, err := a.UserInfo(username)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		src, err := json.MarshalIndent(info, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s\n", src)
+		return nil
+	}
+	return info.Write(out)
+}
+
+func auditEvent(event string, username string) *wsfn.AuditEvent {
+	ev := wsfn.NewAuditEvent(event, username, nil)
+	if u, err := user.Current(); err == nil {
+		ev.Actor = u.Username
+	}
+	ev.Detail = path.Base(os.Args[0])
+	return ev
 }
 
 func listAccess(fName string) error {
@@ -176,14 +566,16 @@ func listAccess(fName string) error {
 		a   *wsfn.Access
 		err error
 	)
-	a, err = wsfn.LoadAccess(fName)
+	a, err = wsfn.OpenAccess(fName)
 	if err != nil {
 		return err
 	}
-	for key, _ := range a.Map {
-		if key != "" {
-			fmt.Fprintf(os.Stdout, "%s\n", key)
-		}
+	usernames, err := a.List()
+	if err != nil {
+		return err
+	}
+	for _, key := range usernames {
+		fmt.Fprintf(os.Stdout, "%s\n", key)
 	}
 	return nil
 }
@@ -194,15 +586,15 @@ func testAccess(fName, username, password string) error {
 		err error
 	)
 	// See if fName exists
-	if _, err = os.Stat(fName); os.IsNotExist(err) {
+	if _, err = os.Stat(fName); os.IsNotExist(err) && wsfn.IsAccessStoreURL(fName) == false {
 		return err
 	}
-	a, err = wsfn.LoadAccess(fName)
+	a, err = wsfn.OpenAccess(fName)
 	if err != nil {
 		return err
 	}
-	if a.Login(username, password) == false {
-		return fmt.Errorf("Failed to authenticate %s", username)
+	if err := a.VerifyLogin(username, password); err != nil {
+		return fmt.Errorf("Failed to authenticate %s, %s", username, err)
 	}
 	return nil
 }
@@ -216,40 +608,19 @@ func listRoutes(a *wsfn.Access) error {
 
 func updateRoutes(fName string, a *wsfn.Access, args []string) error {
 	for _, arg := range args {
-		if strings.HasPrefix(arg, "/") == false {
-			arg = "/" + arg
+		if err := a.AddRoute(arg); err != nil {
+			return err
 		}
-		if strings.HasSuffix(arg, "/") == false {
-			arg += "/"
-		}
-		for _, route := range a.Routes {
-			if strings.HasPrefix(arg, route) || strings.HasPrefix(route, arg) {
-				return fmt.Errorf("%q collides with %q", arg, route)
-			}
-		}
-		a.Routes = append(a.Routes, arg)
-		sort.Strings(a.Routes)
 	}
 	return a.DumpAccess(fName)
 }
 
 func removeRoutes(fName string, a *wsfn.Access, args []string) error {
 	for _, arg := range args {
-		routeFound := false
-		if strings.HasPrefix(arg, "/") == false {
-			arg = "/" + arg
-		}
-		for i, route := range a.Routes {
-			if strings.Compare(arg, route) == 0 {
-				a.Routes = append(a.Routes[:i], a.Routes[i+1:]...)
-				routeFound = true
-			}
-		}
-		if routeFound == false {
-			return fmt.Errorf("Could not find route %q", arg)
+		if err := a.RemoveRoute(arg); err != nil {
+			return err
 		}
 	}
-	sort.Strings(a.Routes)
 	return a.DumpAccess(fName)
 }
 
@@ -300,6 +671,10 @@ func main() {
 	flag.BoolVar(&quiet, "quiet", false, "suppress error messages")
 	flag.StringVar(&outputFName, "o", "", "write output to filename")
 
+	// App Options
+	flag.BoolVar(&jsonOutput, "json", false, "write the audit report or user details as JSON")
+	flag.Var(&genPassword, "gen-password", "update with a generated password of the given length (default 24), printing it once")
+
 	flag.Parse()
 	args := flag.Args()
 
@@ -316,7 +691,7 @@ func main() {
 		os.Exit(0)
 	}
 	if showLicense {
-		fmt.Fprintln(out, wsfn.LicenseText)
+		fmt.Fprint(out, wsfn.LicenseText)
 		os.Exit(0)
 	}
 	if showVersion {
@@ -335,8 +710,14 @@ func main() {
 
 
 
-	verb, fName, userid := "", "", ""
+	verb, fName, userid, target := "", "", "", ""
 	switch len(args) {
+	case 4:
+		verb, fName, userid, target = args[0], args[1], args[2], args[3]
+		if verb != "routes" && verb != "grant" && verb != "revoke" && verb != "expire" && verb != "rename" && verb != "set-encryption" {
+			fmt.Fprintf(eout, "To many parameters, try %s -help\n", appName)
+			os.Exit(1)
+		}
 	case 3:
 		verb, fName, userid = args[0], args[1], args[2]
 	case 2:
@@ -353,7 +734,7 @@ func main() {
 	default:
 		verb, fName, userid = args[0], "", ""
 		if strings.Compare(verb, "routes") != 0 {
-			fmt.Fprintf(eout, "To many parameters, try %s -help\n", appName, appName)
+			fmt.Fprintf(eout, "To many parameters, try %s -help\n", appName)
 			os.Exit(1)
 		}
 	}
@@ -366,21 +747,91 @@ func main() {
 			os.Exit(1)
 		}
 	case "update":
-		fmt.Fprintf(os.Stdout, "Enter a password:\n")
-		password, err := terminal.ReadPassword(0)
-		if err != nil {
-			fmt.Fprintf(eout, "%s\n", err)
-			os.Exit(1)
+		var password []byte
+		if genPassword > 0 {
+			generated, err := wsfn.GeneratePassword(int(genPassword))
+			if err != nil {
+				fmt.Fprintf(eout, "%s\n", err)
+				os.Exit(1)
+			}
+			password = []byte(generated)
+		} else {
+			fmt.Fprintf(os.Stdout, "Enter a password:\n")
+			password, err = terminal.ReadPassword(0)
+			if err != nil {
+				fmt.Fprintf(eout, "%s\n", err)
+				os.Exit(1)
+			}
 		}
 		if err = updateAccess(fName, userid, string(password)); err != nil {
 			fmt.Fprintf(eout, "update failed, %s\n", err)
 			os.Exit(1)
 		}
+		if genPassword > 0 {
+			// Shown once, only the hash is stored.
+			fmt.Fprintf(out, "%s\n", password)
+		}
 	case "remove":
 		if err = removeAccess(fName, userid); err != nil {
 			fmt.Fprintf(eout, "remove failed, %s\n", err)
 			os.Exit(1)
 		}
+	case "disable", "enable":
+		if err = disableAccess(fName, userid, verb == "disable"); err != nil {
+			fmt.Fprintf(eout, "%s failed, %s\n", verb, err)
+			os.Exit(1)
+		}
+	case "grant", "revoke":
+		if target == "" {
+			fmt.Fprintf(eout, "Missing route or group, try %s -help\n", appName)
+			os.Exit(1)
+		}
+		if err = grantAccess(fName, userid, target, verb == "grant"); err != nil {
+			fmt.Fprintf(eout, "%s failed, %s\n", verb, err)
+			os.Exit(1)
+		}
+	case "expire":
+		if target == "" {
+			fmt.Fprintf(eout, "Missing date, try %s -help\n", appName)
+			os.Exit(1)
+		}
+		if err = expireAccess(fName, userid, target); err != nil {
+			fmt.Fprintf(eout, "expire failed, %s\n", err)
+			os.Exit(1)
+		}
+	case "rename":
+		if target == "" {
+			fmt.Fprintf(eout, "Missing new username, try %s -help\n", appName)
+			os.Exit(1)
+		}
+		if err = renameAccess(fName, userid, target); err != nil {
+			fmt.Fprintf(eout, "rename failed, %s\n", err)
+			os.Exit(1)
+		}
+	case "set-encryption":
+		if err = setEncryption(fName, userid, target); err != nil {
+			fmt.Fprintf(eout, "set-encryption failed, %s\n", err)
+			os.Exit(1)
+		}
+	case "show":
+		if err = showAccess(out, fName, userid); err != nil {
+			fmt.Fprintf(eout, "show failed, %s\n", err)
+			os.Exit(1)
+		}
+	case "audit":
+		count, err := auditAccess(out, fName)
+		if err != nil {
+			fmt.Fprintf(eout, "audit failed, %s\n", err)
+			os.Exit(1)
+		}
+		if count > 0 {
+			os.Exit(1)
+		}
+	case "prune":
+		if err = pruneAccess(fName); err != nil {
+			fmt.Fprintf(eout, "prune failed, %s\n", err)
+			os.Exit(1)
+		}
 	case "list":
 		if err = listAccess(fName); err != nil {
 			fmt.Fprintf(eout, "list failed, %s\n", err)
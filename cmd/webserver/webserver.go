@@ -21,14 +21,25 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"log"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"os/user"
 	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	// Caltech Library packages
 	"github.com/caltechlibrary/wsfn"
@@ -78,6 +89,24 @@ content.
 -o
 : write output to filename
 
+-livereload
+: with "start", reload pages open in the browser when files in the
+document root change. Can also follow "start".
+
+-open
+: with "start", open the site in your web browser once it is
+listening. Can also follow "start".
+
+-host
+: with "start", the host or address to listen on. With 0.0.0.0 the
+site's addresses on your network are printed so you can preview it
+from another device. Can also follow "start".
+
+-port
+: with "start", the port to listen on. Use 0 or "auto" to pick a
+free port so several previews can run at once, the URL is printed
+(and opened with -open). Can also follow "start".
+
 
 # CONFIG_FILE
 
@@ -110,6 +139,43 @@ auth
 access
 : sets an external access file. The external access file is managed with the "webaccess" tool.
 
+install-service
+: writes a systemd unit (Linux) or launchd plist (macOS) for running
+{app_name} with the given configuration file. The unit uses the current
+user, the location of the running {app_name} binary and the absolute
+path to the configuration file. An optional second parameter of
+"systemd" or "launchd" picks the format explicitly. Use "-o" to write
+the result directly into place.
+
+check
+: runs the startup checks {app_name} start makes (document root,
+certificates, access and redirect files, reverse proxy upstreams and
+ports) against an optional configuration file, the current
+"{app_name}.toml" otherwise, without starting. Exits with an error if
+a check fails.
+
+sign
+: prints a link to a path below a signed_urls prefix that opens
+without logging in until it expires. The parameters are the
+configuration file, the host the link is for, the path and how long
+the link works (e.g. "72h").
+
+logstats
+: summarizes one or more log files written by {app_name} (or standard
+input if none are given) reporting requests, bandwidth, the status
+distribution and the top ten paths, referrers and user agents.
+
+selftest
+: runs a load test against the configured site (an optional
+configuration file, the current "{app_name}.toml" otherwise) on a
+private port. The home page, the first protected route and the first
+reverse proxy route are requested, plus any paths given. Set
+WSFN_SELFTEST_USER and WSFN_SELFTEST_PASSWORD to authenticate. "-c N"
+sets the number of concurrent clients (default 8), "-d DURATION" how
+long each path is tested (default 5s). Reports requests per second
+and latency percentiles, failed requests are counted with their own
+latencies and retried after a growing pause.
+
 # EXAMPLES
 
 Run web server using the content in the current directory
@@ -119,6 +185,12 @@ Run web server using the content in the current directory
 {app_name} start
 ~~~
 
+Run web server reloading the browser as you edit the site.
+
+~~~
+{app_name} start -livereload
+~~~
+
 Run web server using a specified directory
 
 ~~~
@@ -149,6 +221,35 @@ Configure your web server with these steps
    {app_name} access webserver.toml /etc/wsfn/access.toml
 ~~~
 
+Summarize the usage recorded in a log file.
+
+~~~
+   {app_name} logstats /var/log/webserver.log
+~~~
+
+Measure the throughput of your configuration, e.g. after changing
+the middleware.
+
+~~~
+   {app_name} selftest webserver.toml -c 16 -d 10s /search/
+~~~
+
+Generate a systemd unit for the configuration and install it.
+
+~~~
+   {app_name} -o /etc/systemd/system/{app_name}.service \
+       install-service /etc/wsfn/webserver.toml
+   systemctl daemon-reload
+   systemctl enable --now {app_name}
+~~~
+
+Generate a launchd plist on macOS.
+
+~~~
+   {app_name} -o ~/Library/LaunchAgents/edu.caltech.library.{app_name}.plist \
+       install-service webserver.toml launchd
+~~~
+
 `
 
 	// Standard options
@@ -160,6 +261,12 @@ Configure your web server with these steps
 	generateMarkdown bool
 	generateManPage  bool
 	quiet            bool
+
+	// Start options
+	liveReload  bool
+	openBrowser bool
+	listenHost  string
+	listenPort  string
 )
 
 // initWebService creates an initialization file.
@@ -188,7 +295,7 @@ func initWebService(args []string) error {
 			return err
 		}
 	}
-	return ioutil.WriteFile(fName, src, 0660)
+	return wsfn.WriteConfigFile(fName, src, 0660, nil)
 }
 
 // setDocRootWebService sets the document root in an initialization file.
@@ -333,6 +440,130 @@ func setKeyPEM(args []string) error {
 	return ws.DumpWebService(fName)
 }
 
+// systemdUnit is the template used by install-service to render
+// a systemd unit file.
+const systemdUnit = `[Unit]
+Description={{.AppName}} ({{path .Config}})
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+User={{.User}}
+WorkingDirectory={{path .WorkDir}}
+ExecStart={{arg .Binary}} start {{arg .Config}}
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// launchdPlist is the template used by install-service to render
+// a launchd property list.
+const launchdPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Label</key>
+    <string>edu.caltech.library.{{xml .AppName}}</string>
+    <key>ProgramArguments</key>
+    <array>
+        <string>{{xml .Binary}}</string>
+        <string>start</string>
+        <string>{{xml .Config}}</string>
+    </array>
+    <key>UserName</key>
+    <string>{{xml .User}}</string>
+    <key>WorkingDirectory</key>
+    <string>{{xml .WorkDir}}</string>
+    <key>RunAtLoad</key>
+    <true/>
+    <key>KeepAlive</key>
+    <true/>
+    <key>StandardOutPath</key>
+    <string>{{xml .WorkDir}}/{{xml .AppName}}.log</string>
+    <key>StandardErrorPath</key>
+    <string>{{xml .WorkDir}}/{{xml .AppName}}.log</string>
+</dict>
+</plist>
+`
+
+// installService writes a systemd unit or launchd plist wired to
+// the configuration file, current user and binary location.
+func installService(out io.Writer, appName string, args []string) error {
+	var (
+		fName  string
+		format string
+	)
+	switch len(args) {
+	case 1:
+		fName = args[0]
+		format = "systemd"
+		if runtime.GOOS == "darwin" {
+			format = "launchd"
+		}
+	case 2:
+		fName, format = args[0], args[1]
+	default:
+		return fmt.Errorf("expecting web service filename and optionally systemd or launchd")
+	}
+	// Make sure the configuration is usable before we point a service at it.
+	if _, err := wsfn.LoadWebService(fName); err != nil {
+		return err
+	}
+	config, err := filepath.Abs(fName)
+	if err != nil {
+		return err
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if binary, err = filepath.EvalSymlinks(binary); err != nil {
+		return err
+	}
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	src := ""
+	switch format {
+	case "systemd":
+		src = systemdUnit
+	case "launchd":
+		src = launchdPlist
+	default:
+		return fmt.Errorf("%q is not a supported service format, try systemd or launchd", format)
+	}
+	tmpl, err := template.New(format).Funcs(template.FuncMap{
+		// systemd expands "%" specifiers in paths and "$" variables
+		// in command lines, arguments are quoted so spaces are kept.
+		"path": func(s string) string {
+			return strings.ReplaceAll(s, "%", "%%")
+		},
+		"arg": func(s string) string {
+			s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s)
+			return `"` + s + `"`
+		},
+		"xml": func(s string) string {
+			buf := new(bytes.Buffer)
+			xml.EscapeText(buf, []byte(s))
+			return buf.String()
+		},
+	}).Parse(src)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(out, map[string]string{
+		"AppName": appName,
+		"Config":  config,
+		"Binary":  binary,
+		"User":    u.Username,
+		"WorkDir": filepath.Dir(config),
+	})
+}
+
 func startService(args []string) error {
 	var (
 		cfg string
@@ -356,8 +587,19 @@ func startService(args []string) error {
 		ws = wsfn.DefaultWebService()
 	}
 	// Adhoc overrides
-	for _, arg := range args {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
 		switch {
+		case arg == "-livereload" || arg == "--livereload":
+			liveReload = true
+		case arg == "-open" || arg == "--open":
+			openBrowser = true
+		case (arg == "-host" || arg == "--host") && i+1 < len(args):
+			i++
+			listenHost = args[i]
+		case (arg == "-port" || arg == "--port") && i+1 < len(args):
+			i++
+			listenPort = args[i]
 		case strings.HasSuffix(arg, ".toml") || strings.HasSuffix(arg, ".json"):
 			ws, err = wsfn.LoadWebService(arg)
 			if err != nil {
@@ -393,6 +635,28 @@ func startService(args []string) error {
 			ws.DocRoot = arg
 		}
 	}
+	if liveReload {
+		ws.LiveReload = true
+	}
+	if (listenHost != "" || listenPort != "") && ws.Http == nil && ws.Https == nil {
+		ws.Http = &wsfn.Service{Scheme: "http", Port: "8000"}
+	}
+	if listenHost != "" {
+		if ws.Http != nil {
+			ws.Http.Host = listenHost
+		}
+		if ws.Https != nil {
+			ws.Https.Host = listenHost
+		}
+	}
+	if listenPort != "" {
+		if ws.Http != nil {
+			ws.Http.Port = listenPort
+		} else {
+			ws.Https.Port = listenPort
+		}
+	}
+	ws.Open = openBrowser
 	// Now we should be ready to run the web server
 	if err = ws.Run(); err != nil {
 		return err
@@ -400,6 +664,156 @@ func startService(args []string) error {
 	return nil
 }
 
+// checkService reports the startup checks of the configured site.
+func checkService(out io.Writer, args []string) error {
+	cfg := ""
+	if _, err := os.Stat("webserver.toml"); err == nil {
+		cfg = "webserver.toml"
+	} else if _, err := os.Stat("webserver.json"); err == nil {
+		cfg = "webserver.json"
+	}
+	if len(args) > 0 {
+		cfg = args[0]
+	}
+	ws := wsfn.DefaultWebService()
+	if cfg != "" {
+		var err error
+		if ws, err = wsfn.LoadWebService(cfg); err != nil {
+			return fmt.Errorf("%q, %s", cfg, err)
+		}
+	}
+	failed := 0
+	for _, d := range ws.Diagnose() {
+		fmt.Fprintf(out, "%s\n", d)
+		if d.Level == wsfn.DiagnosticError {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d startup checks failed", failed)
+	}
+	return nil
+}
+
+// signURL prints a signed link for a path.
+func signURL(out io.Writer, args []string) error {
+	if len(args) != 4 {
+		return fmt.Errorf("expected configuration file, host, path and duration")
+	}
+	ws, err := wsfn.LoadWebService(args[0])
+	if err != nil {
+		return fmt.Errorf("%q, %s", args[0], err)
+	}
+	if ws.SignedURLs == nil {
+		return fmt.Errorf("%q has no signed_urls", args[0])
+	}
+	d, err := time.ParseDuration(args[3])
+	if err != nil {
+		return fmt.Errorf("duration %q, %s", args[3], err)
+	}
+	link, err := ws.SignedURLs.Sign(args[1], args[2], time.Now().Add(d))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, link)
+	return nil
+}
+
+// selfTest load tests the configured site on a private port.
+func selfTest(out io.Writer, args []string) error {
+	cfg := ""
+	if _, err := os.Stat("webserver.toml"); err == nil {
+		cfg = "webserver.toml"
+	} else if _, err := os.Stat("webserver.json"); err == nil {
+		cfg = "webserver.json"
+	}
+	concurrency, duration, paths := 8, 5*time.Second, []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-c" && i+1 < len(args):
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				return fmt.Errorf("-c %q, %s", args[i], err)
+			}
+			concurrency = n
+		case arg == "-d" && i+1 < len(args):
+			i++
+			d, err := time.ParseDuration(args[i])
+			if err != nil {
+				return fmt.Errorf("-d %q, %s", args[i], err)
+			}
+			duration = d
+		case strings.HasSuffix(arg, ".toml") || strings.HasSuffix(arg, ".json"):
+			cfg = arg
+		case strings.HasPrefix(arg, "/"):
+			paths = append(paths, arg)
+		default:
+			return fmt.Errorf("unexpected parameter %q", arg)
+		}
+	}
+	ws := wsfn.DefaultWebService()
+	if cfg != "" {
+		var err error
+		if ws, err = wsfn.LoadWebService(cfg); err != nil {
+			return fmt.Errorf("%q, %s", cfg, err)
+		}
+	}
+	targets := ws.SelfTestTargets()
+	for _, p := range paths {
+		targets = append(targets, &wsfn.LoadTarget{Name: "path", Path: p})
+	}
+	// Request logging would swamp the report and skew the results.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	handler, err := ws.Handler()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(ln)
+	defer srv.Close()
+	fmt.Fprintf(out, "Testing %d clients for %s per path\n", concurrency, duration)
+	results, err := wsfn.LoadTest("http://"+ln.Addr().String(), targets, concurrency, duration)
+	if err != nil {
+		return err
+	}
+	wsfn.WriteLoadReport(out, results)
+	return nil
+}
+
+// logStats reports a usage summary of the log files in args.
+func logStats(out io.Writer, in io.Reader, args []string) error {
+	stats := new(wsfn.LogStats)
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	for _, fName := range args {
+		if fName == "-" {
+			if err := stats.ReadLogStats(in); err != nil {
+				return err
+			}
+			continue
+		}
+		fp, err := os.Open(fName)
+		if err != nil {
+			return err
+		}
+		err = stats.ReadLogStats(fp)
+		fp.Close()
+		if err != nil {
+			return fmt.Errorf("%s, %s", fName, err)
+		}
+	}
+	stats.WriteReport(out, 10)
+	return nil
+}
+
 func main() {
 	appName := path.Base(os.Args[0])
 	// NOTE: The following are set when version.go is generated
@@ -415,6 +829,10 @@ func main() {
 	flag.BoolVar(&showVersion, "version", false, "display version")
 	flag.BoolVar(&quiet, "quiet", false, "suppress error messages")
 	flag.StringVar(&outputFName, "o", "", "write output to filename")
+	flag.BoolVar(&liveReload, "livereload", false, "reload browsers when files change (start)")
+	flag.BoolVar(&openBrowser, "open", false, "open the site in your web browser (start)")
+	flag.StringVar(&listenHost, "host", "", "host or address to listen on, e.g. 0.0.0.0 (start)")
+	flag.StringVar(&listenPort, "port", "", "port to listen on, 0 or auto picks a free port (start)")
 
 	flag.Parse()
 	args := flag.Args()
@@ -432,7 +850,7 @@ func main() {
 		os.Exit(0)
 	}
 	if showLicense {
-		fmt.Fprintln(out, wsfn.LicenseText)
+		fmt.Fprint(out, wsfn.LicenseText)
 		os.Exit(0)
 	}
 	if showVersion {
@@ -490,6 +908,36 @@ func main() {
 			fmt.Fprintf(eout, "%s\n", err)
 			os.Exit(1)
 		}
+	case "install-service":
+		if err := installService(out, appName, args); err != nil {
+			fmt.Fprintf(eout, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "check":
+		if err := checkService(out, args); err != nil {
+			fmt.Fprintf(eout, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "sign":
+		if err := signURL(out, args); err != nil {
+			fmt.Fprintf(eout, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "logstats":
+		if err := logStats(out, os.Stdin, args); err != nil {
+			fmt.Fprintf(eout, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "selftest":
+		if err := selfTest(out, args); err != nil {
+			fmt.Fprintf(eout, "%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
 	case "start":
 		if err := startService(args); err != nil {
 			fmt.Fprintf(eout, "%s\n", err)
@@ -0,0 +1,143 @@
+// configfile.go writes configuration files atomically keeping backups.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	// 3rd Party packages
+	"github.com/BurntSushi/toml"
+)
+
+// ConfigBackups is how many previous versions WriteConfigFile keeps,
+// e.g. "access.toml.bak", "access.toml.bak.1" and "access.toml.bak.2".
+// Zero keeps none.
+var ConfigBackups = 3
+
+// decodeConfig parses src in format ("toml" or "json") into v.
+func decodeConfig(src []byte, format string, v interface{}) error {
+	switch formatOf(format) {
+	case "toml":
+		_, err := toml.NewDecoder(bytes.NewReader(src)).Decode(v)
+		return err
+	case "json":
+		return json.Unmarshal(src, v)
+	default:
+		return fmt.Errorf("%q, unsupported format", format)
+	}
+}
+
+// backupName returns the name of the i-th backup of fName.
+func backupName(fName string, i int) string {
+	if i == 0 {
+		return fName + ".bak"
+	}
+	return fmt.Sprintf("%s.bak.%d", fName, i)
+}
+
+// backupConfig rotates the backups of fName and makes fName the
+// newest. fName itself is left in place.
+func backupConfig(fName string) error {
+	if ConfigBackups <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(fName); os.IsNotExist(err) {
+		return nil
+	}
+	for i := ConfigBackups - 1; i > 0; i-- {
+		if err := os.Rename(backupName(fName, i-1), backupName(fName, i)); err != nil && os.IsNotExist(err) == false {
+			return err
+		}
+	}
+	bak := backupName(fName, 0)
+	if err := os.Remove(bak); err != nil && os.IsNotExist(err) == false {
+		return err
+	}
+	// A hard link keeps the old file without a moment where fName
+	// is missing, copy where links aren't supported.
+	if err := os.Link(fName, bak); err == nil {
+		return nil
+	}
+	in, err := os.Open(fName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(bak, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// WriteConfigFile replaces fName with src so a crash can't leave
+// it half written. When verify is given src is checked with it
+// first, e.g. that it parses. The previous file is kept as a
+// backup (see ConfigBackups) and its permissions are kept, perm is
+// used for new files. src is written to a temporary file in the
+// same directory which is renamed over fName.
+func WriteConfigFile(fName string, src []byte, perm os.FileMode, verify func([]byte) error) error {
+	if verify != nil {
+		if err := verify(src); err != nil {
+			return fmt.Errorf("%s not written, %s", fName, err)
+		}
+	}
+	return writeFile(fName, src, perm, true)
+}
+
+// writeFile replaces fName with src through a renamed temporary
+// file, keeping backups when backup is set.
+func writeFile(fName string, src []byte, perm os.FileMode, backup bool) error {
+	if info, err := os.Stat(fName); err == nil {
+		perm = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fName), "."+filepath.Base(fName)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if backup {
+		if err := backupConfig(fName); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), fName)
+}
@@ -0,0 +1,79 @@
+// configfile_test.go tests writing configuration files atomically with backups.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	fName := filepath.Join(dir, "access.toml")
+	a := &Access{AuthType: "basic", Encryption: "md5"}
+	for i := 0; i < 5; i++ {
+		a.AuthName = fmt.Sprintf("version %d", i)
+		if err := a.DumpAccess(fName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The current file and the three previous versions.
+	expected := map[string]string{
+		"access.toml":       "version 4",
+		"access.toml.bak":   "version 3",
+		"access.toml.bak.1": "version 2",
+		"access.toml.bak.2": "version 1",
+	}
+	for name, authName := range expected {
+		fp, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s, %s", name, err)
+			continue
+		}
+		saved, err := LoadAccessFrom(fp, "toml")
+		fp.Close()
+		if err != nil {
+			t.Errorf("%s, %s", name, err)
+			continue
+		}
+		if saved.AuthName != authName {
+			t.Errorf("%s expected %q, got %q", name, authName, saved.AuthName)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != len(expected) {
+		t.Errorf("expected %d files, got %d", len(expected), len(entries))
+	}
+	info, _ := os.Stat(fName)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected 0600, got %s", info.Mode())
+	}
+
+	// Content that doesn't parse is refused, leaving the file alone.
+	verify := func(src []byte) error {
+		return decodeConfig(src, "toml", new(Access))
+	}
+	if err := WriteConfigFile(fName, []byte("auth_type = "), 0600, verify); err == nil {
+		t.Errorf("expected an error for invalid content")
+	}
+	if saved, _ := LoadAccess(fName); saved == nil || saved.AuthName != "version 4" {
+		t.Errorf("expected the file to be left alone")
+	}
+}
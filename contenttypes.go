@@ -0,0 +1,312 @@
+// contenttypes.go decides the Content-Type of static files using the
+// configured ContentTypes, a fallback and charset rules.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// MIME fallback behaviors for files whose extension has no known type.
+const (
+	// MimeSniff lets Go detect the type from the content (default).
+	MimeSniff = "sniff"
+	// MimeReject answers 415 Unsupported Media Type.
+	MimeReject = "reject"
+)
+
+// DefaultContentTypes are the built in types of extensions the
+// system's MIME tables may not know or get wrong. Configured
+// ContentTypes take precedence.
+var DefaultContentTypes = map[string]string{
+	".avif":        "image/avif",
+	".webp":        "image/webp",
+	".woff2":       "font/woff2",
+	".webmanifest": "application/manifest+json",
+	".ts":          "application/typescript",
+	".mts":         "application/typescript",
+	".wasm":        "application/wasm",
+}
+
+// binaryTypes are never given a charset parameter, e.g.
+// WebAssembly.instantiateStreaming() requires exactly
+// "application/wasm".
+var binaryTypes = map[string]bool{
+	"application/wasm": true,
+}
+
+// DefaultGzipExtensions are the extensions whose precompressed
+// ".gz" files are sent gzip encoded with the underlying type when
+// WebService.GzipExtensions isn't set.
+var DefaultGzipExtensions = []string{".html", ".css", ".js", ".mjs", ".json", ".svg", ".txt", ".xml"}
+
+// contentTypes holds the Content-Type rules of a WebService.
+type contentTypes struct {
+	types      map[string]string
+	prefixes   []string
+	forced     map[string]string
+	fallback   string
+	charsets   map[string]string
+	gzipExts   map[string]bool
+	gzipStatic bool
+	strict     bool
+	fs         http.FileSystem
+}
+
+// gzipExtensions returns the extensions as a set.
+func gzipExtensions(exts []string) map[string]bool {
+	m := map[string]bool{}
+	for _, ext := range exts {
+		ext = strings.ToLower(ext)
+		if strings.HasPrefix(ext, ".") == false {
+			ext = "." + ext
+		}
+		m[ext] = true
+	}
+	return m
+}
+
+// precompressed reports if p names a precompressed file of one of
+// exts, e.g. "/data.json.gz", returning the underlying path.
+func precompressed(p string, exts map[string]bool) (string, bool) {
+	if strings.HasSuffix(strings.ToLower(p), ".gz") == false {
+		return "", false
+	}
+	underlying := p[:len(p)-3]
+	return underlying, exts[strings.ToLower(path.Ext(underlying))]
+}
+
+// exists reports if p is a file in the file system.
+func (ct *contentTypes) exists(p string) bool {
+	if ct.fs == nil {
+		return false
+	}
+	f, err := ct.fs.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	return err == nil && info.IsDir() == false
+}
+
+// newContentTypes normalizes the configured rules.
+func (w *WebService) newContentTypes() (*contentTypes, error) {
+	ct := &contentTypes{types: map[string]string{}, forced: map[string]string{}, charsets: map[string]string{}}
+	if w.GzipExtensions != nil {
+		ct.gzipExts = gzipExtensions(w.GzipExtensions)
+	} else {
+		ct.gzipExts = gzipExtensions(DefaultGzipExtensions)
+	}
+	ct.gzipStatic = w.GzipStatic
+	ct.strict = w.StrictMime
+	for ext, mimeType := range w.ContentTypes {
+		ext = strings.ToLower(ext)
+		if strings.HasPrefix(ext, ".") == false {
+			ext = "." + ext
+		}
+		ct.types[ext] = mimeType
+	}
+	for prefix, mimeType := range w.ContentTypePrefixes {
+		if _, _, err := mime.ParseMediaType(mimeType); err != nil {
+			return nil, fmt.Errorf("content_type_prefixes %q, %s", prefix, err)
+		}
+		ct.prefixes = append(ct.prefixes, prefix)
+		ct.forced[prefix] = mimeType
+	}
+	// Longest prefix first so the most specific rule wins.
+	sort.Slice(ct.prefixes, func(i, j int) bool {
+		return len(ct.prefixes[i]) > len(ct.prefixes[j])
+	})
+	switch strings.ToLower(w.MimeFallback) {
+	case "", MimeSniff:
+		ct.fallback = MimeSniff
+	case MimeReject, "415":
+		ct.fallback = MimeReject
+	default:
+		if _, _, err := mime.ParseMediaType(w.MimeFallback); err != nil {
+			return nil, fmt.Errorf("mime_fallback %q, %s", w.MimeFallback, err)
+		}
+		ct.fallback = w.MimeFallback
+	}
+	for pattern, charset := range w.Charsets {
+		ct.charsets[strings.ToLower(pattern)] = charset
+	}
+	return ct, nil
+}
+
+// typeByPrefix returns the type forced for the path, if any.
+func (ct *contentTypes) typeByPrefix(p string) (string, bool) {
+	for _, prefix := range ct.prefixes {
+		if strings.HasPrefix(p, prefix) {
+			return ct.forced[prefix], true
+		}
+	}
+	return "", false
+}
+
+// typeByExtension returns the type for a file extension from the
+// configured ContentTypes or the system's MIME types.
+func (ct *contentTypes) typeByExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if mimeType, ok := ct.types[ext]; ok {
+		return mimeType
+	}
+	if mimeType, ok := DefaultContentTypes[ext]; ok {
+		return mimeType
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// charset returns the charset configured for mimeType matching an
+// exact type (e.g. "application/json") before a wildcard ("text/*").
+func (ct *contentTypes) charset(mimeType string) string {
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	// multipart/byteranges parts carry their own Content-Type.
+	if err != nil || params["charset"] != "" || binaryTypes[mediaType] || strings.HasPrefix(mediaType, "multipart/") {
+		return ""
+	}
+	if charset, ok := ct.charsets[mediaType]; ok {
+		return charset
+	}
+	if i := strings.Index(mediaType, "/"); i > 0 {
+		return ct.charsets[mediaType[:i]+"/*"]
+	}
+	return ""
+}
+
+// withCharset adds the configured charset parameter to mimeType.
+func (ct *contentTypes) withCharset(mimeType string) string {
+	if charset := ct.charset(mimeType); charset != "" {
+		return mimeType + "; charset=" + charset
+	}
+	return mimeType
+}
+
+// charsetWriter adds the configured charset when the handler
+// (e.g. http.FileServer sniffing content) sets the Content-Type.
+type charsetWriter struct {
+	http.ResponseWriter
+	ct      *contentTypes
+	written bool
+}
+
+func (cw *charsetWriter) WriteHeader(status int) {
+	if cw.written == false {
+		cw.written = true
+		if mimeType := cw.Header().Get("Content-Type"); mimeType != "" {
+			cw.Header().Set("Content-Type", cw.ct.withCharset(mimeType))
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *charsetWriter) Write(p []byte) (int, error) {
+	if cw.written == false {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *charsetWriter) ReadFrom(src io.Reader) (int64, error) {
+	if cw.written == false {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return readFrom(cw.ResponseWriter, src)
+}
+
+// Handler sets the Content-Type of static files before calling next
+// (e.g. http.FileServer). Precompressed files (e.g. "data.json.gz")
+// are sent gzip encoded with the underlying type, with gzipStatic a
+// request for "data.json" is answered from "data.json.gz" when the
+// client accepts gzip. A path prefix rule overrides the extension,
+// the fallback applies to unknown extensions. Other paths without an
+// extension (e.g. directories) are left to next.
+func (ct *contentTypes) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if underlying, ok := precompressed(p, ct.gzipExts); ok {
+			w.Header().Set("Content-Encoding", "gzip")
+			p = underlying
+		} else if ct.gzipStatic && ct.gzipExts[strings.ToLower(path.Ext(p))] {
+			w.Header().Add("Vary", "Accept-Encoding")
+			// Ranges are of the file itself so resumed downloads
+			// and viewers get the same bytes whatever they accept.
+			if acceptsEncoding(r, "gzip") && r.Header.Get("Range") == "" && ct.exists(p+".gz") {
+				w.Header().Set("Content-Encoding", "gzip")
+				r = r.Clone(r.Context())
+				r.URL.Path, r.URL.RawPath = p+".gz", ""
+			}
+		}
+		if ct.strict {
+			// Only files with a configured extension are served,
+			// others are not found so stray files aren't revealed.
+			if _, ok := ct.types[strings.ToLower(path.Ext(p))]; ok == false && ct.exists(r.URL.Path) {
+				httpError(w, r, http.StatusNotFound, fmt.Errorf("%q is not a configured content type", path.Ext(p)))
+				return
+			}
+		}
+		if mimeType, ok := ct.typeByPrefix(p); ok {
+			// Browsers must not second guess a forced type.
+			w.Header().Set("Content-Type", mimeType)
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+		} else if ext := path.Ext(p); ext != "" {
+			if mimeType := ct.typeByExtension(ext); mimeType != "" {
+				w.Header().Set("Content-Type", mimeType)
+			} else {
+				switch ct.fallback {
+				case MimeSniff:
+				case MimeReject:
+					httpError(w, r, http.StatusUnsupportedMediaType, fmt.Errorf("%q has no known content type", ext))
+					return
+				default:
+					w.Header().Set("Content-Type", ct.fallback)
+				}
+			}
+		}
+		if len(ct.charsets) > 0 {
+			w = &charsetWriter{ResponseWriter: w, ct: ct}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// staticHandler returns the handler serving files from fs with the
+// WebService's content type rules.
+func (w *WebService) staticHandler(fs http.FileSystem) (http.Handler, error) {
+	ct, err := w.newContentTypes()
+	if err != nil {
+		return nil, err
+	}
+	ct.fs = fs
+	files := http.FileServer(fs)
+	if w.DirectoryListing {
+		files = ListingHandler(fs, files)
+	}
+	h := ct.Handler(fileETagHandler(fs, ProblemHandler(files)))
+	if h, err = MethodsHandler(map[string][]string{"/": StaticMethods}, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
@@ -0,0 +1,194 @@
+// contenttypes_test.go tests the static file Content-Type rules.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// serveStatic returns the status and Content-Type for p served by ws.
+func serveStatic(t *testing.T, ws *WebService, p string) (int, string) {
+	t.Helper()
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+	return rec.Code, rec.Header().Get("Content-Type")
+}
+
+func TestContentTypes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"page.html":   "<html><body>Hello</body></html>",
+		"data.toml":   "title = \"x\"",
+		"notes.xyzzy": "plain text notes",
+		"legacy.txt":  "caf\xe9",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws := &WebService{DocRoot: dir, ContentTypes: map[string]string{"toml": "text/plain+x-toml"}}
+	if _, ct := serveStatic(t, ws, "/data.toml"); ct != "text/plain+x-toml" {
+		t.Errorf("expected configured type, got %q", ct)
+	}
+	if _, ct := serveStatic(t, ws, "/notes.xyzzy"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("expected sniffed type, got %q", ct)
+	}
+
+	ws.MimeFallback = "application/octet-stream"
+	if _, ct := serveStatic(t, ws, "/notes.xyzzy"); ct != "application/octet-stream" {
+		t.Errorf("expected fallback type, got %q", ct)
+	}
+	ws.MimeFallback = "reject"
+	if status, _ := serveStatic(t, ws, "/notes.xyzzy"); status != http.StatusUnsupportedMediaType {
+		t.Errorf("expected %d, got %d", http.StatusUnsupportedMediaType, status)
+	}
+	if status, _ := serveStatic(t, ws, "/page.html"); status != http.StatusOK {
+		t.Errorf("expected known types served, got %d", status)
+	}
+
+	ws.MimeFallback = ""
+	ws.Charsets = map[string]string{"text/*": "iso-8859-1", "text/plain+x-toml": "utf-8"}
+	ws.ContentTypes[".txt"] = "text/plain"
+	if _, ct := serveStatic(t, ws, "/legacy.txt"); ct != "text/plain; charset=iso-8859-1" {
+		t.Errorf("expected wildcard charset, got %q", ct)
+	}
+	if _, ct := serveStatic(t, ws, "/data.toml"); ct != "text/plain+x-toml; charset=utf-8" {
+		t.Errorf("expected exact charset, got %q", ct)
+	}
+	// Types that already name a charset are left alone.
+	if _, ct := serveStatic(t, ws, "/page.html"); ct != "text/html; charset=utf-8" {
+		t.Errorf("expected html charset unchanged, got %q", ct)
+	}
+
+	// Prefix rules override the extension, longest prefix first.
+	os.MkdirAll(filepath.Join(dir, "downloads", "html"), 0775)
+	os.WriteFile(filepath.Join(dir, "downloads", "page.html"), []byte(files["page.html"]), 0664)
+	os.WriteFile(filepath.Join(dir, "downloads", "html", "page.html"), []byte(files["page.html"]), 0664)
+	ws.Charsets = nil
+	ws.ContentTypePrefixes = map[string]string{"/downloads/": "application/octet-stream", "/downloads/html/": "text/html"}
+	if _, ct := serveStatic(t, ws, "/downloads/page.html"); ct != "application/octet-stream" {
+		t.Errorf("expected forced type, got %q", ct)
+	}
+	if _, ct := serveStatic(t, ws, "/downloads/html/page.html"); ct != "text/html" {
+		t.Errorf("expected longest prefix type, got %q", ct)
+	}
+}
+
+func TestPrecompressed(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"data.json.gz", "app.js", "app.js.gz"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("\x1f\x8b"), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws := &WebService{DocRoot: dir}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/data.json.gz", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected underlying type, got %q", ct)
+	}
+	if ce := rec.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("expected gzip encoding, got %q", ce)
+	}
+
+	ws.GzipStatic = true
+	h, err = ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, accept := range []string{"gzip, deflate", "br;q=1.0, gzip;q=0", ""} {
+		req := httptest.NewRequest("GET", "/app.js", nil)
+		req.Header.Set("Accept-Encoding", accept)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		gzipped := rec.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != (accept == "gzip, deflate") {
+			t.Errorf("Accept-Encoding %q, unexpected Content-Encoding %q", accept, rec.Header().Get("Content-Encoding"))
+		}
+		if vary := rec.Header().Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("expected Vary: Accept-Encoding, got %q", vary)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/javascript; charset=utf-8" {
+			t.Errorf("expected javascript type, got %q", ct)
+		}
+	}
+}
+
+func TestDefaultContentTypes(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"photo.avif", "font.woff2", "site.webmanifest", "mod.mts", "app.wasm"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("\x00\x61\x73\x6d"), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws := &WebService{DocRoot: dir, Charsets: map[string]string{"application/*": "utf-8"}}
+	expected := map[string]string{
+		"/photo.avif":       "image/avif",
+		"/font.woff2":       "font/woff2",
+		"/site.webmanifest": "application/manifest+json; charset=utf-8",
+		"/mod.mts":          "application/typescript; charset=utf-8",
+		"/app.wasm":         "application/wasm",
+	}
+	for p, expect := range expected {
+		if _, ct := serveStatic(t, ws, p); ct != expect {
+			t.Errorf("%s, expected %q, got %q", p, expect, ct)
+		}
+	}
+	ws.ContentTypes = map[string]string{".ts": "video/mp2t"}
+	if err := os.WriteFile(filepath.Join(dir, "clip.ts"), []byte("G"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	if _, ct := serveStatic(t, ws, "/clip.ts"); ct != "video/mp2t" {
+		t.Errorf("expected configured type to win, got %q", ct)
+	}
+}
+
+func TestStrictMime(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"index.html", "db.sql", "index.html.bak", "README"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("text"), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws := &WebService{DocRoot: dir, StrictMime: true, ContentTypes: map[string]string{".html": "text/html"}}
+	expected := map[string]int{
+		"/":               http.StatusOK,
+		"/index.html":     http.StatusMovedPermanently,
+		"/db.sql":         http.StatusNotFound,
+		"/index.html.bak": http.StatusNotFound,
+		"/README":         http.StatusNotFound,
+	}
+	for p, expect := range expected {
+		if status, _ := serveStatic(t, ws, p); status != expect {
+			t.Errorf("%s, expected %d, got %d", p, expect, status)
+		}
+	}
+}
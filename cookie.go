@@ -0,0 +1,211 @@
+// cookie.go provides signed and encrypted cookies with key rotation.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCookie is returned when a cookie fails verification,
+// decryption or has expired.
+var ErrInvalidCookie = errors.New("invalid cookie")
+
+// MinCookieKeyLength is the shortest key accepted by NewSecureCookie.
+const MinCookieKeyLength = 32
+
+// SecureCookie signs, and optionally encrypts, cookie values. The
+// first key is used for new cookies, all keys are tried when reading
+// so keys can be rotated by adding a new key to the front and
+// dropping the oldest one after MaxAge has passed.
+type SecureCookie struct {
+	// Keys are the secrets, newest first.
+	Keys [][]byte
+	// Encrypt the value (AES-GCM) as well as authenticating it.
+	Encrypt bool
+	// MaxAge if set rejects cookies older than this.
+	MaxAge time.Duration
+}
+
+// GenerateCookieKey returns a new random key suitable for SecureCookie.
+func GenerateCookieKey() ([]byte, error) {
+	key := make([]byte, MinCookieKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewSecureCookie returns a *SecureCookie signing with keys.
+func NewSecureCookie(keys ...[]byte) (*SecureCookie, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one cookie key is required")
+	}
+	for i, key := range keys {
+		if len(key) < MinCookieKeyLength {
+			return nil, fmt.Errorf("cookie key %d is shorter than %d bytes", i, MinCookieKeyLength)
+		}
+	}
+	return &SecureCookie{Keys: keys}, nil
+}
+
+// deriveKey returns a purpose specific key so the same secret is
+// never used for both signing and encryption.
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("wsfn cookie " + purpose))
+	return mac.Sum(nil)
+}
+
+// cookieMAC authenticates name and payload with key.
+func cookieMAC(key []byte, name string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, deriveKey(key, "hmac"))
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// cookieAEAD returns the AES-GCM cipher for key.
+func cookieAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(key, "encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encode returns value encoded for the cookie called name.
+func (sc *SecureCookie) Encode(name string, value []byte) (string, error) {
+	if len(sc.Keys) == 0 {
+		return "", fmt.Errorf("no cookie keys")
+	}
+	key := sc.Keys[0]
+	payload := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().Unix()))
+	payload = append(payload, value...)
+	if sc.Encrypt {
+		aead, err := cookieAEAD(key)
+		if err != nil {
+			return "", err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = aead.Seal(nonce, nonce, payload, []byte(name))
+	}
+	mac := cookieMAC(key, name, payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac), nil
+}
+
+// Decode verifies and returns the value of the cookie called name.
+func (sc *SecureCookie) Decode(name string, encoded string) ([]byte, error) {
+	parts := strings.Split(encoded, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCookie
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+	for _, key := range sc.Keys {
+		if hmac.Equal(mac, cookieMAC(key, name, payload)) == false {
+			continue
+		}
+		if sc.Encrypt {
+			aead, err := cookieAEAD(key)
+			if err != nil {
+				return nil, err
+			}
+			if len(payload) < aead.NonceSize() {
+				return nil, ErrInvalidCookie
+			}
+			nonce, sealed := payload[:aead.NonceSize()], payload[aead.NonceSize():]
+			if payload, err = aead.Open(nil, nonce, sealed, []byte(name)); err != nil {
+				return nil, ErrInvalidCookie
+			}
+		}
+		if len(payload) < 8 {
+			return nil, ErrInvalidCookie
+		}
+		if sc.MaxAge > 0 {
+			created := time.Unix(int64(binary.BigEndian.Uint64(payload[:8])), 0)
+			if time.Since(created) > sc.MaxAge {
+				return nil, fmt.Errorf("%w, expired", ErrInvalidCookie)
+			}
+		}
+		return payload[8:], nil
+	}
+	return nil, ErrInvalidCookie
+}
+
+// SetCookie encodes value into c and adds it to the response. Path
+// defaults to "/", HttpOnly is always set and SameSite defaults to Lax.
+// When MaxAge is set and c.MaxAge isn't the cookie expires with it.
+func (sc *SecureCookie) SetCookie(w http.ResponseWriter, c *http.Cookie, value []byte) error {
+	encoded, err := sc.Encode(c.Name, value)
+	if err != nil {
+		return err
+	}
+	cookie := *c
+	cookie.Value = encoded
+	cookie.HttpOnly = true
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if cookie.SameSite == http.SameSiteDefaultMode {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	if cookie.MaxAge == 0 && sc.MaxAge > 0 {
+		cookie.MaxAge = int(sc.MaxAge.Seconds())
+	}
+	http.SetCookie(w, &cookie)
+	return nil
+}
+
+// ReadCookie returns the verified value of the cookie called name.
+func (sc *SecureCookie) ReadCookie(r *http.Request, name string) ([]byte, error) {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return nil, err
+	}
+	return sc.Decode(name, c.Value)
+}
+
+// ClearCookie expires the cookie called name.
+func ClearCookie(w http.ResponseWriter, name string, cookiePath string) {
+	if cookiePath == "" {
+		cookiePath = "/"
+	}
+	http.SetCookie(w, &http.Cookie{Name: name, Path: cookiePath, MaxAge: -1, HttpOnly: true})
+}
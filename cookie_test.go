@@ -0,0 +1,81 @@
+// cookie_test.go tests SecureCookie.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecureCookie(t *testing.T) {
+	oldKey, _ := GenerateCookieKey()
+	newKey, _ := GenerateCookieKey()
+	if _, err := NewSecureCookie([]byte("short")); err == nil {
+		t.Errorf("expected short key to be rejected")
+	}
+	for _, encrypt := range []bool{false, true} {
+		old, _ := NewSecureCookie(oldKey)
+		old.Encrypt = encrypt
+		rec := httptest.NewRecorder()
+		if err := old.SetCookie(rec, &http.Cookie{Name: "session"}, []byte("Jane.Doe")); err != nil {
+			t.Fatal(err)
+		}
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].HttpOnly == false || cookies[0].Path != "/" {
+			t.Fatalf("unexpected cookies %+v", cookies)
+		}
+		if encrypt && bytes.Contains([]byte(cookies[0].Value), []byte("Jane")) {
+			t.Errorf("expected encrypted value, got %q", cookies[0].Value)
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(cookies[0])
+
+		// After rotation cookies signed with the old key still verify.
+		rotated, _ := NewSecureCookie(newKey, oldKey)
+		rotated.Encrypt = encrypt
+		if value, err := rotated.ReadCookie(req, "session"); err != nil || string(value) != "Jane.Doe" {
+			t.Errorf("encrypt %t, expected Jane.Doe, got %q, %v", encrypt, value, err)
+		}
+		// Once the old key is dropped they don't.
+		current, _ := NewSecureCookie(newKey)
+		current.Encrypt = encrypt
+		if _, err := current.ReadCookie(req, "session"); errors.Is(err, ErrInvalidCookie) == false {
+			t.Errorf("encrypt %t, expected ErrInvalidCookie, got %v", encrypt, err)
+		}
+		// A cookie can't be replayed under another name.
+		if _, err := rotated.Decode("other", cookies[0].Value); errors.Is(err, ErrInvalidCookie) == false {
+			t.Errorf("encrypt %t, expected ErrInvalidCookie for renamed cookie, got %v", encrypt, err)
+		}
+	}
+
+	// Expired cookies are rejected.
+	sc, _ := NewSecureCookie(newKey)
+	sc.MaxAge = time.Hour
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().Add(-2*time.Hour).Unix()))
+	encoded := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(cookieMAC(newKey, "session", payload))
+	if _, err := sc.Decode("session", encoded); errors.Is(err, ErrInvalidCookie) == false {
+		t.Errorf("expected expired cookie to be rejected, got %v", err)
+	}
+}
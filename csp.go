@@ -0,0 +1,100 @@
+// csp.go provides Content-Security-Policy headers with a per request
+// nonce for inline scripts and styles.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// DefaultCSP is used when CSPPolicy.Policy is empty.
+const DefaultCSP = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; object-src 'none'; base-uri 'self'"
+
+// CSPPolicy sets a Content-Security-Policy header on each response.
+// Each occurrence of "{nonce}" in Policy is replaced by a new random
+// nonce per request, available to handlers via CSPNonce(r) or the
+// "cspNonce" template function from CSPFuncMap(r), e.g.
+//
+//	<script nonce="{{ cspNonce }}">...</script>
+type CSPPolicy struct {
+	// Policy is the header value, DefaultCSP if empty.
+	Policy string `json:"policy,omitempty" toml:"policy,omitempty"`
+	// ReportOnly sends Content-Security-Policy-Report-Only instead
+	// so a policy can be tried without breaking pages.
+	ReportOnly bool `json:"report_only,omitempty" toml:"report_only,omitempty"`
+}
+
+type cspNonceKey struct{}
+
+// newCSPNonce returns a 128 bit random value, base64url encoded so
+// templates needn't escape it.
+func newCSPNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CSPNonce returns the nonce for the request, an empty string if
+// the request didn't pass through a CSPPolicy handler.
+func CSPNonce(r *http.Request) string {
+	if nonce, ok := r.Context().Value(cspNonceKey{}).(string); ok {
+		return nonce
+	}
+	return ""
+}
+
+// CSPFuncMap returns template functions for the request, "cspNonce"
+// returns the request's nonce.
+func CSPFuncMap(r *http.Request) template.FuncMap {
+	nonce := CSPNonce(r)
+	return template.FuncMap{
+		"cspNonce": func() string { return nonce },
+	}
+}
+
+// Handler sets the Content-Security-Policy header and adds the
+// nonce to the request context before calling next.
+func (csp *CSPPolicy) Handler(next http.Handler) http.Handler {
+	if csp == nil {
+		return next
+	}
+	policy := csp.Policy
+	if policy == "" {
+		policy = DefaultCSP
+	}
+	header := "Content-Security-Policy"
+	if csp.ReportOnly {
+		header = "Content-Security-Policy-Report-Only"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce, err := newCSPNonce()
+		if err != nil {
+			httpError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set(header, strings.ReplaceAll(policy, "{nonce}", nonce))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce)))
+	})
+}
@@ -0,0 +1,60 @@
+// csp_test.go tests CSPPolicy.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSPPolicy(t *testing.T) {
+	tmpl := `<script nonce="{{ cspNonce }}">alert(1)</script>`
+	csp := &CSPPolicy{}
+	h := csp.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := template.Must(template.New("page").Funcs(CSPFuncMap(r)).Parse(tmpl))
+		page.Execute(w, nil)
+	}))
+	nonces := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		header := rec.Header().Get("Content-Security-Policy")
+		body := rec.Body.String()
+		nonce := strings.TrimSuffix(strings.TrimPrefix(body, `<script nonce="`), `">alert(1)</script>`)
+		if nonce == "" || nonce == body {
+			t.Fatalf("expected a nonce in %q", body)
+		}
+		if strings.Contains(header, "'nonce-"+nonce+"'") == false {
+			t.Errorf("expected nonce %q in %q", nonce, header)
+		}
+		nonces[nonce] = true
+	}
+	if len(nonces) != 2 {
+		t.Errorf("expected a new nonce per request")
+	}
+
+	csp = &CSPPolicy{Policy: "script-src 'nonce-{nonce}'", ReportOnly: true}
+	rec := httptest.NewRecorder()
+	csp.Handler(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Header().Get("Content-Security-Policy-Report-Only") == "" || rec.Header().Get("Content-Security-Policy") != "" {
+		t.Errorf("expected a report only header, got %v", rec.Header())
+	}
+}
@@ -0,0 +1,187 @@
+// dataset.go serves a dataset collection, or any directory of JSON
+// documents, as a read only JSON API.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDatasetIndexSeconds is how long the key index is reused
+// before the collection is scanned again.
+const DefaultDatasetIndexSeconds = 60
+
+// DatasetService mounts a dataset collection at Prefix. A GET of the
+// prefix lists the keys (paginated with page and size), a GET of
+// Prefix + key returns the JSON document. Protect the prefix with an
+// Access route if the records aren't public.
+type DatasetService struct {
+	// Prefix is the URL path prefix, e.g. "/api/people/".
+	Prefix string `json:"prefix" toml:"prefix"`
+	// Path to the collection (e.g. "people.ds") or a directory of
+	// JSON documents. A dataset collection.json "keymap" is used when
+	// present, otherwise each "*.json" file is a record keyed by
+	// its name without the extension.
+	Path string `json:"path" toml:"path"`
+	// IndexSeconds is how long the key index is cached,
+	// DefaultDatasetIndexSeconds if not set.
+	IndexSeconds int `json:"index_seconds,omitempty" toml:"index_seconds,omitempty"`
+
+	mu      sync.Mutex
+	keys    []string
+	files   map[string]string
+	indexed time.Time
+}
+
+// DatasetKeys is the response listing a collection's keys.
+type DatasetKeys struct {
+	Keys  []string `json:"keys"`
+	Total int      `json:"total"`
+	Page  *Page    `json:"page"`
+}
+
+// datasetCollection holds the parts of collection.json we use.
+type datasetCollection struct {
+	KeyMap map[string]string `json:"keymap"`
+}
+
+// scan builds the key to file index.
+func (ds *DatasetService) scan() ([]string, map[string]string, error) {
+	files := map[string]string{}
+	src, err := os.ReadFile(filepath.Join(ds.Path, "collection.json"))
+	if err == nil {
+		c := new(datasetCollection)
+		if err := json.Unmarshal(src, c); err != nil {
+			return nil, nil, fmt.Errorf("%s, %s", ds.Path, err)
+		}
+		for key, p := range c.KeyMap {
+			name := filepath.Join(ds.Path, filepath.FromSlash(p))
+			if filepath.Ext(name) != ".json" {
+				name = filepath.Join(name, key+".json")
+			}
+			files[key] = name
+		}
+	}
+	if len(files) == 0 {
+		err = filepath.WalkDir(ds.Path, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && name != ds.Path && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if d.IsDir() || filepath.Ext(name) != ".json" || d.Name() == "collection.json" || strings.HasPrefix(d.Name(), ".") {
+				return nil
+			}
+			files[strings.TrimSuffix(d.Name(), ".json")] = name
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, files, nil
+}
+
+// index returns the cached key index, scanning when it is stale.
+func (ds *DatasetService) index() ([]string, map[string]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ttl := time.Duration(ds.IndexSeconds) * time.Second
+	if ds.IndexSeconds <= 0 {
+		ttl = DefaultDatasetIndexSeconds * time.Second
+	}
+	if ds.files == nil || time.Since(ds.indexed) > ttl {
+		keys, files, err := ds.scan()
+		if err != nil {
+			return nil, nil, err
+		}
+		ds.keys, ds.files, ds.indexed = keys, files, time.Now()
+	}
+	return ds.keys, ds.files, nil
+}
+
+// Handler serves the collection below Prefix passing other requests
+// to next.
+func (ds *DatasetService) Handler(next http.Handler) http.Handler {
+	prefix := "/" + strings.Trim(ds.Prefix, "/") + "/"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != strings.TrimSuffix(prefix, "/") && strings.HasPrefix(r.URL.Path, prefix) == false {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			JSONError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("%s is read only", prefix))
+			return
+		}
+		keys, files, err := ds.index()
+		if err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, prefix)
+		if key == "" || r.URL.Path == strings.TrimSuffix(prefix, "/") {
+			p, err := ParsePage(r)
+			if err != nil {
+				JSONError(w, r, http.StatusBadRequest, err)
+				return
+			}
+			start, end := p.Offset(), p.Offset()+p.Size
+			if start < 0 || start > len(keys) {
+				start = len(keys)
+			}
+			if end < start || end > len(keys) {
+				end = len(keys)
+			}
+			SetLinkHeader(w, PageLinks(r, p, len(keys))...)
+			JSONResponse(w, r, http.StatusOK, &DatasetKeys{Keys: keys[start:end], Total: len(keys), Page: p})
+			return
+		}
+		// Keys are only looked up in the index, never joined to a path.
+		fName, ok := files[key]
+		if ok == false {
+			JSONError(w, r, http.StatusNotFound, fmt.Errorf("%q not found", key))
+			return
+		}
+		src, err := os.ReadFile(fName)
+		if err != nil {
+			JSONError(w, r, http.StatusNotFound, fmt.Errorf("%q not found", key))
+			return
+		}
+		if json.Valid(src) == false {
+			JSONError(w, r, http.StatusInternalServerError, fmt.Errorf("%q is not valid JSON", key))
+			return
+		}
+		JSONResponse(w, r, http.StatusOK, json.RawMessage(src))
+	})
+}
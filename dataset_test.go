@@ -0,0 +1,96 @@
+// dataset_test.go tests DatasetService.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDatasetService(t *testing.T) {
+	dir := t.TempDir()
+	for i := 1; i <= 3; i++ {
+		src := fmt.Sprintf(`{"id":"p%d","name":"Person %d"}`, i, i)
+		os.MkdirAll(filepath.Join(dir, "pairtree", fmt.Sprintf("p%d", i)), 0775)
+		if err := os.WriteFile(filepath.Join(dir, "pairtree", fmt.Sprintf("p%d", i), fmt.Sprintf("p%d.json", i)), []byte(src), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, ".secret.json"), []byte(`{}`), 0664)
+	ds := &DatasetService{Prefix: "/api/people/", Path: dir}
+	h := ds.Handler(http.NotFoundHandler())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/people/?size=2", nil))
+	keys := new(DatasetKeys)
+	if err := json.Unmarshal(rec.Body.Bytes(), keys); err != nil {
+		t.Fatalf("%s, %q", err, rec.Body.String())
+	}
+	if keys.Total != 3 || len(keys.Keys) != 2 || keys.Keys[0] != "p1" || rec.Header().Get("Link") == "" {
+		t.Errorf("unexpected listing %+v, %v", keys, rec.Header())
+	}
+
+	for query, status := range map[string]int{
+		"page=368934881474191034&size=25": http.StatusBadRequest,
+		"page=1000":                       http.StatusOK,
+	} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/people/?"+query, nil))
+		if rec.Code != status {
+			t.Errorf("%s, expected %d, got %d", query, status, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/people/p2", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == "" {
+		t.Errorf("expected record, got %d %q", rec.Code, rec.Body.String())
+	}
+	m := map[string]string{}
+	json.Unmarshal(rec.Body.Bytes(), &m)
+	if m["name"] != "Person 2" {
+		t.Errorf("unexpected record %q", rec.Body.String())
+	}
+
+	for _, p := range []string{"/api/people/.secret", "/api/people/missing", "/api/people/../p1"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s, expected %d, got %d", p, http.StatusNotFound, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/people/p1", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+
+	// A dataset keymap is used when present.
+	os.WriteFile(filepath.Join(dir, "collection.json"), []byte(`{"keymap":{"jane":"pairtree/p1/p1.json"}}`), 0664)
+	ds = &DatasetService{Prefix: "/api/people/", Path: dir}
+	rec = httptest.NewRecorder()
+	ds.Handler(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", "/api/people/jane", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected keymap record, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,108 @@
+// devlog.go writes compact, colorized request logs for development.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Log formats for WebService.LogFormat.
+const (
+	LogFormatStandard = "standard"
+	LogFormatDev      = "dev"
+)
+
+// isTerminal reports if out is a terminal (character device).
+func isTerminal(out io.Writer) bool {
+	fp, ok := out.(*os.File)
+	if ok == false {
+		return false
+	}
+	info, err := fp.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// statusColor returns the ANSI color for a status code.
+func statusColor(status int) string {
+	switch {
+	case status >= 500:
+		return "\033[31m"
+	case status >= 400:
+		return "\033[33m"
+	case status >= 300:
+		return "\033[36m"
+	}
+	return "\033[32m"
+}
+
+// roundDuration keeps about three significant digits of d.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
+
+// DevLogger logs one aligned line per request to the standard log's
+// output: time since the server started, status, method, duration,
+// bytes sent and the path. Statuses are colored when writing to a
+// terminal unless NO_COLOR is set.
+func DevLogger(next http.Handler) http.Handler {
+	out := log.Writer()
+	return DevLoggerTo(out, isTerminal(out) && os.Getenv("NO_COLOR") == "", next)
+}
+
+// DevLoggerTo is DevLogger writing to out, with color if set.
+func DevLoggerTo(out io.Writer, color bool, next http.Handler) http.Handler {
+	started := time.Now()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := newStatusWriter(w)
+		next.ServeHTTP(sw, r)
+		status := fmt.Sprintf("%d", sw.Status())
+		if color {
+			status = statusColor(sw.Status()) + status + "\033[0m"
+		}
+		fmt.Fprintf(out, "+%9.3fs %s %-7s %9s %10s %s\n", start.Sub(started).Seconds(), status, r.Method, roundDuration(time.Since(start)), humanBytes(sw.size), r.URL.RequestURI())
+	})
+}
+
+// useDevLog reports if requests are logged with DevLogger, when
+// LogFormat is "dev" or, if not set, the log is a terminal.
+func (w *WebService) useDevLog() bool {
+	if w.LogFormat == "" {
+		return isTerminal(log.Writer())
+	}
+	return w.LogFormat == LogFormatDev
+}
+
+// requestLogger wraps h in DevLogger or RequestLogger.
+func (w *WebService) requestLogger(h http.Handler) http.Handler {
+	if w.useDevLog() {
+		return skippable(MiddlewareLogging, DevLogger(h), h)
+	}
+	return skippable(MiddlewareLogging, RequestLogger(h), h)
+}
@@ -0,0 +1,58 @@
+// devlog_test.go tests the development request log.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestDevLogger(t *testing.T) {
+	out := new(bytes.Buffer)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("hello"))
+	})
+	h := DevLoggerTo(out, false, next)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/index.html?q=1", nil))
+	line := regexp.MustCompile(`^\+ +\d+\.\d{3}s 200 GET +\S+ +5 B /index\.html\?q=1\n$`)
+	if line.MatchString(out.String()) == false {
+		t.Errorf("unexpected log line %q", out.String())
+	}
+
+	out.Reset()
+	DevLoggerTo(out, true, next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/missing", nil))
+	if bytes.Contains(out.Bytes(), []byte("\033[33m404\033[0m POST")) == false {
+		t.Errorf("expected a yellow 404, got %q", out.String())
+	}
+
+	w := &WebService{LogFormat: LogFormatDev}
+	if w.useDevLog() == false {
+		t.Errorf("expected dev log format")
+	}
+	w.LogFormat = "fancy"
+	if _, err := w.Handler(); err == nil {
+		t.Errorf("expected an error for an unknown log_format")
+	}
+}
@@ -0,0 +1,247 @@
+// diagnose.go checks a WebService's configuration at startup, reporting
+// what will fail before serving anything.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Diagnostic levels.
+const (
+	DiagnosticOK      = "ok"
+	DiagnosticWarning = "warning"
+	DiagnosticError   = "error"
+)
+
+// DiagnoseTimeout limits each upstream name lookup made by Diagnose.
+var DiagnoseTimeout = 5 * time.Second
+
+// Diagnostic is the result of one startup check.
+type Diagnostic struct {
+	// Check names what was checked, e.g. "doc_root" or "upstream".
+	Check string `json:"check"`
+	// Level is DiagnosticOK, DiagnosticWarning or DiagnosticError.
+	Level string `json:"level"`
+	// Message describes the result and, for problems, what to fix.
+	Message string `json:"message"`
+}
+
+// String renders the diagnostic as a log line.
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s %s, %s", d.Check, d.Level, d.Message)
+}
+
+// diagnostics collects the results of Diagnose.
+type diagnostics []*Diagnostic
+
+func (ds *diagnostics) add(check string, level string, format string, args ...interface{}) {
+	*ds = append(*ds, &Diagnostic{Check: check, Level: level, Message: fmt.Sprintf(format, args...)})
+}
+
+// Diagnose checks the document root is readable, the certificates
+// and keys load (and when they expire), the access and redirect files
+// parse, the reverse proxy upstreams resolve and the ports can be
+// listened on. Run refuses to start if any check is a DiagnosticError.
+func (w *WebService) Diagnose() []*Diagnostic {
+	ds := diagnostics{}
+	w.diagnoseDocRoots(&ds)
+	w.diagnoseCerts(&ds)
+	w.diagnoseFiles(&ds)
+	w.diagnoseUpstreams(&ds)
+	w.diagnosePorts(&ds)
+	return ds
+}
+
+// diagnoseDocRoots checks the site's and virtual hosts' document roots.
+func (w *WebService) diagnoseDocRoots(ds *diagnostics) {
+	roots := []string{}
+	if w.S3 == nil {
+		roots = append(roots, w.DocRoot)
+	}
+	for _, vh := range w.Hosts {
+		// Wildcard hosts name a directory per subdomain.
+		if vh.DocRoot != "" && strings.Contains(vh.DocRoot, "%s") == false {
+			roots = append(roots, vh.DocRoot)
+		}
+	}
+	for _, root := range roots {
+		if _, err := os.ReadDir(root); err != nil {
+			ds.add("doc_root", DiagnosticError, "%s isn't a readable directory, %s (check htdocs and its permissions)", root, err)
+			continue
+		}
+		ds.add("doc_root", DiagnosticOK, "%s is readable", root)
+	}
+}
+
+// diagnoseCert loads a certificate and key reporting when the
+// certificate expires, a warning if within warning.
+func diagnoseCert(ds *diagnostics, name string, certPEM string, keyPEM string, warning time.Duration) {
+	if certPEM == "" || keyPEM == "" {
+		ds.add("cert", DiagnosticError, "%s needs both cert_pem and key_pem", name)
+		return
+	}
+	cert, err := tls.LoadX509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		ds.add("cert", DiagnosticError, "%s, %s and %s don't load, %s", name, certPEM, keyPEM, err)
+		return
+	}
+	leaf, err := certLeaf(&cert)
+	if err != nil {
+		ds.add("cert", DiagnosticError, "%s, %s doesn't parse, %s", name, certPEM, err)
+		return
+	}
+	notAfter := leaf.NotAfter
+	days := int(time.Until(notAfter).Hours() / 24)
+	switch {
+	case time.Now().After(notAfter):
+		ds.add("cert", DiagnosticError, "%s, %s expired %s, renew it", name, certPEM, notAfter.Format(time.RFC3339))
+	case time.Until(notAfter) < warning:
+		ds.add("cert", DiagnosticWarning, "%s, %s expires %s (%d days), renew it soon", name, certPEM, notAfter.Format(time.RFC3339), days)
+	default:
+		ds.add("cert", DiagnosticOK, "%s, %s expires %s (%d days)", name, certPEM, notAfter.Format(time.RFC3339), days)
+	}
+}
+
+// diagnoseCerts checks the https and virtual host certificates.
+func (w *WebService) diagnoseCerts(ds *diagnostics) {
+	hostCerts := false
+	for _, vh := range w.Hosts {
+		if vh.CertPEM != "" || vh.KeyPEM != "" {
+			diagnoseCert(ds, fmt.Sprintf("host %q", vh.Host), vh.CertPEM, vh.KeyPEM, w.certExpiryWarning())
+			hostCerts = true
+		}
+	}
+	// Virtual host certificates can serve https on their own.
+	if w.Https != nil && (w.Https.CertPEM != "" || w.Https.KeyPEM != "" || hostCerts == false) {
+		diagnoseCert(ds, "https", w.Https.CertPEM, w.Https.KeyPEM, w.certExpiryWarning())
+	}
+}
+
+// diagnoseFiles checks the access and redirect files parse.
+func (w *WebService) diagnoseFiles(ds *diagnostics) {
+	accessFiles := []string{}
+	if w.AccessFile != "" {
+		accessFiles = append(accessFiles, w.AccessFile)
+	}
+	for _, vh := range w.Hosts {
+		if vh.AccessFile != "" {
+			accessFiles = append(accessFiles, vh.AccessFile)
+		}
+	}
+	for _, fName := range accessFiles {
+		a, err := LoadAccess(fName)
+		if err != nil {
+			ds.add("access_file", DiagnosticError, "%s doesn't load, %s (check it with webaccess)", fName, err)
+			continue
+		}
+		ds.add("access_file", DiagnosticOK, "%s protects %d routes", fName, len(a.ListRoutes()))
+	}
+	if w.RedirectsCSV != "" {
+		redirects, err := LoadRedirects(w.RedirectsCSV)
+		if err != nil {
+			ds.add("redirects_csv", DiagnosticError, "%s", err)
+		} else {
+			ds.add("redirects_csv", DiagnosticOK, "%s has %d redirects", w.RedirectsCSV, len(redirects))
+		}
+	}
+}
+
+// diagnoseUpstreams checks the reverse proxy upstreams are URLs
+// whose host names resolve.
+func (w *WebService) diagnoseUpstreams(ds *diagnostics) {
+	upstreams := map[string]string{}
+	for prefix, upstream := range w.ReverseProxy {
+		upstreams[upstream] = prefix
+	}
+	for _, rule := range w.Route {
+		if rule.Type == RouteProxy {
+			upstreams[rule.To] = rule.Prefix
+		}
+	}
+	for prefix, canary := range w.Canaries {
+		upstreams[canary.Upstream] = prefix
+	}
+	names := make([]string, 0, len(upstreams))
+	for upstream := range upstreams {
+		names = append(names, upstream)
+	}
+	sort.Strings(names)
+	for _, upstream := range names {
+		prefix := upstreams[upstream]
+		u, err := url.Parse(upstream)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			ds.add("upstream", DiagnosticError, "%s upstream %q must be an absolute URL", prefix, upstream)
+			continue
+		}
+		host := u.Hostname()
+		if net.ParseIP(host) != nil {
+			ds.add("upstream", DiagnosticOK, "%s upstream %s", prefix, upstream)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), DiagnoseTimeout)
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		cancel()
+		if err != nil {
+			ds.add("upstream", DiagnosticError, "%s upstream %s doesn't resolve, %s (check the host name and DNS)", prefix, upstream, err)
+			continue
+		}
+		ds.add("upstream", DiagnosticOK, "%s upstream %s resolves to %s", prefix, upstream, strings.Join(addrs, ", "))
+	}
+}
+
+// diagnosePorts checks the services' addresses can be listened on.
+func (w *WebService) diagnosePorts(ds *diagnostics) {
+	for _, s := range w.services() {
+		if s.Port == "" || s.Port == "0" || s.Port == "auto" {
+			continue
+		}
+		ln, err := net.Listen("tcp", s.Hostname())
+		if err != nil {
+			ds.add("port", DiagnosticError, "can't listen on %s, %s (is another server running or does the port need privileges?)", s.Hostname(), err)
+			continue
+		}
+		ln.Close()
+		ds.add("port", DiagnosticOK, "%s is available", s.Hostname())
+	}
+}
+
+// diagnose logs the results of Diagnose returning an error listing
+// the problems that prevent starting.
+func (w *WebService) diagnose() error {
+	problems := []string{}
+	for _, d := range w.Diagnose() {
+		log.Printf("Startup check %s", d)
+		if d.Level == DiagnosticError {
+			problems = append(problems, d.Check+", "+d.Message)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("startup checks failed:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
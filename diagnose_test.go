@@ -0,0 +1,101 @@
+// diagnose_test.go tests the startup configuration checks.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, keyPEM := writeTestCert(t, dir, "library.example.edu")
+	redirects := filepath.Join(dir, "redirects.csv")
+	if err := os.WriteFile(redirects, []byte("/old/,/new/\n\"/broken,/x/\n"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	_, port, _ := net.SplitHostPort(busy.Addr().String())
+	w := &WebService{
+		DocRoot:      filepath.Join(dir, "htdocs"),
+		Http:         &Service{Scheme: "http", Host: "127.0.0.1", Port: port},
+		Https:        &Service{Scheme: "https", Host: "127.0.0.1", Port: "auto", CertPEM: certPEM, KeyPEM: keyPEM},
+		AccessFile:   filepath.Join(dir, "missing.toml"),
+		RedirectsCSV: redirects,
+		ReverseProxy: map[string]string{
+			"/api/":    "http://127.0.0.1:9000",
+			"/search/": "search:9200",
+		},
+	}
+	expected := map[string]string{
+		"doc_root":      DiagnosticError,
+		"cert":          DiagnosticWarning,
+		"access_file":   DiagnosticError,
+		"redirects_csv": DiagnosticError,
+		"port":          DiagnosticError,
+	}
+	upstreams := map[string]string{}
+	for _, d := range w.Diagnose() {
+		if d.Check == "upstream" {
+			upstreams[strings.Fields(d.Message)[0]] = d.Level
+			continue
+		}
+		if level, ok := expected[d.Check]; ok == false || level != d.Level {
+			t.Errorf("expected %s %s, got %s", d.Check, level, d)
+		}
+		delete(expected, d.Check)
+	}
+	for check := range expected {
+		t.Errorf("expected a %s check", check)
+	}
+	if upstreams["/api/"] != DiagnosticOK || upstreams["/search/"] != DiagnosticError {
+		t.Errorf("unexpected upstream checks %v", upstreams)
+	}
+
+	// Fixed, everything checks out.
+	os.Mkdir(w.DocRoot, 0775)
+	os.WriteFile(redirects, []byte("/old/,/new/\n"), 0664)
+	busy.Close()
+	w.AccessFile, w.ReverseProxy = "", nil
+	w.Https = nil
+	for _, d := range w.Diagnose() {
+		if d.Level != DiagnosticOK {
+			t.Errorf("expected ok, got %s", d)
+		}
+	}
+}
+
+func TestRunFailsFast(t *testing.T) {
+	dir := t.TempDir()
+	w := &WebService{
+		DocRoot:      dir,
+		Http:         &Service{Scheme: "http", Host: "127.0.0.1", Port: "auto"},
+		RedirectsCSV: filepath.Join(dir, "missing.csv"),
+	}
+	err := w.Run()
+	if err == nil || strings.Contains(err.Error(), "redirects_csv") == false {
+		t.Errorf("expected the redirects_csv check to stop Run, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+// expires.go handles account expiration dates.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// expired reports if the account had lapsed at now.
+func (secret *Secrets) expired(now time.Time) bool {
+	return secret.ExpiresAt != nil && now.Before(*secret.ExpiresAt) == false
+}
+
+// ParseExpires parses an expiration date, either RFC 3339 (e.g.
+// "2024-06-30T17:00:00-07:00") or a date (e.g. "2024-07-01") which
+// expires at midnight UTC starting that day. "never" or an empty
+// string returns the zero time.
+func ParseExpires(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ToLower(s) == "never" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date (YYYY-MM-DD) or RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+// SetExpires sets when username's account lapses, the zero time
+// removes the expiration. Remembered logins are forgotten.
+func (a *Access) SetExpires(username string, expires time.Time) error {
+	old, err := a.store().Lookup(username)
+	if err != nil {
+		return err
+	}
+	secret := new(Secrets)
+	*secret = *old
+	secret.ExpiresAt = nil
+	if expires.IsZero() == false {
+		t := expires.UTC()
+		secret.ExpiresAt = &t
+	}
+	if err := a.store().Update(username, secret); err != nil {
+		return err
+	}
+	a.ClearSessions(username)
+	return nil
+}
+
+// Prune removes the accounts that have expired returning their
+// usernames.
+func (a *Access) Prune() ([]string, error) {
+	usernames, err := a.store().List()
+	if err != nil {
+		return nil, err
+	}
+	now, removed := time.Now(), []string{}
+	for _, username := range usernames {
+		secret, err := a.store().Lookup(username)
+		if err != nil || secret.expired(now) == false {
+			continue
+		}
+		if err := a.RemoveUser(username); err != nil {
+			return removed, err
+		}
+		removed = append(removed, username)
+	}
+	return removed, nil
+}
@@ -0,0 +1,99 @@
+// expires_test.go tests account expiration dates.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExpires(t *testing.T) {
+	tests := map[string]string{
+		"2024-07-01":                "2024-07-01T00:00:00Z",
+		"2024-06-30T17:00:00-07:00": "2024-07-01T00:00:00Z",
+		"never":                     "0001-01-01T00:00:00Z",
+		"":                          "0001-01-01T00:00:00Z",
+	}
+	for s, expected := range tests {
+		got, err := ParseExpires(s)
+		if err != nil {
+			t.Errorf("%q, %s", s, err)
+			continue
+		}
+		if got.Format(time.RFC3339) != expected {
+			t.Errorf("%q expected %s, got %s", s, expected, got.Format(time.RFC3339))
+		}
+	}
+	if _, err := ParseExpires("next week"); err == nil {
+		t.Errorf("expected an error for an invalid date")
+	}
+}
+
+func TestExpires(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "md5"}
+	for _, username := range []string{"Jane.Doe", "Guest", "Visitor"} {
+		if err := a.UpdateUser(username, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.SetExpires("Guest", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetExpires("Visitor", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyLogin("Guest", "secret"); errors.Is(err, ErrUserExpired) == false {
+		t.Errorf("expected ErrUserExpired, got %v", err)
+	}
+	if err := a.VerifyLogin("Visitor", "secret"); err != nil {
+		t.Errorf("expected Visitor to log in, %s", err)
+	}
+
+	// Expiration dates are saved with the access file.
+	fName := filepath.Join(t.TempDir(), "access.toml")
+	if err := a.DumpAccess(fName); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reload(fName); err != nil {
+		t.Fatal(err)
+	}
+	if secret, _ := a.Lookup("Visitor"); secret.ExpiresAt == nil {
+		t.Errorf("expected Visitor's expiration to be saved")
+	}
+
+	removed, err := a.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(removed, " ") != "Guest" {
+		t.Errorf("expected Guest to be pruned, got %q", removed)
+	}
+	usernames, _ := a.List()
+	if strings.Join(usernames, " ") != "Jane.Doe Visitor" {
+		t.Errorf("unexpected users %q", usernames)
+	}
+	if err := a.SetExpires("Visitor", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if secret, _ := a.Lookup("Visitor"); secret.ExpiresAt != nil {
+		t.Errorf("expected Visitor's expiration to be cleared")
+	}
+}
@@ -0,0 +1,89 @@
+// fault.go injects latency, bandwidth limits and errors, so frontend
+// developers can see how their apps behave against a slow or flaky
+// backend.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Fault describes what to inject into requests below Prefix.
+type Fault struct {
+	Prefix string `json:"prefix" toml:"prefix"`
+	// DelayMS delays each response, plus up to JitterMS at random.
+	DelayMS  int `json:"delay_ms,omitempty" toml:"delay_ms,omitempty"`
+	JitterMS int `json:"jitter_ms,omitempty" toml:"jitter_ms,omitempty"`
+	// BytesPerSecond caps how fast response bodies are sent.
+	BytesPerSecond int64 `json:"bytes_per_second,omitempty" toml:"bytes_per_second,omitempty"`
+	// ErrorPercent of requests are answered with ErrorStatus
+	// (503 if not set).
+	ErrorPercent float64 `json:"error_percent,omitempty" toml:"error_percent,omitempty"`
+	ErrorStatus  int     `json:"error_status,omitempty" toml:"error_status,omitempty"`
+}
+
+// FaultHandler injects the first matching Fault into requests
+// before calling next.
+func FaultHandler(faults []*Fault, next http.Handler) (http.Handler, error) {
+	for _, f := range faults {
+		if f.Prefix == "" {
+			return nil, fmt.Errorf("faults require a prefix")
+		}
+		if f.ErrorPercent < 0 || f.ErrorPercent > 100 {
+			return nil, fmt.Errorf("fault %q error_percent must be between 0 and 100", f.Prefix)
+		}
+		if f.ErrorStatus != 0 && (f.ErrorStatus < 400 || f.ErrorStatus > 599) {
+			return nil, fmt.Errorf("fault %q error_status must be a 4xx or 5xx status", f.Prefix)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, f := range faults {
+			if strings.HasPrefix(r.URL.Path, f.Prefix) == false {
+				continue
+			}
+			delay := time.Duration(f.DelayMS) * time.Millisecond
+			if f.JitterMS > 0 {
+				delay += time.Duration(rand.Intn(f.JitterMS+1)) * time.Millisecond
+			}
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			if f.ErrorPercent > 0 && rand.Float64()*100 < f.ErrorPercent {
+				status := f.ErrorStatus
+				if status == 0 {
+					status = http.StatusServiceUnavailable
+				}
+				httpError(w, r, status, fmt.Errorf("injected fault"))
+				return
+			}
+			if f.BytesPerSecond > 0 {
+				w = newThrottleWriter(w, newByteLimiter(f.BytesPerSecond))
+			}
+			break
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
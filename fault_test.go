@@ -0,0 +1,63 @@
+// fault_test.go tests latency and failure injection.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFaultHandler(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 1000)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	})
+	h, err := FaultHandler([]*Fault{
+		{Prefix: "/broken/", ErrorPercent: 100, ErrorStatus: http.StatusBadGateway},
+		{Prefix: "/slow/", DelayMS: 50, JitterMS: 10},
+		{Prefix: "/narrow/", BytesPerSecond: 4000},
+	}, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(p string) (*httptest.ResponseRecorder, time.Duration) {
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+		return rec, time.Since(start)
+	}
+	if rec, _ := serve("/broken/x"); rec.Code != http.StatusBadGateway {
+		t.Errorf("expected %d, got %d", http.StatusBadGateway, rec.Code)
+	}
+	if rec, elapsed := serve("/slow/x"); rec.Code != http.StatusOK || elapsed < 50*time.Millisecond {
+		t.Errorf("expected a delayed 200, got %d after %s", rec.Code, elapsed)
+	}
+	rec, elapsed := serve("/narrow/x")
+	if rec.Body.Len() != len(body) || elapsed < 200*time.Millisecond {
+		t.Errorf("expected %d bytes in at least 200ms, got %d in %s", len(body), rec.Body.Len(), elapsed)
+	}
+	if _, elapsed := serve("/fast/x"); elapsed > 50*time.Millisecond {
+		t.Errorf("expected other paths unaffected, took %s", elapsed)
+	}
+	if _, err := FaultHandler([]*Fault{{Prefix: "/", ErrorStatus: 200}}, next); err == nil {
+		t.Errorf("expected an error for a non error status")
+	}
+}
@@ -0,0 +1,127 @@
+// fileetag_test.go tests ETags on static files.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResumeDownload(t *testing.T) {
+	docRoot := t.TempDir()
+	fName := filepath.Join(docRoot, "master.tif")
+	content := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(content)
+	if err := os.WriteFile(fName, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Wrapped writers (bandwidth, header rules, logging) sit between
+	// the file server and the client.
+	ws := &WebService{
+		DocRoot:   docRoot,
+		Bandwidth: []*Bandwidth{{Prefix: "/", PerRequest: 1 << 30}},
+		Headers:   []*HeaderRule{{Prefix: "/", ResponseSet: map[string]string{"X-Archive": "masters"}}},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// Start a download and disconnect part way through.
+	res, err := http.Get(srv.URL + "/master.tif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := res.Header.Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("expected a strong ETag, got %q", etag)
+	}
+	partial := make([]byte, 100000)
+	if _, err := io.ReadFull(res.Body, partial); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	resume := func(ifRange string) *http.Response {
+		req, _ := http.NewRequest("GET", srv.URL+"/master.tif", nil)
+		req.Header.Set("Range", "bytes=100000-")
+		req.Header.Set("If-Range", ifRange)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	res = resume(etag)
+	rest, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		t.Fatalf("expected 206 resuming with a matching ETag, got %d", res.StatusCode)
+	}
+	if bytes.Equal(append(partial, rest...), content) == false {
+		t.Errorf("expected the resumed download to match the file")
+	}
+	if res.Header.Get("X-Archive") != "masters" {
+		t.Errorf("expected the header rule applied to the partial response")
+	}
+
+	// A weak ETag never matches If-Range.
+	res = resume("W/" + etag)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 for a weak If-Range, got %d", res.StatusCode)
+	}
+
+	// Once the file changes the whole new file is sent.
+	changed := append(content[:len(content)/2:len(content)/2], []byte("revised")...)
+	if err := os.WriteFile(fName, changed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(fName, time.Now(), time.Now().Add(time.Second))
+	res = resume(etag)
+	src, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || bytes.Equal(src, changed) == false {
+		t.Errorf("expected 200 with the changed file for a stale If-Range, got %d (%d bytes)", res.StatusCode, len(src))
+	}
+	if res.Header.Get("ETag") == etag {
+		t.Errorf("expected a new ETag for the changed file")
+	}
+
+	// Conditional GETs are answered from the ETag too.
+	req, _ := http.NewRequest("GET", srv.URL+"/master.tif", nil)
+	req.Header.Set("If-None-Match", res.Header.Get("ETag"))
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", res.StatusCode)
+	}
+}
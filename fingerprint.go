@@ -0,0 +1,197 @@
+// fingerprint.go serves static assets at URLs containing a hash of their
+// content (e.g. "/assets/app.3f9ac2e1.css") so they can be cached
+// forever and busted by changing the URL.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFingerprintLength is the number of hex digits of the hash
+// used in URLs.
+const DefaultFingerprintLength = 8
+
+// Fingerprint serves files below Prefixes at hashed URLs, e.g.
+// "/assets/app.3f9ac2e1.css" is "/assets/app.css" when its content
+// hash starts with "3f9ac2e1", with a year long immutable
+// Cache-Control, private below protected routes so shared caches
+// don't keep them. A stale hash is redirected to the current URL.
+// ManifestPath, if set, serves a JSON map of paths to their hashed
+// URLs, templates can use the "asset" function.
+type Fingerprint struct {
+	Prefixes     []string `json:"prefixes" toml:"prefixes"`
+	Length       int      `json:"length,omitempty" toml:"length,omitempty"`
+	ManifestPath string   `json:"manifest_path,omitempty" toml:"manifest_path,omitempty"`
+
+	// once sets up fs and the hashes, shared by handlers built from
+	// the same configuration.
+	once   sync.Once
+	fs     http.FileSystem
+	hashed *regexp.Regexp
+	mu     sync.Mutex
+	hashes map[string]*assetHash
+}
+
+// assetHash is a file's hash and the size and time it was taken.
+type assetHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// covers reports if p is below one of the Prefixes.
+func (fp *Fingerprint) covers(p string) bool {
+	return hasPathPrefix(p, fp.Prefixes)
+}
+
+// hash returns the hash of file p, reading it again if it changed.
+func (fp *Fingerprint) hash(p string) (string, error) {
+	f, err := fp.fs.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", p)
+	}
+	fp.mu.Lock()
+	h, ok := fp.hashes[p]
+	fp.mu.Unlock()
+	if ok && h.size == info.Size() && h.modTime.Equal(info.ModTime()) {
+		return h.hash, nil
+	}
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", err
+	}
+	h = &assetHash{size: info.Size(), modTime: info.ModTime(), hash: hex.EncodeToString(sum.Sum(nil))[:fp.Length]}
+	fp.mu.Lock()
+	fp.hashes[p] = h
+	fp.mu.Unlock()
+	return h.hash, nil
+}
+
+// URL returns the hashed URL of the file at p, or p if it isn't
+// below the Prefixes or can't be read.
+func (fp *Fingerprint) URL(p string) string {
+	if fp.fs == nil || fp.covers(p) == false {
+		return p
+	}
+	hash, err := fp.hash(p)
+	if err != nil {
+		return p
+	}
+	dir, name := path.Split(p)
+	ext := path.Ext(name)
+	return dir + strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// Manifest maps the path of each file below the Prefixes to its
+// hashed URL.
+func (fp *Fingerprint) Manifest() map[string]string {
+	m := map[string]string{}
+	for _, prefix := range fp.Prefixes {
+		walkFiles(fp.fs, prefix, func(p string, info fs.FileInfo) {
+			m[p] = fp.URL(p)
+		})
+	}
+	return m
+}
+
+// original splits a hashed path into the file's path and hash.
+func (fp *Fingerprint) original(p string) (string, string, bool) {
+	dir, name := path.Split(p)
+	m := fp.hashed.FindStringSubmatch(name)
+	if m == nil {
+		return "", "", false
+	}
+	return dir + m[1] + m[3], m[2], true
+}
+
+// Handler serves hashed URLs from fs passing other requests to next.
+// Assets below access's routes are cached as private.
+func (fp *Fingerprint) Handler(fs http.FileSystem, access *Access, next http.Handler) (http.Handler, error) {
+	if len(fp.Prefixes) == 0 {
+		return nil, fmt.Errorf("fingerprint requires prefixes")
+	}
+	if fp.Length != 0 && (fp.Length < 4 || fp.Length > 64) {
+		return nil, fmt.Errorf("fingerprint length must be between 4 and 64")
+	}
+	fp.once.Do(func() {
+		if fp.Length == 0 {
+			fp.Length = DefaultFingerprintLength
+		}
+		fp.fs, fp.hashes = fs, map[string]*assetHash{}
+		fp.hashed = regexp.MustCompile(fmt.Sprintf(`^(.+)\.([0-9a-f]{%d})(\.[^.]+)?$`, fp.Length))
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fp.ManifestPath != "" && r.URL.Path == fp.ManifestPath {
+			w.Header().Set("Cache-Control", "no-cache")
+			JSONResponse(w, r, http.StatusOK, fp.Manifest())
+			return
+		}
+		if fp.covers(r.URL.Path) == false {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Files that really have a hash like name are served as is.
+		if f, err := fs.Open(r.URL.Path); err == nil {
+			f.Close()
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, hash, ok := fp.original(r.URL.Path)
+		if ok == false {
+			next.ServeHTTP(w, r)
+			return
+		}
+		current, err := fp.hash(p)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if current != hash {
+			w.Header().Set("Cache-Control", "no-cache")
+			http.Redirect(w, r, fp.URL(p), http.StatusFound)
+			return
+		}
+		if access != nil && access.isAccessRoute(p) {
+			w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = p
+		next.ServeHTTP(w, r2)
+	}), nil
+}
@@ -0,0 +1,106 @@
+// fingerprint_test.go tests serving assets at hashed URLs.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "assets"), 0755)
+	os.WriteFile(filepath.Join(root, "assets", "app.css"), []byte("body { color: black; }"), 0644)
+	os.WriteFile(filepath.Join(root, "assets", "vendor.0123abcd.js"), []byte("vendor"), 0644)
+	fs := http.Dir(root)
+	fp := &Fingerprint{Prefixes: []string{"/assets/"}, ManifestPath: "/assets/manifest.json"}
+	h, err := fp.Handler(fs, nil, http.FileServer(fs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(p string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+		return rec
+	}
+	u := fp.URL("/assets/app.css")
+	if regexp.MustCompile(`^/assets/app\.[0-9a-f]{8}\.css$`).MatchString(u) == false {
+		t.Fatalf("unexpected asset URL %q", u)
+	}
+	rec := serve(u)
+	if rec.Code != http.StatusOK || rec.Body.String() != "body { color: black; }" || rec.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("expected immutable app.css, got %d %q %q", rec.Code, rec.Header().Get("Cache-Control"), rec.Body.String())
+	}
+
+	// A changed file gets a new URL, old URLs are redirected to it.
+	os.WriteFile(filepath.Join(root, "assets", "app.css"), []byte("body { color: navy; }"), 0644)
+	rec = serve(u)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") == u || rec.Header().Get("Location") != fp.URL("/assets/app.css") {
+		t.Errorf("expected a redirect to the new URL, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	if rec = serve("/assets/vendor.0123abcd.js"); rec.Body.String() != "vendor" || rec.Header().Get("Cache-Control") != "" {
+		t.Errorf("expected hash named file served as is, got %q %q", rec.Body.String(), rec.Header().Get("Cache-Control"))
+	}
+	if rec = serve("/assets/missing.0123abcd.css"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+	if fp.URL("/index.html") != "/index.html" {
+		t.Errorf("expected paths outside the prefixes unchanged")
+	}
+
+	manifest := map[string]string{}
+	rec = serve("/assets/manifest.json")
+	if err := json.Unmarshal(rec.Body.Bytes(), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest["/assets/app.css"] != fp.URL("/assets/app.css") || len(manifest) != 2 {
+		t.Errorf("unexpected manifest %v", manifest)
+	}
+}
+
+func TestFingerprintPrivate(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "reports"), 0755)
+	os.WriteFile(filepath.Join(root, "reports", "summary.pdf"), []byte("summary"), 0644)
+	fs := http.Dir(root)
+	access := &Access{AuthType: "basic", Routes: []string{"/reports/"}}
+	fp := &Fingerprint{Prefixes: []string{"/reports/"}}
+	if _, err := fp.Handler(fs, access, http.FileServer(fs)); err != nil {
+		t.Fatal(err)
+	}
+	u := fp.URL("/reports/summary.pdf")
+	// A rebuilt handler shares the first one's hashes.
+	h, err := fp.Handler(fs, access, http.FileServer(fs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fp.hashes) != 1 {
+		t.Errorf("expected the hashes kept across rebuilds, got %v", fp.hashes)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", u, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "private, max-age=31536000, immutable" {
+		t.Errorf("expected a private immutable asset, got %d %q", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+}
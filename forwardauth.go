@@ -0,0 +1,115 @@
+// forwardauth.go lets other reverse proxies (nginx auth_request, Traefik
+// forwardAuth, Caddy forward_auth) delegate authentication to wsfn.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ForwardAuthUserHeader names the response header holding the
+// authenticated username.
+const ForwardAuthUserHeader = "X-Forwarded-User"
+
+// originalPath returns the cleaned path of the request the proxy is
+// asking about, from X-Original-URI (nginx) or X-Forwarded-Uri
+// (Traefik, Caddy). ok is false if neither is set. An error is
+// returned for a path that doesn't parse or has "." or ".." segments,
+// such requests are treated as protected.
+func originalPath(r *http.Request) (string, bool, error) {
+	for _, header := range []string{"X-Original-URI", "X-Forwarded-Uri"} {
+		uri := r.Header.Get(header)
+		if uri == "" {
+			continue
+		}
+		u, err := url.ParseRequestURI(uri)
+		if err != nil || strings.HasPrefix(u.Path, "/") == false {
+			return "", true, fmt.Errorf("%s %q doesn't parse", header, uri)
+		}
+		for _, part := range strings.Split(u.Path, "/") {
+			if part == "." || part == ".." {
+				return "", true, fmt.Errorf("%s %q has dot segments", header, uri)
+			}
+		}
+		p := path.Clean(u.Path)
+		if strings.HasSuffix(u.Path, "/") && p != "/" {
+			p += "/"
+		}
+		return p, true, nil
+	}
+	return "", false, nil
+}
+
+// ForwardAuthHandler answers authentication sub-requests from another
+// reverse proxy. It responds 200 with the username in the
+// X-Forwarded-User and Remote-User headers when the credentials are
+// valid, otherwise 401 (Basic) or 403 (remote_user, or a route the
+// user hasn't been granted). When the proxy
+// sends the original URI and it isn't one of the Routes the request
+// is allowed without credentials. Original URIs that don't parse
+// are treated as protected, only users without route restrictions
+// are allowed.
+func (a *Access) ForwardAuthHandler() http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		p, forwarded, err := originalPath(req)
+		if err != nil {
+			traceNote(req, "auth", err.Error())
+		}
+		if p != "" && a.isRemoteUser() == false && a.isLogoutPath(p) {
+			a.logout(res, req)
+			return
+		}
+		if p != "" && a.isAccessRoute(p) == false {
+			res.WriteHeader(http.StatusOK)
+			return
+		}
+		var (
+			username string
+			ok       bool
+		)
+		if a.isRemoteUser() {
+			name, err := a.remoteUser(req)
+			if err != nil {
+				httpError(res, req, http.StatusForbidden, err)
+				return
+			}
+			username, ok = name, true
+		} else {
+			username, ok = a.basicAuth(req)
+		}
+		if ok == false {
+			target := p
+			if target == "" {
+				target = req.URL.Path
+			}
+			a.unauthorized(res, req, target)
+			return
+		}
+		if forwarded && a.allowed(username, p) == false {
+			httpError(res, req, http.StatusForbidden, nil)
+			return
+		}
+		res.Header().Set(ForwardAuthUserHeader, username)
+		res.Header().Set(DefaultRemoteUserHeader, username)
+		res.WriteHeader(http.StatusOK)
+	})
+}
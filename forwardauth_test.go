@@ -0,0 +1,82 @@
+// forwardauth_test.go tests ForwardAuthHandler.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardAuth(t *testing.T) {
+	a := &Access{AuthType: "basic", AuthName: "Library", Encryption: "md5", Routes: []string{"/private/"}, SessionSeconds: -1}
+	a.UpdateAccess("Jane.Doe", "secret")
+	a.UpdateAccess("Ann.Lee", "secret")
+	if err := a.Grant("Ann.Lee", "/private/reports/"); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := NewWebService(WithDocRoot(t.TempDir()), WithAccess(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.ForwardAuthPath = "/auth"
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		uri, username, password string
+		status                  int
+	}{
+		{"/private/report.pdf", "Jane.Doe", "secret", http.StatusOK},
+		{"/private/report.pdf", "Jane.Doe", "wrong", http.StatusUnauthorized},
+		{"/private/report.pdf", "", "", http.StatusUnauthorized},
+		{"/public/index.html", "", "", http.StatusOK},
+		{"", "Jane.Doe", "secret", http.StatusOK},
+		// Paths the proxy normalizes to a protected one.
+		{"/public/../private/report.pdf", "", "", http.StatusUnauthorized},
+		{"//private/report.pdf", "", "", http.StatusUnauthorized},
+		{"/private", "", "", http.StatusUnauthorized},
+		{"/public/%2e%2e/private/report.pdf", "", "", http.StatusUnauthorized},
+		{"/%zz", "", "", http.StatusUnauthorized},
+		{"/private/reports/../report.pdf", "Ann.Lee", "secret", http.StatusForbidden},
+		{"/private/reports/annual.pdf", "Ann.Lee", "secret", http.StatusOK},
+		{"/%zz", "Ann.Lee", "secret", http.StatusForbidden},
+		{"/%zz", "Jane.Doe", "secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/auth", nil)
+		if test.uri != "" {
+			req.Header.Set("X-Original-URI", test.uri)
+		}
+		if test.username != "" {
+			req.SetBasicAuth(test.username, test.password)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%+v, got %d", test, rec.Code)
+		}
+		if rec.Code == http.StatusOK && test.username != "" && rec.Header().Get(ForwardAuthUserHeader) != test.username {
+			t.Errorf("%+v, expected %s header, got %v", test, ForwardAuthUserHeader, rec.Header())
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%+v, expected a WWW-Authenticate challenge", test)
+		}
+	}
+}
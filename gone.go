@@ -0,0 +1,58 @@
+// gone.go answers permanently retired URLs with 410 Gone.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+// GoneHandler answers requests matching patterns with 410 Gone. If
+// page is set it is read from fs and sent as the response body,
+// otherwise a problem document is sent.
+func GoneHandler(patterns []string, fs http.FileSystem, page string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matchPath(r.URL.Path, patterns) == false {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if page != "" && fs != nil {
+			if f, err := fs.Open(page); err == nil {
+				defer f.Close()
+				mimeType := mime.TypeByExtension(path.Ext(page))
+				if mimeType == "" {
+					mimeType = "text/html; charset=utf-8"
+				}
+				w.Header().Set("Content-Type", mimeType)
+				if info, err := f.Stat(); err == nil {
+					w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+				}
+				w.WriteHeader(http.StatusGone)
+				if r.Method != http.MethodHead {
+					io.Copy(w, f)
+				}
+				return
+			}
+		}
+		httpError(w, r, http.StatusGone, fmt.Errorf("%s has been permanently removed", r.URL.Path))
+	})
+}
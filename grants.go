@@ -0,0 +1,175 @@
+// grants.go limits users, directly or through groups, to some of the
+// protected routes.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// normalizeRoute gives a route leading and trailing slashes.
+func normalizeRoute(route string) string {
+	return "/" + strings.Trim(route, "/") + "/"
+}
+
+// without returns list less s and true if s was found.
+func without(list []string, s string) ([]string, bool) {
+	out := []string{}
+	for _, item := range list {
+		if item != s {
+			out = append(out, item)
+		}
+	}
+	return out, len(out) != len(list)
+}
+
+// AllRoutes is the Grant and Revoke target for every protected
+// route. Granting it lifts a user's limits, revoking it leaves them
+// none.
+const AllRoutes = "*"
+
+// unrestricted reports if secret may use every protected route.
+func unrestricted(secret *Secrets) bool {
+	return secret.Restricted == false && len(secret.Routes) == 0 && len(secret.Groups) == 0
+}
+
+// allowed reports if username may use the protected path p. Users
+// never granted routes or groups may use every protected route, as
+// may users unknown to the store (e.g. checked by AuthCommand). Other
+// store errors deny access.
+func (a *Access) allowed(username string, p string) bool {
+	secret, err := a.store().Lookup(username)
+	if errors.Is(err, ErrUnknownUser) {
+		return true
+	}
+	if err != nil {
+		log.Printf("access %q, %s", username, err)
+		return false
+	}
+	if unrestricted(secret) {
+		return true
+	}
+	if hasRoute(p, secret.Routes) {
+		return true
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, group := range secret.Groups {
+		if hasRoute(p, a.Groups[group]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Grant gives name access to target. When name is "@group" target
+// is a route added to the group. Otherwise name is a user and
+// target is a route, a "@group" the user joins or AllRoutes. Once a
+// user has a route or group they are limited to those routes, until
+// granted AllRoutes.
+func (a *Access) Grant(name string, target string) error {
+	if strings.HasPrefix(name, "@") {
+		group, route := name[1:], normalizeRoute(target)
+		if a.isAccessRoute(route) == false {
+			return fmt.Errorf("%q is not a protected route", route)
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		m := make(map[string][]string, len(a.Groups)+1)
+		for k, v := range a.Groups {
+			m[k] = v
+		}
+		routes, _ := without(m[group], route)
+		m[group] = append(routes, route)
+		a.Groups = m
+		return nil
+	}
+	old, err := a.store().Lookup(name)
+	if err != nil {
+		return err
+	}
+	secret := new(Secrets)
+	*secret = *old
+	if target == AllRoutes {
+		secret.Routes, secret.Groups, secret.Restricted = nil, nil, false
+		return a.store().Update(name, secret)
+	}
+	secret.Restricted = true
+	if strings.HasPrefix(target, "@") {
+		groups, _ := without(secret.Groups, target[1:])
+		secret.Groups = append(groups, target[1:])
+	} else {
+		route := normalizeRoute(target)
+		if a.isAccessRoute(route) == false {
+			return fmt.Errorf("%q is not a protected route", route)
+		}
+		routes, _ := without(secret.Routes, route)
+		secret.Routes = append(routes, route)
+	}
+	return a.store().Update(name, secret)
+}
+
+// Revoke undoes Grant. A user keeps their limits when their last
+// route or group is revoked, revoking AllRoutes removes them all.
+func (a *Access) Revoke(name string, target string) error {
+	if strings.HasPrefix(name, "@") {
+		group, route := name[1:], normalizeRoute(target)
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		routes, ok := without(a.Groups[group], route)
+		if ok == false {
+			return fmt.Errorf("group %q does not have %q", group, route)
+		}
+		m := make(map[string][]string, len(a.Groups))
+		for k, v := range a.Groups {
+			m[k] = v
+		}
+		if len(routes) == 0 {
+			delete(m, group)
+		} else {
+			m[group] = routes
+		}
+		a.Groups = m
+		return nil
+	}
+	old, err := a.store().Lookup(name)
+	if err != nil {
+		return err
+	}
+	secret := new(Secrets)
+	*secret = *old
+	ok := false
+	if target == AllRoutes {
+		secret.Routes, secret.Groups, secret.Restricted = nil, nil, true
+		return a.store().Update(name, secret)
+	}
+	// Files saved before Restricted was added limit by grants alone.
+	secret.Restricted = true
+	if strings.HasPrefix(target, "@") {
+		secret.Groups, ok = without(secret.Groups, target[1:])
+	} else {
+		secret.Routes, ok = without(secret.Routes, normalizeRoute(target))
+	}
+	if ok == false {
+		return fmt.Errorf("%q does not have %q", name, target)
+	}
+	return a.store().Update(name, secret)
+}
@@ -0,0 +1,135 @@
+// grants_test.go tests limiting users and groups to protected routes.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestGrants(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/intranet/", "/reports/"}}
+	for _, username := range []string{"Jane.Doe", "Millie", "Bob"} {
+		if err := a.UpdateUser(username, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	grants := [][2]string{
+		{"Jane.Doe", "reports/2023"},
+		{"@staff", "/intranet/"},
+		{"Millie", "@staff"},
+	}
+	for _, grant := range grants {
+		if err := a.Grant(grant[0], grant[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Grant("Jane.Doe", "/public/"); err == nil {
+		t.Errorf("expected an error granting an unprotected route")
+	}
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		username, path string
+		status         int
+	}{
+		{"Jane.Doe", "/reports/2023/q1.html", http.StatusOK},
+		{"Jane.Doe", "/reports/2022/q1.html", http.StatusForbidden},
+		{"Jane.Doe", "/intranet/", http.StatusForbidden},
+		{"Millie", "/intranet/", http.StatusOK},
+		{"Millie", "/reports/2023/", http.StatusForbidden},
+		// Bob has no grants so may use every protected route.
+		{"Bob", "/reports/2022/", http.StatusOK},
+		{"Bob", "/intranet/", http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.SetBasicAuth(test.username, "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s %s expected %d, got %d", test.username, test.path, test.status, rec.Code)
+		}
+	}
+
+	// Grants are saved with the access file.
+	fName := filepath.Join(t.TempDir(), "access.toml")
+	if err := a.DumpAccess(fName); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reload(fName); err != nil {
+		t.Fatal(err)
+	}
+	if a.allowed("Millie", "/intranet/") == false {
+		t.Errorf("expected Millie's group to survive a reload")
+	}
+
+	if err := a.Revoke("Millie", "@staff"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Revoke("@staff", "/intranet"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.Groups["staff"]; ok {
+		t.Errorf("expected the empty staff group to be removed")
+	}
+	if err := a.Revoke("Jane.Doe", "/intranet/"); err == nil {
+		t.Errorf("expected an error revoking a route that wasn't granted")
+	}
+	// Revoking the last grant doesn't lift the limits.
+	if a.allowed("Millie", "/reports/") || a.allowed("Millie", "/intranet/") {
+		t.Errorf("expected Millie without grants to have no routes")
+	}
+	if info, _ := a.UserInfo("Millie"); info.Restricted == false {
+		t.Errorf("expected Millie to be shown as restricted")
+	}
+	if err := a.Grant("Millie", AllRoutes); err != nil {
+		t.Fatal(err)
+	}
+	if a.allowed("Millie", "/reports/") == false {
+		t.Errorf("expected Millie granted * to have every route")
+	}
+	if err := a.Revoke("Bob", AllRoutes); err != nil {
+		t.Fatal(err)
+	}
+	if a.allowed("Bob", "/reports/") {
+		t.Errorf("expected Bob revoked * to have no routes")
+	}
+}
+
+// brokenStore fails every lookup, e.g. a database that went away.
+type brokenStore struct {
+	memStore
+}
+
+func (b *brokenStore) Lookup(username string) (*Secrets, error) {
+	return nil, fmt.Errorf("connection refused")
+}
+
+func TestAllowedStoreErrors(t *testing.T) {
+	a := &Access{AuthType: "basic", Routes: []string{"/reports/"}, Store: &brokenStore{}}
+	if a.allowed("Jane.Doe", "/reports/") {
+		t.Errorf("expected a store error to deny access")
+	}
+	a.Store = &memStore{users: map[string]*Secrets{}}
+	if a.allowed("Jane.Doe", "/reports/") == false {
+		t.Errorf("expected users unknown to the store to be allowed")
+	}
+}
@@ -0,0 +1,325 @@
+// har.go records requests and responses in HTTP Archive (HAR) format
+// for debugging.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHARMaxEntries limits a recording when MaxEntries isn't set.
+const DefaultHARMaxEntries = 1000
+
+// harRedacted headers have their values replaced, HAR files are
+// often shared.
+var harRedacted = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// HARRecorder records requests and responses to File in HAR 1.2
+// format. A recording stops, writing File, after MaxEntries requests,
+// after Seconds or when Stop is called. Recordings can be started and
+// stopped while running through the AdminAPI.
+type HARRecorder struct {
+	// File is where the recording is written.
+	File string `json:"file" toml:"file"`
+	// Enabled starts recording when the handler is created.
+	Enabled bool `json:"enabled,omitempty" toml:"enabled,omitempty"`
+	// MaxEntries is the most requests recorded,
+	// DefaultHARMaxEntries if not set.
+	MaxEntries int `json:"max_entries,omitempty" toml:"max_entries,omitempty"`
+	// Seconds limits how long a recording runs, zero for no limit.
+	Seconds int `json:"seconds,omitempty" toml:"seconds,omitempty"`
+	// MaxBodySize is the most bytes of each request and response body
+	// kept, bodies aren't recorded if zero.
+	MaxBodySize int64 `json:"max_body_size,omitempty" toml:"max_body_size,omitempty"`
+
+	// enable starts the recording for Enabled once, not each time
+	// the handler is rebuilt.
+	enable    sync.Once
+	mu        sync.Mutex
+	recording bool
+	until     time.Time
+	entries   []*harEntry
+}
+
+type harLog struct {
+	Log struct {
+		Version string            `json:"version"`
+		Creator map[string]string `json:"creator"`
+		Entries []*harEntry       `json:"entries"`
+	} `json:"log"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time          `json:"startedDateTime"`
+	Time            float64            `json:"time"`
+	Request         harRequest         `json:"request"`
+	Response        harResponse        `json:"response"`
+	Cache           struct{}           `json:"cache"`
+	Timings         map[string]float64 `json:"timings"`
+	ServerIPAddress string             `json:"serverIPAddress,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+// harHeaders converts headers, redacting credentials.
+func harHeaders(h http.Header) []harNameValue {
+	nv := []harNameValue{}
+	for name, values := range h {
+		for _, value := range values {
+			if harRedacted[name] {
+				value = "[redacted]"
+			}
+			nv = append(nv, harNameValue{Name: name, Value: value})
+		}
+	}
+	return nv
+}
+
+// capBuffer keeps the first max bytes written to it.
+type capBuffer struct {
+	bytes.Buffer
+	max int64
+}
+
+func (cb *capBuffer) Write(p []byte) (int, error) {
+	if room := cb.max - int64(cb.Len()); room > 0 {
+		if int64(len(p)) > room {
+			cb.Buffer.Write(p[:room])
+		} else {
+			cb.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// harWriter records the response body.
+type harWriter struct {
+	*statusWriter
+	body *capBuffer
+}
+
+func (hw *harWriter) Write(p []byte) (int, error) {
+	hw.body.Write(p)
+	return hw.statusWriter.Write(p)
+}
+
+// ReadFrom copies through Write so the body is recorded.
+func (hw *harWriter) ReadFrom(src io.Reader) (int64, error) {
+	return copyPooled(hw, src)
+}
+
+// teeBody records the request body as the handler reads it.
+type teeBody struct {
+	io.Reader
+	io.Closer
+}
+
+// Start begins a new recording.
+func (hr *HARRecorder) Start() {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	hr.recording, hr.entries = true, nil
+	hr.until = time.Time{}
+	if hr.Seconds > 0 {
+		hr.until = time.Now().Add(time.Duration(hr.Seconds) * time.Second)
+	}
+}
+
+// HARStatus reports the state of a HARRecorder.
+type HARStatus struct {
+	File      string `json:"file"`
+	Recording bool   `json:"recording"`
+	Entries   int    `json:"entries"`
+}
+
+// Status returns whether a recording is running and its length.
+func (hr *HARRecorder) Status() *HARStatus {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	return &HARStatus{File: hr.File, Recording: hr.recording, Entries: len(hr.entries)}
+}
+
+// Recording reports if requests are being recorded.
+func (hr *HARRecorder) Recording() bool {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	return hr.recording
+}
+
+// Stop ends the recording writing File.
+func (hr *HARRecorder) Stop() error {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	return hr.stop()
+}
+
+// stop writes the recording, hr.mu must be held.
+func (hr *HARRecorder) stop() error {
+	if hr.recording == false {
+		return nil
+	}
+	hr.recording = false
+	doc := new(harLog)
+	doc.Log.Version = "1.2"
+	doc.Log.Creator = map[string]string{"name": "wsfn", "version": Version}
+	doc.Log.Entries = hr.entries
+	if doc.Log.Entries == nil {
+		doc.Log.Entries = []*harEntry{}
+	}
+	hr.entries = nil
+	src, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(hr.File, src, 0600)
+}
+
+// add records an entry, stopping when a limit is reached.
+func (hr *HARRecorder) add(entry *harEntry) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	if hr.recording == false {
+		return
+	}
+	hr.entries = append(hr.entries, entry)
+	maxEntries := hr.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultHARMaxEntries
+	}
+	if len(hr.entries) >= maxEntries || (hr.until.IsZero() == false && time.Now().After(hr.until)) {
+		if err := hr.stop(); err != nil {
+			log.Printf("har %s, %s", hr.File, err)
+		} else {
+			log.Printf("har recording written to %s", hr.File)
+		}
+	}
+}
+
+// Handler records requests to next while recording.
+func (hr *HARRecorder) Handler(next http.Handler) http.Handler {
+	if hr.Enabled {
+		hr.enable.Do(hr.Start)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hr.Recording() == false {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		reqBody := &capBuffer{max: hr.MaxBodySize}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = teeBody{Reader: io.TeeReader(r.Body, reqBody), Closer: r.Body}
+		}
+		hw := &harWriter{statusWriter: newStatusWriter(w), body: &capBuffer{max: hr.MaxBodySize}}
+		next.ServeHTTP(hw, r)
+		elapsed := float64(time.Since(start).Microseconds()) / 1000
+
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		entry := &harEntry{StartedDateTime: start, Time: elapsed}
+		entry.Request = harRequest{
+			Method:      r.Method,
+			URL:         scheme + "://" + r.Host + r.URL.RequestURI(),
+			HTTPVersion: r.Proto,
+			Headers:     harHeaders(r.Header),
+			QueryString: []harNameValue{},
+			Cookies:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    r.ContentLength,
+		}
+		for name, values := range r.URL.Query() {
+			for _, value := range values {
+				entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{Name: name, Value: value})
+			}
+		}
+		if reqBody.Len() > 0 {
+			entry.Request.PostData = &harPostData{MimeType: r.Header.Get("Content-Type"), Text: reqBody.String()}
+		}
+		status := hw.Status()
+		entry.Response = harResponse{
+			Status:      status,
+			StatusText:  http.StatusText(status),
+			HTTPVersion: r.Proto,
+			Headers:     harHeaders(w.Header()),
+			Cookies:     []harNameValue{},
+			Content:     harContent{Size: hw.size, MimeType: w.Header().Get("Content-Type"), Text: hw.body.String()},
+			RedirectURL: w.Header().Get("Location"),
+			HeadersSize: -1,
+			BodySize:    hw.size,
+		}
+		if strings.HasPrefix(entry.Response.Content.MimeType, "text/") == false && strings.Contains(entry.Response.Content.MimeType, "json") == false {
+			// Binary bodies aren't valid HAR text.
+			entry.Response.Content.Text = ""
+		}
+		entry.Timings = map[string]float64{"send": 0, "wait": elapsed, "receive": 0}
+		hr.add(entry)
+	})
+}
@@ -0,0 +1,120 @@
+// har_test.go tests HAR recording.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHARRecorder(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "debug.har")
+	hr := &HARRecorder{File: fName, Enabled: true, MaxEntries: 2, MaxBodySize: 4}
+	h := hr.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		src, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write(append([]byte("echo "), src...))
+	}))
+	req := httptest.NewRequest("POST", "/api?q=1", strings.NewReader("hello world"))
+	req.Header.Set("Authorization", "Basic c2VjcmV0")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if _, err := os.Stat(fName); err == nil {
+		t.Fatalf("expected the file written once the recording stops")
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if hr.Recording() {
+		t.Errorf("expected the recording to stop after MaxEntries")
+	}
+
+	src, err := os.ReadFile(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(src), "secret") || strings.Contains(string(src), "c2VjcmV0") {
+		t.Errorf("expected credentials redacted, %s", src)
+	}
+	doc := new(harLog)
+	if err := json.Unmarshal(src, doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Log.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(doc.Log.Entries))
+	}
+	entry := doc.Log.Entries[0]
+	if entry.Request.URL != "http://example.com/api?q=1" || entry.Request.PostData == nil || entry.Request.PostData.Text != "hell" {
+		t.Errorf("unexpected request %+v", entry.Request)
+	}
+	if entry.Response.Status != 200 || entry.Response.Content.Text != "echo" || entry.Response.BodySize != 16 {
+		t.Errorf("unexpected response %+v", entry.Response)
+	}
+}
+
+func TestHARAdmin(t *testing.T) {
+	dir := t.TempDir()
+	fName := filepath.Join(dir, "debug.har")
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/admin/"}}
+	if err := a.UpdateUser("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	ws := &WebService{
+		DocRoot: dir,
+		Access:  a,
+		Admin:   &AdminAPI{Prefix: "/admin/", Admins: []string{"Jane.Doe"}},
+		HAR:     &HARRecorder{File: fName, Enabled: true},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method string, p string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, p, nil)
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("Jane.Doe", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	// Rebuilding the handler doesn't restart the recording.
+	do("GET", "/missing.html")
+	if h, err = ws.Handler(); err != nil {
+		t.Fatal(err)
+	}
+	status := new(HARStatus)
+	if err := json.Unmarshal(do("GET", "/admin/har").Body.Bytes(), status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Recording == false || status.Entries != 1 {
+		t.Errorf("expected a running recording, got %+v", status)
+	}
+	if rec := do("DELETE", "/admin/har"); rec.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", rec.Code)
+	}
+	if _, err := os.Stat(fName); err != nil || ws.HAR.Recording() {
+		t.Errorf("expected the recording stopped and written, %v", err)
+	}
+	if rec := do("POST", "/admin/har"); rec.Code != http.StatusAccepted || ws.HAR.Recording() == false {
+		t.Errorf("expected a new recording started, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,190 @@
+// headers.go sets, appends and removes request and response headers
+// using configured rules.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// HeaderRule changes the headers of requests below Prefix that
+// satisfy Match. Request headers are changed before the request is
+// handled, response headers just before the response is written so
+// they apply to every handler's responses. Removals are applied
+// first, then Set and Add.
+type HeaderRule struct {
+	Prefix string   `json:"prefix" toml:"prefix"`
+	Match  *Matcher `json:"match,omitempty" toml:"match,omitempty"`
+
+	// RequestSet replaces, RequestAdd appends request headers.
+	RequestSet map[string]string `json:"request_set,omitempty" toml:"request_set,omitempty"`
+	RequestAdd map[string]string `json:"request_add,omitempty" toml:"request_add,omitempty"`
+	// RequestRemove lists request headers to remove.
+	RequestRemove []string `json:"request_remove,omitempty" toml:"request_remove,omitempty"`
+
+	// ResponseSet replaces, ResponseAdd appends response headers.
+	ResponseSet map[string]string `json:"response_set,omitempty" toml:"response_set,omitempty"`
+	ResponseAdd map[string]string `json:"response_add,omitempty" toml:"response_add,omitempty"`
+	// ResponseRemove lists response headers to remove.
+	ResponseRemove []string `json:"response_remove,omitempty" toml:"response_remove,omitempty"`
+}
+
+// validHeaderName reports if name is a usable header field name.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks the rule's header names and values.
+func (hr *HeaderRule) validate() error {
+	if hr.Prefix == "" && hr.Match == nil {
+		return fmt.Errorf("header rules require a prefix or match")
+	}
+	if err := hr.Match.Validate(); err != nil {
+		return err
+	}
+	for _, m := range []map[string]string{hr.RequestSet, hr.RequestAdd, hr.ResponseSet, hr.ResponseAdd} {
+		for name, value := range m {
+			if validHeaderName(name) == false {
+				return fmt.Errorf("invalid header name %q", name)
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("header %q value holds a line break", name)
+			}
+		}
+	}
+	for _, names := range [][]string{hr.RequestRemove, hr.ResponseRemove} {
+		for _, name := range names {
+			if validHeaderName(name) == false {
+				return fmt.Errorf("invalid header name %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// changeHeaders applies the removals, then set and add to h.
+func changeHeaders(h http.Header, remove []string, set map[string]string, add map[string]string) {
+	for _, name := range remove {
+		h.Del(name)
+	}
+	for name, value := range set {
+		h.Set(name, value)
+	}
+	for name, value := range add {
+		h.Add(name, value)
+	}
+}
+
+// headerWriter applies response header rules before the first write.
+type headerWriter struct {
+	http.ResponseWriter
+	rules   []*HeaderRule
+	applied bool
+}
+
+func (hw *headerWriter) apply() {
+	if hw.applied {
+		return
+	}
+	hw.applied = true
+	for _, hr := range hw.rules {
+		changeHeaders(hw.Header(), hr.ResponseRemove, hr.ResponseSet, hr.ResponseAdd)
+	}
+}
+
+func (hw *headerWriter) WriteHeader(status int) {
+	if informational(status) == false {
+		hw.apply()
+	}
+	hw.ResponseWriter.WriteHeader(status)
+}
+
+func (hw *headerWriter) Write(p []byte) (int, error) {
+	hw.apply()
+	return hw.ResponseWriter.Write(p)
+}
+
+// ReadFrom keeps the underlying writer's fast path, see pool.go.
+func (hw *headerWriter) ReadFrom(src io.Reader) (int64, error) {
+	hw.apply()
+	return readFrom(hw.ResponseWriter, src)
+}
+
+func (hw *headerWriter) Flush() {
+	hw.apply()
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (hw *headerWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := hw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer does not support hijacking")
+}
+
+// Unwrap supports http.ResponseController.
+func (hw *headerWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// HeadersHandler applies the rules fitting each request, in order,
+// before calling next.
+func HeadersHandler(rules []*HeaderRule, next http.Handler) (http.Handler, error) {
+	for i, hr := range rules {
+		if err := hr.validate(); err != nil {
+			return nil, fmt.Errorf("headers %d (%s), %s", i+1, hr.Prefix, err)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		matched := []*HeaderRule{}
+		for _, hr := range rules {
+			if strings.HasPrefix(r.URL.Path, hr.Prefix) && hr.Match.Match(r) {
+				matched = append(matched, hr)
+			}
+		}
+		if len(matched) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = r.Clone(r.Context())
+		respond := false
+		for _, hr := range matched {
+			changeHeaders(r.Header, hr.RequestRemove, hr.RequestSet, hr.RequestAdd)
+			respond = respond || len(hr.ResponseRemove) > 0 || len(hr.ResponseSet) > 0 || len(hr.ResponseAdd) > 0
+		}
+		if respond {
+			w = &headerWriter{ResponseWriter: w, rules: matched}
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
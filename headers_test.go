@@ -0,0 +1,80 @@
+// headers_test.go tests setting, appending and removing headers.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeadersHandler(t *testing.T) {
+	var seen http.Header
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header
+		w.Header().Set("X-Powered-By", "upstream")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("ok"))
+	})
+	rules := []*HeaderRule{
+		{
+			Prefix:         "/api/",
+			RequestSet:     map[string]string{"X-Forwarded-Prefix": "/api"},
+			RequestRemove:  []string{"Cookie"},
+			ResponseSet:    map[string]string{"Cache-Control": "no-store"},
+			ResponseRemove: []string{"X-Powered-By"},
+		},
+		{Prefix: "/", Match: &Matcher{Method: []string{"GET"}}, ResponseAdd: map[string]string{"X-Site": "library"}},
+	}
+	h, err := HeadersHandler(rules, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/api/items", nil)
+	req.Header.Set("Cookie", "session=1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if seen.Get("Cookie") != "" || seen.Get("X-Forwarded-Prefix") != "/api" {
+		t.Errorf("expected the request headers changed, got %v", seen)
+	}
+	if req.Header.Get("Cookie") == "" {
+		t.Errorf("expected the original request left unchanged")
+	}
+	for k, expected := range map[string]string{"Cache-Control": "no-store", "X-Powered-By": "", "X-Site": "library"} {
+		if got := rec.Header().Get(k); got != expected {
+			t.Errorf("expected %s %q, got %q", k, expected, got)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/index.html", nil))
+	if rec.Header().Get("X-Site") != "" || rec.Header().Get("X-Powered-By") != "upstream" {
+		t.Errorf("expected no changes for a request no rule fits, got %v", rec.Header())
+	}
+
+	for _, hr := range []*HeaderRule{
+		{Prefix: "/", ResponseSet: map[string]string{"Bad Name": "x"}},
+		{Prefix: "/", ResponseSet: map[string]string{"X-Split": "a\r\nSet-Cookie: b"}},
+		{RequestRemove: []string{"Cookie"}},
+	} {
+		if _, err := HeadersHandler([]*HeaderRule{hr}, next); err == nil {
+			t.Errorf("expected an error for %+v", hr)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+// hosts.go serves virtual hosts, redirecting host aliases to their
+// canonical host.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// VirtualHost is a site served for requests to Host. Requests to
+// one of Aliases (or, with WWW, the "www." or apex counterpart of
+// Host) are redirected to Host keeping their path and query.
+type VirtualHost struct {
+	// Host is the canonical host name, e.g. "library.example.edu". A
+	// wildcard (e.g. "*.example.edu") serves each subdomain from
+	// DocRoot with "%s" replaced by the subdomain.
+	Host string `json:"host" toml:"host"`
+
+	// Aliases are redirected to Host, e.g. "lib.example.edu".
+	Aliases []string `json:"aliases,omitempty" toml:"aliases,omitempty"`
+
+	// WWW makes "www.<host>" an alias of Host, or the apex an alias
+	// if Host starts with "www.".
+	WWW bool `json:"www,omitempty" toml:"www,omitempty"`
+
+	// DocRoot is the host's document root, the WebService's
+	// if not set.
+	DocRoot string `json:"htdocs,omitempty" toml:"htdocs,omitempty"`
+
+	// Redirects maps path prefixes to their new prefix for this host.
+	Redirects map[string]string `json:"redirects,omitempty" toml:"redirects,omitempty"`
+
+	// CertPEM and KeyPEM are the host's TLS certificate and key,
+	// chosen by SNI. The https service's are used if not set.
+	CertPEM string `json:"cert_pem,omitempty" toml:"cert_pem,omitempty"`
+	KeyPEM  string `json:"key_pem,omitempty" toml:"key_pem,omitempty"`
+
+	// AccessFile is the host's access file, replacing the
+	// WebService's access restrictions for this host. It requires
+	// DocRoot, other hosts would serve the same files without it.
+	AccessFile string `json:"access_file,omitempty" toml:"access_file,omitempty"`
+
+	// CORS is the host's CORS policy, replacing the WebService's.
+	CORS *CORSPolicy `json:"cors,omitempty" toml:"cors,omitempty"`
+
+	// LogFile is the host's access log, the standard log if not set.
+	LogFile string `json:"log_file,omitempty" toml:"log_file,omitempty"`
+
+	// Access is loaded from AccessFile.
+	Access *Access `json:"-" toml:"-"`
+
+	// logger writes to LogFile.
+	logger *log.Logger
+}
+
+// requestLogger wraps h logging to the host's LogFile, or with
+// the WebService's request logger if not set.
+func (vh *VirtualHost) requestLogger(w *WebService, h http.Handler) (http.Handler, error) {
+	if vh.LogFile == "" {
+		return w.requestLogger(h), nil
+	}
+	if vh.logger == nil {
+		fp, err := os.OpenFile(vh.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("host %q, %s", vh.Host, err)
+		}
+		vh.logger = log.New(fp, "", log.LstdFlags)
+	}
+	return skippable(MiddlewareLogging, RequestLoggerTo(vh.logger, h), h), nil
+}
+
+// normalizeHost lower cases host dropping any port and trailing dot.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// aliases returns the normalized alias host names.
+func (vh *VirtualHost) aliases() []string {
+	aliases := []string{}
+	for _, alias := range vh.Aliases {
+		aliases = append(aliases, normalizeHost(alias))
+	}
+	if vh.WWW {
+		host := normalizeHost(vh.Host)
+		if strings.HasPrefix(host, "www.") {
+			aliases = append(aliases, strings.TrimPrefix(host, "www."))
+		} else {
+			aliases = append(aliases, "www."+host)
+		}
+	}
+	return aliases
+}
+
+// canonicalURL returns the URL of the request on the canonical host,
+// keeping a non-default port the client used.
+func (vh *VirtualHost) canonicalURL(r *http.Request) string {
+	scheme := requestScheme(r)
+	host := normalizeHost(vh.Host)
+	if _, port, err := net.SplitHostPort(r.Host); err == nil && port != "80" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	return scheme + "://" + host + r.URL.RequestURI()
+}
+
+// handler returns the handler serving the host from docRoot, shared
+// unless it has its own document root, access or CORS policy.
+func (vh *VirtualHost) handler(w *WebService, shared http.Handler, docRoot string) (http.Handler, error) {
+	h := shared
+	if (vh.AccessFile != "" || vh.Access != nil) && docRoot == "" {
+		return nil, fmt.Errorf("host %q has an access_file, it requires its own htdocs", vh.Host)
+	}
+	if vh.AccessFile != "" && vh.Access == nil {
+		access, err := LoadAccess(vh.AccessFile)
+		if err != nil {
+			return nil, fmt.Errorf("host %q, %s", vh.Host, err)
+		}
+		vh.Access = access
+	}
+	if docRoot != "" || vh.Access != nil || vh.CORS != nil {
+		var fs http.FileSystem
+		var err error
+		if docRoot != "" {
+			fs, err = MakeSafeFileSystem(docRoot)
+		} else {
+			fs, err = w.SafeFileSystem()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("host %q, %s", vh.Host, err)
+		}
+		access, cors := w.Access, w.CORS
+		if vh.Access != nil {
+			access = vh.Access
+		}
+		if vh.CORS != nil {
+			cors = vh.CORS
+		}
+		if h, err = w.siteHandler(fs, access, cors); err != nil {
+			return nil, fmt.Errorf("host %q, %s", vh.Host, err)
+		}
+	}
+	if len(vh.Redirects) > 0 {
+		rs, err := MakeRedirectService(vh.Redirects)
+		if err != nil {
+			return nil, fmt.Errorf("host %q, %s", vh.Host, err)
+		}
+		h = rs.RedirectRouter(h)
+	}
+	return h, nil
+}
+
+// subdomainLabel matches a single DNS label.
+var subdomainLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// wildcardHost serves the subdomains of a "*.example.edu" host, each
+// from the directory named by replacing "%s" in its DocRoot with the
+// subdomain.
+type wildcardHost struct {
+	vh     *VirtualHost
+	suffix string
+
+	mu    sync.Mutex
+	sites map[string]http.Handler
+}
+
+// site returns the handler for the subdomain of host, false if host
+// isn't a subdomain or its directory doesn't exist.
+func (wh *wildcardHost) site(w *WebService, shared http.Handler, host string) (http.Handler, bool) {
+	label := strings.TrimSuffix(host, wh.suffix)
+	if label == host || subdomainLabel.MatchString(label) == false {
+		return nil, false
+	}
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if h, ok := wh.sites[label]; ok {
+		return h, true
+	}
+	// Directories aren't remembered as missing so new subsites are
+	// served without a restart.
+	docRoot := strings.ReplaceAll(wh.vh.DocRoot, "%s", label)
+	if info, err := os.Stat(docRoot); err != nil || info.IsDir() == false {
+		return nil, false
+	}
+	h, err := wh.vh.handler(w, shared, docRoot)
+	if err == nil {
+		h, err = wh.vh.requestLogger(w, h)
+	}
+	if err != nil {
+		log.Printf("%s, %s", host, err)
+		return nil, false
+	}
+	wh.sites[label] = h
+	return h, true
+}
+
+// hostHandler dispatches requests by Host to the virtual hosts,
+// others are passed to shared.
+func (w *WebService) hostHandler(shared http.Handler) (http.Handler, error) {
+	sites := map[string]http.Handler{}
+	canonical := map[string]*VirtualHost{}
+	wildcards := []*wildcardHost{}
+	for _, vh := range w.Hosts {
+		host := normalizeHost(vh.Host)
+		if host == "" {
+			return nil, fmt.Errorf("hosts require a host name")
+		}
+		if strings.HasPrefix(host, "*.") {
+			if vh.DocRoot == "" || len(vh.aliases()) > 0 {
+				return nil, fmt.Errorf("host %q requires htdocs and can't have aliases", host)
+			}
+			wildcards = append(wildcards, &wildcardHost{vh: vh, suffix: host[1:], sites: map[string]http.Handler{}})
+			continue
+		}
+		if _, ok := sites[host]; ok {
+			return nil, fmt.Errorf("host %q is defined more than once", host)
+		}
+		h, err := vh.handler(w, shared, vh.DocRoot)
+		if err != nil {
+			return nil, err
+		}
+		if h, err = vh.requestLogger(w, h); err != nil {
+			return nil, err
+		}
+		sites[host] = h
+		for _, alias := range vh.aliases() {
+			canonical[alias] = vh
+		}
+	}
+	for alias := range canonical {
+		if _, ok := sites[alias]; ok {
+			return nil, fmt.Errorf("host %q is also an alias", alias)
+		}
+	}
+	unmatched, err := w.unmatchedHost(sites, w.requestLogger(shared))
+	if err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		host := normalizeHost(r.Host)
+		if h, ok := sites[host]; ok {
+			h.ServeHTTP(rw, r)
+			return
+		}
+		if vh, ok := canonical[host]; ok {
+			w.requestLogger(http.RedirectHandler(vh.canonicalURL(r), http.StatusMovedPermanently)).ServeHTTP(rw, r)
+			return
+		}
+		for _, wh := range wildcards {
+			if h, ok := wh.site(w, shared, host); ok {
+				h.ServeHTTP(rw, r)
+				return
+			}
+		}
+		unmatched.ServeHTTP(rw, r)
+	}), nil
+}
+
+// unmatchedHost returns the handler for requests to hosts that
+// aren't configured: the DefaultHost's site, the UnknownHostPage
+// or, if neither is set, shared.
+func (w *WebService) unmatchedHost(sites map[string]http.Handler, shared http.Handler) (http.Handler, error) {
+	if w.DefaultHost != "" {
+		h, ok := sites[normalizeHost(w.DefaultHost)]
+		if ok == false {
+			return nil, fmt.Errorf("default host %q is not one of the hosts", w.DefaultHost)
+		}
+		return h, nil
+	}
+	if w.UnknownHostPage != "" {
+		src, err := os.ReadFile(w.UnknownHostPage)
+		if err != nil {
+			return nil, err
+		}
+		mimeType := mime.TypeByExtension(path.Ext(w.UnknownHostPage))
+		if mimeType == "" {
+			mimeType = "text/html; charset=utf-8"
+		}
+		return w.requestLogger(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rw.Header().Set("Content-Type", mimeType)
+			rw.WriteHeader(http.StatusNotFound)
+			rw.Write(src)
+		})), nil
+	}
+	return shared, nil
+}
+
+// tlsConfig returns a TLS configuration choosing the certificate
+// by SNI from the hosts with their own, falling back to the https
+// service's. It returns nil if there are no certificates. The
+// certificates are kept for checkCertExpiry.
+func (w *WebService) tlsConfig() (*tls.Config, error) {
+	certs := map[string]*tls.Certificate{}
+	served := []*servedCert{}
+	var fallback *tls.Certificate
+	for _, vh := range w.Hosts {
+		if vh.CertPEM == "" && vh.KeyPEM == "" {
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(vh.CertPEM, vh.KeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("host %q, %s", vh.Host, err)
+		}
+		hosts := []string{normalizeHost(vh.Host)}
+		certs[hosts[0]] = &cert
+		for _, alias := range vh.aliases() {
+			certs[alias] = &cert
+			hosts = append(hosts, alias)
+		}
+		served = append(served, &servedCert{hosts: hosts, cert: &cert})
+		if fallback == nil {
+			fallback = &cert
+		}
+	}
+	if w.Https != nil && w.Https.CertPEM != "" {
+		cert, err := tls.LoadX509KeyPair(w.Https.CertPEM, w.Https.KeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		fallback = &cert
+		served = append(served, &servedCert{hosts: w.Https.certHosts(&cert), cert: &cert})
+	}
+	w.certMu.Lock()
+	w.served = served
+	w.certMu.Unlock()
+	if fallback == nil {
+		return nil, nil
+	}
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			host := normalizeHost(hello.ServerName)
+			if cert, ok := certs[host]; ok {
+				return cert, nil
+			}
+			if i := strings.Index(host, "."); i > 0 {
+				if cert, ok := certs["*"+host[i:]]; ok {
+					return cert, nil
+				}
+			}
+			return fallback, nil
+		},
+	}, nil
+}
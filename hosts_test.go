@@ -0,0 +1,303 @@
+// hosts_test.go tests virtual hosts and canonical host redirects.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	shared, library := t.TempDir(), t.TempDir()
+	for dir, src := range map[string]string{shared: "shared", library: "library"} {
+		if err := os.WriteFile(filepath.Join(dir, "page.html"), []byte(src), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws := &WebService{DocRoot: shared, Hosts: []*VirtualHost{
+		{
+			Host:      "library.example.edu",
+			Aliases:   []string{"lib.example.edu"},
+			WWW:       true,
+			DocRoot:   library,
+			Redirects: map[string]string{"/old/": "/new/"},
+		},
+	}}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(host string, p string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", p, nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := get("Library.Example.EDU:8000", "/page.html"); rec.Body.String() != "library" {
+		t.Errorf("expected the host's page, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := get("other.example.edu", "/page.html"); rec.Body.String() != "shared" {
+		t.Errorf("expected the shared page, got %d %q", rec.Code, rec.Body.String())
+	}
+	expected := map[string]string{
+		"lib.example.edu":          "http://library.example.edu/page.html?q=1",
+		"www.library.example.edu":  "http://library.example.edu/page.html?q=1",
+		"lib.example.edu:8000":     "http://library.example.edu:8000/page.html?q=1",
+		"www.library.example.edu.": "http://library.example.edu/page.html?q=1",
+	}
+	for host, location := range expected {
+		rec := get(host, "/page.html?q=1")
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != location {
+			t.Errorf("%s, expected 301 to %s, got %d %q", host, location, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+	rec := get("library.example.edu", "/old/page.html")
+	if loc := rec.Header().Get("Location"); rec.Code != http.StatusMovedPermanently || loc != "/new/page.html" {
+		t.Errorf("expected host redirect, got %d %q", rec.Code, loc)
+	}
+
+	// Behind a trusted proxy terminating TLS redirects stay on https.
+	ws.TrustedProxies = []string{"192.0.2.1"}
+	if h, err = ws.Handler(); err != nil {
+		t.Fatal(err)
+	}
+	for remote, location := range map[string]string{
+		"192.0.2.1:1234":    "https://library.example.edu/page.html",
+		"198.51.100.9:1234": "http://library.example.edu/page.html",
+	} {
+		req := httptest.NewRequest("GET", "/page.html", nil)
+		req.Host, req.RemoteAddr = "lib.example.edu", remote
+		req.Header.Set("X-Forwarded-Proto", "https")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if loc := rec.Header().Get("Location"); loc != location {
+			t.Errorf("from %s, expected a redirect to %s, got %d %q", remote, location, rec.Code, loc)
+		}
+	}
+	ws.TrustedProxies = nil
+
+	ws.Hosts = append(ws.Hosts, &VirtualHost{Host: "lib.example.edu"})
+	if _, err := ws.Handler(); err == nil {
+		t.Errorf("expected an error for a host that is also an alias")
+	}
+}
+
+// writeTestCert writes a self signed certificate for host to dir
+// returning the certificate and key file names.
+func writeTestCert(t *testing.T, dir string, host string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM := filepath.Join(dir, host+".pem"), filepath.Join(dir, host+".key")
+	os.WriteFile(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certPEM, keyPEM
+}
+
+func TestVirtualHostSecurity(t *testing.T) {
+	dir := t.TempDir()
+	archives := filepath.Join(dir, "archives")
+	os.Mkdir(archives, 0775)
+	for _, d := range []string{dir, archives} {
+		if err := os.WriteFile(filepath.Join(d, "page.html"), []byte("page"), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	libCert, libKey := writeTestCert(t, dir, "library.example.edu")
+	archCert, archKey := writeTestCert(t, dir, "archives.example.edu")
+	restricted := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/"}}
+	restricted.UpdateAccess("Jane.Doe", "secret")
+	ws := &WebService{DocRoot: dir, Hosts: []*VirtualHost{
+		{Host: "library.example.edu", CertPEM: libCert, KeyPEM: libKey},
+		{Host: "archives.example.edu", WWW: true, DocRoot: archives, CertPEM: archCert, KeyPEM: archKey, Access: restricted},
+	}}
+
+	cfg, err := ws.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, expected := range map[string]string{
+		"library.example.edu":      "library.example.edu",
+		"www.archives.example.edu": "archives.example.edu",
+		"unknown.example.edu":      "library.example.edu",
+	} {
+		cert, err := cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: host})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		if leaf.Subject.CommonName != expected {
+			t.Errorf("%s, expected certificate for %s, got %s", host, expected, leaf.Subject.CommonName)
+		}
+	}
+
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, expected := range map[string]int{
+		"library.example.edu":  http.StatusOK,
+		"archives.example.edu": http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest("GET", "/page.html", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != expected {
+			t.Errorf("%s, expected %d, got %d", host, expected, rec.Code)
+		}
+	}
+
+	// Protecting the shared htdocs on one host would leave it open
+	// on the others.
+	ws.Hosts[1].DocRoot = ""
+	if _, err := ws.Handler(); err == nil {
+		t.Errorf("expected an access_file without htdocs refused")
+	}
+}
+
+func TestVirtualHostLogFile(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "library.log")
+	ws := &WebService{DocRoot: dir, Hosts: []*VirtualHost{{Host: "library.example.edu", LogFile: logFile}}}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"library.example.edu", "other.example.edu"} {
+		req := httptest.NewRequest("GET", "/missing.html", nil)
+		req.Host = host
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	fp, err := os.Open(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	stats := new(LogStats)
+	if err := stats.ReadLogStats(fp); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Requests != 1 || stats.Hosts["library.example.edu"] != 1 {
+		t.Errorf("expected only the host's request logged, got %+v", stats)
+	}
+}
+
+func TestWildcardHost(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"sites/physics/page.html", "sites/chem/page.html", "page.html"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0775)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws := &WebService{DocRoot: dir, Hosts: []*VirtualHost{
+		{Host: "*.example.edu", DocRoot: filepath.Join(dir, "sites", "%s")},
+	}}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, expected := range map[string]string{
+		"physics.example.edu":     "sites/physics/page.html",
+		"Chem.Example.edu:8000":   "sites/chem/page.html",
+		"biology.example.edu":     "page.html",
+		"a.physics.example.edu":   "page.html",
+		"example.edu":             "page.html",
+		"physics.example.edu.org": "page.html",
+	} {
+		req := httptest.NewRequest("GET", "/page.html", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Body.String() != expected {
+			t.Errorf("%s, expected %q, got %d %q", host, expected, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestDefaultHost(t *testing.T) {
+	dir, library := t.TempDir(), t.TempDir()
+	for fName, src := range map[string]string{
+		filepath.Join(dir, "page.html"):     "shared",
+		filepath.Join(library, "page.html"): "library",
+		filepath.Join(dir, "no-site.html"):  "no such site",
+	} {
+		if err := os.WriteFile(fName, []byte(src), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(ws *WebService) *httptest.ResponseRecorder {
+		t.Helper()
+		h, err := ws.Handler()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "/page.html", nil)
+		req.Host = "unknown.example.org"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	ws := &WebService{DocRoot: dir, Hosts: []*VirtualHost{{Host: "library.example.edu", DocRoot: library}}}
+	if rec := get(ws); rec.Body.String() != "shared" {
+		t.Errorf("expected the shared site, got %q", rec.Body.String())
+	}
+	ws.DefaultHost = "library.example.edu"
+	if rec := get(ws); rec.Body.String() != "library" {
+		t.Errorf("expected the default host, got %q", rec.Body.String())
+	}
+	ws.DefaultHost, ws.UnknownHostPage = "", filepath.Join(dir, "no-site.html")
+	if rec := get(ws); rec.Code != http.StatusNotFound || rec.Body.String() != "no such site" {
+		t.Errorf("expected the unknown host page, got %d %q", rec.Code, rec.Body.String())
+	}
+	ws.DefaultHost = "archives.example.edu"
+	if _, err := ws.Handler(); err == nil {
+		t.Errorf("expected an error for an undefined default host")
+	}
+}
@@ -0,0 +1,99 @@
+// identity.go carries the authenticated user in the request context and
+// passes it to trusted upstreams.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Headers sent to upstreams listed in WebService.IdentityHeaders.
+const (
+	AuthenticatedUserHeader   = "X-Authenticated-User"
+	AuthenticatedGroupsHeader = "X-Authenticated-Groups"
+)
+
+// Identity is the authenticated user of a request.
+type Identity struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// identityKey is the context key holding the *Identity.
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx holding id.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFrom returns the authenticated user of a request, nil if
+// the request wasn't authenticated (e.g. it isn't below a protected
+// route).
+func IdentityFrom(r *http.Request) *Identity {
+	id, _ := r.Context().Value(identityKey{}).(*Identity)
+	return id
+}
+
+// AuthenticatedUser returns the authenticated username of a request,
+// an empty string if there is none.
+func AuthenticatedUser(r *http.Request) string {
+	if id := IdentityFrom(r); id != nil {
+		return id.Username
+	}
+	return ""
+}
+
+// withIdentity returns req carrying username and their groups.
+func (a *Access) withIdentity(req *http.Request, username string) *http.Request {
+	id := &Identity{Username: username}
+	if secret, err := a.store().Lookup(username); err == nil {
+		id.Groups = secret.Groups
+	}
+	return req.WithContext(WithIdentity(req.Context(), id))
+}
+
+// identityHeader reports if name is read as an identity header,
+// including spellings like "x_authenticated_user" that upstreams
+// (e.g. CGI and many frameworks) treat as the same header.
+func identityHeader(name string) bool {
+	name = strings.ReplaceAll(name, "_", "-")
+	return strings.EqualFold(name, AuthenticatedUserHeader) ||
+		strings.EqualFold(name, AuthenticatedGroupsHeader)
+}
+
+// identityHandler removes identity headers sent by clients and,
+// when inject is true, sets them from the request's Identity.
+func identityHandler(next http.Handler, inject bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name := range r.Header {
+			if identityHeader(name) {
+				delete(r.Header, name)
+			}
+		}
+		if id := IdentityFrom(r); inject && id != nil {
+			r.Header.Set(AuthenticatedUserHeader, id.Username)
+			if len(id.Groups) > 0 {
+				r.Header.Set(AuthenticatedGroupsHeader, strings.Join(id.Groups, ","))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,112 @@
+// identity_test.go tests passing the authenticated user to handlers and upstreams.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIdentity(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%q %q", r.Header.Get(AuthenticatedUserHeader), r.Header.Get(AuthenticatedGroupsHeader))
+	}))
+	defer upstream.Close()
+
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/api/", "/other/"}}
+	if err := a.UpdateUser("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Grant("@staff", "/api/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Grant("Jane.Doe", "@staff"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Grant("Jane.Doe", "/other/"); err != nil {
+		t.Fatal(err)
+	}
+	ws := &WebService{
+		DocRoot:         t.TempDir(),
+		Access:          a,
+		ReverseProxy:    map[string]string{"/api/": upstream.URL, "/other/": upstream.URL},
+		IdentityHeaders: []string{"/api/"},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"/api/items":   `"Jane.Doe" "staff"`,
+		"/other/items": `"" ""`,
+	}
+	for p, expected := range tests {
+		req := httptest.NewRequest("GET", p, nil)
+		req.SetBasicAuth("Jane.Doe", "secret")
+		// Clients can't set the headers themselves.
+		req.Header.Set(AuthenticatedUserHeader, "admin")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		src, _ := io.ReadAll(rec.Body)
+		if string(src) != expected {
+			t.Errorf("%s expected %s, got %s", p, expected, src)
+		}
+	}
+
+	// Handlers read the user from the context.
+	var got *Identity
+	h = a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = IdentityFrom(r)
+	}))
+	req := httptest.NewRequest("GET", "/other/", nil)
+	req.SetBasicAuth("Jane.Doe", "secret")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got == nil || got.Username != "Jane.Doe" {
+		t.Errorf("expected Jane.Doe in the context, got %+v", got)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/public/", nil))
+	if got != nil {
+		t.Errorf("expected no identity for a public path, got %+v", got)
+	}
+
+	// Nor spell them differently.
+	h = identityHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name := range r.Header {
+			if identityHeader(name) {
+				fmt.Fprintf(w, "%s: %s\n", name, r.Header[name])
+			}
+		}
+	}), true)
+	req = httptest.NewRequest("GET", "/api/", nil)
+	req.Header["X_Authenticated_User"] = []string{"admin"}
+	req.Header["x-authenticated-groups"] = []string{"admin"}
+	req = req.WithContext(WithIdentity(req.Context(), &Identity{Username: "Jane.Doe"}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if expected := "X-Authenticated-User: [Jane.Doe]\n"; rec.Body.String() != expected {
+		t.Errorf("expected %q, got %q", expected, rec.Body.String())
+	}
+
+	ws.IdentityHeaders = []string{"/missing/"}
+	if _, err := ws.Handler(); err == nil {
+		t.Errorf("expected an error for a prefix that isn't a reverse_proxy route")
+	}
+}
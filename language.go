@@ -0,0 +1,137 @@
+// language.go serves language variants of static files (e.g.
+// index.en.html, /es/) based on Accept-Language.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Languages configures Accept-Language negotiation of static files.
+// Variants are named with the language before the extension (e.g.
+// "index.en.html", "index.es.html") or, with Directories, kept in a
+// directory per language (e.g. "/en/index.html", "/es/index.html").
+type Languages struct {
+	// Supported lists the language tags of the variants, e.g.
+	// [ "en", "es" ].
+	Supported []string `json:"supported" toml:"supported"`
+
+	// Default is served when none of the client's languages are
+	// supported.
+	Default string `json:"default,omitempty" toml:"default,omitempty"`
+
+	// Directories looks for variants in "/<lang>/" directories rather
+	// than "<name>.<lang>.<ext>" files.
+	Directories bool `json:"directories,omitempty" toml:"directories,omitempty"`
+}
+
+// preferred returns the supported languages acceptable to the
+// request, most preferred first, followed by Default.
+func (l *Languages) preferred(r *http.Request) []string {
+	tokens := parseAcceptTokens(r.Header.Get("Accept-Language"))
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].q > tokens[j].q
+	})
+	langs := []string{}
+	add := func(lang string) {
+		for _, l := range langs {
+			if l == lang {
+				return
+			}
+		}
+		langs = append(langs, lang)
+	}
+	for _, at := range tokens {
+		if at.q <= 0 {
+			continue
+		}
+		for _, lang := range l.Supported {
+			tag := strings.ToLower(lang)
+			if at.token == "*" || at.token == tag || strings.HasPrefix(at.token, tag+"-") {
+				add(lang)
+				if at.token == "*" {
+					break
+				}
+			}
+		}
+	}
+	if l.Default != "" {
+		add(l.Default)
+	}
+	return langs
+}
+
+// variant returns the path of the lang variant of p.
+func (l *Languages) variant(p string, lang string) string {
+	if l.Directories {
+		return "/" + lang + p
+	}
+	if strings.HasSuffix(p, "/") {
+		return p + "index." + lang + ".html"
+	}
+	ext := path.Ext(p)
+	if ext == "" {
+		return ""
+	}
+	return strings.TrimSuffix(p, ext) + "." + lang + ext
+}
+
+// isVariant reports if p already names a language (e.g.
+// "/en/about.html" or "/about.en.html").
+func (l *Languages) isVariant(p string) bool {
+	for _, lang := range l.Supported {
+		if l.Directories {
+			if strings.HasPrefix(p, "/"+lang+"/") {
+				return true
+			}
+		} else if strings.Contains(path.Base(p), "."+lang+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler serves the client's preferred variant of GET and HEAD
+// requests from fs, setting Content-Language and Vary. Requests
+// naming a variant, or without one, are passed to next as is.
+func (l *Languages) Handler(fs http.FileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || l.isVariant(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, lang := range l.preferred(r) {
+			p := l.variant(r.URL.Path, lang)
+			if p == "" {
+				break
+			}
+			if f, err := fs.Open(p); err == nil {
+				f.Close()
+				w.Header().Add("Vary", "Accept-Language")
+				w.Header().Set("Content-Language", lang)
+				r = r.Clone(r.Context())
+				r.URL.Path, r.URL.RawPath = p, ""
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,87 @@
+// language_test.go tests Accept-Language negotiation of static files.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLanguages(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"index.en.html", "index.es.html", "about.en.html", "es/exhibit.html", "en/exhibit.html"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0775)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(ws *WebService, p string, accept string) (string, string) {
+		t.Helper()
+		h, err := ws.Handler()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", p, nil)
+		req.Header.Set("Accept-Language", accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if vary := rec.Header().Get("Vary"); vary != "Accept-Language" {
+			t.Errorf("%s, expected Vary: Accept-Language, got %q", p, vary)
+		}
+		src, _ := io.ReadAll(rec.Body)
+		return string(src), rec.Header().Get("Content-Language")
+	}
+
+	ws := &WebService{DocRoot: dir, Languages: &Languages{Supported: []string{"en", "es"}, Default: "en"}}
+	expected := []struct{ p, accept, body, lang string }{
+		{"/", "es-MX, en;q=0.5", "index.es.html", "es"},
+		{"/", "fr, en;q=0.8, es;q=0.9", "index.es.html", "es"},
+		{"/", "fr", "index.en.html", "en"},
+		{"/about.html", "es", "about.en.html", "en"},
+	}
+	for _, e := range expected {
+		if body, lang := get(ws, e.p, e.accept); body != e.body || lang != e.lang {
+			t.Errorf("%s %q, expected %s (%s), got %s (%s)", e.p, e.accept, e.body, e.lang, body, lang)
+		}
+	}
+
+	ws.Languages.Directories = true
+	if body, lang := get(ws, "/exhibit.html", "es"); body != "es/exhibit.html" || lang != "es" {
+		t.Errorf("expected es/exhibit.html, got %s (%s)", body, lang)
+	}
+
+	// Access checks the variant's path, not the one requested.
+	ws.Access = &Access{AuthType: "basic", Routes: []string{"/es/"}}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for accept, status := range map[string]int{"es": http.StatusUnauthorized, "en": http.StatusOK} {
+		req := httptest.NewRequest("GET", "/exhibit.html", nil)
+		req.Header.Set("Accept-Language", accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Errorf("Accept-Language %q, expected %d, got %d", accept, status, rec.Code)
+		}
+	}
+}
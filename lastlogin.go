@@ -0,0 +1,135 @@
+// lastlogin.go keeps users' last login times beside the access file,
+// so logging in never rewrites the access file itself.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// lastLoginFile names the file beside an access file holding the
+// last login times, e.g. "access.toml.logins.json".
+func lastLoginFile(source string) string {
+	return source + ".logins.json"
+}
+
+// readLastLogins reads the last login times saved beside source.
+func readLastLogins(source string) (map[string]time.Time, error) {
+	logins := map[string]time.Time{}
+	src, err := os.ReadFile(lastLoginFile(source))
+	if os.IsNotExist(err) {
+		return logins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(src, &logins); err != nil {
+		return nil, fmt.Errorf("%s, %s", lastLoginFile(source), err)
+	}
+	return logins, nil
+}
+
+// loadLastLogins sets the users' LastLoginAt from the file beside
+// the access file when it is later.
+func (a *Access) loadLastLogins() error {
+	logins, err := readLastLogins(a.source)
+	if err != nil {
+		return err
+	}
+	for username, t := range logins {
+		secret, err := a.store().Lookup(username)
+		if err != nil || (secret.LastLoginAt != nil && secret.LastLoginAt.After(t) == false) {
+			continue
+		}
+		updated := new(Secrets)
+		*updated = *secret
+		last := t
+		updated.LastLoginAt = &last
+		if err := a.store().Update(username, updated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveLastLogin records username's login beside the access file,
+// dropping users no longer in it.
+func (a *Access) saveLastLogin(username string, t time.Time) error {
+	a.loginMu.Lock()
+	defer a.loginMu.Unlock()
+	logins, err := readLastLogins(a.source)
+	if err != nil {
+		return err
+	}
+	logins[username] = t
+	for name := range logins {
+		if _, err := a.store().Lookup(name); err != nil {
+			delete(logins, name)
+		}
+	}
+	src, err := json.MarshalIndent(logins, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFile(lastLoginFile(a.source), src, 0600, false)
+}
+
+// saveRehash saves username's rehashed password to the access file.
+// The file is read again and only that user's password is replaced,
+// and only if it is still the one old was read from, so changes made
+// since it was loaded (e.g. by webaccess) aren't undone.
+func (a *Access) saveRehash(username string, old *Secrets) error {
+	secret, err := a.store().Lookup(username)
+	if err != nil {
+		return err
+	}
+	a.dumpMu.Lock()
+	disk, err := LoadAccess(a.source)
+	a.dumpMu.Unlock()
+	if err != nil {
+		return err
+	}
+	saved, err := disk.Lookup(username)
+	if err != nil || bytes.Equal(saved.Key, old.Key) == false {
+		log.Printf("rehash %q, changed in %s, not saved", username, a.source)
+		return nil
+	}
+	a.mu.RLock()
+	encryption := a.Encryption
+	a.mu.RUnlock()
+	if err := disk.SetEncryption(encryption); err != nil {
+		return err
+	}
+	// SetEncryption may have pinned the old scheme.
+	if saved, err = disk.Lookup(username); err != nil {
+		return err
+	}
+	updated := new(Secrets)
+	*updated = *saved
+	updated.Salt, updated.Key = secret.Salt, secret.Key
+	updated.Argon2, updated.PBKDF2, updated.Encryption = secret.Argon2, secret.PBKDF2, secret.Encryption
+	if err := disk.store().Update(username, updated); err != nil {
+		return err
+	}
+	return disk.DumpAccess(a.source)
+}
@@ -0,0 +1,123 @@
+// lastlogin_test.go tests keeping last login times beside the access file.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLastLogin(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "access.toml")
+	a := &Access{AuthType: "basic", Encryption: "argon2id", SessionSeconds: -1}
+	for _, username := range []string{"Jane.Doe", "John.Doe"} {
+		if err := a.UpdateUser(username, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.DumpAccess(fName); err != nil {
+		t.Fatal(err)
+	}
+	a, err := LoadAccess(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// John.Doe is removed while the server is running.
+	other, _ := LoadAccess(fName)
+	other.RemoveAccess("John.Doe")
+	if err := other.DumpAccess(fName); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(fName)
+	if err := a.VerifyLogin("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(fName); string(after) != string(before) {
+		t.Errorf("expected logging in not to rewrite %s", fName)
+	}
+	if _, err := os.Stat(fName + ".bak.1"); err == nil {
+		t.Errorf("expected logging in not to rotate backups")
+	}
+	saved, err := LoadAccess(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := saved.Lookup("John.Doe"); err == nil {
+		t.Errorf("expected John.Doe to stay removed")
+	}
+	if secret, _ := saved.Lookup("Jane.Doe"); secret.LastLoginAt == nil {
+		t.Errorf("expected Jane.Doe's last login to be loaded")
+	}
+}
+
+func TestRehashMerge(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "access.toml")
+	a := &Access{AuthType: "basic", Encryption: "md5", SessionSeconds: -1}
+	for _, username := range []string{"Jane.Doe", "John.Doe"} {
+		if err := a.UpdateUser(username, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.DumpAccess(fName); err != nil {
+		t.Fatal(err)
+	}
+	a, err := LoadAccess(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetEncryption("argon2id"); err != nil {
+		t.Fatal(err)
+	}
+	// John.Doe is removed while the server is running.
+	other, _ := LoadAccess(fName)
+	other.RemoveAccess("John.Doe")
+	if err := other.DumpAccess(fName); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyLogin("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := LoadAccess(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := saved.Lookup("John.Doe"); err == nil {
+		t.Errorf("expected John.Doe to stay removed")
+	}
+	if pending, _ := saved.PendingRehash(); len(pending) != 0 {
+		t.Errorf("expected Jane.Doe's rehash to be saved, pending %q", pending)
+	}
+	// A password changed on disk isn't replaced by a rehash.
+	a, _ = LoadAccess(fName)
+	a.Encryption = "md5"
+	a.UpdateUser("Jane.Doe", "secret")
+	a.DumpAccess(fName)
+	a, _ = LoadAccess(fName)
+	a.SetEncryption("argon2id")
+	other, _ = LoadAccess(fName)
+	other.UpdateUser("Jane.Doe", "changed")
+	other.DumpAccess(fName)
+	if err := a.VerifyLogin("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ = LoadAccess(fName)
+	if err := saved.VerifyLogin("Jane.Doe", "changed"); err != nil {
+		t.Errorf("expected the changed password to be kept, %s", err)
+	}
+}
@@ -0,0 +1,374 @@
+// listing.go renders directory listings with sortable columns, file type
+// icons, breadcrumbs and the directory's README.md.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// listingEntry is a file or directory in a listing.
+type listingEntry struct {
+	Name    string
+	Href    string
+	Icon    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+// listingCrumb is a link to a parent directory.
+type listingCrumb struct {
+	Name string
+	Href string
+}
+
+// listingColumn is a sortable column heading.
+type listingColumn struct {
+	Title string
+	Href  string
+	Arrow string
+}
+
+// listingPage is the data rendered by listingTemplate.
+type listingPage struct {
+	Path    string
+	Crumbs  []*listingCrumb
+	Columns []*listingColumn
+	Entries []*listingEntry
+	Readme  template.HTML
+}
+
+var listingTemplate = template.Must(template.New("listing").Funcs(templateFuncs).Funcs(template.FuncMap{
+	"humanBytes": humanBytes,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Index of {{.Path}}</title>
+<style nonce="{{cspNonce}}">
+body { font-family: sans-serif; margin: 1em 2em; }
+nav a { text-decoration: none; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.25em 0.75em; border-bottom: 1px solid #ddd; }
+th a { text-decoration: none; color: inherit; }
+td.size, th.size { text-align: right; }
+section.readme { border: 1px solid #ddd; padding: 0 1em; margin-bottom: 1em; }
+</style>
+</head>
+<body>
+<nav>{{range $i, $c := .Crumbs}}{{if $i}} / {{end}}<a href="{{$c.Href}}">{{$c.Name}}</a>{{end}}</nav>
+<h1>Index of {{.Path}}</h1>
+{{if .Readme}}<section class="readme">
+{{.Readme}}
+</section>
+{{end}}<table>
+<thead><tr>{{range .Columns}}<th{{if eq .Title "Size"}} class="size"{{end}}><a href="{{.Href}}">{{.Title}}{{.Arrow}}</a></th>{{end}}</tr></thead>
+<tbody>
+{{if ne .Path "/"}}<tr><td>&#x2B06;&#xFE0F; <a href="../">Parent directory</a></td><td class="size"></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td>{{.Icon}} <a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td class="size">{{if not .IsDir}}{{humanBytes .Size}}{{end}}</td><td>{{.ModTime.Format "2006-01-02 15:04"}}</td></tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
+
+// listingIcon returns an icon for a file's type.
+func listingIcon(name string, isDir bool) string {
+	if isDir {
+		return "\U0001F4C1"
+	}
+	mimeType := mime.TypeByExtension(path.Ext(name))
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "\U0001F5BC\uFE0F"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "\U0001F3B5"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "\U0001F39E\uFE0F"
+	case mimeType == "application/pdf":
+		return "\U0001F4D5"
+	case strings.Contains(mimeType, "zip") || strings.Contains(mimeType, "tar") || strings.Contains(mimeType, "compress"):
+		return "\U0001F5DC\uFE0F"
+	case strings.HasPrefix(mimeType, "text/") || strings.Contains(mimeType, "json") || strings.Contains(mimeType, "xml"):
+		return "\U0001F4C4"
+	}
+	return "\U0001F4E6"
+}
+
+// sortListing orders entries by name, size or modified time
+// (directories first).
+func sortListing(entries []*listingEntry, by string, desc bool) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if desc {
+			a, b = b, a
+		}
+		switch by {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "modified":
+			if a.ModTime.Equal(b.ModTime) == false {
+				return a.ModTime.Before(b.ModTime)
+			}
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+}
+
+// listingColumns returns the column headings, each linking to sort
+// by it (reversing the current order when already sorted by it).
+func listingColumns(by string, desc bool) []*listingColumn {
+	columns := []*listingColumn{}
+	for _, c := range [][2]string{{"name", "Name"}, {"size", "Size"}, {"modified", "Modified"}} {
+		col := &listingColumn{Title: c[1], Href: "?sort=" + c[0]}
+		if c[0] == by {
+			col.Arrow = " ▲"
+			if desc {
+				col.Arrow = " ▼"
+			} else {
+				col.Href += "&order=desc"
+			}
+		}
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+// listingCrumbs returns links to each directory in p.
+func listingCrumbs(p string) []*listingCrumb {
+	crumbs := []*listingCrumb{{Name: "Home", Href: "/"}}
+	href := "/"
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		href += url.PathEscape(name) + "/"
+		crumbs = append(crumbs, &listingCrumb{Name: name, Href: href})
+	}
+	return crumbs
+}
+
+var (
+	mdHeading = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	mdBullet  = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdNumber  = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	mdLink    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdStrong  = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdEm      = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+)
+
+// markdownInline renders code spans, links, strong and emphasis in
+// a line of text, escaping everything else.
+func markdownInline(s string) string {
+	out := new(strings.Builder)
+	for i, part := range strings.Split(s, "`") {
+		if i%2 == 1 {
+			out.WriteString("<code>" + template.HTMLEscapeString(part) + "</code>")
+			continue
+		}
+		part = template.HTMLEscapeString(part)
+		part = mdLink.ReplaceAllStringFunc(part, func(m string) string {
+			sub := mdLink.FindStringSubmatch(m)
+			u, err := url.Parse(html.UnescapeString(sub[2]))
+			if err != nil || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "mailto") {
+				return sub[1]
+			}
+			return `<a href="` + template.HTMLEscapeString(u.String()) + `">` + sub[1] + `</a>`
+		})
+		part = mdStrong.ReplaceAllString(part, "<strong>$1$2</strong>")
+		part = mdEm.ReplaceAllString(part, "<em>$1$2</em>")
+		out.WriteString(part)
+	}
+	return out.String()
+}
+
+// renderMarkdown renders the common parts of Markdown (headings,
+// paragraphs, lists, fenced code, links and emphasis) for README
+// files. Raw HTML is escaped.
+func renderMarkdown(src []byte) template.HTML {
+	out := new(strings.Builder)
+	para, list, code := []string{}, "", false
+	flush := func() {
+		if len(para) > 0 {
+			out.WriteString("<p>" + markdownInline(strings.Join(para, " ")) + "</p>\n")
+			para = para[:0]
+		}
+		if list != "" {
+			out.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	item := func(tag string, text string) {
+		if len(para) > 0 || list != tag {
+			flush()
+			out.WriteString("<" + tag + ">\n")
+			list = tag
+		}
+		out.WriteString("<li>" + markdownInline(text) + "</li>\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if code {
+				out.WriteString("</code></pre>\n")
+			} else {
+				flush()
+				out.WriteString("<pre><code>")
+			}
+			code = code == false
+			continue
+		}
+		if code {
+			out.WriteString(template.HTMLEscapeString(line) + "\n")
+			continue
+		}
+		if m := mdHeading.FindStringSubmatch(line); m != nil {
+			flush()
+			tag := "h" + string(rune('0'+len(m[1])))
+			out.WriteString("<" + tag + ">" + markdownInline(m[2]) + "</" + tag + ">\n")
+		} else if m := mdBullet.FindStringSubmatch(line); m != nil {
+			item("ul", m[1])
+		} else if m := mdNumber.FindStringSubmatch(line); m != nil {
+			item("ol", m[1])
+		} else if strings.TrimSpace(line) == "" {
+			flush()
+		} else {
+			if list != "" {
+				flush()
+			}
+			para = append(para, strings.TrimSpace(line))
+		}
+	}
+	if code {
+		out.WriteString("</code></pre>\n")
+	}
+	flush()
+	return template.HTML(out.String())
+}
+
+// readme returns the rendered README.md in directory p, if any.
+func readme(fs http.FileSystem, p string) template.HTML {
+	for _, name := range []string{"README.md", "readme.md", "Readme.md"} {
+		f, err := fs.Open(path.Join(p, name))
+		if err != nil {
+			continue
+		}
+		src, err := io.ReadAll(io.LimitReader(f, 1<<20))
+		f.Close()
+		if err == nil {
+			return renderMarkdown(src)
+		}
+	}
+	return ""
+}
+
+// ListingHandler answers requests for directories without an
+// index.html with an HTML listing: sortable by name, size or
+// modified time (the "sort" and "order" query parameters), with file
+// type icons, breadcrumbs and the directory's README.md rendered
+// above it. Other requests are passed to next, e.g.
+// http.FileServer(fs).
+func ListingHandler(fs http.FileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean("/" + r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/") == false || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if f, err := fs.Open(path.Join(p, "index.html")); err == nil {
+			f.Close()
+			next.ServeHTTP(w, r)
+			return
+		}
+		dir, err := fs.Open(p)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer dir.Close()
+		if info, err := dir.Stat(); err != nil || info.IsDir() == false {
+			next.ServeHTTP(w, r)
+			return
+		}
+		infos, err := dir.Readdir(-1)
+		if err != nil {
+			httpError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		entries := []*listingEntry{}
+		for _, info := range infos {
+			if strings.HasPrefix(info.Name(), ".") {
+				continue
+			}
+			href := url.PathEscape(info.Name())
+			if info.IsDir() {
+				href += "/"
+			}
+			entries = append(entries, &listingEntry{
+				Name:    info.Name(),
+				Href:    href,
+				Icon:    listingIcon(info.Name(), info.IsDir()),
+				IsDir:   info.IsDir(),
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			})
+		}
+		by, desc := r.URL.Query().Get("sort"), r.URL.Query().Get("order") == "desc"
+		if by != "size" && by != "modified" {
+			by = "name"
+		}
+		sortListing(entries, by, desc)
+		page := &listingPage{
+			Path:    strings.TrimSuffix(p, "/") + "/",
+			Crumbs:  listingCrumbs(p),
+			Columns: listingColumns(by, desc),
+			Entries: entries,
+			Readme:  readme(fs, p),
+		}
+		tmpl, err := listingTemplate.Clone()
+		if err != nil {
+			httpError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		buf := new(bytes.Buffer)
+		if err := tmpl.Funcs(CSPFuncMap(r)).Execute(buf, page); err != nil {
+			httpError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		writeBody(w, r, http.StatusOK, buf.Bytes())
+	})
+}
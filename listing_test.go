@@ -0,0 +1,71 @@
+// listing_test.go tests the directory listing handler.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListingHandler(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "data")
+	os.MkdirAll(filepath.Join(dir, "images"), 0755)
+	os.MkdirAll(filepath.Join(root, "site"), 0755)
+	os.WriteFile(filepath.Join(dir, "small.txt"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "large.pdf"), []byte(strings.Repeat("x", 4096)), 0644)
+	os.WriteFile(filepath.Join(dir, ".hidden"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("# Survey data\n\nSee the **codebook** and [docs](https://example.edu/docs).\n\n- one\n- two\n\n<script>alert(1)</script> [bad](javascript:alert(1))\n"), 0644)
+	os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("home"), 0644)
+
+	h := ListingHandler(http.Dir(root), http.FileServer(http.Dir(root)))
+	serve := func(p string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+		return rec
+	}
+	rec := serve("/data/?sort=size&order=desc")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") == false {
+		t.Fatalf("expected an HTML listing, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	images, large, small := strings.Index(body, ">images/<"), strings.Index(body, ">large.pdf<"), strings.Index(body, ">small.txt<")
+	if images < 0 || large < 0 || small < 0 || (images < large && large < small) == false {
+		t.Errorf("expected directories first then largest first, got\n%s", body)
+	}
+	for _, s := range []string{`<a href="/data/">data</a>`, "<h1>Survey data</h1>", "<strong>codebook</strong>", `<a href="https://example.edu/docs">docs</a>`, "<li>two</li>", "&lt;script&gt;", `href="?sort=size"`} {
+		if strings.Contains(body, s) == false {
+			t.Errorf("expected %q in listing", s)
+		}
+	}
+	for _, s := range []string{".hidden", "javascript:", "<script>"} {
+		if strings.Contains(body, s) {
+			t.Errorf("unexpected %q in listing", s)
+		}
+	}
+	if rec = serve("/site/"); rec.Body.String() != "home" {
+		t.Errorf("expected index.html to be served, got %q", rec.Body.String())
+	}
+	if rec = serve("/data/small.txt"); rec.Body.String() != "x" {
+		t.Errorf("expected file to be served, got %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,258 @@
+// livereload.go reloads browsers when files in the document root
+// change, a development aid.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// LiveReloadPath is the server sent events endpoint browsers
+	// listen to for reloads.
+	LiveReloadPath = "/_wsfn/livereload"
+
+	// LiveReloadInterval is how often the document root is checked
+	// for changes.
+	LiveReloadInterval = 500 * time.Millisecond
+)
+
+// liveReload watches directories, notifying subscribers of changes.
+// The directories are only polled while a browser is listening.
+type liveReload struct {
+	dirs []string
+
+	mu      sync.Mutex
+	clients map[chan struct{}]bool
+	stop    chan struct{}
+}
+
+func newLiveReload(dirs []string) *liveReload {
+	return &liveReload{dirs: dirs, clients: map[chan struct{}]bool{}}
+}
+
+// snapshot returns a hash of the names, sizes and modification
+// times of the files being watched.
+func (lr *liveReload) snapshot() uint64 {
+	h := fnv.New64a()
+	for _, dir := range lr.dirs {
+		filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if p != dir && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				fmt.Fprintf(h, "%s %d %d\n", p, info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+	}
+	return h.Sum64()
+}
+
+// watch polls for changes until stop is closed.
+func (lr *liveReload) watch(stop chan struct{}) {
+	last := lr.snapshot()
+	ticker := time.NewTicker(LiveReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if current := lr.snapshot(); current != last {
+				last = current
+				lr.mu.Lock()
+				for ch := range lr.clients {
+					select {
+					case ch <- struct{}{}:
+					default:
+					}
+				}
+				lr.mu.Unlock()
+			}
+		}
+	}
+}
+
+// subscribe returns a channel receiving a value on each change.
+func (lr *liveReload) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.clients[ch] = true
+	if len(lr.clients) == 1 {
+		lr.stop = make(chan struct{})
+		go lr.watch(lr.stop)
+	}
+	return ch
+}
+
+func (lr *liveReload) unsubscribe(ch chan struct{}) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	delete(lr.clients, ch)
+	if len(lr.clients) == 0 && lr.stop != nil {
+		close(lr.stop)
+		lr.stop = nil
+	}
+}
+
+// ServeHTTP streams a "reload" event for each change.
+func (lr *liveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if ok == false {
+		httpError(w, r, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ch := lr.subscribe()
+	defer lr.unsubscribe(ch)
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-ch:
+			fmt.Fprintf(w, "event: reload\ndata: %d\n\n", time.Now().Unix())
+		}
+		flusher.Flush()
+	}
+}
+
+// liveReloadScript returns the script listening for reloads, with
+// the request's CSP nonce if any.
+func liveReloadScript(r *http.Request) string {
+	nonce := ""
+	if n := CSPNonce(r); n != "" {
+		nonce = ` nonce="` + n + `"`
+	}
+	return fmt.Sprintf(`<script%s>new EventSource(%q).addEventListener("reload", function () { location.reload(); });</script>`, nonce, LiveReloadPath)
+}
+
+// injectWriter buffers HTML responses so the live reload script can
+// be added before "</body>".
+type injectWriter struct {
+	http.ResponseWriter
+	html   bool
+	status int
+	buf    bytes.Buffer
+}
+
+func (iw *injectWriter) WriteHeader(status int) {
+	if iw.status != 0 {
+		return
+	}
+	iw.status = status
+	iw.html = status == http.StatusOK && iw.Header().Get("Content-Encoding") == "" &&
+		strings.HasPrefix(iw.Header().Get("Content-Type"), "text/html")
+	if iw.html == false {
+		iw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (iw *injectWriter) Write(p []byte) (int, error) {
+	if iw.status == 0 {
+		iw.WriteHeader(http.StatusOK)
+	}
+	if iw.html {
+		return iw.buf.Write(p)
+	}
+	return iw.ResponseWriter.Write(p)
+}
+
+// finish writes the buffered HTML with the script added.
+func (iw *injectWriter) finish(r *http.Request) {
+	if iw.html == false {
+		return
+	}
+	src, script := iw.buf.Bytes(), []byte(liveReloadScript(r))
+	if i := bytes.LastIndex(bytes.ToLower(src), []byte("</body>")); i >= 0 {
+		src = append(src[:i:i], append(script, src[i:]...)...)
+	} else {
+		src = append(src, script...)
+	}
+	// The page no longer matches the file's ETag.
+	iw.Header().Del("ETag")
+	if r.Method == http.MethodHead {
+		// The page wasn't sent, its length is the file's plus the script.
+		if n, err := strconv.Atoi(iw.Header().Get("Content-Length")); err == nil {
+			iw.Header().Set("Content-Length", strconv.Itoa(n+len(script)))
+		}
+	} else {
+		iw.Header().Set("Content-Length", strconv.Itoa(len(src)))
+	}
+	iw.ResponseWriter.WriteHeader(iw.status)
+	if r.Method != http.MethodHead {
+		iw.ResponseWriter.Write(src)
+	}
+}
+
+// injectLiveReload adds the live reload script to the HTML pages
+// served by next.
+func injectLiveReload(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Pages must be sent whole to add the script.
+		r = r.Clone(r.Context())
+		for _, h := range []string{"If-Modified-Since", "If-None-Match", "Range", "Accept-Encoding"} {
+			r.Header.Del(h)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		iw := &injectWriter{ResponseWriter: w}
+		next.ServeHTTP(iw, r)
+		iw.finish(r)
+	})
+}
+
+// liveReloadDirs returns the document roots to watch.
+func (w *WebService) liveReloadDirs() []string {
+	dirs := []string{}
+	if w.S3 == nil {
+		dirs = append(dirs, w.DocRoot)
+	}
+	dirs = append(dirs, w.DocRootLayers...)
+	if w.Templates != nil {
+		dirs = append(dirs, w.Templates.Directory)
+	}
+	for _, vh := range w.Hosts {
+		if vh.DocRoot != "" && strings.Contains(vh.DocRoot, "%s") == false {
+			dirs = append(dirs, vh.DocRoot)
+		}
+	}
+	return dirs
+}
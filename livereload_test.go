@@ -0,0 +1,101 @@
+// livereload_test.go tests reloading browsers on changes.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveReload(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	if err := os.WriteFile(page, []byte("<html><body><h1>Hi</h1></body></html>"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	interval := LiveReloadInterval
+	LiveReloadInterval = 20 * time.Millisecond
+	defer func() { LiveReloadInterval = interval }()
+
+	ws := &WebService{DocRoot: dir, LiveReload: true}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if strings.Contains(string(src), LiveReloadPath+`").addEventListener("reload"`) == false || strings.HasSuffix(string(src), "</script></body></html>") == false {
+		t.Errorf("expected the script before </body>, got %s", src)
+	}
+	if res.ContentLength != int64(len(src)) {
+		t.Errorf("expected Content-Length %d, got %d", len(src), res.ContentLength)
+	}
+	res, err = http.Head(srv.URL + "/page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.ContentLength != int64(len(src)) {
+		t.Errorf("expected HEAD Content-Length %d, got %d", len(src), res.ContentLength)
+	}
+
+	res, err = http.Get(srv.URL + LiveReloadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+	events := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "event: ") {
+				events <- scanner.Text()
+				return
+			}
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(page, []byte("<html><body><h1>Hello</h1></body></html>"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-events:
+		if ev != "event: reload" {
+			t.Errorf("expected a reload event, got %q", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected a reload event after the change")
+	}
+}
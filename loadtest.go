@@ -0,0 +1,216 @@
+// loadtest.go is a small load generator for measuring a site's
+// throughput and latency, see "webserver selftest".
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoadTestBackoff is how long a LoadTest worker waits after a
+// failed request, doubling for each failure in a row up to
+// LoadTestMaxBackoff, so a down server isn't spun against.
+var (
+	LoadTestBackoff    = 10 * time.Millisecond
+	LoadTestMaxBackoff = time.Second
+)
+
+// LoadTarget is a path requested by LoadTest, with Basic auth
+// credentials if Username is set.
+type LoadTarget struct {
+	Name     string
+	Path     string
+	Username string
+	Password string
+}
+
+// LoadResult summarizes the responses for a LoadTarget.
+type LoadResult struct {
+	Name       string
+	Path       string
+	Requests   int64
+	Errors     int64
+	Statuses   map[int]int64
+	Elapsed    time.Duration
+	Throughput float64
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+	// ErrorP50 and ErrorMax are the latencies of failed requests,
+	// e.g. how long timeouts took.
+	ErrorP50 time.Duration
+	ErrorMax time.Duration
+}
+
+// percentile returns the p'th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p / 100)
+	return sorted[i]
+}
+
+// loadTarget requests target from concurrency workers for duration.
+func loadTarget(client *http.Client, baseURL string, target *LoadTarget, concurrency int, duration time.Duration) (*LoadResult, error) {
+	u := strings.TrimSuffix(baseURL, "/") + target.Path
+	if _, err := http.NewRequest(http.MethodGet, u, nil); err != nil {
+		return nil, err
+	}
+	res := &LoadResult{Name: target.Name, Path: target.Path, Statuses: map[int]int64{}}
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		latencies []time.Duration
+		failures  []time.Duration
+	)
+	start := time.Now()
+	deadline := start.Add(duration)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses, times, errTimes := map[int]int64{}, []time.Duration{}, []time.Duration{}
+			backoff := time.Duration(0)
+			for time.Now().Before(deadline) {
+				req, _ := http.NewRequest(http.MethodGet, u, nil)
+				if target.Username != "" {
+					req.SetBasicAuth(target.Username, target.Password)
+				}
+				t := time.Now()
+				resp, err := client.Do(req)
+				if err != nil {
+					errTimes = append(errTimes, time.Since(t))
+					if backoff = backoff * 2; backoff == 0 {
+						backoff = LoadTestBackoff
+					}
+					if backoff > LoadTestMaxBackoff {
+						backoff = LoadTestMaxBackoff
+					}
+					if wait := time.Until(deadline); wait < backoff {
+						time.Sleep(wait)
+					} else {
+						time.Sleep(backoff)
+					}
+					continue
+				}
+				backoff = 0
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				times = append(times, time.Since(t))
+				statuses[resp.StatusCode]++
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			res.Errors += int64(len(errTimes))
+			for status, n := range statuses {
+				res.Statuses[status] += n
+			}
+			latencies = append(latencies, times...)
+			failures = append(failures, errTimes...)
+		}()
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	res.Requests = int64(len(latencies))
+	res.Throughput = float64(res.Requests) / res.Elapsed.Seconds()
+	res.P50, res.P90, res.P99 = percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99)
+	if len(latencies) > 0 {
+		res.Max = latencies[len(latencies)-1]
+	}
+	if len(failures) > 0 {
+		sort.Slice(failures, func(i, j int) bool { return failures[i] < failures[j] })
+		res.ErrorP50, res.ErrorMax = percentile(failures, 50), failures[len(failures)-1]
+	}
+	return res, nil
+}
+
+// LoadTest requests each target at baseURL (e.g.
+// "http://localhost:8000") from concurrency clients for duration,
+// one target after another.
+func LoadTest(baseURL string, targets []*LoadTarget, concurrency int, duration time.Duration) ([]*LoadResult, error) {
+	if concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	client := &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	defer transport.CloseIdleConnections()
+	results := []*LoadResult{}
+	for _, target := range targets {
+		res, err := loadTarget(client, baseURL, target, concurrency, duration)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// WriteLoadReport writes results as a plain text table. Latencies
+// are of responses, failed requests are counted and timed apart.
+func WriteLoadReport(out io.Writer, results []*LoadResult) {
+	fmt.Fprintf(out, "%-10s %9s %10s %9s %9s %9s %9s  %s\n", "target", "requests", "req/s", "p50", "p90", "p99", "max", "statuses")
+	for _, res := range results {
+		statuses := []string{}
+		for status, n := range res.Statuses {
+			statuses = append(statuses, fmt.Sprintf("%d:%d", status, n))
+		}
+		sort.Strings(statuses)
+		if res.Errors > 0 {
+			statuses = append(statuses, fmt.Sprintf("errors:%d (p50 %s, max %s)", res.Errors, roundDuration(res.ErrorP50), roundDuration(res.ErrorMax)))
+		}
+		fmt.Fprintf(out, "%-10s %9d %10.1f %9s %9s %9s %9s  %s %s\n", res.Name, res.Requests, res.Throughput,
+			roundDuration(res.P50), roundDuration(res.P90), roundDuration(res.P99), roundDuration(res.Max),
+			strings.Join(statuses, " "), res.Path)
+	}
+}
+
+// SelfTestTargets returns the targets for "webserver selftest": the
+// home page, the first access route (authenticating as the
+// WSFN_SELFTEST_USER and WSFN_SELFTEST_PASSWORD environment
+// variables if set) and the first reverse_proxy route.
+func (w *WebService) SelfTestTargets() []*LoadTarget {
+	targets := []*LoadTarget{{Name: "static", Path: "/"}}
+	if w.Access != nil && len(w.Access.Routes) > 0 {
+		targets = append(targets, &LoadTarget{
+			Name:     "protected",
+			Path:     w.Access.Routes[0],
+			Username: os.Getenv("WSFN_SELFTEST_USER"),
+			Password: os.Getenv("WSFN_SELFTEST_PASSWORD"),
+		})
+	}
+	prefixes := []string{}
+	for prefix := range w.ReverseProxy {
+		prefixes = append(prefixes, prefix)
+	}
+	if len(prefixes) > 0 {
+		sort.Strings(prefixes)
+		targets = append(targets, &LoadTarget{Name: "proxied", Path: prefixes[0]})
+	}
+	return targets
+}
@@ -0,0 +1,146 @@
+// loadtest_test.go tests the load generator and benchmarks the handler
+// stack for static, protected and proxied routes.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, ok := r.BasicAuth(); ok == false || user != "jane" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	targets := []*LoadTarget{
+		{Name: "open", Path: "/"},
+		{Name: "auth", Path: "/private/", Username: "jane", Password: "secret"},
+	}
+	results, err := LoadTest(srv.URL, targets, 2, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Requests == 0 || results[0].Statuses[http.StatusUnauthorized] != results[0].Requests ||
+		results[1].Statuses[http.StatusOK] != results[1].Requests || results[1].P50 > results[1].Max {
+		t.Errorf("unexpected results %+v %+v", results[0], results[1])
+	}
+	out := new(bytes.Buffer)
+	WriteLoadReport(out, results)
+	if strings.Contains(out.String(), "401:") == false || strings.Contains(out.String(), "/private/") == false {
+		t.Errorf("unexpected report\n%s", out.String())
+	}
+
+	// Failed requests back off rather than spinning, and are timed.
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	results, err = LoadTest(down.URL, []*LoadTarget{{Name: "down", Path: "/"}}, 2, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := results[0]; res.Requests != 0 || res.Errors == 0 || res.Errors > 20 || res.ErrorMax < res.ErrorP50 {
+		t.Errorf("expected a few timed errors, got %+v", res)
+	}
+	out.Reset()
+	WriteLoadReport(out, results)
+	if strings.Contains(out.String(), "errors:") == false || strings.Contains(out.String(), "max ") == false {
+		t.Errorf("expected error latencies in the report\n%s", out.String())
+	}
+
+	w := &WebService{
+		Access:       &Access{Routes: []string{"/private/"}},
+		ReverseProxy: map[string]string{"/b/": "http://localhost:9001", "/a/": "http://localhost:9000"},
+	}
+	targets = w.SelfTestTargets()
+	if len(targets) != 3 || targets[1].Path != "/private/" || targets[2].Path != "/a/" {
+		t.Errorf("unexpected self test targets %+v", targets)
+	}
+}
+
+// benchmarkHandler serves p from a WebService with a protected
+// route and a reverse proxy, reporting allocations.
+func benchmarkHandler(b *testing.B, p string, username string, password string) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	}))
+	defer upstream.Close()
+	docRoot := b.TempDir()
+	os.MkdirAll(filepath.Join(docRoot, "private"), 0755)
+	os.WriteFile(filepath.Join(docRoot, "index.html"), bytes.Repeat([]byte("x"), 4096), 0644)
+	os.WriteFile(filepath.Join(docRoot, "private", "index.html"), []byte("private"), 0644)
+	a := &Access{AuthType: "basic", AuthName: "Library", Encryption: "md5", Routes: []string{"/private/"}, SessionSeconds: -1}
+	a.UpdateAccess("Jane.Doe", "secret")
+	ws, err := NewWebService(WithDocRoot(docRoot), WithAccess(a))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ws.ReverseProxy = map[string]string{"/api/": upstream.URL}
+	h, err := ws.Handler()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest("GET", p, nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("expected 200, got %d", rec.Code)
+		}
+	}
+}
+
+func BenchmarkStatic(b *testing.B) {
+	benchmarkHandler(b, "/", "", "")
+}
+
+func BenchmarkProtected(b *testing.B) {
+	benchmarkHandler(b, "/private/", "Jane.Doe", "secret")
+}
+
+func BenchmarkProxied(b *testing.B) {
+	benchmarkHandler(b, "/api/items", "", "")
+}
+
+func BenchmarkRequestLogger(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	h := RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/index.html?q=1", nil))
+	}
+}
@@ -0,0 +1,137 @@
+// loginstats.go counts logins per user and route and calls a hook for
+// each one, e.g. to feed a SIEM.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxLoginStatsUsers bounds the users counted, later usernames are
+// counted under LoginStatsOther so credential stuffing with random
+// usernames can't exhaust memory.
+const maxLoginStatsUsers = 10000
+
+// LoginStatsOther collects the counts of users beyond the limit.
+const LoginStatsOther = "*other*"
+
+// LoginStats counts successful and failed logins. Logins remembered
+// by the session cache aren't counted again.
+type LoginStats struct {
+	Success int64 `json:"success"`
+	Failure int64 `json:"failure"`
+}
+
+// LoginMetrics holds LoginStats by username and protected route.
+type LoginMetrics struct {
+	Users  map[string]LoginStats `json:"users"`
+	Routes map[string]LoginStats `json:"routes"`
+}
+
+// loginCounter accumulates LoginMetrics.
+type loginCounter struct {
+	mu     sync.Mutex
+	users  map[string]*LoginStats
+	routes map[string]*LoginStats
+	hook   func(ev *AuditEvent)
+}
+
+// add counts a login for username at route.
+func (lc *loginCounter) add(username string, route string, ok bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.users == nil {
+		lc.users, lc.routes = map[string]*LoginStats{}, map[string]*LoginStats{}
+	}
+	if _, found := lc.users[username]; found == false && len(lc.users) >= maxLoginStatsUsers {
+		username = LoginStatsOther
+	}
+	for _, s := range []*LoginStats{lc.stats(lc.users, username), lc.stats(lc.routes, route)} {
+		if ok {
+			s.Success++
+		} else {
+			s.Failure++
+		}
+	}
+}
+
+// stats returns the entry for key creating it if needed.
+func (lc *loginCounter) stats(m map[string]*LoginStats, key string) *LoginStats {
+	s, ok := m[key]
+	if ok == false {
+		s = new(LoginStats)
+		m[key] = s
+	}
+	return s
+}
+
+// LoginMetrics returns a copy of the login counts.
+func (a *Access) LoginMetrics() *LoginMetrics {
+	a.logins.mu.Lock()
+	defer a.logins.mu.Unlock()
+	m := &LoginMetrics{Users: map[string]LoginStats{}, Routes: map[string]LoginStats{}}
+	for k, s := range a.logins.users {
+		m.Users[k] = *s
+	}
+	for k, s := range a.logins.routes {
+		m.Routes[k] = *s
+	}
+	return m
+}
+
+// SetLoginHook sets a function called with the AuditLogin or
+// AuditLoginFailure event of each login, e.g. to alert on
+// credential stuffing. It is called from the request, so it should
+// return quickly.
+func (a *Access) SetLoginHook(hook func(ev *AuditEvent)) {
+	a.logins.mu.Lock()
+	defer a.logins.mu.Unlock()
+	a.logins.hook = hook
+}
+
+// routeOf returns the protected route p is below.
+func (a *Access) routeOf(p string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, route := range a.Routes {
+		if strings.HasPrefix(p, route) {
+			return route
+		}
+	}
+	return ""
+}
+
+// login records a login or failure for username from req, in the
+// audit log, the login counts and with the login hook.
+func (a *Access) login(username string, req *http.Request, ok bool) {
+	event := AuditLogin
+	if ok == false {
+		event = AuditLoginFailure
+	}
+	ev := NewAuditEvent(event, username, req)
+	a.audit(ev)
+	a.logins.add(username, a.routeOf(req.URL.Path), ok)
+	a.logins.mu.Lock()
+	hook := a.logins.hook
+	a.logins.mu.Unlock()
+	if hook != nil {
+		hook(ev)
+	}
+}
@@ -0,0 +1,68 @@
+// loginstats_test.go tests counting logins per user and route.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoginMetrics(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/private/", "/reports/"}}
+	if err := a.UpdateUser("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	events := []*AuditEvent{}
+	a.SetLoginHook(func(ev *AuditEvent) {
+		events = append(events, ev)
+	})
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, test := range []struct{ path, username, password string }{
+		{"/private/", "Jane.Doe", "wrong"},
+		{"/private/", "Jane.Doe", "secret"},
+		// Remembered by the session cache, not counted again.
+		{"/private/a.html", "Jane.Doe", "secret"},
+		{"/reports/", "admin", "admin"},
+		{"/public/", "admin", "admin"},
+	} {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.SetBasicAuth(test.username, test.password)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	m := a.LoginMetrics()
+	if s := m.Users["Jane.Doe"]; s.Success != 1 || s.Failure != 1 {
+		t.Errorf("unexpected Jane.Doe stats %+v", s)
+	}
+	if s := m.Users["admin"]; s.Success != 0 || s.Failure != 1 {
+		t.Errorf("unexpected admin stats %+v", s)
+	}
+	if s := m.Routes["/reports/"]; s.Failure != 1 {
+		t.Errorf("unexpected /reports/ stats %+v", s)
+	}
+	if len(events) != 3 || events[1].Event != AuditLogin || events[2].Path != "/reports/" {
+		t.Errorf("unexpected hook events %+v", events)
+	}
+	// The status only reports the routes, not who logged in.
+	src, _ := json.Marshal((&WebService{Access: a}).Status())
+	if strings.Contains(string(src), "Jane.Doe") || strings.Contains(string(src), `"/reports/":{`) == false {
+		t.Errorf("expected only route login counts in the status, got %s", src)
+	}
+}
@@ -0,0 +1,67 @@
+// logout.go signs users out of Basic auth protected areas.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+)
+
+// LoggedOutRealm is appended to AuthName in the challenge sent by
+// LogoutPath. Browsers discard the cached credentials when the realm
+// changes.
+var LoggedOutRealm = " (signed out)"
+
+// drop forgets the session for one set of credentials.
+func (c *authCache) drop(username string, password string) bool {
+	d := c.digest(username, password)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.sessions[d]; ok == false {
+		return false
+	}
+	delete(c.sessions, d)
+	return true
+}
+
+// isLogoutPath reports if p is the configured LogoutPath.
+func (a *Access) isLogoutPath(p string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.LogoutPath != "" && p == a.LogoutPath
+}
+
+// logout forgets the session for the request's credentials and
+// answers 401 with a changed realm so the browser stops sending them.
+func (a *Access) logout(res http.ResponseWriter, req *http.Request) {
+	if username, password, ok := req.BasicAuth(); ok {
+		if a.sessions().drop(username, password) {
+			a.audit(NewAuditEvent(AuditLogout, username, req))
+		}
+	}
+	a.mu.RLock()
+	realm := a.AuthName + LoggedOutRealm
+	a.mu.RUnlock()
+	res.Header().Set("WWW-Authenticate", basicChallenge(realm))
+	res.Header().Set("Cache-Control", "no-store")
+	if acceptsJSON(req) {
+		JSONResponse(res, req, http.StatusUnauthorized, map[string]string{"message": "signed out"})
+		return
+	}
+	http.Error(res, "Signed out", http.StatusUnauthorized)
+	ResponseLogger(req, http.StatusUnauthorized, nil)
+}
@@ -0,0 +1,167 @@
+// logstats.go summarizes the access log written by RequestLogger.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// SentLogFormat is the layout of the line RequestLogger writes once a
+// request is handled. The fields are method, the quoted host and path,
+// remote address, the quoted user agent, status, bytes sent and the
+// quoted referrer. Quoting keeps client supplied values from forging
+// fields or lines.
+const SentLogFormat = "sent Method: %s Host: %q Path: %q RemoteAddr: %s UserAgent: %q Status: %d Bytes: %d Referer: %q\n"
+
+// quotedField matches a Go quoted string.
+const quotedField = `("(?:[^"\\]|\\.)*")`
+
+var (
+	// sentLine matches a SentLogFormat line, including any log prefix.
+	sentLine = regexp.MustCompile(`sent Method: (\S+) Host: ` + quotedField + ` Path: ` + quotedField + ` RemoteAddr: (\S*) UserAgent: ` + quotedField + ` Status: (\d+) Bytes: (\d+) Referer: ` + quotedField + `$`)
+	// legacySentLine matches the lines written before the host, path
+	// and user agent were quoted.
+	legacySentLine = regexp.MustCompile(`sent Method: (\S+) Host: (\S*) Path: (.*?) RemoteAddr: (\S*) UserAgent: (.*) Status: (\d+) Bytes: (\d+) Referer: (".*")$`)
+)
+
+// unquoteField returns a quoted field's value, s if it isn't quoted.
+func unquoteField(s string) string {
+	if v, err := strconv.Unquote(s); err == nil {
+		return v
+	}
+	return s
+}
+
+// LogCount is a value and the number of times it was seen.
+type LogCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// LogStats summarizes an access log.
+type LogStats struct {
+	Requests   int            `json:"requests"`
+	Bytes      int64          `json:"bytes"`
+	Hosts      map[string]int `json:"hosts"`
+	Paths      map[string]int `json:"paths"`
+	Statuses   map[string]int `json:"statuses"`
+	Referrers  map[string]int `json:"referrers"`
+	UserAgents map[string]int `json:"user_agents"`
+}
+
+// ReadLogStats reads the log lines from r adding them to the
+// summary. Lines other than those in SentLogFormat are skipped.
+func (s *LogStats) ReadLogStats(r io.Reader) error {
+	if s.Paths == nil {
+		s.Hosts, s.Paths, s.Statuses = map[string]int{}, map[string]int{}, map[string]int{}
+		s.Referrers, s.UserAgents = map[string]int{}, map[string]int{}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		m := sentLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			m = legacySentLine.FindStringSubmatch(scanner.Text())
+		}
+		if m == nil {
+			continue
+		}
+		for _, i := range []int{2, 3, 5, 8} {
+			m[i] = unquoteField(m[i])
+		}
+		size, _ := strconv.ParseInt(m[7], 10, 64)
+		referrer := m[8]
+		s.Requests++
+		s.Bytes += size
+		if m[2] != "" {
+			s.Hosts[m[2]]++
+		}
+		s.Paths[m[3]]++
+		s.Statuses[m[6]]++
+		if referrer != "" {
+			s.Referrers[referrer]++
+		}
+		if m[5] != "" {
+			s.UserAgents[m[5]]++
+		}
+	}
+	return scanner.Err()
+}
+
+// TopCounts returns the n most frequent values of counts, all of them
+// if n is less than one.
+func TopCounts(counts map[string]int, n int) []LogCount {
+	top := []LogCount{}
+	for value, count := range counts {
+		top = append(top, LogCount{Value: value, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count == top[j].Count {
+			return top[i].Value < top[j].Value
+		}
+		return top[i].Count > top[j].Count
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// humanBytes formats size with a binary unit, e.g. "1.5 MiB".
+func humanBytes(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	f, units := float64(size), []string{"KiB", "MiB", "GiB", "TiB"}
+	unit := ""
+	for _, unit = range units {
+		f /= 1024
+		if f < 1024 {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f %s", f, unit)
+}
+
+// WriteReport writes a plain text report listing the n most
+// frequent paths, referrers and user agents.
+func (s *LogStats) WriteReport(out io.Writer, n int) {
+	fmt.Fprintf(out, "Requests: %d\nBandwidth: %s (%d bytes)\n", s.Requests, humanBytes(s.Bytes), s.Bytes)
+	section := func(title string, counts []LogCount) {
+		fmt.Fprintf(out, "\n%s\n", title)
+		for _, lc := range counts {
+			fmt.Fprintf(out, "%8d  %s\n", lc.Count, lc.Value)
+		}
+	}
+	statuses := TopCounts(s.Statuses, 0)
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Value < statuses[j].Value
+	})
+	section("Status", statuses)
+	if len(s.Hosts) > 1 {
+		section("Top hosts", TopCounts(s.Hosts, n))
+	}
+	section("Top paths", TopCounts(s.Paths, n))
+	section("Top referrers", TopCounts(s.Referrers, n))
+	section("Top user agents", TopCounts(s.UserAgents, n))
+}
@@ -0,0 +1,89 @@
+// logstats_test.go tests the access log summary.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogStats(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.html"), []byte("0123456789"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	h, err := (&WebService{DocRoot: dir}).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/a.html", "/a.html", "/missing.html"} {
+		req := httptest.NewRequest("GET", p, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux) Status: 1")
+		req.Header.Set("Referer", "https://example.edu/")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	stats := new(LogStats)
+	if err := stats.ReadLogStats(buf); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Requests != 3 || stats.Paths["/a.html"] != 2 || stats.Statuses["404"] != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.Bytes < 20 {
+		t.Errorf("expected at least 20 bytes, got %d", stats.Bytes)
+	}
+	if stats.Referrers["https://example.edu/"] != 3 || stats.UserAgents["Mozilla/5.0 (X11; Linux) Status: 1"] != 3 {
+		t.Errorf("unexpected referrers or user agents %+v", stats)
+	}
+	out := new(bytes.Buffer)
+	stats.WriteReport(out, 1)
+	if strings.Contains(out.String(), "       2  /a.html") == false || strings.Contains(out.String(), "/missing.html") {
+		t.Errorf("unexpected report\n%s", out.String())
+	}
+
+	// Client values can't forge fields or lines.
+	buf.Reset()
+	req := httptest.NewRequest("GET", "/missing%0Asent%20Method:%20GET%20Host:%20%22%22%20Path:%20%22/forged%22", nil)
+	req.Header.Set("User-Agent", `x" Status: 200 Bytes: 99999 Referer: "y`)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	// Lines written before quoting are still read.
+	buf.WriteString(`2024/01/02 03:04:05 sent Method: GET Host: example.edu Path: /old.html RemoteAddr: 192.0.2.1:1234 UserAgent: curl/8.0 Status: 200 Bytes: 5 Referer: ""` + "\n")
+	stats = new(LogStats)
+	if err := stats.ReadLogStats(buf); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Requests != 2 || stats.Paths["/forged"] != 0 || stats.Paths["/old.html"] != 1 || stats.Statuses["404"] != 1 || stats.Bytes > 1000 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.UserAgents[`x" Status: 200 Bytes: 99999 Referer: "y`] != 1 {
+		t.Errorf("expected the user agent read whole, got %+v", stats.UserAgents)
+	}
+	if s := humanBytes(1536); s != "1.5 KiB" {
+		t.Errorf("expected 1.5 KiB, got %s", s)
+	}
+}
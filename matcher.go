@@ -0,0 +1,192 @@
+// matcher.go selects requests by host, path, method, header and query
+// so middleware and handlers can apply to some requests only.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strings"
+)
+
+// Matcher selects requests. Each criterion that is set must match,
+// a list matches when any of its entries does. A nil or empty
+// Matcher matches every request.
+type Matcher struct {
+	// Host names, "*.example.edu" matches any subdomain.
+	Host []string `json:"host,omitempty" toml:"host,omitempty"`
+	// Path prefixes, or patterns holding "*", "?" or "[" matched
+	// with path.Match (e.g. "/exhibits/*.php").
+	Path []string `json:"path,omitempty" toml:"path,omitempty"`
+	// Method names, e.g. "GET".
+	Method []string `json:"method,omitempty" toml:"method,omitempty"`
+	// Header maps a header name to a path.Match pattern for its
+	// value, an empty pattern only requires the header be present.
+	Header map[string]string `json:"header,omitempty" toml:"header,omitempty"`
+	// Query maps a query parameter to a pattern like Header.
+	Query map[string]string `json:"query,omitempty" toml:"query,omitempty"`
+}
+
+// hasPathPrefix reports if p is below one of prefixes.
+func hasPathPrefix(p string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRoute reports if p is below one of routes, or is a route
+// without its trailing slash (e.g. "/private" for "/private/").
+func hasRoute(p string, routes []string) bool {
+	return hasPathPrefix(p, routes) || (strings.HasSuffix(p, "/") == false && hasPathPrefix(p+"/", routes))
+}
+
+// isPattern reports if s is a path.Match pattern.
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// matchPath reports if p is below a prefix or matches a pattern.
+func matchPath(p string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if isPattern(pattern) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		} else if strings.HasPrefix(p, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost reports if host matches one of names.
+func matchHost(host string, names []string) bool {
+	host = normalizeHost(host)
+	for _, name := range names {
+		name = normalizeHost(name)
+		if strings.HasPrefix(name, "*.") {
+			if strings.HasSuffix(host, name[1:]) {
+				return true
+			}
+		} else if host == name {
+			return true
+		}
+	}
+	return false
+}
+
+// matchValues reports if each name has a value matching its pattern.
+func matchValues(values map[string][]string, patterns map[string]string, canonical bool) bool {
+	for name, pattern := range patterns {
+		if canonical {
+			name = textproto.CanonicalMIMEHeaderKey(name)
+		}
+		found := false
+		for _, v := range values[name] {
+			if ok, _ := path.Match(pattern, v); ok || pattern == "" {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the patterns are well formed.
+func (m *Matcher) Validate() error {
+	if m == nil {
+		return nil
+	}
+	for _, pattern := range m.Path {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("match path %q, %s", pattern, err)
+		}
+	}
+	for _, method := range m.Method {
+		if method == "" || strings.ContainsAny(method, " \t,") {
+			return fmt.Errorf("match method %q is not valid", method)
+		}
+	}
+	for _, patterns := range []map[string]string{m.Header, m.Query} {
+		for name, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("match %q pattern %q, %s", name, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Match reports if r satisfies the Matcher.
+func (m *Matcher) Match(r *http.Request) bool {
+	if m == nil {
+		return true
+	}
+	if len(m.Host) > 0 && matchHost(r.Host, m.Host) == false {
+		return false
+	}
+	if len(m.Path) > 0 && matchPath(r.URL.Path, m.Path) == false {
+		return false
+	}
+	if len(m.Method) > 0 {
+		found := false
+		for _, method := range m.Method {
+			if strings.EqualFold(method, r.Method) {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			return false
+		}
+	}
+	if matchValues(r.Header, m.Header, true) == false {
+		return false
+	}
+	if len(m.Query) > 0 && matchValues(r.URL.Query(), m.Query, false) == false {
+		return false
+	}
+	return true
+}
+
+// Handler calls wrapped for matching requests and next for the rest.
+func (m *Matcher) Handler(wrapped http.Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.Match(r) {
+			wrapped.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// When applies middleware only to requests matching m, e.g.
+// WithMiddleware(When(&Matcher{Method: []string{"POST"}}, limit)).
+func When(m *Matcher, middleware func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return m.Handler(middleware(next), next)
+	}
+}
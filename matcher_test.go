@@ -0,0 +1,99 @@
+// matcher_test.go tests selecting requests by host, path, method, header and query.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	m := &Matcher{
+		Host:   []string{"library.example.edu", "*.caltech.edu"},
+		Path:   []string{"/api/", "/exhibits/*.php"},
+		Method: []string{"get", "POST"},
+		Header: map[string]string{"x-monitor": "", "Accept": "application/*"},
+		Query:  map[string]string{"format": "json*"},
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	match := func(method string, url string, headers map[string]string) bool {
+		req := httptest.NewRequest(method, url, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return m.Match(req)
+	}
+	ok := map[string]string{"X-Monitor": "1", "Accept": "application/json"}
+	tests := []struct {
+		method   string
+		url      string
+		headers  map[string]string
+		expected bool
+	}{
+		{"GET", "http://library.example.edu/api/items?format=json", ok, true},
+		{"POST", "http://www.caltech.edu:8000/exhibits/old.php?format=jsonld", ok, true},
+		{"GET", "http://example.edu/api/items?format=json", ok, false},
+		{"GET", "http://library.example.edu/static/?format=json", ok, false},
+		{"DELETE", "http://library.example.edu/api/items?format=json", ok, false},
+		{"GET", "http://library.example.edu/api/items?format=json", map[string]string{"Accept": "application/json"}, false},
+		{"GET", "http://library.example.edu/api/items?format=json", map[string]string{"X-Monitor": "1", "Accept": "text/html"}, false},
+		{"GET", "http://library.example.edu/api/items?format=xml", ok, false},
+	}
+	for _, test := range tests {
+		if got := match(test.method, test.url, test.headers); got != test.expected {
+			t.Errorf("%s %s %v, expected %t, got %t", test.method, test.url, test.headers, test.expected, got)
+		}
+	}
+	var none *Matcher
+	if none.Match(httptest.NewRequest("GET", "/", nil)) == false {
+		t.Errorf("expected a nil matcher to match every request")
+	}
+	if err := (&Matcher{Path: []string{"/a/[b"}}).Validate(); err == nil {
+		t.Errorf("expected an error for a malformed pattern")
+	}
+
+	// When gates middleware.
+	tag := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Tagged", "yes")
+			next.ServeHTTP(w, r)
+		})
+	}
+	h := When(&Matcher{Method: []string{"POST"}}, tag)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for method, expected := range map[string]string{"POST": "yes", "GET": ""} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+		if got := rec.Header().Get("X-Tagged"); got != expected {
+			t.Errorf("%s, expected X-Tagged %q, got %q", method, expected, got)
+		}
+	}
+
+	// CORS policies can be limited to matching requests.
+	cors := &CORSPolicy{Origin: "https://library.example.edu", Match: &Matcher{Path: []string{"/api/"}}}
+	h = cors.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for p, expected := range map[string]string{"/api/items": cors.Origin, "/private/": ""} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != expected {
+			t.Errorf("%s, expected %q, got %q", p, expected, got)
+		}
+	}
+}
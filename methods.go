@@ -0,0 +1,113 @@
+// methods.go restricts the HTTP methods allowed below path prefixes.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// StaticMethods are the methods the static file handler answers,
+// other methods get "405 Method Not Allowed".
+var StaticMethods = []string{http.MethodGet, http.MethodHead}
+
+// methodRule is the allowed methods of a prefix.
+type methodRule struct {
+	prefix  string
+	allowed map[string]bool
+	allow   string
+}
+
+// newMethodRule normalizes methods, GET implies HEAD.
+func newMethodRule(prefix string, methods []string) (*methodRule, error) {
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("methods %q lists no methods", prefix)
+	}
+	rule := &methodRule{prefix: prefix, allowed: map[string]bool{}}
+	for _, m := range methods {
+		m = strings.ToUpper(strings.TrimSpace(m))
+		if m == "" || strings.ContainsAny(m, " \t,;\"") {
+			return nil, fmt.Errorf("methods %q, invalid method %q", prefix, m)
+		}
+		rule.allowed[m] = true
+	}
+	if rule.allowed[http.MethodGet] {
+		rule.allowed[http.MethodHead] = true
+	}
+	allow := []string{}
+	for m := range rule.allowed {
+		allow = append(allow, m)
+	}
+	if rule.allowed[http.MethodOptions] == false {
+		allow = append(allow, http.MethodOptions)
+	}
+	sort.Strings(allow)
+	rule.allow = strings.Join(allow, ", ")
+	return rule, nil
+}
+
+// serve answers OPTIONS and methods not allowed, reporting if the
+// request was handled.
+func (rule *methodRule) serve(w http.ResponseWriter, r *http.Request) bool {
+	if rule.allowed[r.Method] {
+		return false
+	}
+	w.Header().Set("Allow", rule.allow)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		ResponseLogger(r, http.StatusNoContent, nil)
+		return true
+	}
+	httpError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+	return true
+}
+
+// MethodsHandler allows only the listed methods (e.g. "GET", "POST")
+// below each prefix, the longest matching prefix applies. Other
+// methods are answered "405 Method Not Allowed" with an Allow header,
+// OPTIONS is answered with the Allow header unless it is listed.
+// GET implies HEAD.
+func MethodsHandler(methods map[string][]string, next http.Handler) (http.Handler, error) {
+	rules := []*methodRule{}
+	for prefix, list := range methods {
+		if prefix == "" {
+			return nil, fmt.Errorf("methods require a prefix")
+		}
+		rule, err := newMethodRule(prefix, list)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return len(rules[i].prefix) > len(rules[j].prefix)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rule := range rules {
+			if strings.HasPrefix(r.URL.Path, rule.prefix) {
+				if rule.serve(w, r) {
+					return
+				}
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
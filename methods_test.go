@@ -0,0 +1,79 @@
+// methods_test.go tests restricting the methods allowed below prefixes.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMethodsHandler(t *testing.T) {
+	docRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(docRoot, "index.html"), []byte("<p>hello</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ws := &WebService{
+		DocRoot: docRoot,
+		Methods: map[string][]string{"/api/": {"get", "POST"}, "/api/admin/": {"DELETE"}},
+	}
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	WithHandler("/api/", api)(ws)
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{"GET", "/", http.StatusOK, ""},
+		{"HEAD", "/", http.StatusOK, ""},
+		{"PUT", "/index.html", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{"DELETE", "/index.html", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{"OPTIONS", "/index.html", http.StatusNoContent, "GET, HEAD, OPTIONS"},
+		{"POST", "/api/items", http.StatusAccepted, ""},
+		{"HEAD", "/api/items", http.StatusAccepted, ""},
+		{"DELETE", "/api/items", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS, POST"},
+		{"DELETE", "/api/admin/x", http.StatusAccepted, ""},
+		{"GET", "/api/admin/x", http.StatusMethodNotAllowed, "DELETE, OPTIONS"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("%+v, got status %d", test, rec.Code)
+		}
+		if got := rec.Header().Get("Allow"); got != test.allow {
+			t.Errorf("%+v, got Allow %q", test, got)
+		}
+	}
+
+	if _, err := MethodsHandler(map[string][]string{"/api/": {}}, api); err == nil {
+		t.Errorf("expected an error for an empty method list")
+	}
+	if _, err := MethodsHandler(map[string][]string{"/api/": {"GET, POST"}}, api); err == nil {
+		t.Errorf("expected an error for an invalid method")
+	}
+}
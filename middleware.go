@@ -0,0 +1,122 @@
+// middleware.go turns named middleware off (or back on) below path
+// prefixes, e.g. no request logging for health checks or no bandwidth
+// limit for an API.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Middleware names used in MiddlewareRule.
+const (
+	MiddlewareLogging   = "logging"
+	MiddlewareCORS      = "cors"
+	MiddlewareCSP       = "csp"
+	MiddlewareBandwidth = "bandwidth"
+	MiddlewareFaults    = "faults"
+	MiddlewareHAR       = "har"
+)
+
+// middlewareNames are the middleware a rule may name.
+var middlewareNames = map[string]bool{
+	MiddlewareLogging:   true,
+	MiddlewareCORS:      true,
+	MiddlewareCSP:       true,
+	MiddlewareBandwidth: true,
+	MiddlewareFaults:    true,
+	MiddlewareHAR:       true,
+}
+
+// MiddlewareRule disables, or re-enables, middleware for requests
+// below Prefix that satisfy Match. Rules apply from the shortest
+// prefix to the longest so a longer prefix can enable what a shorter
+// one disabled. Authentication can't be disabled, protected routes
+// are set in the access file.
+type MiddlewareRule struct {
+	Prefix  string   `json:"prefix" toml:"prefix"`
+	Match   *Matcher `json:"match,omitempty" toml:"match,omitempty"`
+	Disable []string `json:"disable,omitempty" toml:"disable,omitempty"`
+	Enable  []string `json:"enable,omitempty" toml:"enable,omitempty"`
+}
+
+// disabledKey is the context key holding the disabled middleware.
+type disabledKey struct{}
+
+// middlewareDisabled reports if rules disabled middleware name for r.
+func middlewareDisabled(r *http.Request, name string) bool {
+	disabled, _ := r.Context().Value(disabledKey{}).(map[string]bool)
+	return disabled[name]
+}
+
+// skippable returns wrapped, calling next instead for requests that
+// have middleware name disabled.
+func skippable(name string, wrapped http.Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if middlewareDisabled(r, name) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		wrapped.ServeHTTP(w, r)
+	})
+}
+
+// MiddlewareRulesHandler records the middleware rules disable for
+// each request before calling next.
+func MiddlewareRulesHandler(rules []*MiddlewareRule, next http.Handler) (http.Handler, error) {
+	for _, rule := range rules {
+		if rule.Prefix == "" && rule.Match == nil {
+			return nil, fmt.Errorf("middleware rules require a prefix or match")
+		}
+		if err := rule.Match.Validate(); err != nil {
+			return nil, fmt.Errorf("middleware rule %q, %s", rule.Prefix, err)
+		}
+		for _, name := range append(append([]string{}, rule.Disable...), rule.Enable...) {
+			if name == "auth" || name == "access" {
+				return nil, fmt.Errorf("middleware rule %q, %q can't be disabled, protected routes are set in the access file", rule.Prefix, name)
+			}
+			if middlewareNames[name] == false {
+				return nil, fmt.Errorf("middleware rule %q, unknown middleware %q", rule.Prefix, name)
+			}
+		}
+	}
+	ordered := append([]*MiddlewareRule{}, rules...)
+	sort.SliceStable(ordered, func(i, j int) bool { return len(ordered[i].Prefix) < len(ordered[j].Prefix) })
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		disabled := map[string]bool{}
+		for _, rule := range ordered {
+			if strings.HasPrefix(r.URL.Path, rule.Prefix) == false || rule.Match.Match(r) == false {
+				continue
+			}
+			for _, name := range rule.Disable {
+				disabled[name] = true
+			}
+			for _, name := range rule.Enable {
+				delete(disabled, name)
+			}
+		}
+		if len(disabled) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), disabledKey{}, disabled))
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
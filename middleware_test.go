@@ -0,0 +1,98 @@
+// middleware_test.go tests turning middleware off and on by prefix.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareRules(t *testing.T) {
+	out := new(bytes.Buffer)
+	log.SetOutput(out)
+	defer log.SetOutput(os.Stderr)
+	docRoot := t.TempDir()
+	for _, dir := range []string{"health", "downloads/api"} {
+		os.MkdirAll(filepath.Join(docRoot, dir), 0755)
+		os.WriteFile(filepath.Join(docRoot, dir, "index.html"), []byte("ok"), 0644)
+	}
+	ws, err := NewWebService(WithDocRoot(docRoot), WithCORS(&CORSPolicy{Origin: "https://example.edu"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.LogFormat = LogFormatStandard
+	ws.MiddlewareRules = []*MiddlewareRule{
+		{Prefix: "/downloads/api/", Enable: []string{"cors"}},
+		{Prefix: "/downloads/", Disable: []string{"cors", "logging"}},
+		{Prefix: "/health/", Disable: []string{"logging"}},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		cors   bool
+		logged bool
+	}{
+		{"/index.html", true, true},
+		{"/health/", true, false},
+		{"/downloads/", false, false},
+		{"/downloads/api/", true, false},
+	}
+	for _, test := range tests {
+		out.Reset()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+		if cors := rec.Header().Get("Access-Control-Allow-Origin") != ""; cors != test.cors {
+			t.Errorf("%s, expected CORS %t, got %t", test.path, test.cors, cors)
+		}
+		if logged := strings.Contains(out.String(), "Path: "+test.path); logged != test.logged {
+			t.Errorf("%s, expected logged %t, got %t", test.path, test.logged, logged)
+		}
+	}
+
+	// Rules may match on more than the path, e.g. monitoring probes.
+	ws.MiddlewareRules = []*MiddlewareRule{{Match: &Matcher{Header: map[string]string{"X-Monitor": ""}}, Disable: []string{"logging"}}}
+	if h, err = ws.Handler(); err != nil {
+		t.Fatal(err)
+	}
+	for _, probe := range []bool{true, false} {
+		out.Reset()
+		req := httptest.NewRequest("GET", "/index.html", nil)
+		if probe {
+			req.Header.Set("X-Monitor", "1")
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if logged := strings.Contains(out.String(), "Path: /index.html"); logged == probe {
+			t.Errorf("probe %t, got logged %t", probe, logged)
+		}
+	}
+
+	for _, rule := range []*MiddlewareRule{{Prefix: "/", Disable: []string{"auth"}}, {Prefix: "/", Disable: []string{"gzip"}}} {
+		ws.MiddlewareRules = []*MiddlewareRule{rule}
+		if _, err := ws.Handler(); err == nil {
+			t.Errorf("expected an error disabling %q", rule.Disable[0])
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// mirror.go copies a share of live requests to a secondary upstream,
+// e.g. to validate a rewritten backend against production traffic.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// MirrorTimeout limits how long a mirrored request may take.
+	MirrorTimeout = 10 * time.Second
+
+	// MirrorMaxBody is the largest request body mirrored, requests
+	// with larger (or unknown length) bodies aren't mirrored.
+	MirrorMaxBody int64 = 1 << 20
+
+	// MirrorConcurrency limits the mirrored requests in flight per
+	// Mirror, others are dropped rather than queued.
+	MirrorConcurrency = 64
+)
+
+// Mirror sends a copy of Percent of the requests below Prefix to
+// Upstream. Mirrored responses are discarded, the client is always
+// answered by the primary handler. The Authorization and Cookie
+// headers aren't sent unless SendCredentials is set.
+type Mirror struct {
+	Prefix          string  `json:"prefix" toml:"prefix"`
+	Upstream        string  `json:"upstream" toml:"upstream"`
+	Percent         float64 `json:"percent" toml:"percent"`
+	SendCredentials bool    `json:"send_credentials,omitempty" toml:"send_credentials,omitempty"`
+
+	// once sets up the state shared by handlers built from the
+	// Mirror, so rebuilding them keeps the requests in flight.
+	once     sync.Once
+	upstream *url.URL
+	inflight chan struct{}
+	client   *http.Client
+}
+
+// sample reports if this request should be mirrored.
+func (m *Mirror) sample() bool {
+	return m.Percent >= 100 || rand.Float64()*100 < m.Percent
+}
+
+// send replays the request to the upstream, discarding the response.
+func (m *Mirror) send(r *http.Request, body []byte) {
+	defer func() { <-m.inflight }()
+	ctx, cancel := context.WithTimeout(context.Background(), MirrorTimeout)
+	defer cancel()
+	u := *m.upstream
+	u.Path = strings.TrimSuffix(u.Path, "/") + r.URL.Path
+	u.RawPath = ""
+	u.RawQuery = r.URL.RawQuery
+	req, err := http.NewRequestWithContext(ctx, r.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		log.Printf("mirror %s, %s", m.Upstream, err)
+		return
+	}
+	req.Header = r.Header.Clone()
+	if m.SendCredentials == false {
+		req.Header.Del("Authorization")
+		req.Header.Del("Cookie")
+	}
+	req.Header.Set("X-Forwarded-For", clientIP(r))
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	res, err := m.client.Do(req)
+	if err != nil {
+		log.Printf("mirror %s, %s", u.String(), err)
+		return
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+}
+
+// MirrorHandler mirrors the sampled requests of each Mirror before
+// calling next.
+func MirrorHandler(mirrors []*Mirror, next http.Handler) (http.Handler, error) {
+	for _, m := range mirrors {
+		u, err := url.Parse(m.Upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("mirror %q upstream %q must be an http or https URL", m.Prefix, m.Upstream)
+		}
+		if m.Prefix == "" || m.Percent <= 0 || m.Percent > 100 {
+			return nil, fmt.Errorf("mirror %q requires a prefix and a percent between 0 and 100", m.Prefix)
+		}
+		m.once.Do(func() {
+			m.upstream = u
+			m.inflight = make(chan struct{}, MirrorConcurrency)
+			m.client = &http.Client{
+				// Redirects are the upstream's answer, don't follow them.
+				CheckRedirect: func(*http.Request, []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			}
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range mirrors {
+			if strings.HasPrefix(r.URL.Path, m.Prefix) == false || m.sample() == false {
+				continue
+			}
+			var body []byte
+			if r.Body != nil && r.Body != http.NoBody {
+				if r.ContentLength < 0 || r.ContentLength > MirrorMaxBody {
+					continue
+				}
+				src, err := io.ReadAll(io.LimitReader(r.Body, MirrorMaxBody))
+				r.Body.Close()
+				// The primary handler reads the buffered body.
+				r.Body = io.NopCloser(bytes.NewReader(src))
+				if err != nil {
+					continue
+				}
+				body = src
+			}
+			select {
+			case m.inflight <- struct{}{}:
+				go m.send(r.Clone(context.Background()), body)
+			default:
+				// Too many in flight, drop rather than slow the site.
+			}
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,93 @@
+// mirror_test.go tests mirroring requests to a secondary upstream.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	mirrored := make(chan string, 4)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		src, _ := io.ReadAll(r.Body)
+		mirrored <- r.Method + " " + r.URL.RequestURI() + " " + string(src) + r.Header.Get("Authorization") + r.Header.Get("Cookie")
+		http.Error(w, "ignored", http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	primary := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		src, _ := io.ReadAll(r.Body)
+		w.Write(src)
+	})
+	mirrors := []*Mirror{{Prefix: "/api/", Upstream: upstream.URL + "/v2", Percent: 100}}
+	h, err := MirrorHandler(mirrors, primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rebuilt handlers share the requests in flight.
+	inflight := mirrors[0].inflight
+	if _, err := MirrorHandler(mirrors, primary); err != nil || mirrors[0].inflight != inflight {
+		t.Errorf("expected the mirror's state to be kept, %v", err)
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/items?id=1", strings.NewReader(`{"a":1}`))
+	// Credentials aren't sent by default.
+	req.SetBasicAuth("Jane.Doe", "secret")
+	req.Header.Set("Cookie", "session=1")
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"a":1}` {
+		t.Errorf("primary response changed, %d %q", rec.Code, rec.Body.String())
+	}
+	select {
+	case got := <-mirrored:
+		if expected := `POST /v2/api/items?id=1 {"a":1}`; got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+
+	mirrors[0].SendCredentials = true
+	req = httptest.NewRequest("GET", "/api/items", nil)
+	req.Header.Set("Cookie", "session=1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	select {
+	case got := <-mirrored:
+		if expected := "GET /v2/api/items session=1"; got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/about.html", nil))
+	select {
+	case got := <-mirrored:
+		t.Errorf("unexpected mirror of %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if _, err := MirrorHandler([]*Mirror{{Prefix: "/api/", Upstream: "localhost:9001", Percent: 10}}, primary); err == nil {
+		t.Errorf("expected an error for an upstream without a scheme")
+	}
+}
@@ -0,0 +1,201 @@
+// mock.go serves canned JSON responses from fixture files so front end
+// work can start before a backend exists.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// MockAPI answers requests below Prefix with fixtures read from the
+// "*.json" files in Directory. Each file holds a MockFixture or a
+// list of them, e.g.
+//
+//	[
+//	  { "path": "/api/items/{id}", "body": { "id": "{id}" } },
+//	  { "method": "DELETE", "path": "/api/items/{id}", "status": 204 }
+//	]
+//
+// Paths use Router patterns, "{name}" in the body and headers is
+// replaced by the path parameter. Requests without a fixture get a
+// 404 (or 405 if only the method is wrong).
+type MockAPI struct {
+	// Prefix is the URL path prefix mocked, e.g. "/api/".
+	Prefix string `json:"prefix" toml:"prefix"`
+	// Directory holds the fixture files.
+	Directory string `json:"directory" toml:"directory"`
+	// Reload reads the fixtures on each request, for development.
+	Reload bool `json:"reload,omitempty" toml:"reload,omitempty"`
+
+	mu     sync.Mutex
+	router *Router
+}
+
+// MockFixture is a canned response for a method and path pattern.
+type MockFixture struct {
+	// Method defaults to GET.
+	Method string `json:"method,omitempty"`
+	// Path is a Router pattern, e.g. "/api/items/{id}".
+	Path string `json:"path"`
+	// Status defaults to 200.
+	Status int `json:"status,omitempty"`
+	// Headers are added to the response.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is the JSON response, omitted if empty.
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// expand replaces "{name}" in s with the request's path parameters,
+// escaped for use inside a JSON string when quote is true.
+func expand(r *http.Request, s string, quote bool) string {
+	for name, val := range PathParams(r) {
+		if quote {
+			src, _ := json.Marshal(val)
+			val = string(src[1 : len(src)-1])
+		}
+		s = strings.ReplaceAll(s, "{"+name+"}", val)
+	}
+	return s
+}
+
+// ServeHTTP writes the fixture's response.
+func (f *MockFixture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := f.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if len(f.Body) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	for k, v := range f.Headers {
+		w.Header().Set(k, expand(r, v, false))
+	}
+	if len(f.Body) == 0 {
+		w.WriteHeader(status)
+		return
+	}
+	writeBody(w, r, status, []byte(expand(r, string(f.Body), true)+"\n"))
+}
+
+// readFixtures returns the fixtures held in a file.
+func readFixtures(name string) ([]*MockFixture, error) {
+	src, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	fixtures := []*MockFixture{}
+	src = bytes.TrimSpace(src)
+	if bytes.HasPrefix(src, []byte("[")) {
+		err = json.Unmarshal(src, &fixtures)
+	} else {
+		f := new(MockFixture)
+		err = json.Unmarshal(src, f)
+		fixtures = append(fixtures, f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s, %s", name, err)
+	}
+	for _, f := range fixtures {
+		if f.Path == "" {
+			return nil, fmt.Errorf("%s, fixture without a path", name)
+		}
+		if f.Status != 0 && (f.Status < 100 || f.Status > 599) {
+			return nil, fmt.Errorf("%s, %s invalid status %d", name, f.Path, f.Status)
+		}
+	}
+	return fixtures, nil
+}
+
+// load builds a Router from the fixture files, in file name order.
+func (m *MockAPI) load() (*Router, error) {
+	rt := NewRouter()
+	rt.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		JSONError(w, r, http.StatusNotFound, fmt.Errorf("no fixture for %s %s", r.Method, r.URL.Path))
+	})
+	err := filepath.WalkDir(m.Directory, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(name) != ".json" || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		fixtures, err := readFixtures(name)
+		if err != nil {
+			return err
+		}
+		for _, f := range fixtures {
+			method := f.Method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rt.Handle(method, f.Path, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// routes returns the fixture Router, reading the fixtures the first
+// time or on each call when Reload is set.
+func (m *MockAPI) routes() (*Router, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.router == nil || m.Reload {
+		rt, err := m.load()
+		if err != nil {
+			return nil, err
+		}
+		m.router = rt
+	}
+	return m.router, nil
+}
+
+// Handler answers requests below Prefix from the fixtures passing
+// other requests to next.
+func (m *MockAPI) Handler(next http.Handler) (http.Handler, error) {
+	if m.Prefix == "" || m.Directory == "" {
+		return nil, fmt.Errorf("mock_api requires a prefix and directory")
+	}
+	if _, err := m.routes(); err != nil {
+		return nil, err
+	}
+	prefix := "/" + strings.Trim(m.Prefix, "/") + "/"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != strings.TrimSuffix(prefix, "/") && strings.HasPrefix(r.URL.Path, prefix) == false {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rt, err := m.routes()
+		if err != nil {
+			JSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		rt.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,83 @@
+// mock_test.go tests the fixture backed mock API.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMockAPI(t *testing.T) {
+	dir := t.TempDir()
+	items := `[
+  { "path": "/api/items/{id}", "body": { "id": "{id}", "title": "Item {id}" } },
+  { "method": "DELETE", "path": "/api/items/{id}", "status": 204, "headers": { "X-Deleted": "{id}" } }
+]`
+	if err := os.WriteFile(filepath.Join(dir, "items.json"), []byte(items), 0600); err != nil {
+		t.Fatal(err)
+	}
+	health := `{ "path": "/api/health", "status": 503, "body": { "ok": false } }`
+	if err := os.WriteFile(filepath.Join(dir, "health.json"), []byte(health), 0600); err != nil {
+		t.Fatal(err)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("static"))
+	})
+	m := &MockAPI{Prefix: "/api/", Directory: dir}
+	h, err := m.Handler(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, p string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, p, nil))
+		return rec
+	}
+	rec := serve("GET", "/api/items/a%22b")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected 200 JSON, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if strings.Contains(rec.Body.String(), `"id": "a\"b"`) == false || strings.Contains(rec.Body.String(), `"Item a\"b"`) == false {
+		t.Errorf("expected expanded, escaped id, got %s", rec.Body.String())
+	}
+	rec = serve("DELETE", "/api/items/42")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("X-Deleted") != "42" || rec.Body.Len() != 0 {
+		t.Errorf("expected 204 with X-Deleted 42, got %d %q %q", rec.Code, rec.Header().Get("X-Deleted"), rec.Body.String())
+	}
+	if rec = serve("GET", "/api/health"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+	if rec = serve("POST", "/api/health"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", rec.Code)
+	}
+	if rec = serve("GET", "/api/missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+	if rec = serve("GET", "/index.html"); rec.Body.String() != "static" {
+		t.Errorf("expected other paths passed on, got %q", rec.Body.String())
+	}
+
+	os.WriteFile(filepath.Join(dir, "bad.json"), []byte(`{ "status": 200 }`), 0600)
+	if _, err := (&MockAPI{Prefix: "/api/", Directory: dir}).Handler(next); err == nil {
+		t.Errorf("expected an error for a fixture without a path")
+	}
+}
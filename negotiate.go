@@ -0,0 +1,226 @@
+// negotiate.go provides Accept header content negotiation and a Respond
+// helper that renders a value as JSON, HTML or plain text based on
+// what the client asked for.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptRange is one media range from an Accept header.
+type acceptRange struct {
+	mediaType string
+	subType   string
+	q         float64
+}
+
+// parseAccept splits an Accept header into media ranges.
+func parseAccept(accept string) []acceptRange {
+	ranges := []acceptRange{}
+	for _, part := range strings.Split(accept, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		params := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		ar := acceptRange{q: 1.0}
+		if mt == "*" {
+			mt = "*/*"
+		}
+		if i := strings.Index(mt, "/"); i > 0 {
+			ar.mediaType, ar.subType = mt[0:i], mt[i+1:]
+		} else {
+			continue
+		}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(strings.TrimSpace(kv[0])) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					ar.q = q
+				}
+			}
+		}
+		ranges = append(ranges, ar)
+	}
+	return ranges
+}
+
+// acceptToken is an entry of an Accept-Encoding or Accept-Language
+// header.
+type acceptToken struct {
+	token string
+	q     float64
+}
+
+// parseAcceptTokens splits headers like Accept-Encoding or
+// Accept-Language into lower cased tokens and their quality, in
+// header order.
+func parseAcceptTokens(header string) []acceptToken {
+	tokens := []acceptToken{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(strings.TrimSpace(part), ";")
+		at := acceptToken{token: strings.ToLower(strings.TrimSpace(params[0])), q: 1.0}
+		if at.token == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(strings.TrimSpace(kv[0])) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					at.q = q
+				}
+			}
+		}
+		tokens = append(tokens, at)
+	}
+	return tokens
+}
+
+// acceptsEncoding reports if the request's Accept-Encoding allows
+// encoding (e.g. "gzip").
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	q := -1.0
+	for _, at := range parseAcceptTokens(r.Header.Get("Accept-Encoding")) {
+		switch at.token {
+		case encoding:
+			return at.q > 0
+		case "*":
+			q = at.q
+		}
+	}
+	return q > 0
+}
+
+// quality returns the q value the ranges assign to offer using the
+// most specific matching range, -1 if nothing matches.
+func quality(ranges []acceptRange, offer string) float64 {
+	offer = strings.ToLower(offer)
+	if i := strings.Index(offer, ";"); i > 0 {
+		offer = strings.TrimSpace(offer[0:i])
+	}
+	mediaType, subType := offer, ""
+	if i := strings.Index(offer, "/"); i > 0 {
+		mediaType, subType = offer[0:i], offer[i+1:]
+	}
+	q, specificity := -1.0, -1
+	for _, ar := range ranges {
+		s := -1
+		switch {
+		case ar.mediaType == mediaType && ar.subType == subType:
+			s = 2
+		case ar.mediaType == mediaType && ar.subType == "*":
+			s = 1
+		case ar.mediaType == "*" && ar.subType == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = ar.q, s
+		}
+	}
+	return q
+}
+
+// Negotiate picks the best of the offered media types for the
+// request's Accept header honoring quality values and wildcards.
+// When offers tie the earlier offer wins. If there is no Accept
+// header the first offer is returned. An empty string is returned
+// when none of the offers are acceptable.
+func Negotiate(r *http.Request, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	ranges := parseAccept(accept)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := quality(ranges, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// RenderFunc writes data to w in a specific media type.
+type RenderFunc func(w io.Writer, data interface{}) error
+
+// TextRender writes data as plain text. Values implementing
+// fmt.Stringer or error use that representation, others are
+// formatted with "%v".
+func TextRender(w io.Writer, data interface{}) error {
+	var err error
+	switch v := data.(type) {
+	case fmt.Stringer:
+		_, err = fmt.Fprintln(w, v.String())
+	case error:
+		_, err = fmt.Fprintln(w, v.Error())
+	default:
+		_, err = fmt.Fprintf(w, "%v\n", v)
+	}
+	return err
+}
+
+// Respond chooses between JSON, HTML and plain text renderings of
+// data based on the request's Accept header. JSON is written with
+// JSONResponse, plain text with TextRender and HTML with html. If
+// html is nil then HTML is not offered. A 406 is returned when the
+// client accepts none of them.
+func Respond(w http.ResponseWriter, r *http.Request, status int, data interface{}, html RenderFunc) {
+	offers := []string{"application/json", "text/plain"}
+	if html != nil {
+		offers = []string{"application/json", "text/html", "text/plain"}
+	}
+	var (
+		render      RenderFunc
+		contentType string
+	)
+	// Every answer depends on Accept, shared caches must key on it.
+	w.Header().Add("Vary", "Accept")
+	switch Negotiate(r, offers...) {
+	case "application/json":
+		JSONResponse(w, r, status, data)
+		return
+	case "text/html":
+		render, contentType = html, "text/html; charset=utf-8"
+	case "text/plain":
+		render, contentType = TextRender, "text/plain; charset=utf-8"
+	default:
+		httpError(w, r, http.StatusNotAcceptable, fmt.Errorf("can't satisfy Accept %q", r.Header.Get("Accept")))
+		return
+	}
+	buf := new(bytes.Buffer)
+	if err := render(buf, data); err != nil {
+		httpError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	if err := writeBody(w, r, status, buf.Bytes()); err != nil {
+		ResponseLogger(r, status, err)
+		return
+	}
+	ResponseLogger(r, status, nil)
+}
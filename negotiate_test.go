@@ -0,0 +1,71 @@
+// negotiate_test.go test routines for negotiate.go
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"application/json", "text/html", "text/plain"}
+	tests := map[string]string{
+		"":                                   "application/json",
+		"*/*":                                "application/json",
+		"text/html":                          "text/html",
+		"text/*":                             "text/html",
+		"text/plain;q=0.9, text/html;q=0.5":  "text/plain",
+		"application/json;q=0, text/*;q=0.1": "text/html",
+		"image/png":                          "",
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": "text/html",
+	}
+	for accept, expected := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if got := Negotiate(req, offers...); got != expected {
+			t.Errorf("Accept %q, expected %q, got %q", accept, expected, got)
+		}
+	}
+}
+
+func TestRespond(t *testing.T) {
+	html := func(w io.Writer, data interface{}) error {
+		_, err := fmt.Fprintf(w, "<p>%v</p>", data)
+		return err
+	}
+	for _, test := range []struct{ method, accept, contentType, body string }{
+		{"GET", "application/json", "application/json; charset=utf-8", "\"ok\""},
+		{"GET", "text/html", "text/html; charset=utf-8", "<p>ok</p>"},
+		{"GET", "text/plain", "text/plain; charset=utf-8", "ok\n"},
+		{"HEAD", "text/html", "text/html; charset=utf-8", ""},
+		{"HEAD", "text/plain", "text/plain; charset=utf-8", ""},
+	} {
+		req := httptest.NewRequest(test.method, "/", nil)
+		req.Header.Set("Accept", test.accept)
+		rec := httptest.NewRecorder()
+		Respond(rec, req, http.StatusOK, "ok", html)
+		if rec.Header().Get("Vary") != "Accept" || rec.Header().Get("Content-Type") != test.contentType || rec.Body.String() != test.body {
+			t.Errorf("%s %s, unexpected response %q %q %q", test.method, test.accept, rec.Header().Get("Vary"), rec.Header().Get("Content-Type"), rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// options.go provides a functional options constructor for WebService.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+)
+
+// Option configures a *WebService created with NewWebService.
+type Option func(*WebService) error
+
+// NewWebService returns a *WebService with the defaults of
+// DefaultWebService() modified by opts. E.g.
+//
+//	ws, err := wsfn.NewWebService(
+//		wsfn.WithDocRoot("htdocs"),
+//		wsfn.WithHTTP("localhost", "8000"),
+//	)
+func NewWebService(opts ...Option) (*WebService, error) {
+	w := DefaultWebService()
+	for _, opt := range opts {
+		if err := opt(w); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// WithDocRoot sets the document root.
+func WithDocRoot(docRoot string) Option {
+	return func(w *WebService) error {
+		w.DocRoot = docRoot
+		return nil
+	}
+}
+
+// WithHTTP sets the host and port of the http service.
+func WithHTTP(host string, port string) Option {
+	return func(w *WebService) error {
+		w.Http = &Service{Scheme: "http", Host: host, Port: port}
+		return nil
+	}
+}
+
+// WithHTTPS sets the host, port and certificates of the https service.
+func WithHTTPS(host string, port string, certPEM string, keyPEM string) Option {
+	return func(w *WebService) error {
+		w.Https = &Service{Scheme: "https", Host: host, Port: port, CertPEM: certPEM, KeyPEM: keyPEM}
+		return nil
+	}
+}
+
+// WithAccess sets the access policy.
+func WithAccess(a *Access) Option {
+	return func(w *WebService) error {
+		w.Access = a
+		return nil
+	}
+}
+
+// WithAccessFile loads the access policy from fName.
+func WithAccessFile(fName string) Option {
+	return func(w *WebService) error {
+		a, err := LoadAccess(fName)
+		if err != nil {
+			return err
+		}
+		w.AccessFile, w.Access = fName, a
+		return nil
+	}
+}
+
+// WithCORS sets the CORS policy.
+func WithCORS(cors *CORSPolicy) Option {
+	return func(w *WebService) error {
+		w.CORS = cors
+		return nil
+	}
+}
+
+// WithMiddleware adds middleware wrapping the handlers served
+// after access checks. The first middleware added is the outermost.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(w *WebService) error {
+		w.middleware = append(w.middleware, mw...)
+		return nil
+	}
+}
+
+// WithHandler mounts h at pattern alongside the static files.
+func WithHandler(pattern string, h http.Handler) Option {
+	return func(w *WebService) error {
+		if w.handlers == nil {
+			w.handlers = map[string]http.Handler{}
+		}
+		w.handlers[pattern] = h
+		return nil
+	}
+}
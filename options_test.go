@@ -0,0 +1,63 @@
+// options_test.go tests NewWebService and its options.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewWebService(t *testing.T) {
+	tag := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Tag", "yes")
+			next.ServeHTTP(w, r)
+		})
+	}
+	ws, err := NewWebService(
+		WithDocRoot(t.TempDir()),
+		WithHTTP("localhost", "8001"),
+		WithCORS(&CORSPolicy{Origin: "*"}),
+		WithMiddleware(tag),
+		WithHandler("/hello", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.Http.Hostname() != "localhost:8001" {
+		t.Errorf("unexpected hostname %q", ws.Http.Hostname())
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/hello", nil))
+	if rec.Body.String() != "hello" {
+		t.Errorf("unexpected body %q", rec.Body.String())
+	}
+	if rec.Header().Get("X-Tag") != "yes" || rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("expected middleware and CORS headers, got %v", rec.Header())
+	}
+	if _, err := NewWebService(WithAccessFile("missing.toml")); err == nil {
+		t.Errorf("expected an error for a missing access file")
+	}
+}
@@ -0,0 +1,154 @@
+// paginate.go holds helpers for parsing pagination query parameters and
+// writing RFC 8288 Link headers so API endpoints page consistently.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultPageSize is used when a request doesn't include "size".
+	DefaultPageSize = 25
+	// MaxPageSize is the largest "size" accepted by ParsePage.
+	MaxPageSize = 1000
+)
+
+// Page describes the slice of results a request asked for. Pages are
+// numbered from one. Cursor holds an opaque position for cursor based
+// paging, it is empty for page/size requests.
+type Page struct {
+	Page   int    `json:"page,omitempty"`
+	Size   int    `json:"size"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Offset is the index of the first item on the page.
+func (p *Page) Offset() int {
+	if p.Page < 1 {
+		return 0
+	}
+	return (p.Page - 1) * p.Size
+}
+
+// ParsePage reads the "page", "size" and "cursor" query parameters.
+// Missing values default to page 1 and DefaultPageSize, a size larger
+// than MaxPageSize is reduced to MaxPageSize. An error is returned for
+// values that aren't positive integers or pages too far to count.
+func ParsePage(r *http.Request) (*Page, error) {
+	q := r.URL.Query()
+	p := &Page{Page: 1, Size: DefaultPageSize, Cursor: q.Get("cursor")}
+	if s := q.Get("page"); s != "" {
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 1 {
+			return nil, fmt.Errorf("page must be a positive integer, got %q", s)
+		}
+		p.Page = i
+	}
+	if s := q.Get("size"); s != "" {
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 1 {
+			return nil, fmt.Errorf("size must be a positive integer, got %q", s)
+		}
+		if i > MaxPageSize {
+			i = MaxPageSize
+		}
+		p.Size = i
+	}
+	if p.Page > math.MaxInt/p.Size {
+		return nil, fmt.Errorf("page %d is out of range", p.Page)
+	}
+	return p, nil
+}
+
+// Link is a single web link as described by RFC 8288.
+type Link struct {
+	URL string
+	Rel string
+}
+
+// String renders the link in Link header form, e.g. `</items?page=2>; rel="next"`
+func (l Link) String() string {
+	return fmt.Sprintf(`<%s>; rel="%s"`, l.URL, l.Rel)
+}
+
+// SetLinkHeader writes links into the response's Link header.
+func SetLinkHeader(w http.ResponseWriter, links ...Link) {
+	if len(links) == 0 {
+		return
+	}
+	parts := make([]string, len(links))
+	for i, l := range links {
+		parts[i] = l.String()
+	}
+	w.Header().Set("Link", strings.Join(parts, ", "))
+}
+
+// pageURL returns the request's path and query with the given
+// values replaced.
+func pageURL(r *http.Request, set map[string]string) string {
+	q := r.URL.Query()
+	for k, v := range set {
+		if v == "" {
+			q.Del(k)
+		} else {
+			q.Set(k, v)
+		}
+	}
+	u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// PageLinks returns the first, prev, next and last links for a page of
+// results. If total is negative the number of items is unknown so "last"
+// is omitted and "next" is always included.
+func PageLinks(r *http.Request, p *Page, total int) []Link {
+	size := strconv.Itoa(p.Size)
+	link := func(page int, rel string) Link {
+		return Link{URL: pageURL(r, map[string]string{"page": strconv.Itoa(page), "size": size, "cursor": ""}), Rel: rel}
+	}
+	last := -1
+	if total >= 0 {
+		last = (total + p.Size - 1) / p.Size
+		if last < 1 {
+			last = 1
+		}
+	}
+	links := []Link{link(1, "first")}
+	if p.Page > 1 {
+		links = append(links, link(p.Page-1, "prev"))
+	}
+	if last < 0 || p.Page < last {
+		links = append(links, link(p.Page+1, "next"))
+	}
+	if last > 0 {
+		links = append(links, link(last, "last"))
+	}
+	return links
+}
+
+// CursorLink returns a link for cursor based paging, an empty cursor
+// returns the link without a cursor parameter (e.g. for "first").
+func CursorLink(r *http.Request, cursor string, rel string) Link {
+	return Link{URL: pageURL(r, map[string]string{"cursor": cursor, "page": ""}), Rel: rel}
+}
@@ -0,0 +1,53 @@
+// paginate_test.go test routines for paginate.go
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPageLinks(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/items?page=2&size=10&q=cats", nil)
+	p, err := ParsePage(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Page != 2 || p.Size != 10 || p.Offset() != 10 {
+		t.Errorf("unexpected page %+v, offset %d", p, p.Offset())
+	}
+	rec := httptest.NewRecorder()
+	SetLinkHeader(rec, PageLinks(req, p, 35)...)
+	expected := `</api/items?page=1&q=cats&size=10>; rel="first", ` +
+		`</api/items?page=1&q=cats&size=10>; rel="prev", ` +
+		`</api/items?page=3&q=cats&size=10>; rel="next", ` +
+		`</api/items?page=4&q=cats&size=10>; rel="last"`
+	if got := rec.Header().Get("Link"); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+
+	req = httptest.NewRequest("GET", "/api/items?page=0", nil)
+	if _, err := ParsePage(req); err == nil {
+		t.Errorf("expected an error for page=0")
+	}
+	// Pages whose offset would overflow are rejected.
+	req = httptest.NewRequest("GET", "/api/items?page=368934881474191034&size=25", nil)
+	if _, err := ParsePage(req); err == nil {
+		t.Errorf("expected an error for a page out of range")
+	}
+}
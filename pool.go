@@ -0,0 +1,78 @@
+// pool.go keeps large files on the sendfile fast path and pools the
+// buffers used to copy response bodies.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// copyBufferSize matches the buffer io.Copy allocates.
+const copyBufferSize = 32 * 1024
+
+// copyBuffers holds buffers for copying response bodies. Response
+// writer wrappers pass ReadFrom on (see readFrom) so http.FileServer
+// can still use sendfile; those that must see the bytes, e.g. to
+// throttle or record them, copy with these instead of allocating a
+// 32 KiB buffer per response.
+var copyBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+// bufferPool lends copyBuffers to httputil.ReverseProxy so proxied
+// responses share the same buffers.
+type bufferPool struct{}
+
+func (bufferPool) Get() []byte {
+	return *(copyBuffers.Get().(*[]byte))
+}
+
+func (bufferPool) Put(buf []byte) {
+	if cap(buf) == copyBufferSize {
+		buf = buf[:copyBufferSize]
+		copyBuffers.Put(&buf)
+	}
+}
+
+// writerOnly hides any ReadFrom method of the writer it holds so
+// io.CopyBuffer writes through Write.
+type writerOnly struct {
+	io.Writer
+}
+
+// copyPooled copies src to dst through dst's Write method using a
+// pooled buffer.
+func copyPooled(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(writerOnly{dst}, src, *buf)
+}
+
+// readFrom passes src to w's ReadFrom (e.g. sendfile) if it has one,
+// copying with a pooled buffer otherwise.
+func readFrom(w http.ResponseWriter, src io.Reader) (int64, error) {
+	if rf, ok := w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return copyPooled(w, src)
+}
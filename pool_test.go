@@ -0,0 +1,103 @@
+// pool_test.go tests large files stay on the ReadFrom (sendfile) path.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readFromRecorder records the reader passed to ReadFrom.
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+	src io.Reader
+}
+
+func (rr *readFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	rr.src = src
+	return io.Copy(rr.ResponseRecorder, src)
+}
+
+// underlyingFile reports if src is an *os.File, possibly limited.
+func underlyingFile(src io.Reader) bool {
+	if lr, ok := src.(*io.LimitedReader); ok {
+		src = lr.R
+	}
+	_, ok := src.(*os.File)
+	return ok
+}
+
+func TestReadFromFastPath(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	docRoot := t.TempDir()
+	body := bytes.Repeat([]byte("0123456789abcdef"), 8192)
+	os.WriteFile(filepath.Join(docRoot, "master.tif"), body, 0644)
+	ws, err := NewWebService(WithDocRoot(docRoot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.ContentTypes = map[string]string{".tif": "image/tiff"}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, accept := range []string{"", "application/json"} {
+		rec := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+		req := httptest.NewRequest("GET", "/master.tif", nil)
+		req.Header.Set("Accept", accept)
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Body.Len() != len(body) {
+			t.Fatalf("expected %d bytes, got %d %d", len(body), rec.Code, rec.Body.Len())
+		}
+		if underlyingFile(rec.src) == false {
+			t.Errorf("Accept %q, expected ReadFrom with the *os.File, got %T", accept, rec.src)
+		}
+	}
+
+	// Throttled responses must not bypass the limit.
+	throttled, err := BandwidthHandler([]*Bandwidth{{Prefix: "/", PerRequest: 1 << 20}}, http.FileServer(http.Dir(docRoot)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	start := time.Now()
+	throttled.ServeHTTP(rec, httptest.NewRequest("GET", "/master.tif", nil))
+	if d := time.Since(start); d < 80*time.Millisecond || rec.Body.Len() != len(body) {
+		t.Errorf("expected 128 KiB at 1 MiB/s to take about 100ms, took %s for %d bytes", d, rec.Body.Len())
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	var pool bufferPool
+	buf := pool.Get()
+	if len(buf) != copyBufferSize {
+		t.Errorf("expected a %d byte buffer, got %d", copyBufferSize, len(buf))
+	}
+	pool.Put(buf[:10])
+	if buf = pool.Get(); len(buf) != copyBufferSize {
+		t.Errorf("expected a full length buffer after Put, got %d", len(buf))
+	}
+}
@@ -0,0 +1,116 @@
+// preload.go sends Link preload headers, and optionally 103 Early
+// Hints, for the critical assets of pages.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Preload lists the assets browsers should fetch early for Pages.
+type Preload struct {
+	// Pages are path prefixes or patterns (e.g. "/exhibits/*.html").
+	Pages []string `json:"pages" toml:"pages"`
+	// Assets are the URLs to preload (e.g. "/css/site.css"), the
+	// "as" type is worked out from the extension. An entry holding
+	// ";" is sent as is, e.g. `</hero.avif>; rel=preload; as=image`.
+	Assets []string `json:"assets" toml:"assets"`
+	// EarlyHints sends the Link headers in a "103 Early Hints"
+	// response before the page, so browsers start fetching while
+	// the page is prepared.
+	EarlyHints bool `json:"early_hints,omitempty" toml:"early_hints,omitempty"`
+}
+
+// preloadAs returns the "as" type and if the asset must be fetched
+// with CORS (fonts and data are).
+func preloadAs(asset string) (string, bool) {
+	switch strings.ToLower(path.Ext(strings.SplitN(asset, "?", 2)[0])) {
+	case ".css":
+		return "style", false
+	case ".js", ".mjs":
+		return "script", false
+	case ".woff", ".woff2", ".ttf", ".otf":
+		return "font", true
+	case ".json":
+		return "fetch", true
+	case ".avif", ".webp", ".png", ".jpg", ".jpeg", ".gif", ".svg":
+		return "image", false
+	default:
+		return "", false
+	}
+}
+
+// PreloadLink returns the Link header value preloading asset.
+func PreloadLink(asset string) string {
+	if strings.Contains(asset, ";") {
+		return asset
+	}
+	if strings.HasSuffix(strings.ToLower(path.Ext(asset)), ".mjs") {
+		return fmt.Sprintf("<%s>; rel=modulepreload", asset)
+	}
+	link := fmt.Sprintf("<%s>; rel=preload", asset)
+	if as, cors := preloadAs(asset); as != "" {
+		link += "; as=" + as
+		if cors {
+			link += "; crossorigin"
+		}
+	}
+	return link
+}
+
+// PreloadHandler adds the Link headers of the Preload entries
+// fitting GET and HEAD requests before calling next. Assets served
+// with Fingerprint get their hashed URLs.
+func (w *WebService) PreloadHandler(next http.Handler) (http.Handler, error) {
+	for _, p := range w.Preload {
+		if len(p.Pages) == 0 || len(p.Assets) == 0 {
+			return nil, fmt.Errorf("preload requires pages and assets")
+		}
+		for _, asset := range p.Assets {
+			if strings.ContainsAny(asset, "\r\n") {
+				return nil, fmt.Errorf("preload asset %q holds a line break", asset)
+			}
+		}
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			next.ServeHTTP(res, req)
+			return
+		}
+		hints := false
+		for _, p := range w.Preload {
+			if matchPath(req.URL.Path, p.Pages) == false {
+				continue
+			}
+			for _, asset := range p.Assets {
+				if w.Fingerprint != nil && strings.HasPrefix(asset, "/") {
+					asset = w.Fingerprint.URL(asset)
+				}
+				res.Header().Add("Link", PreloadLink(asset))
+			}
+			hints = hints || p.EarlyHints
+		}
+		if hints && req.Method == http.MethodGet && req.ProtoAtLeast(1, 1) {
+			res.WriteHeader(http.StatusEarlyHints)
+		}
+		next.ServeHTTP(res, req)
+	}), nil
+}
@@ -0,0 +1,96 @@
+// preload_test.go tests Link preload headers and 103 Early Hints.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPreload(t *testing.T) {
+	docRoot := t.TempDir()
+	os.MkdirAll(filepath.Join(docRoot, "exhibits"), 0755)
+	os.WriteFile(filepath.Join(docRoot, "exhibits", "index.html"), []byte("<p>exhibit</p>"), 0644)
+	os.WriteFile(filepath.Join(docRoot, "index.html"), []byte("<p>home</p>"), 0644)
+	ws := &WebService{
+		DocRoot: docRoot,
+		Preload: []*Preload{{
+			Pages:      []string{"/exhibits/"},
+			Assets:     []string{"/css/exhibit.css", "/js/viewer.mjs", "/fonts/serif.woff2", "</hero.avif>; rel=preload; as=image; fetchpriority=high"},
+			EarlyHints: true,
+		}},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	expected := []string{
+		"</css/exhibit.css>; rel=preload; as=style",
+		"</js/viewer.mjs>; rel=modulepreload",
+		"</fonts/serif.woff2>; rel=preload; as=font; crossorigin",
+		"</hero.avif>; rel=preload; as=image; fetchpriority=high",
+	}
+	var hints []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				hints = header["Link"]
+			}
+			return nil
+		},
+	}
+	req, _ := http.NewRequest("GET", srv.URL+"/exhibits/", nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", res.StatusCode)
+	}
+	if reflect.DeepEqual(res.Header["Link"], expected) == false {
+		t.Errorf("expected Link %q, got %q", expected, res.Header["Link"])
+	}
+	if reflect.DeepEqual(hints, expected) == false {
+		t.Errorf("expected early hints %q, got %q", expected, hints)
+	}
+
+	res, err = http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(res.Header["Link"]) != 0 {
+		t.Errorf("expected no Link headers for other pages, got %q", res.Header["Link"])
+	}
+
+	ws.Preload = []*Preload{{Pages: []string{"/"}}}
+	if _, err := ws.Handler(); err == nil {
+		t.Errorf("expected an error for a preload without assets")
+	}
+}
@@ -0,0 +1,157 @@
+// problem.go implements RFC 7807 "problem details" error responses
+// (application/problem+json) along with the helpers wsfn uses to
+// answer errors in JSON when a client asks for it.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// ProblemDetails describes an error as defined by RFC 7807.
+// See https://www.rfc-editor.org/rfc/rfc7807
+type ProblemDetails struct {
+	// Type is a URI reference identifying the problem type,
+	// "about:blank" if not set.
+	Type string `json:"type,omitempty"`
+	// Title is a short human readable summary of the problem type.
+	Title string `json:"title,omitempty"`
+	// Status is the HTTP status code.
+	Status int `json:"status,omitempty"`
+	// Detail is a human readable explanation specific to this occurrence.
+	Detail string `json:"detail,omitempty"`
+	// Instance is a URI reference identifying this occurrence, usually
+	// the request path.
+	Instance string `json:"instance,omitempty"`
+	// RequestID identifies the request in the logs, an extension
+	// member used by proxy error pages.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// NewProblem returns a *ProblemDetails for the request and status. The
+// title is the standard status text and the instance the request path.
+// If err is not nil its message becomes the detail.
+func NewProblem(r *http.Request, status int, err error) *ProblemDetails {
+	p := &ProblemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: r.URL.Path,
+	}
+	if err != nil {
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+// WriteProblem writes p as an application/problem+json response
+// using p.Status (500 if unset) as the status code.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *ProblemDetails) {
+	status := p.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	src, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	writeBody(w, r, status, src)
+}
+
+// acceptsJSON returns true if the request's Accept header prefers
+// JSON or problem+json over plain text.
+func acceptsJSON(r *http.Request) bool {
+	switch Negotiate(r, "text/plain", "application/problem+json", "application/json") {
+	case "application/problem+json", "application/json":
+		return true
+	}
+	return false
+}
+
+// httpError answers an error using problem+json when the client
+// accepts JSON and plain text otherwise. The response is logged.
+func httpError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if acceptsJSON(r) {
+		WriteProblem(w, r, NewProblem(r, status, err))
+	} else {
+		http.Error(w, http.StatusText(status), status)
+	}
+	ResponseLogger(r, status, err)
+}
+
+// problemWriter replaces the plain text error bodies written by
+// handlers like http.FileServer with problem+json.
+type problemWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	problem bool
+}
+
+// WriteHeader swaps in a problem+json body for error statuses.
+func (pw *problemWriter) WriteHeader(status int) {
+	if status >= 400 {
+		pw.problem = true
+		WriteProblem(pw.ResponseWriter, pw.r, NewProblem(pw.r, status, nil))
+		return
+	}
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+// Write discards the original body once a problem has been written.
+func (pw *problemWriter) Write(p []byte) (int, error) {
+	if pw.problem {
+		return len(p), nil
+	}
+	return pw.ResponseWriter.Write(p)
+}
+
+// ReadFrom keeps the underlying writer's fast path.
+func (pw *problemWriter) ReadFrom(src io.Reader) (int64, error) {
+	if pw.problem {
+		return io.Copy(io.Discard, src)
+	}
+	return readFrom(pw.ResponseWriter, src)
+}
+
+func (pw *problemWriter) Flush() {
+	if f, ok := pw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap supports http.ResponseController.
+func (pw *problemWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+// ProblemHandler wraps next so error responses (status >= 400) are
+// written as application/problem+json when the client accepts JSON.
+func ProblemHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if acceptsJSON(r) == false {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&problemWriter{ResponseWriter: w, r: r}, r)
+	})
+}
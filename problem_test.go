@@ -0,0 +1,111 @@
+// problem_test.go tests RFC 7807 problem+json responses.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteProblem(t *testing.T) {
+	r := httptest.NewRequest("GET", "/reports/missing.pdf", nil)
+	rec := httptest.NewRecorder()
+	WriteProblem(rec, r, NewProblem(r, http.StatusNotFound, fmt.Errorf("no such report")))
+	p := ProblemDetails{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	expected := ProblemDetails{Type: "about:blank", Title: "Not Found", Status: http.StatusNotFound, Detail: "no such report", Instance: "/reports/missing.pdf"}
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != "application/problem+json" || p != expected {
+		t.Errorf("unexpected problem %d %q %+v", rec.Code, rec.Header().Get("Content-Type"), p)
+	}
+
+	// Without a status the problem is a 500, HEAD gets no body.
+	rec = httptest.NewRecorder()
+	WriteProblem(rec, httptest.NewRequest("HEAD", "/", nil), &ProblemDetails{Title: "Broken"})
+	if rec.Code != http.StatusInternalServerError || rec.Body.Len() != 0 || rec.Header().Get("Content-Length") == "" {
+		t.Errorf("expected a bodyless 500, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	for accept, contentType := range map[string]string{
+		"":                 "text/plain; charset=utf-8",
+		"text/html":        "text/plain; charset=utf-8",
+		"application/json": "application/problem+json",
+		"application/problem+json, text/plain;q=0.5": "application/problem+json",
+	} {
+		r := httptest.NewRequest("GET", "/private/", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		httpError(rec, r, http.StatusForbidden, fmt.Errorf("not allowed"))
+		if rec.Code != http.StatusForbidden || rec.Header().Get("Content-Type") != contentType {
+			t.Errorf("Accept %q, expected %d %q, got %d %q", accept, http.StatusForbidden, contentType, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		// The error detail is for the logs, not plain text clients.
+		if contentType != "application/problem+json" && strings.Contains(rec.Body.String(), "not allowed") {
+			t.Errorf("expected the detail left out of %q", rec.Body.String())
+		}
+	}
+}
+
+func TestProblemHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/stream":
+			w.Write([]byte("one\n"))
+			http.NewResponseController(w).Flush()
+			w.Write([]byte("two\n"))
+		default:
+			w.Write([]byte("ok"))
+		}
+	})
+	h := ProblemHandler(next)
+	serve := func(p, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", p, nil)
+		r.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+	rec := serve("/missing", "application/json")
+	p := ProblemDetails{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("expected only the problem in the body, %s %q", err, rec.Body.String())
+	}
+	if rec.Code != http.StatusNotFound || p.Status != http.StatusNotFound || p.Instance != "/missing" {
+		t.Errorf("unexpected problem %d %+v", rec.Code, p)
+	}
+	if rec = serve("/missing", "text/plain"); rec.Body.String() != "404 page not found\n" {
+		t.Errorf("expected plain text clients untouched, got %q", rec.Body.String())
+	}
+	if rec = serve("/", "application/json"); rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("expected successful responses untouched, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec = serve("/stream", "application/json"); rec.Flushed == false || rec.Body.String() != "one\ntwo\n" {
+		t.Errorf("expected the flush passed through, got %t %q", rec.Flushed, rec.Body.String())
+	}
+}
@@ -0,0 +1,148 @@
+// proxies.go resolves the client IP address for requests arriving
+// through trusted proxies (e.g. a load balancer).
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies holds the networks whose X-Forwarded-For,
+// X-Real-IP and X-Forwarded-Proto headers are believed.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a list of CIDRs (e.g. "10.0.0.0/8") or
+// single IP addresses.
+func ParseTrustedProxies(cidrs []string) (TrustedProxies, error) {
+	tp := TrustedProxies{}
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if strings.Contains(s, "/") == false {
+			if ip := net.ParseIP(s); ip != nil {
+				bits := 8 * net.IPv4len
+				if ip.To4() == nil {
+					bits = 8 * net.IPv6len
+				}
+				s = fmt.Sprintf("%s/%d", s, bits)
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q, %s", s, err)
+		}
+		tp = append(tp, ipNet)
+	}
+	return tp, nil
+}
+
+// Contains reports if ip is a trusted proxy.
+func (tp TrustedProxies) Contains(ip string) bool {
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil {
+		return false
+	}
+	for _, ipNet := range tp {
+		if ipNet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client's IP address. When the request comes
+// from a trusted proxy X-Forwarded-For is read right to left skipping
+// trusted proxies, falling back to X-Real-IP.
+func (tp TrustedProxies) ClientIP(r *http.Request) string {
+	ip := clientIP(r)
+	if tp.Contains(ip) == false {
+		return ip
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if tp.Contains(hop) == false {
+				return hop
+			}
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return ip
+}
+
+type peerIPKey struct{}
+
+type forwardedProtoKey struct{}
+
+// requestScheme returns "https" if the client used TLS, to us or to
+// a trusted proxy reporting it with X-Forwarded-Proto.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if proto, ok := r.Context().Value(forwardedProtoKey{}).(string); ok {
+		return proto
+	}
+	return "http"
+}
+
+// PeerIP returns the IP address of the host connected to us, the
+// proxy rather than the client when TrustedProxies.Handler has
+// rewritten RemoteAddr.
+func PeerIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(peerIPKey{}).(string); ok {
+		return ip
+	}
+	return clientIP(r)
+}
+
+// Handler sets the request's RemoteAddr to the ClientIP so logging
+// and access checks see the client rather than the proxy. The
+// proxy's address remains available from PeerIP. A trusted proxy's
+// X-Forwarded-Proto is used for the scheme of redirects and cookies.
+func (tp TrustedProxies) Handler(next http.Handler) http.Handler {
+	if len(tp) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, peer := tp.ClientIP(r), clientIP(r)
+		if tp.Contains(peer) {
+			// The first proxy's is the scheme the client used.
+			proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+			if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "https" || proto == "http" {
+				r = r.WithContext(context.WithValue(r.Context(), forwardedProtoKey{}, proto))
+			}
+		}
+		if ip != peer {
+			r = r.WithContext(context.WithValue(r.Context(), peerIPKey{}, peer))
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	})
+}
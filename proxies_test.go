@@ -0,0 +1,88 @@
+// proxies_test.go tests TrustedProxies.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	tp, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Errorf("expected an error for an invalid proxy")
+	}
+	tests := []struct {
+		remote, xff, realIP, expected string
+	}{
+		// Untrusted clients can't spoof their address.
+		{"198.51.100.9:1234", "203.0.113.5", "", "198.51.100.9"},
+		{"10.1.2.3:1234", "203.0.113.5", "", "203.0.113.5"},
+		// Trusted hops are skipped, spoofed entries left of the
+		// client are ignored.
+		{"10.1.2.3:1234", "1.1.1.1, 203.0.113.5, 192.0.2.1", "", "203.0.113.5"},
+		{"10.1.2.3:1234", "10.9.9.9", "", "10.9.9.9"},
+		{"10.1.2.3:1234", "", "203.0.113.6", "203.0.113.6"},
+		{"[2001:db8::1]:443", "2001:db9::7", "", "2001:db9::7"},
+		{"10.1.2.3:1234", "garbage", "", "10.1.2.3"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remote
+		if test.xff != "" {
+			req.Header.Set("X-Forwarded-For", test.xff)
+		}
+		if test.realIP != "" {
+			req.Header.Set("X-Real-IP", test.realIP)
+		}
+		if ip := tp.ClientIP(req); ip != test.expected {
+			t.Errorf("%+v, expected %q, got %q", test, test.expected, ip)
+		}
+	}
+
+	var remote string
+	h := tp.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote = clientIP(r)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.5")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if remote != "203.0.113.5" {
+		t.Errorf("expected handler to see 203.0.113.5, got %q", remote)
+	}
+
+	// Only trusted proxies report the scheme.
+	var scheme string
+	h = tp.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme = requestScheme(r)
+	}))
+	for remote, expected := range map[string]string{"10.1.2.3:1234": "https", "198.51.100.9:1234": "http"} {
+		req = httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-Proto", "HTTPS, http")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if scheme != expected {
+			t.Errorf("from %s, expected %s, got %s", remote, expected, scheme)
+		}
+	}
+}
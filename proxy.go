@@ -0,0 +1,192 @@
+// proxy.go sends reverse_proxy routes to their upstreams, optionally
+// splitting traffic with a canary upstream.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultCanaryCookie names the cookie recording a sticky canary
+// choice when Canary.Cookie isn't set.
+const DefaultCanaryCookie = "wsfn_canary"
+
+// Canary sends Percent of a reverse_proxy route's requests to
+// Upstream instead of the route's stable upstream. With Sticky a
+// client keeps the upstream it was first given using a cookie.
+type Canary struct {
+	Upstream string  `json:"upstream" toml:"upstream"`
+	Percent  float64 `json:"percent" toml:"percent"`
+	Sticky   bool    `json:"sticky,omitempty" toml:"sticky,omitempty"`
+	Cookie   string  `json:"cookie,omitempty" toml:"cookie,omitempty"`
+}
+
+// UpstreamStats counts the requests sent to an upstream, those
+// answered with a 5xx status (or not answered) and their latency.
+type UpstreamStats struct {
+	Requests    int64  `json:"requests"`
+	Errors      int64  `json:"errors"`
+	MeanLatency string `json:"mean_latency"`
+
+	total time.Duration
+}
+
+// upstreamMetrics holds UpstreamStats by upstream URL.
+type upstreamMetrics struct {
+	mu    sync.Mutex
+	stats map[string]*UpstreamStats
+}
+
+// record adds a response from upstream.
+func (um *upstreamMetrics) record(upstream string, status int, d time.Duration) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	if um.stats == nil {
+		um.stats = map[string]*UpstreamStats{}
+	}
+	s, ok := um.stats[upstream]
+	if ok == false {
+		s = new(UpstreamStats)
+		um.stats[upstream] = s
+	}
+	s.Requests++
+	if status >= 500 {
+		s.Errors++
+	}
+	s.total += d
+	s.MeanLatency = (s.total / time.Duration(s.Requests)).String()
+}
+
+// snapshot returns a copy of the stats.
+func (um *upstreamMetrics) snapshot() map[string]UpstreamStats {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	m := map[string]UpstreamStats{}
+	for upstream, s := range um.stats {
+		m[upstream] = *s
+	}
+	return m
+}
+
+// proxyHandler returns a reverse proxy to upstream recording its
+// metrics in um. Failures are answered with pe's pages (the built in
+// pages if nil).
+func proxyHandler(upstream string, um *upstreamMetrics, pe *ProxyErrorPages) (http.Handler, error) {
+	u, err := url.Parse(upstream)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("upstream %q must be an http or https URL", upstream)
+	}
+	rp := httputil.NewSingleHostReverseProxy(u)
+	rp.BufferPool = bufferPool{}
+	if timeout := pe.timeout(); timeout > 0 {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.ResponseHeaderTimeout = timeout
+		rp.Transport = transport
+	}
+	rp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		pe.write(w, r, gatewayStatus(err), fmt.Errorf("upstream %s, %s", u.Host, err))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Upstreams log the same ID the error pages show.
+		requestID(r)
+		start := time.Now()
+		sw := newStatusWriter(w)
+		rp.ServeHTTP(sw, r)
+		um.record(upstream, sw.Status(), time.Since(start))
+	}), nil
+}
+
+// canaryHandler splits requests between stable and canary.
+func canaryHandler(prefix string, c *Canary, stable http.Handler, canary http.Handler) http.Handler {
+	cookie := c.Cookie
+	if cookie == "" {
+		cookie = DefaultCanaryCookie
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		choice := ""
+		if c.Sticky {
+			if ck, err := r.Cookie(cookie); err == nil && (ck.Value == "canary" || ck.Value == "stable") {
+				choice = ck.Value
+			}
+		}
+		if choice == "" {
+			choice = "stable"
+			if rand.Float64()*100 < c.Percent {
+				choice = "canary"
+			}
+			if c.Sticky {
+				http.SetCookie(w, &http.Cookie{Name: cookie, Value: choice, Path: prefix, Secure: requestScheme(r) == "https", HttpOnly: true, SameSite: http.SameSiteLaxMode})
+			}
+		}
+		if choice == "canary" {
+			canary.ServeHTTP(w, r)
+			return
+		}
+		stable.ServeHTTP(w, r)
+	})
+}
+
+// proxyRoutes adds the ReverseProxy routes (and their Canaries) to mux.
+func (w *WebService) proxyRoutes(mux *http.ServeMux) error {
+	for prefix := range w.ProxyQueues {
+		if _, ok := w.ReverseProxy[prefix]; ok == false {
+			return fmt.Errorf("proxy queue %q is not a reverse_proxy route", prefix)
+		}
+	}
+	for prefix := range w.Canaries {
+		if _, ok := w.ReverseProxy[prefix]; ok == false {
+			return fmt.Errorf("canary %q is not a reverse_proxy route", prefix)
+		}
+	}
+	trusted := map[string]bool{}
+	for _, prefix := range w.IdentityHeaders {
+		if _, ok := w.ReverseProxy[prefix]; ok == false {
+			return fmt.Errorf("identity headers %q is not a reverse_proxy route", prefix)
+		}
+		trusted[prefix] = true
+	}
+	for prefix, upstream := range w.ReverseProxy {
+		h, err := proxyHandler(upstream, &w.upstreams, w.ProxyErrors)
+		if err != nil {
+			return fmt.Errorf("reverse_proxy %q, %s", prefix, err)
+		}
+		if c, ok := w.Canaries[prefix]; ok {
+			if c.Percent < 0 || c.Percent > 100 {
+				return fmt.Errorf("canary %q percent must be between 0 and 100", prefix)
+			}
+			canary, err := proxyHandler(c.Upstream, &w.upstreams, w.ProxyErrors)
+			if err != nil {
+				return fmt.Errorf("canary %q, %s", prefix, err)
+			}
+			h = canaryHandler(prefix, c, h, canary)
+		}
+		if q, ok := w.ProxyQueues[prefix]; ok {
+			if h, err = q.Handler(h); err != nil {
+				return fmt.Errorf("proxy queue %q, %s", prefix, err)
+			}
+		}
+		mux.Handle(prefix, traceLayer("proxy", prefix+" -> "+upstream, identityHandler(h, trusted[prefix])))
+	}
+	return nil
+}
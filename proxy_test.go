@@ -0,0 +1,84 @@
+// proxy_test.go tests reverse proxy routes and canary routing.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCanary(t *testing.T) {
+	upstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s", name, r.URL.Path)
+		}))
+	}
+	stable, canary := upstream("stable"), upstream("canary")
+	defer stable.Close()
+	defer canary.Close()
+
+	ws := &WebService{
+		DocRoot:      t.TempDir(),
+		ReverseProxy: map[string]string{"/api/": stable.URL},
+		Canaries:     map[string]*Canary{"/api/": {Upstream: canary.URL, Percent: 100, Sticky: true}},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(c *http.Cookie) (string, *http.Response) {
+		req := httptest.NewRequest("GET", "/api/items", nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		res := rec.Result()
+		src, _ := io.ReadAll(res.Body)
+		return string(src), res
+	}
+	body, res := get(nil)
+	if body != "canary /api/items" {
+		t.Errorf("expected the canary, got %q", body)
+	}
+	if len(res.Cookies()) != 1 || res.Cookies()[0].Value != "canary" || res.Cookies()[0].HttpOnly == false || res.Cookies()[0].Secure {
+		t.Errorf("expected a sticky HttpOnly cookie, got %+v", res.Cookies())
+	}
+	if body, _ = get(&http.Cookie{Name: DefaultCanaryCookie, Value: "stable"}); body != "stable /api/items" {
+		t.Errorf("expected sticky stable, got %q", body)
+	}
+	upstreams := ws.Status().Upstreams
+	if upstreams[stable.URL].Requests != 1 || upstreams[canary.URL].Requests != 1 {
+		t.Errorf("unexpected upstream metrics %+v", upstreams)
+	}
+
+	// Over https the cookie is only sent back over https.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "https://example.edu/api/items", nil))
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].Secure == false {
+		t.Errorf("expected a secure sticky cookie, got %+v", cookies)
+	}
+
+	ws.Canaries = map[string]*Canary{"/other/": {Upstream: canary.URL, Percent: 5}}
+	if _, err := ws.Handler(); err == nil {
+		t.Errorf("expected an error for a canary without a reverse_proxy route")
+	}
+}
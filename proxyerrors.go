@@ -0,0 +1,186 @@
+// proxyerrors.go answers failed reverse proxy requests with 502 and 504
+// pages carrying a request ID for support tickets.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestIDHeader carries the request ID sent to upstreams and
+// returned with proxy error pages.
+const RequestIDHeader = "X-Request-Id"
+
+// defaultProxyErrorPage is used when no page is configured for
+// a status.
+const defaultProxyErrorPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{status} {title}</title>
+</head>
+<body>
+<h1>{title}</h1>
+<p>{message} Please try again later.</p>
+<p>If the problem continues contact us with the request ID
+<code>{request_id}</code>.</p>
+</body>
+</html>
+`
+
+// proxyErrorMessages explain proxy failures to visitors.
+var proxyErrorMessages = map[int]string{
+	http.StatusBadGateway:     "The service behind this page isn't available.",
+	http.StatusGatewayTimeout: "The service behind this page didn't answer in time.",
+}
+
+// ProxyErrorPages are the responses sent when a reverse proxy
+// upstream can't be reached (502 Bad Gateway) or doesn't answer in
+// time (504 Gateway Timeout). Browsers get an HTML page, clients
+// accepting JSON application/problem+json with a "request_id".
+type ProxyErrorPages struct {
+	// BadGateway and GatewayTimeout are HTML files sent for 502 and
+	// 504 responses, a plain built in page if not set. "{status}",
+	// "{title}", "{message}" and "{request_id}" are replaced.
+	BadGateway     string `json:"bad_gateway,omitempty" toml:"bad_gateway,omitempty"`
+	GatewayTimeout string `json:"gateway_timeout,omitempty" toml:"gateway_timeout,omitempty"`
+	// TimeoutSeconds limits how long an upstream has to send its
+	// response headers before a 504, no limit if not set.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty" toml:"timeout_seconds,omitempty"`
+
+	pages map[int]string
+}
+
+// load reads the configured pages.
+func (pe *ProxyErrorPages) load() error {
+	pages := map[int]string{}
+	for status, fName := range map[int]string{
+		http.StatusBadGateway:     pe.BadGateway,
+		http.StatusGatewayTimeout: pe.GatewayTimeout,
+	} {
+		if fName == "" {
+			continue
+		}
+		src, err := os.ReadFile(fName)
+		if err != nil {
+			return fmt.Errorf("proxy_errors, %s", err)
+		}
+		pages[status] = string(src)
+	}
+	pe.pages = pages
+	return nil
+}
+
+// timeout returns the upstream response header timeout, zero for
+// no limit.
+func (pe *ProxyErrorPages) timeout() time.Duration {
+	if pe == nil || pe.TimeoutSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(pe.TimeoutSeconds) * time.Second
+}
+
+// page returns the HTML page for status.
+func (pe *ProxyErrorPages) page(status int) string {
+	if pe != nil {
+		if page, ok := pe.pages[status]; ok {
+			return page
+		}
+	}
+	return defaultProxyErrorPage
+}
+
+// gatewayStatus returns 504 for errors caused by a timeout, 502
+// otherwise.
+func gatewayStatus(err error) int {
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// validRequestID accepts IDs of up to 128 letters, digits, ".", "_"
+// and "-".
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && strings.ContainsRune("._-", c) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the request's X-Request-Id, setting a new
+// random one if it is missing or malformed.
+func requestID(r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if validRequestID(id) {
+		return id
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	id = hex.EncodeToString(buf)
+	r.Header.Set(RequestIDHeader, id)
+	return id
+}
+
+// write answers a failed proxy request with the page (or problem)
+// for status, logging err. The upstream's address and error are
+// only logged, never sent to the client.
+func (pe *ProxyErrorPages) write(w http.ResponseWriter, r *http.Request, status int, err error) {
+	id := requestID(r)
+	w.Header().Set(RequestIDHeader, id)
+	w.Header().Set("Cache-Control", "no-store")
+	message := proxyErrorMessages[status]
+	switch Negotiate(r, "text/html", "application/problem+json", "application/json", "text/plain") {
+	case "application/problem+json", "application/json":
+		p := NewProblem(r, status, nil)
+		p.Detail, p.RequestID = message, id
+		WriteProblem(w, r, p)
+	case "text/html":
+		page := strings.NewReplacer(
+			"{status}", strconv.Itoa(status),
+			"{title}", html.EscapeString(http.StatusText(status)),
+			"{message}", html.EscapeString(message),
+			"{request_id}", html.EscapeString(id),
+		).Replace(pe.page(status))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		writeBody(w, r, status, []byte(page))
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		writeBody(w, r, status, []byte(fmt.Sprintf("%d %s\n%s\nRequest ID %s\n", status, http.StatusText(status), message, id)))
+	}
+	ResponseLogger(r, status, fmt.Errorf("request %s, %s", id, err))
+}
@@ -0,0 +1,114 @@
+// proxyerrors_test.go tests the reverse proxy error pages.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyErrorPages(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "502.html")
+	if err := os.WriteFile(page, []byte("<p>Library systems are down ({status}), ref {request_id}</p>"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	forwarded := make(chan string, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- r.Header.Get(RequestIDHeader)
+		time.Sleep(1500 * time.Millisecond)
+	}))
+	defer slow.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	ws := &WebService{
+		DocRoot: dir,
+		ReverseProxy: map[string]string{
+			"/down/": down.URL,
+			"/slow/": slow.URL,
+		},
+		ProxyErrors: &ProxyErrorPages{BadGateway: page, TimeoutSeconds: 1},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(p string, accept string, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", p, nil)
+		req.Header.Set("Accept", accept)
+		if id != "" {
+			req.Header.Set(RequestIDHeader, id)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The configured page, with a new request ID.
+	rec := serve("/down/", "text/html,*/*;q=0.8", "")
+	id := rec.Header().Get(RequestIDHeader)
+	if rec.Code != http.StatusBadGateway || validRequestID(id) == false {
+		t.Fatalf("expected 502 with a request ID, got %d %q", rec.Code, id)
+	}
+	if body := rec.Body.String(); body != "<p>Library systems are down (502), ref "+id+"</p>" {
+		t.Errorf("unexpected page %q", body)
+	}
+	if strings.Contains(rec.Body.String(), "127.0.0.1") {
+		t.Errorf("the upstream address shouldn't be shown, %q", rec.Body.String())
+	}
+
+	// JSON clients get a problem, the client's ID is kept.
+	rec = serve("/down/", "application/json", "ticket-42")
+	problem := new(ProblemDetails)
+	if err := json.Unmarshal(rec.Body.Bytes(), problem); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Header().Get("Content-Type") != "application/problem+json" || problem.Status != http.StatusBadGateway || problem.RequestID != "ticket-42" {
+		t.Errorf("expected a 502 problem for ticket-42, got %s", rec.Body)
+	}
+
+	// Timeouts get the built in 504 page, malformed IDs are replaced
+	// and the ID is sent upstream.
+	rec = serve("/slow/", "text/html", "bad id\n")
+	id = rec.Header().Get(RequestIDHeader)
+	if rec.Code != http.StatusGatewayTimeout || validRequestID(id) == false {
+		t.Fatalf("expected 504 with a request ID, got %d %q", rec.Code, id)
+	}
+	if sent := <-forwarded; sent != id {
+		t.Errorf("expected %q sent upstream, got %q", id, sent)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "<code>"+id+"</code>") == false || strings.Contains(body, "answer in time") == false {
+		t.Errorf("unexpected page %q", body)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("error pages shouldn't be cached, got %q", rec.Header().Get("Cache-Control"))
+	}
+
+	// A missing page file is reported when the handler is built.
+	ws.ProxyErrors.GatewayTimeout = filepath.Join(dir, "missing.html")
+	if _, err := ws.Handler(); err == nil {
+		t.Errorf("expected an error for a missing page")
+	}
+}
@@ -0,0 +1,209 @@
+// proxyproto.go accepts HAProxy PROXY protocol v1 and v2 headers so
+// the client address survives TCP load balancing.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyHeaderTimeout is how long a new connection has to send its
+// PROXY protocol header.
+var ProxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature starts a PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// NewProxyProtocolListener wraps ln so each connection must start
+// with a PROXY protocol v1 or v2 header. The connection's RemoteAddr
+// and LocalAddr report the addresses from the header. Only enable it
+// when every client connects through the load balancer, otherwise
+// clients can claim any address.
+func NewProxyProtocolListener(ln net.Listener) net.Listener {
+	return &proxyProtoListener{Listener: ln}
+}
+
+type proxyProtoListener struct {
+	net.Listener
+}
+
+// Accept returns the next connection. The header is read on the
+// first Read, RemoteAddr or LocalAddr so a slow client can't stall
+// Accept.
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtoConn{Conn: conn}, nil
+}
+
+type proxyProtoConn struct {
+	net.Conn
+	once   sync.Once
+	r      *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+	err    error
+}
+
+// init reads the PROXY header.
+func (c *proxyProtoConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout))
+		c.r = bufio.NewReader(c.Conn)
+		c.remote, c.local, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtoConn) LocalAddr() net.Addr {
+	c.init()
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader reads a v1 or v2 header returning the source and
+// destination addresses, nil addresses for LOCAL or UNKNOWN.
+func readProxyHeader(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch b[0] {
+	case 'P':
+		return readProxyV1(r)
+	case '\r':
+		return readProxyV2(r)
+	default:
+		return nil, nil, fmt.Errorf("missing PROXY protocol header")
+	}
+}
+
+// readProxyV1 reads a header like "PROXY TCP4 192.0.2.7 198.51.100.1 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	// The longest v1 header is 107 bytes.
+	line := make([]byte, 0, 107)
+	for len(line) < 107 {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+	if bytes.HasSuffix(line, []byte("\r\n")) == false {
+		return nil, nil, fmt.Errorf("invalid PROXY v1 header")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[0] == "PROXY" && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || fields[0] != "PROXY" || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid PROXY v1 header %q", line)
+	}
+	src, err := proxyAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := proxyAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+// proxyAddr parses an address and port from a v1 header.
+func proxyAddr(ip string, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("invalid PROXY address %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY port %q", port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+// readProxyV2 reads a binary v2 header.
+func readProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(hdr[:12], proxyV2Signature) == false || hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("invalid PROXY v2 header")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	// LOCAL connections (e.g. health checks) keep the real addresses.
+	if hdr[12]&0x0f == 0 {
+		return nil, nil, nil
+	}
+	var ipLen int
+	switch hdr[13] >> 4 {
+	case 1:
+		ipLen = net.IPv4len
+	case 2:
+		ipLen = net.IPv6len
+	default:
+		// AF_UNSPEC or AF_UNIX, there are no IP addresses
+		return nil, nil, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, fmt.Errorf("short PROXY v2 address block")
+	}
+	src := &net.TCPAddr{IP: net.IP(body[:ipLen]), Port: int(binary.BigEndian.Uint16(body[2*ipLen:]))}
+	dst := &net.TCPAddr{IP: net.IP(body[ipLen : 2*ipLen]), Port: int(binary.BigEndian.Uint16(body[2*ipLen+2:]))}
+	return src, dst, nil
+}
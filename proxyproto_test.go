@@ -0,0 +1,82 @@
+// proxyproto_test.go tests the PROXY protocol listener.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyRoundTrip sends header followed by "hello" through a PROXY
+// protocol listener returning the remote address and data seen.
+func proxyRoundTrip(t *testing.T, header []byte) (string, string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pln := NewProxyProtocolListener(ln)
+	defer pln.Close()
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(header)
+		conn.Write([]byte("hello"))
+	}()
+	conn, err := pln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data, err := io.ReadAll(bufio.NewReader(conn))
+	return conn.RemoteAddr().String(), string(data), err
+}
+
+func TestProxyProtocol(t *testing.T) {
+	remote, data, err := proxyRoundTrip(t, []byte("PROXY TCP4 192.0.2.7 198.51.100.1 56324 443\r\n"))
+	if err != nil || remote != "192.0.2.7:56324" || data != "hello" {
+		t.Errorf("v1, got %q, %q, %v", remote, data, err)
+	}
+	remote, _, _ = proxyRoundTrip(t, []byte("PROXY UNKNOWN\r\n"))
+	if strings.HasPrefix(remote, "127.0.0.1:") == false {
+		t.Errorf("v1 UNKNOWN, expected the real address, got %q", remote)
+	}
+
+	v2 := append([]byte{}, proxyV2Signature...)
+	v2 = append(v2, 0x21, 0x21, 0, 36+3)
+	v2 = append(v2, net.ParseIP("2001:db8::7").To16()...)
+	v2 = append(v2, net.ParseIP("2001:db8::1").To16()...)
+	v2 = binary.BigEndian.AppendUint16(v2, 40000)
+	v2 = binary.BigEndian.AppendUint16(v2, 443)
+	// A TLV the listener skips.
+	v2 = append(v2, 0x04, 0, 0)
+	remote, data, err = proxyRoundTrip(t, v2)
+	if err != nil || remote != "[2001:db8::7]:40000" || data != "hello" {
+		t.Errorf("v2, got %q, %q, %v", remote, data, err)
+	}
+
+	if _, _, err = proxyRoundTrip(t, []byte("GET / HTTP/1.1\r\n")); err == nil {
+		t.Errorf("expected an error without a PROXY header")
+	}
+}
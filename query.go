@@ -0,0 +1,100 @@
+// query.go normalizes request query strings, e.g. stripping tracking
+// parameters.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultStripQuery are common tracking parameters.
+var DefaultStripQuery = []string{"utm_*", "fbclid", "gclid", "msclkid", "mc_cid", "mc_eid"}
+
+// QueryRules normalize query strings before the request is logged
+// or handled, so tracking parameters don't create duplicate cache
+// entries or log noise.
+type QueryRules struct {
+	// Strip lists parameter names to remove, "*" matches any
+	// characters (e.g. "utm_*"). DefaultStripQuery if not set.
+	Strip []string `json:"strip,omitempty" toml:"strip,omitempty"`
+
+	// Sort orders the remaining parameters by name.
+	Sort bool `json:"sort,omitempty" toml:"sort,omitempty"`
+}
+
+// stripped reports if the parameter name is stripped.
+func (qr *QueryRules) stripped(name string) bool {
+	name = strings.ToLower(name)
+	patterns := qr.Strip
+	if patterns == nil {
+		patterns = DefaultStripQuery
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize returns rawQuery with the rules applied. Parameters
+// keep their original encoding, only their order changes.
+func (qr *QueryRules) Normalize(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	params := []string{}
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		name := param
+		if i := strings.Index(param, "="); i >= 0 {
+			name = param[:i]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if qr.stripped(name) {
+			continue
+		}
+		params = append(params, param)
+	}
+	if qr.Sort {
+		sort.SliceStable(params, func(i, j int) bool {
+			return strings.SplitN(params[i], "=", 2)[0] < strings.SplitN(params[j], "=", 2)[0]
+		})
+	}
+	return strings.Join(params, "&")
+}
+
+// Handler normalizes the request's query before calling next.
+func (qr *QueryRules) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rawQuery := qr.Normalize(r.URL.RawQuery); rawQuery != r.URL.RawQuery {
+			r = r.Clone(r.Context())
+			r.URL.RawQuery = rawQuery
+			r.RequestURI = r.URL.RequestURI()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
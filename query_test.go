@@ -0,0 +1,54 @@
+// query_test.go tests query string normalization.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryRules(t *testing.T) {
+	qr := &QueryRules{Sort: true}
+	expected := map[string]string{
+		"":                                    "",
+		"utm_source=x&utm_medium=y":           "",
+		"q=caltech&fbclid=abc&page=2":         "page=2&q=caltech",
+		"b=2&a=1&a=0&UTM_Campaign=z":          "a=1&a=0&b=2",
+		"q=a%26b&gclid=1":                     "q=a%26b",
+		"utm%5Fsource=x&title=Caltech+Thesis": "title=Caltech+Thesis",
+	}
+	for rawQuery, expect := range expected {
+		if got := qr.Normalize(rawQuery); got != expect {
+			t.Errorf("%q, expected %q, got %q", rawQuery, expect, got)
+		}
+	}
+	qr = &QueryRules{Strip: []string{}}
+	if got := qr.Normalize("b=1&utm_source=x"); got != "b=1&utm_source=x" {
+		t.Errorf("expected the query unchanged, got %q", got)
+	}
+
+	seen := ""
+	h := (&QueryRules{}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.URL.Query().Encode()
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?id=7&utm_source=news", nil))
+	if seen != "id=7" {
+		t.Errorf("expected id=7, got %q", seen)
+	}
+}
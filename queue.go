@@ -0,0 +1,123 @@
+// queue.go holds reverse_proxy requests in a bounded queue while an
+// upstream is busy rather than failing them at once.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultQueueWaitMS is how long a request waits for a busy
+// upstream when ProxyQueue.MaxWaitMS isn't set.
+const DefaultQueueWaitMS = 10000
+
+// ProxyQueue limits the requests a reverse_proxy route sends to its
+// upstream at once. Up to MaxQueue more wait as long as MaxWaitMS for
+// a turn, others are answered "503 Service Unavailable".
+type ProxyQueue struct {
+	MaxConcurrent int `json:"max_concurrent" toml:"max_concurrent"`
+	MaxQueue      int `json:"max_queue,omitempty" toml:"max_queue,omitempty"`
+	MaxWaitMS     int `json:"max_wait_ms,omitempty" toml:"max_wait_ms,omitempty"`
+
+	mu    sync.Mutex
+	once  sync.Once
+	slots chan struct{}
+	stats QueueStats
+}
+
+// QueueStats reports a ProxyQueue's current depth and how many
+// requests it turned away.
+type QueueStats struct {
+	InFlight int   `json:"in_flight"`
+	Queued   int   `json:"queued"`
+	Rejected int64 `json:"rejected"`
+	TimedOut int64 `json:"timed_out"`
+}
+
+// Stats returns a copy of the queue's stats.
+func (q *ProxyQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stats
+}
+
+// count applies fn to the stats while holding the lock.
+func (q *ProxyQueue) count(fn func(s *QueueStats)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fn(&q.stats)
+}
+
+// Handler queues requests for next. Handlers built again from the
+// same ProxyQueue, e.g. on reload, share its slots.
+func (q *ProxyQueue) Handler(next http.Handler) (http.Handler, error) {
+	if q.MaxConcurrent < 1 || q.MaxQueue < 0 || q.MaxWaitMS < 0 {
+		return nil, fmt.Errorf("max_concurrent must be positive, max_queue and max_wait_ms can't be negative")
+	}
+	q.once.Do(func() { q.slots = make(chan struct{}, q.MaxConcurrent) })
+	slots := q.slots
+	wait := time.Duration(q.MaxWaitMS) * time.Millisecond
+	if q.MaxWaitMS == 0 {
+		wait = DefaultQueueWaitMS * time.Millisecond
+	}
+	busy := func(w http.ResponseWriter, r *http.Request, reason string) {
+		w.Header().Set("Retry-After", "5")
+		httpError(w, r, http.StatusServiceUnavailable, fmt.Errorf("upstream busy, %s", reason))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			full := false
+			q.count(func(s *QueueStats) {
+				if full = s.Queued >= q.MaxQueue; full {
+					s.Rejected++
+				} else {
+					s.Queued++
+				}
+			})
+			if full {
+				busy(w, r, "queue full")
+				return
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case slots <- struct{}{}:
+				timer.Stop()
+				q.count(func(s *QueueStats) { s.Queued-- })
+			case <-timer.C:
+				q.count(func(s *QueueStats) { s.Queued--; s.TimedOut++ })
+				busy(w, r, "timed out waiting")
+				return
+			case <-r.Context().Done():
+				timer.Stop()
+				q.count(func(s *QueueStats) { s.Queued-- })
+				return
+			}
+		}
+		q.count(func(s *QueueStats) { s.InFlight++ })
+		defer func() {
+			q.count(func(s *QueueStats) { s.InFlight-- })
+			<-slots
+		}()
+		next.ServeHTTP(w, r)
+	}), nil
+}
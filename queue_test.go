@@ -0,0 +1,107 @@
+// queue_test.go tests queueing requests for busy upstreams.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProxyQueue(t *testing.T) {
+	release := make(chan bool)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/slow" {
+			<-release
+		}
+		w.Write([]byte("ok"))
+	})
+	q := &ProxyQueue{MaxConcurrent: 1, MaxQueue: 1, MaxWaitMS: 100}
+	h, err := q.Handler(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(p string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+		return rec
+	}
+	// waitFor polls the stats until ok reports true.
+	waitFor := func(ok func(s QueueStats) bool) {
+		for i := 0; i < 100 && ok(q.Stats()) == false; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	slow := make(chan int)
+	go func() { slow <- serve("/api/slow").Code }()
+	waitFor(func(s QueueStats) bool { return s.InFlight == 1 })
+	queued := make(chan int)
+	go func() { queued <- serve("/api/fast").Code }()
+	waitFor(func(s QueueStats) bool { return s.Queued == 1 })
+
+	if rec := serve("/api/fast"); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After when the queue is full, got %d", rec.Code)
+	}
+	if code := <-queued; code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 after waiting, got %d", code)
+	}
+	if s := q.Stats(); s.InFlight != 1 || s.Queued != 0 || s.Rejected != 1 || s.TimedOut != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	// A queued request is served once the upstream is free.
+	go func() { queued <- serve("/api/fast").Code }()
+	waitFor(func(s QueueStats) bool { return s.Queued == 1 })
+	release <- true
+	if code := <-slow; code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+	if code := <-queued; code != http.StatusOK {
+		t.Errorf("expected queued request to be served, got %d", code)
+	}
+	if s := q.Stats(); s.InFlight != 0 || s.Queued != 0 {
+		t.Errorf("expected an empty queue, got %+v", s)
+	}
+
+	// A rebuilt handler shares the slots still held by the old one.
+	go func() { slow <- serve("/api/slow").Code }()
+	waitFor(func(s QueueStats) bool { return s.InFlight == 1 })
+	rebuilt, err := q.Handler(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		rec := httptest.NewRecorder()
+		rebuilt.ServeHTTP(rec, httptest.NewRequest("GET", "/api/fast", nil))
+		queued <- rec.Code
+	}()
+	waitFor(func(s QueueStats) bool { return s.Queued == 1 })
+	if s := q.Stats(); s.InFlight != 1 || s.Queued != 1 {
+		t.Errorf("expected the rebuilt handler to wait its turn, got %+v", s)
+	}
+	release <- true
+	<-slow
+	if code := <-queued; code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+	if _, err := (&ProxyQueue{}).Handler(next); err == nil {
+		t.Errorf("expected an error without max_concurrent")
+	}
+}
@@ -0,0 +1,156 @@
+// ranges_test.go tests byte range requests.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rangeSite returns a WebService with the middleware that touches
+// file responses turned on: gzip_static, charsets, the asset cache,
+// a zip mount and a bandwidth limit.
+func rangeSite(t *testing.T, content []byte) *WebService {
+	docRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(docRoot, "scan.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	gz.Write(content)
+	gz.Close()
+	if err := os.WriteFile(filepath.Join(docRoot, "scan.txt.gz"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zipName := filepath.Join(t.TempDir(), "archive.zip")
+	fp, err := os.Create(zipName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(fp)
+	w, _ := zw.Create("scan.txt")
+	w.Write(content)
+	zw.Close()
+	fp.Close()
+	return &WebService{
+		DocRoot:    docRoot,
+		GzipStatic: true,
+		Charsets:   map[string]string{"text/*": "utf-8", "multipart/*": "utf-8"},
+		AssetCache: &AssetCache{Warm: []string{"/"}},
+		ZipMounts:  map[string]string{"/archive/": zipName},
+		Bandwidth:  []*Bandwidth{{Prefix: "/", PerRequest: 1 << 30}},
+	}
+}
+
+func TestByteRanges(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	h, err := rangeSite(t, content).Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(p string, rng string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", p, nil)
+		req.Header.Set("Range", rng)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	for _, p := range []string{"/scan.txt", "/archive/scan.txt"} {
+		// A single range.
+		rec := get(p, "bytes=10-19")
+		if rec.Code != http.StatusPartialContent {
+			t.Fatalf("%s, expected 206, got %d", p, rec.Code)
+		}
+		if rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s, expected ranges of the file itself, got Content-Encoding %q", p, rec.Header().Get("Content-Encoding"))
+		}
+		if got := rec.Header().Get("Content-Range"); got != fmt.Sprintf("bytes 10-19/%d", len(content)) {
+			t.Errorf("%s, unexpected Content-Range %q", p, got)
+		}
+		if got := rec.Body.String(); got != string(content[10:20]) {
+			t.Errorf("%s, expected %q, got %q", p, content[10:20], got)
+		}
+
+		// Several ranges are sent as multipart/byteranges.
+		rec = get(p, "bytes=0-4, 500-509, -5")
+		if rec.Code != http.StatusPartialContent {
+			t.Fatalf("%s, expected 206, got %d", p, rec.Code)
+		}
+		mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" || len(params) != 1 {
+			t.Fatalf("%s, expected multipart/byteranges, got %q", p, rec.Header().Get("Content-Type"))
+		}
+		mr := multipart.NewReader(rec.Body, params["boundary"])
+		expected := []struct {
+			contentRange string
+			body         []byte
+		}{
+			{"bytes 0-4/1000", content[0:5]},
+			{"bytes 500-509/1000", content[500:510]},
+			{"bytes 995-999/1000", content[995:]},
+		}
+		for i, part := range expected {
+			pr, err := mr.NextPart()
+			if err != nil {
+				t.Fatalf("%s, part %d, %s", p, i, err)
+			}
+			if got := pr.Header.Get("Content-Range"); got != part.contentRange {
+				t.Errorf("%s, part %d, expected %q, got %q", p, i, part.contentRange, got)
+			}
+			if strings.HasPrefix(pr.Header.Get("Content-Type"), "text/plain") == false {
+				t.Errorf("%s, part %d, expected text/plain, got %q", p, i, pr.Header.Get("Content-Type"))
+			}
+			if src, _ := io.ReadAll(pr); bytes.Equal(src, part.body) == false {
+				t.Errorf("%s, part %d, expected %q, got %q", p, i, part.body, src)
+			}
+		}
+		if _, err := mr.NextPart(); err != io.EOF {
+			t.Errorf("%s, expected 3 parts, %v", p, err)
+		}
+
+		// Ranges past the end can't be satisfied.
+		rec = get(p, "bytes=5000-")
+		if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("%s, expected 416, got %d", p, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Range"); got != "bytes */1000" {
+			t.Errorf("%s, expected Content-Range bytes */1000, got %q", p, got)
+		}
+	}
+
+	// Without a Range the gzip sibling is still used.
+	req := httptest.NewRequest("GET", "/scan.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected the gzip sibling without a Range, got %q", rec.Header().Get("Content-Encoding"))
+	}
+}
@@ -0,0 +1,108 @@
+// rehash.go changes the password hashing scheme, rehashing passwords
+// as users log in.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"log"
+)
+
+// SetEncryption changes the scheme used for new passwords (e.g.
+// "argon2id"). Existing passwords keep the scheme they were made
+// with and are rehashed with the new one the next time the user
+// logs in, rather than every login breaking.
+func (a *Access) SetEncryption(encryption string) error {
+	switch encryption {
+	case "argon2id", "pbkdf2", "md5", "sha512":
+	default:
+		return fmt.Errorf("%w, encryption %q", ErrUnsupportedScheme, encryption)
+	}
+	a.mu.RLock()
+	old := a.Encryption
+	a.mu.RUnlock()
+	if old == encryption {
+		return nil
+	}
+	usernames, err := a.store().List()
+	if err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		secret, err := a.store().Lookup(username)
+		if err != nil {
+			return err
+		}
+		if secret.Encryption != "" || old == "" {
+			continue
+		}
+		pinned := new(Secrets)
+		*pinned = *secret
+		pinned.Encryption = old
+		if err := a.store().Update(username, pinned); err != nil {
+			return err
+		}
+	}
+	a.mu.Lock()
+	a.Encryption = encryption
+	a.mu.Unlock()
+	return nil
+}
+
+// needsRehash reports if secret was made with a scheme other than
+// Encryption.
+func (a *Access) needsRehash(secret *Secrets) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return secret.Encryption != "" && secret.Encryption != a.Encryption
+}
+
+// PendingRehash returns the users whose passwords will be rehashed
+// at their next login.
+func (a *Access) PendingRehash() ([]string, error) {
+	usernames, err := a.store().List()
+	if err != nil {
+		return nil, err
+	}
+	pending := []string{}
+	for _, username := range usernames {
+		if secret, err := a.store().Lookup(username); err == nil && a.needsRehash(secret) {
+			pending = append(pending, username)
+		}
+	}
+	return pending, nil
+}
+
+// rehash stores password with the current scheme after a login,
+// replacing old, and saves it to the access file it was loaded from
+// (see saveRehash). Failures are logged, the login still succeeds.
+func (a *Access) rehash(username string, password string, old *Secrets) {
+	if err := a.UpdateUser(username, password); err != nil {
+		log.Printf("rehash %q, %s", username, err)
+		return
+	}
+	if a.source != "" {
+		if err := a.saveRehash(username, old); err != nil {
+			log.Printf("rehash %q, %s", username, err)
+			return
+		}
+	}
+	ev := NewAuditEvent(AuditRehash, username, nil)
+	ev.Detail = a.Encryption
+	a.audit(ev)
+}
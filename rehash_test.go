@@ -0,0 +1,77 @@
+// rehash_test.go tests changing the password hashing scheme.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetEncryption(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "access.toml")
+	a := &Access{AuthType: "basic", Encryption: "md5", SessionSeconds: -1}
+	for _, username := range []string{"Jane.Doe", "John.Doe"} {
+		if err := a.UpdateUser(username, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.DumpAccess(fName); err != nil {
+		t.Fatal(err)
+	}
+	a, err := LoadAccess(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetEncryption("rot13"); errors.Is(err, ErrUnsupportedScheme) == false {
+		t.Errorf("expected ErrUnsupportedScheme, got %v", err)
+	}
+	if err := a.SetEncryption("argon2id"); err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := a.PendingRehash()
+	if len(pending) != 2 {
+		t.Errorf("expected 2 users pending rehash, got %q", pending)
+	}
+	// Existing passwords still work, logging in rehashes them.
+	if err := a.VerifyLogin("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := a.Lookup("Jane.Doe")
+	if secret.Encryption != "" || secret.Argon2 == nil {
+		t.Errorf("expected Jane.Doe to be rehashed with argon2id, got %q", secret.Encryption)
+	}
+	if err := a.VerifyLogin("Jane.Doe", "secret"); err != nil {
+		t.Errorf("expected the rehashed password to work, %s", err)
+	}
+	// The rehash was saved to the access file.
+	saved, err := LoadAccess(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Encryption != "argon2id" {
+		t.Errorf("expected argon2id to be saved, got %q", saved.Encryption)
+	}
+	pending, _ = saved.PendingRehash()
+	if len(pending) != 1 || pending[0] != "John.Doe" {
+		t.Errorf("expected John.Doe pending rehash, got %q", pending)
+	}
+	if err := saved.VerifyLogin("John.Doe", "secret"); err != nil {
+		t.Errorf("expected John.Doe's md5 password to still work, %s", err)
+	}
+}
@@ -0,0 +1,118 @@
+// remoteuser.go trusts a username header (e.g. REMOTE_USER) set by an
+// upstream single sign on proxy such as Apache with a Shibboleth SP.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// DefaultRemoteUserHeader is used when Access.RemoteUserHeader is empty.
+const DefaultRemoteUserHeader = "Remote-User"
+
+// isRemoteUser reports if the Access uses the "remote_user" AuthType.
+func (a *Access) isRemoteUser() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return strings.ToLower(a.AuthType) == "remote_user"
+}
+
+// remoteUserHeader returns the header holding the username.
+func (a *Access) remoteUserHeader() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.RemoteUserHeader != "" {
+		return a.RemoteUserHeader
+	}
+	return DefaultRemoteUserHeader
+}
+
+// trustedRemoteUserPeer reports if the request came directly from one
+// of the RemoteUserProxies.
+func (a *Access) trustedRemoteUserPeer(r *http.Request) bool {
+	a.mu.RLock()
+	cidrs := a.RemoteUserProxies
+	a.mu.RUnlock()
+	tp, err := ParseTrustedProxies(cidrs)
+	if err != nil {
+		log.Printf("remote_user_proxies, %s", err)
+		return false
+	}
+	return tp.Contains(PeerIP(r))
+}
+
+// remoteUser returns the username set by a trusted proxy. When the
+// Map lists users only they are authorized.
+func (a *Access) remoteUser(r *http.Request) (string, error) {
+	header := a.remoteUserHeader()
+	username := strings.TrimSpace(r.Header.Get(header))
+	if username == "" {
+		return "", fmt.Errorf("missing %s header", header)
+	}
+	if a.trustedRemoteUserPeer(r) == false {
+		return "", fmt.Errorf("%s header from untrusted address %s", header, PeerIP(r))
+	}
+	a.mu.RLock()
+	restricted := len(a.Map) > 0 || a.Store != nil
+	a.mu.RUnlock()
+	if restricted {
+		secret, err := a.store().Lookup(username)
+		if err != nil {
+			return username, err
+		}
+		if err := checkAccount(username, secret); err != nil {
+			return username, err
+		}
+	}
+	return username, nil
+}
+
+// serveRemoteUser implements Access.Handler for the "remote_user"
+// AuthType. The header is removed from requests that didn't come
+// from a trusted proxy so later handlers can't be fooled by it.
+func (a *Access) serveRemoteUser(res http.ResponseWriter, req *http.Request, next http.Handler) {
+	header := a.remoteUserHeader()
+	if req.Header.Get(header) != "" && a.trustedRemoteUserPeer(req) == false {
+		req.Header.Del(header)
+	}
+	if a.isAccessRoute(req.URL.Path) && signedURL(req) {
+		traceNote(req, "auth", "signed URL")
+	} else if a.isAccessRoute(req.URL.Path) {
+		username, err := a.remoteUser(req)
+		if err != nil {
+			if username != "" {
+				a.logAuthFailure(req, username)
+				a.login(username, req, false)
+			}
+			traceNote(req, "auth", err.Error())
+			httpError(res, req, http.StatusForbidden, err)
+			return
+		}
+		if a.allowed(username, req.URL.Path) == false {
+			traceNote(req, "auth", fmt.Sprintf("%q not allowed", username))
+			httpError(res, req, http.StatusForbidden, nil)
+			return
+		}
+		traceNote(req, "auth", fmt.Sprintf("%q allowed", username))
+		req = a.withIdentity(req, username)
+	}
+	next.ServeHTTP(res, req)
+}
@@ -0,0 +1,87 @@
+// remoteuser_test.go tests the remote_user AuthType.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteUser(t *testing.T) {
+	a := &Access{
+		AuthType:          "remote_user",
+		RemoteUserHeader:  "X-Remote-User",
+		RemoteUserProxies: []string{"10.0.0.5"},
+		Routes:            []string{"/private/"},
+	}
+	var seen string
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = a.GetUsername(r)
+		if seen == "" {
+			seen = r.Header.Get("X-Remote-User")
+		}
+	}))
+	tests := []struct {
+		remote, user, path string
+		status             int
+		seen               string
+	}{
+		{"10.0.0.5:1234", "Jane.Doe", "/private/", http.StatusOK, "Jane.Doe"},
+		{"10.0.0.5:1234", "", "/private/", http.StatusForbidden, ""},
+		// A spoofed header from elsewhere is refused and stripped.
+		{"192.0.2.7:1234", "Jane.Doe", "/private/", http.StatusForbidden, ""},
+		{"192.0.2.7:1234", "Jane.Doe", "/public/", http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		seen = ""
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.RemoteAddr = test.remote
+		if test.user != "" {
+			req.Header.Set("X-Remote-User", test.user)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.status || seen != test.seen {
+			t.Errorf("%+v, got %d, %q", test, rec.Code, seen)
+		}
+	}
+
+	// Through trusted_proxies the peer is still the SSO proxy.
+	tp, _ := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	req := httptest.NewRequest("GET", "/private/", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.5")
+	req.Header.Set("X-Remote-User", "Jane.Doe")
+	rec := httptest.NewRecorder()
+	tp.Handler(h).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected %d via trusted proxy, got %d", http.StatusOK, rec.Code)
+	}
+
+	// Listing users restricts who is authorized.
+	a.Map = map[string]*Secrets{"John.Doe": {}}
+	req = httptest.NewRequest("GET", "/private/", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+	req.Header.Set("X-Remote-User", "Jane.Doe")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected %d for unlisted user, got %d", http.StatusForbidden, rec.Code)
+	}
+}
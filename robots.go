@@ -0,0 +1,118 @@
+// robots.go serves a generated robots.txt and X-Robots-Tag headers.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultRobotsTag is the X-Robots-Tag sent when Robots.Tag isn't set.
+const DefaultRobotsTag = "noindex, nofollow"
+
+// RobotsAgent are the rules for a crawler's user agent ("*" for all).
+type RobotsAgent struct {
+	UserAgent  string   `json:"user_agent" toml:"user_agent"`
+	Allow      []string `json:"allow,omitempty" toml:"allow,omitempty"`
+	Disallow   []string `json:"disallow,omitempty" toml:"disallow,omitempty"`
+	CrawlDelay int      `json:"crawl_delay,omitempty" toml:"crawl_delay,omitempty"`
+}
+
+// Robots generates /robots.txt, overriding any file in the document
+// root, and adds an X-Robots-Tag header to responses below Prefixes
+// (and the access routes if Protected is set).
+type Robots struct {
+	Agents    []*RobotsAgent `json:"agents,omitempty" toml:"agents,omitempty"`
+	Sitemaps  []string       `json:"sitemaps,omitempty" toml:"sitemaps,omitempty"`
+	Prefixes  []string       `json:"prefixes,omitempty" toml:"prefixes,omitempty"`
+	Protected bool           `json:"protected,omitempty" toml:"protected,omitempty"`
+	Tag       string         `json:"tag,omitempty" toml:"tag,omitempty"`
+}
+
+// String renders the robots.txt document.
+func (rb *Robots) String() string {
+	var sb strings.Builder
+	for i, agent := range rb.Agents {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		userAgent := agent.UserAgent
+		if userAgent == "" {
+			userAgent = "*"
+		}
+		fmt.Fprintf(&sb, "User-agent: %s\n", userAgent)
+		for _, p := range agent.Allow {
+			fmt.Fprintf(&sb, "Allow: %s\n", p)
+		}
+		for _, p := range agent.Disallow {
+			fmt.Fprintf(&sb, "Disallow: %s\n", p)
+		}
+		if len(agent.Allow) == 0 && len(agent.Disallow) == 0 {
+			// An empty Disallow allows everything.
+			sb.WriteString("Disallow:\n")
+		}
+		if agent.CrawlDelay > 0 {
+			fmt.Fprintf(&sb, "Crawl-delay: %d\n", agent.CrawlDelay)
+		}
+	}
+	if len(rb.Sitemaps) > 0 && len(rb.Agents) > 0 {
+		sb.WriteString("\n")
+	}
+	for _, sitemap := range rb.Sitemaps {
+		fmt.Fprintf(&sb, "Sitemap: %s\n", sitemap)
+	}
+	return sb.String()
+}
+
+// tagged reports if the response for p gets an X-Robots-Tag.
+func (rb *Robots) tagged(p string, access *Access) bool {
+	if hasPathPrefix(p, rb.Prefixes) {
+		return true
+	}
+	return rb.Protected && access != nil && access.isAccessRoute(p)
+}
+
+// Handler answers /robots.txt and adds X-Robots-Tag before calling
+// next. access is used when Protected is set and may be nil.
+func (rb *Robots) Handler(next http.Handler, access *Access) http.Handler {
+	tag := rb.Tag
+	if tag == "" {
+		tag = DefaultRobotsTag
+	}
+	src := []byte(rb.String())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				w.Header().Set("Allow", "GET, HEAD")
+				httpError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(src)))
+			if r.Method == http.MethodGet {
+				w.Write(src)
+			}
+			return
+		}
+		if rb.tagged(r.URL.Path, access) {
+			w.Header().Set("X-Robots-Tag", tag)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
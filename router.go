@@ -0,0 +1,199 @@
+// router.go provides a small method aware router with path parameters
+// for building JSON services on top of wsfn's middleware.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Middleware wraps an http.Handler returning a new http.Handler,
+// e.g. RequestLogger or (*CORSPolicy).Handler.
+type Middleware func(http.Handler) http.Handler
+
+// paramsKey is the context key holding path parameters.
+type paramsKey struct{}
+
+// route is a single method and pattern registered with a Router.
+type route struct {
+	method   string
+	segments []string
+	handler  http.Handler
+}
+
+// match compares a request path against the route's segments
+// returning any path parameters and true on a match. A final
+// segment of the form "{name...}" matches the rest of the path.
+func (rt *route) match(p string) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	params := map[string]string{}
+	for i, seg := range rt.segments {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "...}") {
+			if i >= len(parts) {
+				return nil, false
+			}
+			params[seg[1:len(seg)-4]] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[seg[1:len(seg)-1]] = parts[i]
+			continue
+		}
+		if seg != parts[i] {
+			return nil, false
+		}
+	}
+	if len(parts) != len(rt.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// Router dispatches requests by method and path pattern. Patterns
+// are slash separated segments where "{name}" captures a single
+// segment and a trailing "{name...}" captures the remainder, e.g.
+//
+//	rt := wsfn.NewRouter()
+//	rt.Get("/api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+//	    id := wsfn.PathParam(r, "id")
+//	    ...
+//	})
+//	http.ListenAndServe(":8000", wsfn.RequestLogger(rt))
+//
+// Routes are matched in the order they are added. A path that matches
+// but with the wrong method is answered with 405 and an Allow header.
+// HEAD requests are served by GET routes.
+type Router struct {
+	// NotFound is used when no route matches, defaults to a 404.
+	NotFound http.Handler
+
+	routes     []*route
+	middleware []Middleware
+}
+
+// NewRouter returns an empty *Router.
+func NewRouter() *Router {
+	return new(Router)
+}
+
+// Use adds middleware applied to every route's handler. Middleware
+// must be added before routes are registered.
+func (rt *Router) Use(mw ...Middleware) {
+	rt.middleware = append(rt.middleware, mw...)
+}
+
+// Handle registers handler for method and pattern.
+func (rt *Router) Handle(method string, pattern string, handler http.Handler) {
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		handler = rt.middleware[i](handler)
+	}
+	rt.routes = append(rt.routes, &route{
+		method:   strings.ToUpper(method),
+		segments: strings.Split(strings.Trim(pattern, "/"), "/"),
+		handler:  handler,
+	})
+}
+
+// HandleFunc registers a handler func for method and pattern.
+func (rt *Router) HandleFunc(method string, pattern string, fn http.HandlerFunc) {
+	rt.Handle(method, pattern, fn)
+}
+
+// Get registers fn for GET (and HEAD) requests matching pattern.
+func (rt *Router) Get(pattern string, fn http.HandlerFunc) {
+	rt.Handle(http.MethodGet, pattern, fn)
+}
+
+// Post registers fn for POST requests matching pattern.
+func (rt *Router) Post(pattern string, fn http.HandlerFunc) {
+	rt.Handle(http.MethodPost, pattern, fn)
+}
+
+// Put registers fn for PUT requests matching pattern.
+func (rt *Router) Put(pattern string, fn http.HandlerFunc) {
+	rt.Handle(http.MethodPut, pattern, fn)
+}
+
+// Patch registers fn for PATCH requests matching pattern.
+func (rt *Router) Patch(pattern string, fn http.HandlerFunc) {
+	rt.Handle(http.MethodPatch, pattern, fn)
+}
+
+// Delete registers fn for DELETE requests matching pattern.
+func (rt *Router) Delete(pattern string, fn http.HandlerFunc) {
+	rt.Handle(http.MethodDelete, pattern, fn)
+}
+
+// ServeHTTP dispatches the request to the first matching route.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	allowed := map[string]bool{}
+	for _, route := range rt.routes {
+		params, ok := route.match(r.URL.Path)
+		if ok == false {
+			continue
+		}
+		if route.method == r.Method || (r.Method == http.MethodHead && route.method == http.MethodGet) {
+			ctx := context.WithValue(r.Context(), paramsKey{}, params)
+			route.handler.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		allowed[route.method] = true
+		if route.method == http.MethodGet {
+			allowed[http.MethodHead] = true
+		}
+	}
+	if len(allowed) > 0 {
+		methods := []string{}
+		for method := range allowed {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		httpError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed for %s", r.Method, r.URL.Path))
+		return
+	}
+	if rt.NotFound != nil {
+		rt.NotFound.ServeHTTP(w, r)
+		return
+	}
+	httpError(w, r, http.StatusNotFound, nil)
+}
+
+// PathParam returns the named path parameter captured by a Router,
+// an empty string if it isn't set.
+func PathParam(r *http.Request, name string) string {
+	return PathParams(r)[name]
+}
+
+// PathParams returns all path parameters captured by a Router.
+func PathParams(r *http.Request) map[string]string {
+	if params, ok := r.Context().Value(paramsKey{}).(map[string]string); ok {
+		return params
+	}
+	return map[string]string{}
+}
@@ -0,0 +1,147 @@
+// routes.go dispatches requests using the ordered [[route]] list of a
+// WebService configuration.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Route types.
+const (
+	RouteRedirect = "redirect"
+	RouteProxy    = "proxy"
+	RouteStatic   = "static"
+	RouteGone     = "gone"
+)
+
+// RouteRule is an entry in the WebService's ordered route list. The
+// first rule whose Prefix and Match fit the request handles it,
+// requests no rule fits are served as before (reverse_proxy routes,
+// then static files). Access checks happen before routing on the
+// cleaned path (see CleanPathHandler), rules can't bypass protected
+// routes.
+type RouteRule struct {
+	// Type is "redirect", "proxy", "static" or "gone".
+	Type string `json:"type" toml:"type"`
+	// Prefix is the URL path prefix handled, e.g. "/api/".
+	Prefix string `json:"prefix" toml:"prefix"`
+	// Match narrows the requests handled, e.g. by method or host.
+	Match *Matcher `json:"match,omitempty" toml:"match,omitempty"`
+	// To is the destination prefix (a path or URL) of a redirect or
+	// the upstream URL of a proxy.
+	To string `json:"to,omitempty" toml:"to,omitempty"`
+	// Status of a redirect, 301 (Moved Permanently) if not set.
+	Status int `json:"status,omitempty" toml:"status,omitempty"`
+	// Root is the directory a static route serves below Prefix, the
+	// document root (with Prefix kept in the path) if not set.
+	Root string `json:"root,omitempty" toml:"root,omitempty"`
+
+	handler http.Handler
+}
+
+// redirectHandler sends requests below prefix to the same path
+// below to, keeping the query string.
+func redirectHandler(prefix string, to string, status int) (http.Handler, error) {
+	dest, err := url.Parse(to)
+	if err != nil || (dest.Scheme == "" && strings.HasPrefix(dest.Path, "/") == false) {
+		return nil, fmt.Errorf("redirect destination %q must be a path or URL", to)
+	}
+	switch status {
+	case 0:
+		status = http.StatusMovedPermanently
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("redirect status %d is not a redirect", status)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := *dest
+		u.Path = path.Join(dest.Path, strings.TrimPrefix(r.URL.Path, prefix))
+		if strings.HasSuffix(r.URL.Path, "/") && strings.HasSuffix(u.Path, "/") == false {
+			u.Path += "/"
+		}
+		u.RawQuery = r.URL.RawQuery
+		http.Redirect(w, r, u.String(), status)
+	}), nil
+}
+
+// routeHandler builds the handler of a rule.
+func (w *WebService) routeHandler(rule *RouteRule, fs http.FileSystem) (http.Handler, error) {
+	if err := rule.Match.Validate(); err != nil {
+		return nil, err
+	}
+	switch rule.Type {
+	case RouteRedirect:
+		return redirectHandler(rule.Prefix, rule.To, rule.Status)
+	case RouteProxy:
+		h, err := proxyHandler(rule.To, &w.upstreams, w.ProxyErrors)
+		if err != nil {
+			return nil, err
+		}
+		// Only identity_headers routes are trusted with the user.
+		return identityHandler(h, false), nil
+	case RouteStatic:
+		if rule.Root == "" {
+			return w.staticHandler(fs)
+		}
+		sfs, err := MakeSafeFileSystem(rule.Root)
+		if err != nil {
+			return nil, err
+		}
+		h, err := w.staticHandler(sfs)
+		if err != nil {
+			return nil, err
+		}
+		return http.StripPrefix(strings.TrimSuffix(rule.Prefix, "/"), h), nil
+	case RouteGone:
+		return GoneHandler([]string{rule.Prefix}, fs, w.GonePage, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", rule.Type)
+	}
+}
+
+// RoutesHandler dispatches requests to the first fitting rule of
+// w.Route, passing the rest to next.
+func (w *WebService) RoutesHandler(fs http.FileSystem, next http.Handler) (http.Handler, error) {
+	rules := []*RouteRule{}
+	for i, rule := range w.Route {
+		if strings.HasPrefix(rule.Prefix, "/") == false {
+			return nil, fmt.Errorf("route %d, prefix %q must start with /", i+1, rule.Prefix)
+		}
+		h, err := w.routeHandler(rule, fs)
+		if err != nil {
+			return nil, fmt.Errorf("route %d (%s %s), %s", i+1, rule.Type, rule.Prefix, err)
+		}
+		rules = append(rules, &RouteRule{Type: rule.Type, Prefix: rule.Prefix, Match: rule.Match, handler: h})
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		for _, rule := range rules {
+			if strings.HasPrefix(req.URL.Path, rule.Prefix) && rule.Match.Match(req) {
+				traceNote(req, "route", rule.Type+" "+rule.Prefix)
+				rule.handler.ServeHTTP(res, req)
+				return
+			}
+		}
+		next.ServeHTTP(res, req)
+	}), nil
+}
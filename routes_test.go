@@ -0,0 +1,132 @@
+// routes_test.go tests dispatching requests with the ordered route list.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRoutesHandler(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "upstream %s", r.URL.Path)
+	}))
+	defer upstream.Close()
+	docRoot, docs := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(docRoot, "api"), 0755)
+	os.WriteFile(filepath.Join(docRoot, "api", "index.html"), []byte("site"), 0644)
+	os.WriteFile(filepath.Join(docs, "guide.txt"), []byte("guide"), 0644)
+	ws := &WebService{
+		DocRoot: docRoot,
+		Route: []*RouteRule{
+			{Type: RouteRedirect, Prefix: "/old/", To: "/new/", Status: http.StatusFound},
+			{Type: RouteStatic, Prefix: "/api/docs/", Root: docs},
+			{Type: RouteProxy, Prefix: "/api/", To: upstream.URL, Match: &Matcher{Method: []string{"POST"}}},
+			{Type: RouteGone, Prefix: "/retired/"},
+		},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method   string
+		path     string
+		status   int
+		body     string
+		location string
+	}{
+		{"GET", "/old/a/b.html?x=1", http.StatusFound, "", "/new/a/b.html?x=1"},
+		{"GET", "/api/docs/guide.txt", http.StatusOK, "guide", ""},
+		{"POST", "/api/docs/guide.txt", http.StatusMethodNotAllowed, "", ""},
+		{"POST", "/api/items", http.StatusOK, "upstream /api/items", ""},
+		{"GET", "/api/", http.StatusOK, "site", ""},
+		{"GET", "/retired/page.html", http.StatusGone, "", ""},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s %s, expected %d, got %d", test.method, test.path, test.status, rec.Code)
+		}
+		if src, _ := io.ReadAll(rec.Body); test.body != "" && string(src) != test.body {
+			t.Errorf("%s %s, expected %q, got %q", test.method, test.path, test.body, src)
+		}
+		if got := rec.Header().Get("Location"); got != test.location {
+			t.Errorf("%s %s, expected Location %q, got %q", test.method, test.path, test.location, got)
+		}
+	}
+
+	for _, rule := range []*RouteRule{
+		{Type: "rewrite", Prefix: "/a/"},
+		{Type: RouteRedirect, Prefix: "a/", To: "/b/"},
+		{Type: RouteRedirect, Prefix: "/a/", To: "b/"},
+		{Type: RouteRedirect, Prefix: "/a/", To: "/b/", Status: 200},
+		{Type: RouteProxy, Prefix: "/a/", To: "ftp://example.edu"},
+	} {
+		ws.Route = []*RouteRule{rule}
+		if _, err := ws.Handler(); err == nil {
+			t.Errorf("expected an error for %+v", rule)
+		}
+	}
+}
+
+func TestRoutesCleanPath(t *testing.T) {
+	docRoot := t.TempDir()
+	os.MkdirAll(filepath.Join(docRoot, "exhibits"), 0755)
+	os.MkdirAll(filepath.Join(docRoot, "private"), 0755)
+	os.WriteFile(filepath.Join(docRoot, "private", "secret.txt"), []byte("secret"), 0644)
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/private/"}}
+	a.UpdateAccess("Jane.Doe", "secret")
+	ws := &WebService{
+		DocRoot: docRoot,
+		Access:  a,
+		Route:   []*RouteRule{{Type: RouteStatic, Prefix: "/exhibits/"}},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		method   string
+		path     string
+		status   int
+		location string
+	}{
+		{"GET", "/exhibits/../private/secret.txt", http.StatusMovedPermanently, "/private/secret.txt"},
+		{"GET", "/exhibits/./../private/secret.txt?x=1", http.StatusMovedPermanently, "/private/secret.txt?x=1"},
+		{"GET", "//private/secret.txt", http.StatusMovedPermanently, "/private/secret.txt"},
+		{"POST", "/exhibits/../private/secret.txt", http.StatusBadRequest, ""},
+		{"GET", "/private/secret.txt", http.StatusUnauthorized, ""},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+		if rec.Code != test.status || rec.Header().Get("Location") != test.location {
+			t.Errorf("%s %s, expected %d %q, got %d %q", test.method, test.path, test.status, test.location, rec.Code, rec.Header().Get("Location"))
+		}
+		if strings.TrimSpace(rec.Body.String()) == "secret" {
+			t.Errorf("%s %s, leaked %q", test.method, test.path, rec.Body)
+		}
+	}
+}
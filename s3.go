@@ -0,0 +1,500 @@
+// s3.go provides an http.FileSystem backed by an S3 compatible bucket so
+// static sites published to object storage can be served with wsfn's
+// auth, redirect and dot path protections.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultS3CacheSeconds is how long objects are cached when
+	// CacheSeconds isn't set.
+	DefaultS3CacheSeconds = 60
+	// DefaultS3CacheMaxBytes is the size of the object cache when
+	// CacheMaxBytes isn't set.
+	DefaultS3CacheMaxBytes = 64 << 20
+)
+
+// S3FileSystem serves objects from an S3 compatible bucket. Credentials
+// default to the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment
+// variables, without them requests are sent unsigned (public buckets).
+type S3FileSystem struct {
+	// Endpoint is the service URL, e.g. "https://s3.us-west-2.amazonaws.com"
+	Endpoint string `json:"endpoint" toml:"endpoint"`
+	// Region used when signing requests, e.g. "us-west-2"
+	Region string `json:"region,omitempty" toml:"region,omitempty"`
+	// Bucket holding the site.
+	Bucket string `json:"bucket" toml:"bucket"`
+	// Prefix is prepended to object keys, e.g. "sites/library/"
+	Prefix string `json:"prefix,omitempty" toml:"prefix,omitempty"`
+	// VirtualHost addresses the bucket as a subdomain of the endpoint
+	// instead of as the first path element.
+	VirtualHost bool `json:"virtual_host,omitempty" toml:"virtual_host,omitempty"`
+	// CacheSeconds is how long a fetched object is reused.
+	CacheSeconds int `json:"cache_seconds,omitempty" toml:"cache_seconds,omitempty"`
+	// CacheMaxBytes limits the memory used for cached objects,
+	// larger objects are streamed.
+	CacheMaxBytes int64 `json:"cache_max_bytes,omitempty" toml:"cache_max_bytes,omitempty"`
+
+	// AccessKeyID and SecretAccessKey override the environment.
+	AccessKeyID     string `json:"-" toml:"-"`
+	SecretAccessKey string `json:"-" toml:"-"`
+	// Client is used for requests, http.DefaultClient if nil.
+	Client *http.Client `json:"-" toml:"-"`
+
+	mu         sync.Mutex
+	cache      map[string]*s3Object
+	cacheBytes int64
+}
+
+// s3Object is a cached object or directory listing.
+type s3Object struct {
+	name    string
+	data    []byte
+	size    int64
+	modTime time.Time
+	isDir   bool
+	entries []fs.FileInfo
+	expires time.Time
+}
+
+// Name, Size, Mode, ModTime, IsDir and Sys implement fs.FileInfo.
+func (o *s3Object) Name() string       { return o.name }
+func (o *s3Object) Size() int64        { return o.size }
+func (o *s3Object) ModTime() time.Time { return o.modTime }
+func (o *s3Object) IsDir() bool        { return o.isDir }
+func (o *s3Object) Sys() interface{}   { return nil }
+func (o *s3Object) Mode() fs.FileMode {
+	if o.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// s3File is an http.File reading from a fetched object.
+type s3File struct {
+	*bytes.Reader
+	obj *s3Object
+	pos int
+}
+
+// Close is a no-op, the data is held in memory.
+func (f *s3File) Close() error { return nil }
+
+// Stat returns the object's info.
+func (f *s3File) Stat() (fs.FileInfo, error) { return f.obj, nil }
+
+// Readdir lists a directory object.
+func (f *s3File) Readdir(count int) ([]fs.FileInfo, error) {
+	if f.obj.isDir == false {
+		return nil, fmt.Errorf("%s is not a directory", f.obj.name)
+	}
+	entries := f.obj.entries[f.pos:]
+	if count > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		if count < len(entries) {
+			entries = entries[0:count]
+		}
+	}
+	f.pos += len(entries)
+	return entries, nil
+}
+
+// s3Stream is an http.File reading an object too large to cache
+// from the response, seeking with ranged GETs.
+type s3Stream struct {
+	s    *S3FileSystem
+	key  string
+	etag string
+	obj  *s3Object
+
+	// body is read from bodyPos, pos is where Read continues.
+	body    io.ReadCloser
+	bodyPos int64
+	pos     int64
+}
+
+// Read reads from the current response, requesting the object
+// from pos when the stream has been seeked.
+func (f *s3Stream) Read(p []byte) (int, error) {
+	if f.pos >= f.obj.size {
+		return 0, io.EOF
+	}
+	if f.body == nil || f.bodyPos != f.pos {
+		if f.body != nil {
+			f.body.Close()
+			f.body = nil
+		}
+		header := http.Header{}
+		header.Set("Range", fmt.Sprintf("bytes=%d-", f.pos))
+		if f.etag != "" {
+			// Don't mix the parts of a replaced object.
+			header.Set("If-Match", f.etag)
+		}
+		res, err := f.s.get(f.key, nil, header)
+		if err != nil {
+			return 0, err
+		}
+		if res.StatusCode != http.StatusPartialContent {
+			res.Body.Close()
+			return 0, fmt.Errorf("%s, %s", f.obj.name, res.Status)
+		}
+		f.body, f.bodyPos = res.Body, f.pos
+	}
+	n, err := f.body.Read(p)
+	f.pos += int64(n)
+	f.bodyPos += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker, the next Read requests the range.
+func (f *s3Stream) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.obj.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("%s, negative position", f.obj.name)
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Close closes the current response.
+func (f *s3Stream) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+// Stat returns the object's info.
+func (f *s3Stream) Stat() (fs.FileInfo, error) { return f.obj, nil }
+
+// Readdir fails, objects aren't directories.
+func (f *s3Stream) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, fmt.Errorf("%s is not a directory", f.obj.name)
+}
+
+// credentials returns the access key pair.
+func (s *S3FileSystem) credentials() (string, string) {
+	if s.AccessKeyID != "" {
+		return s.AccessKeyID, s.SecretAccessKey
+	}
+	return os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+}
+
+// s3Escape encodes a key as required for a canonical URI, every
+// byte other than the unreserved characters and "/" is escaped.
+func s3Escape(p string) string {
+	buf := new(strings.Builder)
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+// objectURL builds the request URL for a key and query.
+func (s *S3FileSystem) objectURL(key string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	p := "/" + key
+	if s.VirtualHost {
+		u.Host = s.Bucket + "." + u.Host
+	} else {
+		p = "/" + s.Bucket + p
+	}
+	u.Path = p
+	u.RawPath = s3Escape(p)
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	return u, nil
+}
+
+// hmacSHA256 is used to derive the signing key.
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, s)
+	return h.Sum(nil)
+}
+
+// sign adds AWS Signature Version 4 headers to a GET request.
+func (s *S3FileSystem) sign(req *http.Request, now time.Time) {
+	accessKey, secretKey := s.credentials()
+	if accessKey == "" || secretKey == "" {
+		return
+	}
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[0:8]
+	emptyHash := sha256.Sum256([]byte{})
+	payloadHash := hex.EncodeToString(emptyHash[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+// get sends a signed GET for key and query with header added.
+func (s *S3FileSystem) get(key string, query url.Values, header http.Header) (*http.Response, error) {
+	u, err := s.objectURL(key, query)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, time.Now())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// s3ListResult is the part of a ListObjectsV2 response we use.
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns the directory entries below prefix.
+func (s *S3FileSystem) list(prefix string) ([]fs.FileInfo, error) {
+	entries := []fs.FileInfo{}
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("delimiter", "/")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		res, err := s.get("", q, nil)
+		if err != nil {
+			return nil, err
+		}
+		result := new(s3ListResult)
+		err = xml.NewDecoder(res.Body).Decode(result)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("list %s, %s", prefix, res.Status)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			name := strings.TrimPrefix(c.Key, prefix)
+			if name == "" {
+				continue
+			}
+			entries = append(entries, &s3Object{name: name, size: c.Size, modTime: c.LastModified})
+		}
+		for _, p := range result.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/")
+			entries = append(entries, &s3Object{name: name, isDir: true})
+		}
+		if result.IsTruncated == false || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// cached returns an unexpired cache entry.
+func (s *S3FileSystem) cached(name string) *s3Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if obj, ok := s.cache[name]; ok {
+		if time.Now().Before(obj.expires) {
+			return obj
+		}
+		s.cacheBytes -= int64(len(obj.data))
+		delete(s.cache, name)
+	}
+	return nil
+}
+
+// cacheMaxBytes returns the size of the object cache.
+func (s *S3FileSystem) cacheMaxBytes() int64 {
+	if s.CacheMaxBytes == 0 {
+		return DefaultS3CacheMaxBytes
+	}
+	return s.CacheMaxBytes
+}
+
+// remember adds an object to the cache when there is room.
+func (s *S3FileSystem) remember(name string, obj *s3Object) {
+	ttl := s.CacheSeconds
+	if ttl == 0 {
+		ttl = DefaultS3CacheSeconds
+	}
+	if ttl < 0 {
+		return
+	}
+	limit := s.cacheMaxBytes()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cache == nil {
+		s.cache = map[string]*s3Object{}
+	}
+	size := int64(len(obj.data))
+	if size > limit {
+		return
+	}
+	// Drop expired entries, then the oldest until there is room.
+	now := time.Now()
+	for key, o := range s.cache {
+		if now.After(o.expires) {
+			s.cacheBytes -= int64(len(o.data))
+			delete(s.cache, key)
+		}
+	}
+	for s.cacheBytes+size > limit && len(s.cache) > 0 {
+		oldest := ""
+		for key, o := range s.cache {
+			if oldest == "" || o.expires.Before(s.cache[oldest].expires) {
+				oldest = key
+			}
+		}
+		s.cacheBytes -= int64(len(s.cache[oldest].data))
+		delete(s.cache, oldest)
+	}
+	obj.expires = now.Add(time.Duration(ttl) * time.Second)
+	s.cache[name] = obj
+	s.cacheBytes += size
+}
+
+// Open implements http.FileSystem. Keys ending in "/" (and the root)
+// are treated as directories and listed. Objects larger than the
+// cache are streamed rather than read into memory.
+func (s *S3FileSystem) Open(name string) (http.File, error) {
+	name = path.Clean("/" + name)
+	if obj := s.cached(name); obj != nil {
+		return &s3File{Reader: bytes.NewReader(obj.data), obj: obj}, nil
+	}
+	key := strings.TrimPrefix(path.Join(s.Prefix, name), "/")
+	if name != "/" {
+		res, err := s.get(key, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		switch res.StatusCode {
+		case http.StatusOK:
+			obj := &s3Object{name: path.Base(name), size: res.ContentLength}
+			obj.modTime, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+			if res.ContentLength > s.cacheMaxBytes() {
+				return &s3Stream{s: s, key: key, etag: res.Header.Get("ETag"), obj: obj, body: res.Body}, nil
+			}
+			defer res.Body.Close()
+			// Without a Content-Length read no more than would be cached.
+			data, err := io.ReadAll(io.LimitReader(res.Body, s.cacheMaxBytes()+1))
+			if err != nil {
+				return nil, err
+			}
+			if int64(len(data)) > s.cacheMaxBytes() {
+				return nil, fmt.Errorf("%s, too large to serve without a Content-Length", name)
+			}
+			obj.data, obj.size = data, int64(len(data))
+			s.remember(name, obj)
+			return &s3File{Reader: bytes.NewReader(data), obj: obj}, nil
+		case http.StatusNotFound, http.StatusForbidden:
+			// Might be a directory, checked below.
+			res.Body.Close()
+		default:
+			res.Body.Close()
+			return nil, fmt.Errorf("%s, %s", name, res.Status)
+		}
+	}
+	dirPrefix := ""
+	if key != "" {
+		dirPrefix = key + "/"
+	}
+	entries, err := s.list(dirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if name != "/" && len(entries) == 0 {
+		return nil, os.ErrNotExist
+	}
+	obj := &s3Object{name: path.Base(name), isDir: true, entries: entries}
+	s.remember(name, obj)
+	return &s3File{Reader: bytes.NewReader(nil), obj: obj}, nil
+}
@@ -0,0 +1,126 @@
+// s3_test.go test routines for s3.go
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestS3FileSystem(t *testing.T) {
+	objects := map[string]string{
+		"/site/site/index.html":  "<h1>Hello</h1>",
+		"/site/site/.git/config": "secret",
+	}
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") == false {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Get("list-type") == "2" {
+			io.WriteString(w, `<ListBucketResult><Contents><Key>site/index.html</Key><Size>14</Size></Contents></ListBucketResult>`)
+			return
+		}
+		if src, ok := objects[r.URL.Path]; ok {
+			io.WriteString(w, src)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	s3 := &S3FileSystem{Endpoint: ts.URL, Bucket: "site", Prefix: "site/", AccessKeyID: "AKID", SecretAccessKey: "secret"}
+	h := http.FileServer(SafeFileSystem{s3})
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/index.html", nil))
+		if rec.Code != http.StatusMovedPermanently {
+			// FileServer redirects /index.html to /
+			t.Errorf("expected 301, got %d", rec.Code)
+		}
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "<h1>Hello</h1>" {
+			t.Errorf("expected index.html, got %d %q", rec.Code, rec.Body.String())
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/.git/config", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a dot path, got %d", rec.Code)
+	}
+	if hits > 3 {
+		t.Errorf("expected cached objects to be reused, %d requests made", hits)
+	}
+}
+
+func TestS3Stream(t *testing.T) {
+	large := strings.Repeat("0123456789", 10)
+	ranges := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/site/large.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			ranges++
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "large.txt", time.Time{}, strings.NewReader(large))
+	}))
+	defer ts.Close()
+
+	s3 := &S3FileSystem{Endpoint: ts.URL, Bucket: "site", CacheMaxBytes: 16}
+	h := http.FileServer(s3)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/large.txt", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != large {
+		t.Errorf("expected the whole object, got %d %q", rec.Code, rec.Body)
+	}
+	req := httptest.NewRequest("GET", "/large.txt", nil)
+	req.Header.Set("Range", "bytes=25-34")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != large[25:35] {
+		t.Errorf("expected bytes 25-34, got %d %q", rec.Code, rec.Body)
+	}
+	if ranges != 1 {
+		t.Errorf("expected one ranged GET, got %d", ranges)
+	}
+	if s3.cacheBytes != 0 {
+		t.Errorf("expected large objects not to be cached, %d bytes cached", s3.cacheBytes)
+	}
+
+	// A replaced object isn't mixed with the old one.
+	f, err := s3.Open("/large.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.(*s3Stream).etag = `"v0"`
+	f.Seek(50, io.SeekStart)
+	if _, err := io.ReadAll(f); err == nil {
+		t.Errorf("expected an error reading a changed object")
+	}
+}
@@ -0,0 +1,102 @@
+// safepath.go provides SafePath for checking request paths before
+// they are mapped onto a file system.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrUnsafePath is returned by SafePath.
+var ErrUnsafePath = errors.New("unsafe path")
+
+// encodedUnsafe are percent encodings that should have been decoded
+// already, seeing them means the request was encoded twice.
+var encodedUnsafe = []string{"%2e", "%2f", "%5c", "%00"}
+
+// SafePath checks a request path and returns it cleaned and rooted
+// (e.g. "/docs/index.html"). It returns an error wrapping ErrUnsafePath
+// if p contains a NUL byte, a ".." segment, a dot file or directory
+// (e.g. ".git"), a percent encoded dot, slash, backslash or NUL left
+// over from double encoding, or, on Windows, a backslash.
+func SafePath(p string) (string, error) {
+	if strings.Contains(p, "\x00") {
+		return "", fmt.Errorf("%w, contains NUL", ErrUnsafePath)
+	}
+	if os.PathSeparator == '\\' && strings.Contains(p, "\\") {
+		return "", fmt.Errorf("%w, contains a backslash", ErrUnsafePath)
+	}
+	lower := strings.ToLower(p)
+	for _, enc := range encodedUnsafe {
+		if strings.Contains(lower, enc) {
+			return "", fmt.Errorf("%w, contains %q", ErrUnsafePath, enc)
+		}
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w, contains \"..\"", ErrUnsafePath)
+		}
+		if part != "." && strings.HasPrefix(part, ".") {
+			return "", fmt.Errorf("%w, contains dot name %q", ErrUnsafePath, part)
+		}
+	}
+	return path.Clean("/" + p), nil
+}
+
+// CleanPathHandler redirects requests whose path isn't clean (e.g.
+// "/public/../private/x" or "//private/x") to the clean path, as
+// http.ServeMux does, so access checks and routing see the path that
+// is served. Only GET and HEAD are redirected, other methods are
+// answered "400 Bad Request".
+func CleanPathHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		cleaned := path.Clean("/" + p)
+		if strings.HasSuffix(p, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		if cleaned == p {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			httpError(w, r, http.StatusBadRequest, fmt.Errorf("path %q isn't clean", p))
+			return
+		}
+		u := *r.URL
+		u.Path, u.RawPath = cleaned, ""
+		http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// IsDotPath checks to see if a path is requested with a dot file (e.g. docs/.git/* or docs/.htaccess)
+//
+// Deprecated: use SafePath which also rejects traversal and encoded paths.
+func IsDotPath(p string) bool {
+	for _, part := range strings.Split(path.Clean(p), "/") {
+		if strings.HasPrefix(part, "..") == false && strings.HasPrefix(part, ".") == true && len(part) > 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+// safepath_test.go tests SafePath including a fuzz test.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSafePath(t *testing.T) {
+	safe := map[string]string{
+		"":                   "/",
+		"/":                  "/",
+		"index.html":         "/index.html",
+		"/docs/./index.html": "/docs/index.html",
+		"/docs//a.html":      "/docs/a.html",
+		"/a%20b.html":        "/a%20b.html",
+	}
+	for p, expected := range safe {
+		got, err := SafePath(p)
+		if err != nil {
+			t.Errorf("%q, unexpected error %s", p, err)
+		} else if got != expected {
+			t.Errorf("%q, expected %q, got %q", p, expected, got)
+		}
+	}
+	unsafe := []string{
+		"/../etc/passwd",
+		"..",
+		"/docs/../../etc/passwd",
+		"/.git/config",
+		"/docs/.htaccess",
+		"/%2e%2e/etc/passwd",
+		"/%2E%2E%2Fetc/passwd",
+		"/docs%2f..%2fsecret",
+		"/a%00.html",
+		"/a\x00.html",
+		"/..%5c..%5cwindows",
+	}
+	if os.PathSeparator == '\\' {
+		unsafe = append(unsafe, `/..\..\windows`, `\docs\a.html`)
+	}
+	for _, p := range unsafe {
+		if _, err := SafePath(p); errors.Is(err, ErrUnsafePath) == false {
+			t.Errorf("%q, expected ErrUnsafePath, got %v", p, err)
+		}
+	}
+}
+
+func FuzzSafePath(f *testing.F) {
+	for _, seed := range []string{"/", "/index.html", "/../x", "/.git", "/%2e%2e/", "/a\x00b", `/a\..\b`, "a/./b//c"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, p string) {
+		got, err := SafePath(p)
+		if err != nil {
+			return
+		}
+		if strings.HasPrefix(got, "/") == false || path.Clean(got) != got {
+			t.Fatalf("%q, result %q is not clean and rooted", p, got)
+		}
+		if strings.Contains(got, "\x00") {
+			t.Fatalf("%q, result %q contains NUL", p, got)
+		}
+		if os.PathSeparator == '\\' && strings.Contains(got, "\\") {
+			t.Fatalf("%q, result %q contains a backslash", p, got)
+		}
+		for _, part := range strings.Split(got, "/") {
+			if part == ".." || strings.HasPrefix(part, ".") {
+				t.Fatalf("%q, result %q contains %q", p, got, part)
+			}
+		}
+		if lower := strings.ToLower(got); strings.Contains(lower, "%2e") || strings.Contains(lower, "%2f") {
+			t.Fatalf("%q, result %q contains encoded traversal", p, got)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+// schedule.go gates content by publish windows, e.g. embargoed theses.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PublishWindow makes the content below Prefix available only
+// between NotBefore and NotAfter. Either may be left zero for an
+// open ended window. Outside the window requests are answered with
+// Status, 404 Not Found if not set (403 Forbidden reveals the
+// content exists).
+type PublishWindow struct {
+	Prefix    string    `json:"prefix" toml:"prefix"`
+	NotBefore time.Time `json:"not_before,omitempty" toml:"not_before,omitempty"`
+	NotAfter  time.Time `json:"not_after,omitempty" toml:"not_after,omitempty"`
+	Status    int       `json:"status,omitempty" toml:"status,omitempty"`
+}
+
+// Published reports if the window is open at t.
+func (pw *PublishWindow) Published(t time.Time) bool {
+	if pw.NotBefore.IsZero() == false && t.Before(pw.NotBefore) {
+		return false
+	}
+	if pw.NotAfter.IsZero() == false && t.After(pw.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// ScheduleHandler answers requests below a closed window's prefix
+// with its status rather than calling next.
+func ScheduleHandler(windows []*PublishWindow, next http.Handler) (http.Handler, error) {
+	for _, pw := range windows {
+		if pw.Prefix == "" {
+			return nil, fmt.Errorf("schedule requires a prefix")
+		}
+		if pw.NotBefore.IsZero() == false && pw.NotAfter.IsZero() == false && pw.NotAfter.Before(pw.NotBefore) {
+			return nil, fmt.Errorf("schedule %q not_after is before not_before", pw.Prefix)
+		}
+		switch pw.Status {
+		case 0, http.StatusForbidden, http.StatusNotFound:
+		default:
+			return nil, fmt.Errorf("schedule %q status must be 403 or 404", pw.Prefix)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		for _, pw := range windows {
+			if strings.HasPrefix(r.URL.Path, pw.Prefix) && pw.Published(now) == false {
+				status := pw.Status
+				if status == 0 {
+					status = http.StatusNotFound
+				}
+				// Caches must not keep the answer past the window.
+				w.Header().Set("Cache-Control", "no-store")
+				httpError(w, r, status, fmt.Errorf("%s is not available", r.URL.Path))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
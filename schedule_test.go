@@ -0,0 +1,57 @@
+// schedule_test.go tests publish windows.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"embargo/thesis.pdf", "retired/old.pdf", "open/paper.pdf"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0775)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("%PDF"), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	ws := &WebService{DocRoot: dir, Schedule: []*PublishWindow{
+		{Prefix: "/embargo/", NotBefore: now.Add(time.Hour)},
+		{Prefix: "/retired/", NotAfter: now.Add(-time.Hour), Status: http.StatusForbidden},
+		{Prefix: "/open/", NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)},
+	}}
+	expected := map[string]int{
+		"/embargo/thesis.pdf": http.StatusNotFound,
+		"/retired/old.pdf":    http.StatusForbidden,
+		"/open/paper.pdf":     http.StatusOK,
+	}
+	for p, expect := range expected {
+		if status, _ := serveStatic(t, ws, p); status != expect {
+			t.Errorf("%s, expected %d, got %d", p, expect, status)
+		}
+	}
+
+	ws.Schedule = []*PublishWindow{{Prefix: "/x/", NotBefore: now, NotAfter: now.Add(-time.Hour)}}
+	if _, err := ws.Handler(); err == nil {
+		t.Errorf("expected an error for an inverted window")
+	}
+}
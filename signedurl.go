@@ -0,0 +1,168 @@
+// signedurl.go signs links to protected files so they can be shared
+// until they expire without creating accounts.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+)
+
+// Signed URL query parameters.
+const (
+	SignedExpiresParam   = "expires"
+	SignedSignatureParam = "signature"
+)
+
+var (
+	// ErrSignatureExpired is returned for a signed URL past its expiry.
+	ErrSignatureExpired = errors.New("link has expired")
+	// ErrInvalidSignature is returned for a missing or forged signature.
+	ErrInvalidSignature = errors.New("invalid link signature")
+)
+
+// signedKey is the context key marking requests with a verified
+// signature.
+type signedKey struct{}
+
+// SignedURLs lets GET and HEAD requests below Prefixes skip the
+// access policy when they carry a valid "expires" and "signature",
+// e.g. "/private/report.pdf?expires=1767225600&signature=...". Create
+// links with Sign (or "webserver sign"), a link only opens on the host
+// it was signed for. Keys are rotated by adding a new key first, links
+// signed with any listed key are accepted.
+type SignedURLs struct {
+	// Prefixes are the paths links can be signed for.
+	Prefixes []string `json:"prefixes" toml:"prefixes"`
+	// Keys are base64 encoded secrets of at least 32 bytes, newest
+	// first, e.g. from "openssl rand -base64 32".
+	Keys []string `json:"keys" toml:"keys"`
+
+	keys [][]byte
+}
+
+// load decodes the keys.
+func (su *SignedURLs) load() error {
+	if len(su.Prefixes) == 0 {
+		return fmt.Errorf("signed_urls requires prefixes")
+	}
+	if len(su.Keys) == 0 {
+		return fmt.Errorf("signed_urls requires a key")
+	}
+	keys := [][]byte{}
+	for i, s := range su.Keys {
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return fmt.Errorf("signed_urls key %d, %s", i, err)
+		}
+		if len(key) < MinCookieKeyLength {
+			return fmt.Errorf("signed_urls key %d is shorter than %d bytes", i, MinCookieKeyLength)
+		}
+		keys = append(keys, key)
+	}
+	su.keys = keys
+	return nil
+}
+
+// signature returns the signature of p on host expiring at expires.
+func signature(key []byte, host string, p string, expires string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("wsfn signed url\n" + normalizeHost(host) + "\n" + p + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns a link to the path p on host (e.g.
+// "library.example.edu") valid until expires.
+func (su *SignedURLs) Sign(host string, p string, expires time.Time) (string, error) {
+	if su.keys == nil {
+		if err := su.load(); err != nil {
+			return "", err
+		}
+	}
+	if normalizeHost(host) == "" {
+		return "", fmt.Errorf("a host is required")
+	}
+	if path.Clean(p) != p || hasPathPrefix(p, su.Prefixes) == false {
+		return "", fmt.Errorf("%q isn't below a signed_urls prefix", p)
+	}
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{}
+	q.Set(SignedExpiresParam, exp)
+	q.Set(SignedSignatureParam, signature(su.keys[0], host, p, exp))
+	u := &url.URL{Path: p, RawQuery: q.Encode()}
+	return u.String(), nil
+}
+
+// Verify checks the request's expiry and its signature for the
+// requested host and path.
+func (su *SignedURLs) Verify(r *http.Request) error {
+	q := r.URL.Query()
+	exp, sig := q.Get(SignedExpiresParam), q.Get(SignedSignatureParam)
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || sig == "" {
+		return ErrInvalidSignature
+	}
+	for _, key := range su.keys {
+		if hmac.Equal([]byte(signature(key, r.Host, r.URL.Path, exp)), []byte(sig)) {
+			if time.Now().Unix() > expires {
+				return ErrSignatureExpired
+			}
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// Handler verifies signed requests below Prefixes before passing
+// them to next, which must include the Access handler. Invalid or
+// expired links are answered "403 Forbidden".
+func (su *SignedURLs) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has(SignedSignatureParam) == false || hasPathPrefix(r.URL.Path, su.Prefixes) == false {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			httpError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("signed links are read only"))
+			return
+		}
+		if err := su.Verify(r); err != nil {
+			httpError(w, r, http.StatusForbidden, err)
+			return
+		}
+		// Shared caches mustn't hand the file to others.
+		w.Header().Set("Cache-Control", "private")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signedKey{}, true)))
+	})
+}
+
+// signedURL reports if the request's signature was verified.
+func signedURL(r *http.Request) bool {
+	signed, _ := r.Context().Value(signedKey{}).(bool)
+	return signed
+}
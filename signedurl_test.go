@@ -0,0 +1,135 @@
+// signedurl_test.go tests signed links to protected files.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURLs(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "private", "reports"), 0775)
+	os.WriteFile(filepath.Join(dir, "private", "reports", "annual.txt"), []byte("annual report"), 0664)
+	os.WriteFile(filepath.Join(dir, "private", "secret.txt"), []byte("secret"), 0664)
+	oldKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))
+	newKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("n", 32)))
+
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/private/"}}
+	a.UpdateAccess("Jane.Doe", "secret")
+	ws := &WebService{
+		DocRoot:    dir,
+		Access:     a,
+		SignedURLs: &SignedURLs{Prefixes: []string{"/private/reports/"}, Keys: []string{oldKey}},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := ws.SignedURLs.Sign("example.com", "/private/reports/annual.txt", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _ := ws.SignedURLs.Sign("example.com", "/private/reports/annual.txt", time.Now().Add(-time.Minute))
+	if _, err := ws.SignedURLs.Sign("example.com", "/private/secret.txt", time.Now().Add(time.Hour)); err == nil {
+		t.Errorf("expected paths outside the prefixes refused")
+	}
+	if _, err := ws.SignedURLs.Sign("example.com", "/private/reports/../secret.txt", time.Now().Add(time.Hour)); err == nil {
+		t.Errorf("expected unclean paths refused")
+	}
+
+	serve := func(method string, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+	forged := strings.Replace(link, "annual.txt", "other.txt", 1)
+	tampered := link[:strings.Index(link, "expires=")] + "expires=99999999999&" + link[strings.Index(link, "signature="):]
+	for _, test := range []struct {
+		method string
+		target string
+		status int
+	}{
+		{"GET", link, http.StatusOK},
+		{"HEAD", link, http.StatusOK},
+		{"GET", "/private/reports/annual.txt", http.StatusUnauthorized},
+		{"GET", expired, http.StatusForbidden},
+		{"GET", forged, http.StatusForbidden},
+		{"GET", tampered, http.StatusForbidden},
+		{"PUT", link, http.StatusMethodNotAllowed},
+		// The signature only opens the signed path.
+		{"GET", "/private/secret.txt?" + link[strings.Index(link, "?")+1:], http.StatusUnauthorized},
+	} {
+		rec := serve(test.method, test.target)
+		if rec.Code != test.status {
+			t.Errorf("%s %s expected %d, got %d", test.method, test.target, test.status, rec.Code)
+		}
+	}
+	// Nor on another host.
+	req := httptest.NewRequest("GET", link, nil)
+	req.Host = "other.example.com"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 on another host, got %d", rec.Code)
+	}
+	if _, err := ws.SignedURLs.Sign("", "/private/reports/annual.txt", time.Now().Add(time.Hour)); err == nil {
+		t.Errorf("expected a host to be required")
+	}
+	if rec := serve("GET", link); rec.Body.String() != "annual report" || rec.Header().Get("Cache-Control") != "private" {
+		t.Errorf("expected the private report, got %q %q", rec.Header().Get("Cache-Control"), rec.Body)
+	}
+
+	// After rotating keys old links keep working, new ones use the new key.
+	ws.SignedURLs.Keys = []string{newKey, oldKey}
+	if h, err = ws.Handler(); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := ws.SignedURLs.Sign("example.com", "/private/reports/annual.txt", time.Now().Add(time.Hour))
+	if rotated == link {
+		t.Errorf("expected a new signature after rotating keys")
+	}
+	for _, target := range []string{link, rotated} {
+		if rec := serve("GET", target); rec.Code != http.StatusOK {
+			t.Errorf("expected %s to work after rotation, got %d", target, rec.Code)
+		}
+	}
+
+	// Signed links open without the remote_user header too.
+	ws.Access = &Access{AuthType: "remote_user", RemoteUserProxies: []string{"10.0.0.5"}, Routes: []string{"/private/"}}
+	if h, err = ws.Handler(); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve("GET", link); rec.Code != http.StatusOK {
+		t.Errorf("expected signed links to open with remote_user, got %d", rec.Code)
+	}
+	if rec := serve("GET", "/private/reports/annual.txt"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without a signature, got %d", rec.Code)
+	}
+
+	ws.SignedURLs.Keys = []string{base64.StdEncoding.EncodeToString([]byte("short"))}
+	if _, err := ws.Handler(); err == nil {
+		t.Errorf("expected short keys refused")
+	}
+}
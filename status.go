@@ -0,0 +1,106 @@
+// status.go provides a JSON endpoint describing the running build
+// and a summary of its configuration so monitoring can verify which
+// version of a service is deployed where.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ServiceStatus is the document returned by the status endpoint.
+// It only reports paths and addresses, never credentials.
+type ServiceStatus struct {
+	Version      string                   `json:"version"`
+	ReleaseDate  string                   `json:"release_date"`
+	ReleaseHash  string                   `json:"release_hash"`
+	Started      time.Time                `json:"started"`
+	Uptime       string                   `json:"uptime"`
+	DocRoot      string                   `json:"htdocs"`
+	S3           string                   `json:"s3,omitempty"`
+	Http         string                   `json:"http,omitempty"`
+	Https        string                   `json:"https,omitempty"`
+	CertPEM      string                   `json:"cert_pem,omitempty"`
+	KeyPEM       string                   `json:"key_pem,omitempty"`
+	AccessFile   string                   `json:"access_file,omitempty"`
+	AccessRoutes []string                 `json:"access_routes,omitempty"`
+	RedirectsCSV string                   `json:"redirects_csv,omitempty"`
+	ReverseProxy []string                 `json:"reverse_proxy,omitempty"`
+	Upstreams    map[string]UpstreamStats `json:"upstreams,omitempty"`
+	Queues       map[string]QueueStats    `json:"queues,omitempty"`
+	Logins       map[string]LoginStats    `json:"logins,omitempty"`
+	Certificates []*CertStatus            `json:"certificates,omitempty"`
+}
+
+// Status returns a *ServiceStatus summarizing the build and configuration
+// of the *WebService.
+func (w *WebService) Status() *ServiceStatus {
+	s := new(ServiceStatus)
+	s.Version = Version
+	s.ReleaseDate = ReleaseDate
+	s.ReleaseHash = ReleaseHash
+	s.Started = w.started
+	if w.started.IsZero() == false {
+		s.Uptime = time.Since(w.started).Round(time.Second).String()
+	}
+	s.DocRoot = w.DocRoot
+	if w.S3 != nil {
+		s.S3 = strings.TrimSuffix(w.S3.Endpoint, "/") + "/" + w.S3.Bucket + path.Join("/", w.S3.Prefix)
+	}
+	if w.Http != nil {
+		s.Http = w.Http.String()
+	}
+	if w.Https != nil {
+		s.Https = w.Https.String()
+		s.CertPEM = w.Https.CertPEM
+		s.KeyPEM = w.Https.KeyPEM
+	}
+	s.AccessFile = w.AccessFile
+	if w.Access != nil {
+		s.AccessRoutes = w.Access.ListRoutes()
+		// Counts by user are only shown by the AdminAPI.
+		s.Logins = w.Access.LoginMetrics().Routes
+	}
+	s.RedirectsCSV = w.RedirectsCSV
+	for prefix := range w.ReverseProxy {
+		s.ReverseProxy = append(s.ReverseProxy, prefix)
+	}
+	sort.Strings(s.ReverseProxy)
+	if upstreams := w.upstreams.snapshot(); len(upstreams) > 0 {
+		s.Upstreams = upstreams
+	}
+	for prefix, q := range w.ProxyQueues {
+		if s.Queues == nil {
+			s.Queues = map[string]QueueStats{}
+		}
+		s.Queues[prefix] = q.Stats()
+	}
+	s.Certificates = w.certStatus()
+	return s
+}
+
+// StatusHandler serves the *WebService status as JSON.
+func (w *WebService) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		JSONResponse(res, req, http.StatusOK, w.Status())
+	})
+}
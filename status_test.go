@@ -0,0 +1,73 @@
+// status_test.go tests the build and configuration status document.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusHandler(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "argon2id", Routes: []string{"/private/"}}
+	if err := a.UpdateUser("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	ws := &WebService{
+		DocRoot:      "htdocs",
+		Http:         &Service{Scheme: "http", Host: "localhost", Port: "8000"},
+		AccessFile:   "access.toml",
+		Access:       a,
+		ReverseProxy: map[string]string{"/api/": "http://localhost:9000/", "/search/": "http://localhost:9200/"},
+		started:      time.Now().Add(-time.Minute),
+	}
+	rec := httptest.NewRecorder()
+	ws.StatusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/status", nil))
+	if rec.Code != http.StatusOK || strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") == false {
+		t.Fatalf("expected a JSON status, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// Unset parts of the configuration are left out.
+	expected := "access_file access_routes htdocs http release_date release_hash reverse_proxy started uptime version"
+	if strings.Join(keys, " ") != expected {
+		t.Errorf("expected keys %q, got %q", expected, strings.Join(keys, " "))
+	}
+	if doc["version"] != Version || doc["htdocs"] != "htdocs" || doc["http"] != "http://localhost:8000" || doc["uptime"] != "1m0s" {
+		t.Errorf("unexpected status %s", rec.Body.String())
+	}
+	if src, _ := json.Marshal(doc["reverse_proxy"]); string(src) != `["/api/","/search/"]` {
+		t.Errorf("expected sorted proxy prefixes, got %s", src)
+	}
+	for _, secret := range []string{"Jane.Doe", "localhost:9000", "argon2id"} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Errorf("expected %q left out of the status", secret)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// store.go opens credential backends (AccessStore) by URL.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// AccessStoreOpener opens a credential backend from its URL.
+type AccessStoreOpener func(u *url.URL) (AccessStore, error)
+
+var (
+	storesMu sync.RWMutex
+	stores   = map[string]AccessStoreOpener{}
+)
+
+// RegisterAccessStore makes a backend available to OpenAccess under
+// a URL scheme, e.g. "sqlite" or "ldap". Like database/sql drivers
+// it is usually called from the init function of the package
+// implementing the backend, so programs choose the backends (and
+// their dependencies) they are built with.
+func RegisterAccessStore(scheme string, open AccessStoreOpener) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	stores[scheme] = open
+}
+
+// AccessStores returns the registered backend URL schemes.
+func AccessStores() []string {
+	storesMu.RLock()
+	defer storesMu.RUnlock()
+	schemes := []string{}
+	for scheme := range stores {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// IsAccessStoreURL reports if location names a backend rather than
+// an access file, e.g. "ldap://ldap.example.edu/" or "sqlite:users.db"
+// when a sqlite backend is registered. Other names with a colon, like
+// "backup:access.toml" or "C:\etc\access.toml", are access files.
+func IsAccessStoreURL(location string) bool {
+	u, err := url.Parse(location)
+	// A single letter scheme is a Windows drive.
+	if err != nil || len(u.Scheme) < 2 {
+		return false
+	}
+	if strings.HasPrefix(location, u.Scheme+"://") {
+		return true
+	}
+	storesMu.RLock()
+	defer storesMu.RUnlock()
+	_, ok := stores[u.Scheme]
+	return ok
+}
+
+// OpenAccess returns the Access for location, an access file (see
+// LoadAccess) or the URL of a registered backend. Backends hold
+// users only, the Access returned uses Basic auth with argon2id.
+// Routes and groups belong in an access file.
+func OpenAccess(location string) (*Access, error) {
+	if IsAccessStoreURL(location) == false {
+		return LoadAccess(location)
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	storesMu.RLock()
+	open, ok := stores[u.Scheme]
+	storesMu.RUnlock()
+	if ok == false {
+		return nil, fmt.Errorf("%q, no %s backend in this build (have %q)", location, u.Scheme, AccessStores())
+	}
+	store, err := open(u)
+	if err != nil {
+		return nil, err
+	}
+	return &Access{AuthType: "basic", Encryption: "argon2id", Store: store}, nil
+}
@@ -0,0 +1,115 @@
+// store_test.go tests opening credential backends by URL.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memStore is an in memory AccessStore.
+type memStore struct {
+	mu    sync.Mutex
+	users map[string]*Secrets
+}
+
+func (m *memStore) Lookup(username string) (*Secrets, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if secret, ok := m.users[username]; ok {
+		return secret, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownUser, username)
+}
+
+func (m *memStore) Update(username string, secret *Secrets) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[username] = secret
+	return nil
+}
+
+func (m *memStore) Remove(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[username]; ok == false {
+		return fmt.Errorf("%w %q", ErrUnknownUser, username)
+	}
+	delete(m.users, username)
+	return nil
+}
+
+func (m *memStore) List() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usernames := []string{}
+	for username := range m.users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames, nil
+}
+
+func TestOpenAccess(t *testing.T) {
+	store := &memStore{users: map[string]*Secrets{}}
+	RegisterAccessStore("mem", func(u *url.URL) (AccessStore, error) {
+		if u.Opaque != "test" {
+			return nil, fmt.Errorf("unknown store %q", u.Opaque)
+		}
+		return store, nil
+	})
+	a, err := OpenAccess("mem:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.UpdateUser("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.users["Jane.Doe"]; ok == false {
+		t.Errorf("expected the user to be added to the store")
+	}
+	if err := a.RenameUser("Jane.Doe", "Jane.Smith"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyLogin("Jane.Smith", "secret"); err != nil {
+		t.Errorf("expected login from the store, %s", err)
+	}
+	if _, err := OpenAccess("mem:other"); err == nil {
+		t.Errorf("expected the opener's error")
+	}
+	if _, err := OpenAccess("sqlite://users.db"); err == nil || strings.Contains(err.Error(), "no sqlite backend") == false {
+		t.Errorf("expected a missing backend error, got %v", err)
+	}
+	for location, expected := range map[string]bool{
+		"access.toml":              false,
+		`C:\etc\access.toml`:       false,
+		"mem:test":                 true,
+		"sqlite:users.db":          false,
+		"backup:access.toml":       false,
+		"sqlite://users.db":        true,
+		"ldap://ldap.example.edu/": true,
+	} {
+		if IsAccessStoreURL(location) != expected {
+			t.Errorf("IsAccessStoreURL(%q) expected %t", location, expected)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// stream.go provides newline delimited JSON (NDJSON) responses so large
+// record sets can be exported without buffering them in memory.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultFlushEvery is the number of records written between flushes.
+	DefaultFlushEvery = 100
+	// DefaultFlushInterval is the longest a written record waits before
+	// being flushed to the client.
+	DefaultFlushInterval = time.Second
+)
+
+// NDJSONWriter writes one JSON document per line to a response,
+// flushing periodically so clients see records as they are produced.
+type NDJSONWriter struct {
+	// FlushEvery is the number of records between flushes.
+	FlushEvery int
+	// FlushInterval is the maximum time between flushes.
+	FlushInterval time.Duration
+
+	w         http.ResponseWriter
+	enc       *json.Encoder
+	flusher   http.Flusher
+	pending   int
+	lastFlush time.Time
+}
+
+// NewNDJSONWriter sets the NDJSON headers on w and returns an
+// *NDJSONWriter using the default flush settings.
+func NewNDJSONWriter(w http.ResponseWriter) *NDJSONWriter {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	nw := &NDJSONWriter{
+		FlushEvery:    DefaultFlushEvery,
+		FlushInterval: DefaultFlushInterval,
+		w:             w,
+		enc:           json.NewEncoder(w),
+		lastFlush:     time.Now(),
+	}
+	if f, ok := w.(http.Flusher); ok {
+		nw.flusher = f
+	}
+	return nw
+}
+
+// Encode writes v as a single line of JSON, flushing when
+// FlushEvery records are pending or FlushInterval has passed.
+func (nw *NDJSONWriter) Encode(v interface{}) error {
+	if err := nw.enc.Encode(v); err != nil {
+		return err
+	}
+	nw.pending++
+	if nw.pending >= nw.FlushEvery || time.Since(nw.lastFlush) >= nw.FlushInterval {
+		nw.Flush()
+	}
+	return nil
+}
+
+// Flush sends any buffered records to the client.
+func (nw *NDJSONWriter) Flush() {
+	if nw.flusher != nil {
+		nw.flusher.Flush()
+	}
+	nw.pending = 0
+	nw.lastFlush = time.Now()
+}
+
+// StreamJSON writes each value received from records as NDJSON until
+// the channel is closed or the client goes away. Pending records are
+// flushed whenever the channel has nothing ready so slow producers
+// don't leave records sitting in the buffer.
+func StreamJSON(w http.ResponseWriter, r *http.Request, records <-chan interface{}) error {
+	nw := NewNDJSONWriter(w)
+	defer nw.Flush()
+	ctx := r.Context()
+	for {
+		var (
+			rec interface{}
+			ok  bool
+		)
+		select {
+		case rec, ok = <-records:
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			if nw.pending > 0 {
+				nw.Flush()
+			}
+			select {
+			case rec, ok = <-records:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if ok == false {
+			return nil
+		}
+		if err := nw.Encode(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// StreamJSONFunc writes the values returned by next as NDJSON. next
+// returns io.EOF when there are no more records, any other error
+// stops the stream and is returned.
+func StreamJSONFunc(w http.ResponseWriter, r *http.Request, next func() (interface{}, error)) error {
+	nw := NewNDJSONWriter(w)
+	defer nw.Flush()
+	ctx := r.Context()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rec, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := nw.Encode(rec); err != nil {
+			return err
+		}
+	}
+}
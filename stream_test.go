@@ -0,0 +1,165 @@
+// stream_test.go tests flushing and cancelling NDJSON streams.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flushRecorder counts flushes and the lines written before each.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	mu      sync.Mutex
+	flushed []int
+}
+
+func (f *flushRecorder) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ResponseRecorder.Write(p)
+}
+
+func (f *flushRecorder) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushed = append(f.flushed, strings.Count(f.Body.String(), "\n"))
+}
+
+func (f *flushRecorder) flushes() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int{}, f.flushed...)
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	nw := NewNDJSONWriter(rec)
+	nw.FlushEvery, nw.FlushInterval = 2, time.Hour
+	for i := 0; i < 5; i++ {
+		if err := nw.Encode(map[string]int{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if flushed := rec.flushes(); len(flushed) != 2 || flushed[0] != 2 || flushed[1] != 4 {
+		t.Errorf("expected flushes after 2 and 4 records, got %v", flushed)
+	}
+	nw.Flush()
+	if rec.Header().Get("Content-Type") != "application/x-ndjson" || rec.Body.String() != "{\"n\":0}\n{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n{\"n\":4}\n" {
+		t.Errorf("unexpected stream %q %q", rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+
+	// An interval flushes however few records are pending.
+	rec = &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	nw = NewNDJSONWriter(rec)
+	nw.FlushEvery, nw.FlushInterval = 100, time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	nw.Encode("one")
+	if flushed := rec.flushes(); len(flushed) != 1 {
+		t.Errorf("expected the interval to flush, got %v", flushed)
+	}
+}
+
+func TestStreamJSON(t *testing.T) {
+	// A record waiting on a slow producer is flushed.
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	records := make(chan interface{})
+	done := make(chan error)
+	go func() {
+		done <- StreamJSON(rec, httptest.NewRequest("GET", "/", nil), records)
+	}()
+	records <- "first"
+	deadline := time.Now().Add(time.Second)
+	for len(rec.flushes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if flushed := rec.flushes(); len(flushed) == 0 || flushed[0] != 1 {
+		t.Errorf("expected the pending record flushed, got %v", flushed)
+	}
+	close(records)
+	if err := <-done; err != nil {
+		t.Errorf("expected the stream to end cleanly, %s", err)
+	}
+
+	// A client going away stops the stream, even with records ready.
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	records = make(chan interface{}, 1)
+	go func() {
+		done <- StreamJSON(httptest.NewRecorder(), r, records)
+	}()
+	cancel()
+	go func() {
+		for {
+			select {
+			case records <- "more":
+			case <-time.After(time.Second):
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		if errors.Is(err, context.Canceled) == false {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the stream to stop when the client goes away")
+	}
+}
+
+func TestStreamJSONFunc(t *testing.T) {
+	rec := httptest.NewRecorder()
+	n := 0
+	err := StreamJSONFunc(rec, httptest.NewRequest("GET", "/", nil), func() (interface{}, error) {
+		if n == 3 {
+			return nil, io.EOF
+		}
+		n++
+		return n, nil
+	})
+	if err != nil || rec.Body.String() != "1\n2\n3\n" || rec.Flushed == false {
+		t.Errorf("expected three flushed records, got %v %q", err, rec.Body.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rec = httptest.NewRecorder()
+	n = 0
+	err = StreamJSONFunc(rec, httptest.NewRequest("GET", "/", nil).WithContext(ctx), func() (interface{}, error) {
+		if n++; n == 2 {
+			cancel()
+		}
+		return n, nil
+	})
+	if errors.Is(err, context.Canceled) == false || rec.Body.String() != "1\n2\n" {
+		t.Errorf("expected the stream cancelled after two records, got %v %q", err, rec.Body.String())
+	}
+
+	boom := errors.New("boom")
+	if err := StreamJSONFunc(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), func() (interface{}, error) {
+		return nil, boom
+	}); err != boom {
+		t.Errorf("expected the producer's error, got %v", err)
+	}
+}
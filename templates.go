@@ -0,0 +1,236 @@
+// templates.go renders Go html/template pages at mapped paths, e.g. a
+// library hours page or an alerts banner.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party packages
+	"github.com/BurntSushi/toml"
+)
+
+// Templates renders the templates in Directory for the paths in
+// Pages. Templates are executed with a *TemplateData. The
+// "cspNonce" function returns the request's CSP nonce and "asset"
+// the fingerprinted URL of a path (see Fingerprint).
+type Templates struct {
+	// Directory holds the templates, all are parsed together so
+	// pages can share layouts and partials with "template".
+	Directory string `json:"directory" toml:"directory"`
+
+	// Pages maps a URL path (e.g. "/hours/") to a template name
+	// (e.g. "hours.html").
+	Pages map[string]string `json:"pages" toml:"pages"`
+
+	// Data maps a name to a JSON or TOML file, read again when the
+	// file changes. It is available in templates as .Data.<name>.
+	Data map[string]string `json:"data,omitempty" toml:"data,omitempty"`
+
+	// Env lists the environment variables available as .Env.<name>.
+	Env []string `json:"env,omitempty" toml:"env,omitempty"`
+
+	// Reload parses the templates on each request, for development.
+	Reload bool `json:"reload,omitempty" toml:"reload,omitempty"`
+
+	// CacheSeconds sets Cache-Control max-age of rendered pages.
+	CacheSeconds int `json:"cache_seconds,omitempty" toml:"cache_seconds,omitempty"`
+
+	mu     sync.Mutex
+	tmpl   *template.Template
+	cache  map[string]*templateSource
+	assets *Fingerprint
+}
+
+// TemplateData is passed to templates.
+type TemplateData struct {
+	Path  string
+	Query map[string][]string
+	Now   time.Time
+	Data  map[string]interface{}
+	Env   map[string]string
+}
+
+// templateSource is a data file and when it was read.
+type templateSource struct {
+	modTime time.Time
+	value   interface{}
+}
+
+// templateFuncs are available when templates are parsed, the
+// request's values are bound when executed.
+var templateFuncs = template.FuncMap{
+	"cspNonce": func() string { return "" },
+	"asset":    func(p string) string { return p },
+}
+
+// parse reads the templates in Directory.
+func (t *Templates) parse() (*template.Template, error) {
+	tmpl, err := template.New("").Funcs(templateFuncs).ParseGlob(filepath.Join(t.Directory, "*"))
+	if err != nil {
+		return nil, fmt.Errorf("templates %s, %s", t.Directory, err)
+	}
+	for p, name := range t.Pages {
+		if tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("templates %q, no template %q", p, name)
+		}
+	}
+	return tmpl, nil
+}
+
+// templates returns the parsed templates, parsing them again if
+// Reload is set.
+func (t *Templates) templates() (*template.Template, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tmpl == nil || t.Reload {
+		tmpl, err := t.parse()
+		if err != nil {
+			return nil, err
+		}
+		t.tmpl = tmpl
+	}
+	return t.tmpl, nil
+}
+
+// readData decodes a JSON or TOML file.
+func readData(fName string) (interface{}, error) {
+	src, err := os.ReadFile(fName)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch strings.ToLower(filepath.Ext(fName)) {
+	case ".toml":
+		m := map[string]interface{}{}
+		if _, err := toml.Decode(string(src), &m); err != nil {
+			return nil, fmt.Errorf("%s, %s", fName, err)
+		}
+		value = m
+	case ".json":
+		if err := json.Unmarshal(src, &value); err != nil {
+			return nil, fmt.Errorf("%s, %s", fName, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s, data must be JSON or TOML", fName)
+	}
+	return value, nil
+}
+
+// data returns the data sources, reading those that changed.
+func (t *Templates) data() (map[string]interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cache == nil {
+		t.cache = map[string]*templateSource{}
+	}
+	data := map[string]interface{}{}
+	for name, fName := range t.Data {
+		info, err := os.Stat(fName)
+		if err != nil {
+			return nil, err
+		}
+		src, ok := t.cache[name]
+		if ok == false || info.ModTime().Equal(src.modTime) == false {
+			value, err := readData(fName)
+			if err != nil {
+				return nil, err
+			}
+			src = &templateSource{modTime: info.ModTime(), value: value}
+			t.cache[name] = src
+		}
+		data[name] = src.value
+	}
+	return data, nil
+}
+
+// render executes the page's template for the request.
+func (t *Templates) render(r *http.Request, name string) ([]byte, error) {
+	tmpl, err := t.templates()
+	if err != nil {
+		return nil, err
+	}
+	data, err := t.data()
+	if err != nil {
+		return nil, err
+	}
+	env := map[string]string{}
+	for _, key := range t.Env {
+		env[key] = os.Getenv(key)
+	}
+	td := &TemplateData{Path: r.URL.Path, Query: r.URL.Query(), Now: time.Now(), Data: data, Env: env}
+	// Clone so the request's nonce isn't shared with other requests.
+	page, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	page.Funcs(CSPFuncMap(r))
+	if t.assets != nil {
+		page.Funcs(template.FuncMap{"asset": t.assets.URL})
+	}
+	if err := page.ExecuteTemplate(buf, name, td); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Handler serves the Pages passing other requests to next.
+func (t *Templates) Handler(next http.Handler) (http.Handler, error) {
+	if _, err := t.templates(); err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := t.Pages[r.URL.Path]
+		if ok == false {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			httpError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+			return
+		}
+		src, err := t.render(r, name)
+		if err != nil {
+			httpError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		mimeType := mime.TypeByExtension(filepath.Ext(name))
+		if mimeType == "" || filepath.Ext(name) == ".tmpl" {
+			mimeType = "text/html; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", mimeType)
+		if t.CacheSeconds > 0 {
+			w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", t.CacheSeconds))
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		writeBody(w, r, http.StatusOK, src)
+	}), nil
+}
@@ -0,0 +1,90 @@
+// templates_test.go tests rendering html/template pages.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplates(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"templates/layout.html": `{{define "layout"}}<html><body>{{template "content" .}}</body></html>{{end}}`,
+		"templates/hours.html":  `{{define "content"}}Open {{.Data.hours.open}}, {{.Env.LIBRARY_ALERT}} <script nonce="{{cspNonce}}"></script>{{end}}{{template "layout" .}}`,
+		"data/hours.json":       `{"open": "8am"}`,
+	}
+	for name, src := range files {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0775)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Setenv("LIBRARY_ALERT", "closed <Monday>")
+	defer os.Unsetenv("LIBRARY_ALERT")
+
+	ws := &WebService{
+		DocRoot: dir,
+		CSP:     &CSPPolicy{Policy: "script-src 'nonce-{nonce}'"},
+		Templates: &Templates{
+			Directory: filepath.Join(dir, "templates"),
+			Pages:     map[string]string{"/hours/": "hours.html"},
+			Data:      map[string]string{"hours": filepath.Join(dir, "data", "hours.json")},
+			Env:       []string{"LIBRARY_ALERT"},
+		},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/hours/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body.String())
+		}
+		nonce := strings.TrimPrefix(rec.Header().Get("Content-Security-Policy"), "script-src 'nonce-")
+		nonce = strings.TrimSuffix(nonce, "'")
+		if strings.Contains(rec.Body.String(), `nonce="`+nonce+`"`) == false {
+			t.Errorf("expected the request's nonce %q in %s", nonce, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+	if body := get(); strings.Contains(body, "<html><body>Open 8am, closed &lt;Monday&gt;") == false {
+		t.Errorf("unexpected page %s", body)
+	}
+
+	// Data files are read again when they change.
+	later := time.Now().Add(time.Second)
+	fName := filepath.Join(dir, "data", "hours.json")
+	os.WriteFile(fName, []byte(`{"open": "9am"}`), 0664)
+	os.Chtimes(fName, later, later)
+	if body := get(); strings.Contains(body, "Open 9am") == false {
+		t.Errorf("expected updated data, got %s", body)
+	}
+
+	ws.Templates = &Templates{Directory: filepath.Join(dir, "templates"), Pages: map[string]string{"/x/": "missing.html"}}
+	if _, err := ws.Handler(); err == nil {
+		t.Errorf("expected an error for a missing template")
+	}
+}
@@ -0,0 +1,234 @@
+// trace.go captures how selected requests are dispatched, for
+// debugging misroutes on a running server.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTraceCount is how many requests a trace captures when
+	// the filter doesn't say.
+	DefaultTraceCount = 10
+	// MaxTraceCount limits the requests captured and kept.
+	MaxTraceCount = 100
+)
+
+// TraceFilter selects the requests to trace.
+type TraceFilter struct {
+	// Prefix is the URL path prefix traced, all paths if empty.
+	Prefix string `json:"prefix,omitempty"`
+	// IP is the client address or CIDR traced, all clients if empty.
+	IP string `json:"ip,omitempty"`
+	// Count is how many of the next matching requests are traced.
+	Count int `json:"count,omitempty"`
+
+	network *net.IPNet
+}
+
+// TraceStep is a layer the request passed through, or a rule that
+// matched it (Duration zero).
+type TraceStep struct {
+	Name   string `json:"name"`
+	Detail string `json:"detail,omitempty"`
+	// Start is when the step began, relative to the request.
+	Start time.Duration `json:"start_ns"`
+	// Duration is the time spent in the layer, including the
+	// layers inside it.
+	Duration time.Duration `json:"duration_ns,omitempty"`
+}
+
+// RequestTrace is the dispatch trace of a request.
+type RequestTrace struct {
+	Time     time.Time     `json:"time"`
+	Method   string        `json:"method"`
+	Host     string        `json:"host"`
+	Path     string        `json:"path"`
+	IP       string        `json:"ip"`
+	Status   int           `json:"status"`
+	Duration time.Duration `json:"duration_ns"`
+	Steps    []*TraceStep  `json:"steps"`
+
+	mu    sync.Mutex
+	start time.Time
+}
+
+// TraceReport is the state of a Tracer.
+type TraceReport struct {
+	Filter    *TraceFilter    `json:"filter,omitempty"`
+	Remaining int             `json:"remaining"`
+	Traces    []*RequestTrace `json:"traces"`
+}
+
+// Tracer captures the dispatch traces of requests matching a filter.
+type Tracer struct {
+	mu        sync.Mutex
+	filter    *TraceFilter
+	remaining int
+	traces    []*RequestTrace
+	// skip is a path prefix never traced, e.g. the admin API
+	// reading the traces.
+	skip string
+}
+
+// traceKey is the context key holding the *RequestTrace.
+type traceKey struct{}
+
+// traceFrom returns the request's trace, nil when it isn't traced.
+func traceFrom(r *http.Request) *RequestTrace {
+	rt, _ := r.Context().Value(traceKey{}).(*RequestTrace)
+	return rt
+}
+
+// begin adds a step starting now.
+func (rt *RequestTrace) begin(name string, detail string) *TraceStep {
+	step := &TraceStep{Name: name, Detail: detail}
+	rt.mu.Lock()
+	step.Start = time.Since(rt.start)
+	rt.Steps = append(rt.Steps, step)
+	rt.mu.Unlock()
+	return step
+}
+
+// end records the step's duration.
+func (rt *RequestTrace) end(step *TraceStep) {
+	rt.mu.Lock()
+	step.Duration = time.Since(rt.start) - step.Start
+	rt.mu.Unlock()
+}
+
+// traceNote records that a rule matched r, if r is traced.
+func traceNote(r *http.Request, name string, detail string) {
+	if rt := traceFrom(r); rt != nil {
+		rt.begin(name, detail)
+	}
+}
+
+// traceLayer times the requests passing through next.
+func traceLayer(name string, detail string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt := traceFrom(r)
+		if rt == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		step := rt.begin(name, detail)
+		next.ServeHTTP(w, r)
+		rt.end(step)
+	})
+}
+
+// Start traces the next filter.Count requests matching filter,
+// forgetting earlier traces.
+func (t *Tracer) Start(filter *TraceFilter) error {
+	if filter.Count <= 0 {
+		filter.Count = DefaultTraceCount
+	}
+	if filter.Count > MaxTraceCount {
+		return fmt.Errorf("count must be at most %d", MaxTraceCount)
+	}
+	if filter.IP != "" {
+		if strings.Contains(filter.IP, "/") == false {
+			ip := net.ParseIP(filter.IP)
+			if ip == nil {
+				return fmt.Errorf("%q is not an IP address or CIDR", filter.IP)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			filter.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		} else {
+			_, network, err := net.ParseCIDR(filter.IP)
+			if err != nil {
+				return fmt.Errorf("%q is not an IP address or CIDR", filter.IP)
+			}
+			filter.network = network
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.filter, t.remaining, t.traces = filter, filter.Count, nil
+	return nil
+}
+
+// Stop ends tracing and forgets the traces.
+func (t *Tracer) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.filter, t.remaining, t.traces = nil, 0, nil
+}
+
+// Report returns the filter and the traces captured so far.
+func (t *Tracer) Report() *TraceReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := &TraceReport{Filter: t.filter, Remaining: t.remaining, Traces: []*RequestTrace{}}
+	for _, rt := range t.traces {
+		rt.mu.Lock()
+		if rt.Status != 0 {
+			report.Traces = append(report.Traces, rt)
+		}
+		rt.mu.Unlock()
+	}
+	return report
+}
+
+// capture returns a new trace for r if it should be traced.
+func (t *Tracer) capture(r *http.Request) *RequestTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.remaining == 0 || (t.skip != "" && strings.HasPrefix(r.URL.Path, t.skip)) {
+		return nil
+	}
+	if strings.HasPrefix(r.URL.Path, t.filter.Prefix) == false {
+		return nil
+	}
+	ip := clientIP(r)
+	if t.filter.network != nil && t.filter.network.Contains(net.ParseIP(ip)) == false {
+		return nil
+	}
+	t.remaining--
+	rt := &RequestTrace{Time: time.Now().UTC(), Method: r.Method, Host: r.Host, Path: r.URL.Path, IP: ip, start: time.Now()}
+	t.traces = append(t.traces, rt)
+	return rt
+}
+
+// Handler traces the requests matching the filter while Start's
+// count lasts.
+func (t *Tracer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt := t.capture(r)
+		if rt == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := newStatusWriter(w)
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), traceKey{}, rt)))
+		rt.mu.Lock()
+		rt.Status, rt.Duration = sw.Status(), time.Since(rt.start)
+		rt.mu.Unlock()
+	})
+}
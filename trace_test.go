@@ -0,0 +1,107 @@
+// trace_test.go tests capturing how requests are dispatched.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	a := &Access{AuthType: "basic", AuthName: "test", Encryption: "md5", Routes: []string{"/admin/", "/private/"}}
+	if err := a.UpdateUser("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	ws := &WebService{
+		DocRoot:      t.TempDir(),
+		Access:       a,
+		Admin:        &AdminAPI{Prefix: "/admin/", Admins: []string{"Jane.Doe"}},
+		ReverseProxy: map[string]string{"/api/": upstream.URL},
+		Route:        []*RouteRule{{Type: RouteRedirect, Prefix: "/old/", To: "/new/"}},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method string, p string, body string, login bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, p, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if login {
+			req.SetBasicAuth("Jane.Doe", "secret")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := do("POST", "/admin/trace", `{"ip": "not an address"}`, true); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad filter, got %d", rec.Code)
+	}
+	if rec := do("POST", "/admin/trace", `{"prefix": "/", "ip": "192.0.2.0/24", "count": 3}`, true); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d %s", rec.Code, rec.Body)
+	}
+	do("GET", "/old/page.html", "", false)
+	do("GET", "/api/items", "", false)
+	do("GET", "/private/", "", false)
+	do("GET", "/private/", "", true)
+
+	rec := do("GET", "/admin/trace", "", true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	report := new(TraceReport)
+	if err := json.Unmarshal(rec.Body.Bytes(), report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Traces) != 3 || report.Remaining != 0 {
+		t.Fatalf("expected 3 traces and none remaining, got %d, %d", len(report.Traces), report.Remaining)
+	}
+	expected := []struct {
+		path   string
+		status int
+		steps  []string
+	}{
+		{"/old/page.html", http.StatusMovedPermanently, []string{"logging", "access", "routes", "route"}},
+		{"/api/items", http.StatusOK, []string{"logging", "access", "routes", "proxy"}},
+		{"/private/", http.StatusUnauthorized, []string{"logging", "access", "auth"}},
+	}
+	for i, test := range expected {
+		rt := report.Traces[i]
+		if rt.Path != test.path || rt.Status != test.status {
+			t.Errorf("trace %d, expected %s %d, got %s %d", i, test.path, test.status, rt.Path, rt.Status)
+		}
+		names := []string{}
+		for _, step := range rt.Steps {
+			names = append(names, step.Name)
+		}
+		if strings.Join(names, " ") != strings.Join(test.steps, " ") {
+			t.Errorf("trace %d, expected steps %q, got %q", i, test.steps, names)
+		}
+	}
+
+	if rec := do("DELETE", "/admin/trace", "", true); rec.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", rec.Code)
+	}
+	if report := ws.tracer.Report(); report.Filter != nil || len(report.Traces) != 0 {
+		t.Errorf("expected tracing stopped, got %+v", report)
+	}
+}
@@ -0,0 +1,386 @@
+// tus.go implements the tus.io resumable upload protocol (version 1.0.0,
+// core plus the creation and termination extensions) so interrupted
+// large uploads can pick up where they left off.
+// See https://tus.io/protocols/resumable-upload
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TusVersion is the version of the tus protocol implemented.
+const TusVersion = "1.0.0"
+
+// TusUpload describes an upload in progress.
+type TusUpload struct {
+	ID       string            `json:"id"`
+	Size     int64             `json:"size"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Created  time.Time         `json:"created"`
+}
+
+// Complete returns true once all the bytes have been received.
+func (u *TusUpload) Complete() bool {
+	return u.Offset == u.Size
+}
+
+// TusStore is the storage used by a TusService. WriteChunk appends
+// src at offset returning the number of bytes written, it is
+// never called concurrently for the same upload.
+type TusStore interface {
+	Create(upload *TusUpload) error
+	Info(id string) (*TusUpload, error)
+	WriteChunk(id string, offset int64, src io.Reader) (int64, error)
+	Terminate(id string) error
+}
+
+// TusFileStore keeps uploads in a directory, "<id>.bin" holds the data
+// and "<id>.info" the JSON encoded *TusUpload.
+type TusFileStore struct {
+	Directory string
+}
+
+// Create makes the empty data file and saves the upload info.
+func (fs *TusFileStore) Create(upload *TusUpload) error {
+	if err := os.MkdirAll(fs.Directory, 0775); err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(filepath.Join(fs.Directory, upload.ID+".bin"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	fp.Close()
+	return fs.saveInfo(upload)
+}
+
+// saveInfo writes the upload info file.
+func (fs *TusFileStore) saveInfo(upload *TusUpload) error {
+	src, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(fs.Directory, upload.ID+".info"), src, 0664)
+}
+
+// Info reads the upload info.
+func (fs *TusFileStore) Info(id string) (*TusUpload, error) {
+	src, err := os.ReadFile(filepath.Join(fs.Directory, id+".info"))
+	if err != nil {
+		return nil, err
+	}
+	upload := new(TusUpload)
+	if err := json.Unmarshal(src, upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// WriteChunk appends src to the data file and records the new offset.
+// Bytes received before an interruption are kept.
+func (fs *TusFileStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	upload, err := fs.Info(id)
+	if err != nil {
+		return 0, err
+	}
+	fp, err := os.OpenFile(filepath.Join(fs.Directory, id+".bin"), os.O_WRONLY, 0664)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := fp.Seek(offset, io.SeekStart); err != nil {
+		fp.Close()
+		return 0, err
+	}
+	n, err := io.Copy(fp, io.LimitReader(src, upload.Size-offset))
+	if cErr := fp.Close(); err == nil {
+		err = cErr
+	}
+	upload.Offset = offset + n
+	if sErr := fs.saveInfo(upload); err == nil {
+		err = sErr
+	}
+	return n, err
+}
+
+// Terminate removes the upload's files.
+func (fs *TusFileStore) Terminate(id string) error {
+	os.Remove(filepath.Join(fs.Directory, id+".info"))
+	return os.Remove(filepath.Join(fs.Directory, id+".bin"))
+}
+
+// Path returns the data file of an upload, useful from OnComplete.
+func (fs *TusFileStore) Path(id string) string {
+	return filepath.Join(fs.Directory, id+".bin")
+}
+
+// TusService accepts resumable uploads below Prefix.
+type TusService struct {
+	// Prefix is the upload URL path, e.g. "/deposits/"
+	Prefix string `json:"prefix" toml:"prefix"`
+	// Directory is used by the default TusFileStore, it is required
+	// without a Store and can't be inside the document root.
+	Directory string `json:"directory,omitempty" toml:"directory,omitempty"`
+	// MaxSize is the largest upload accepted in bytes, zero for no limit.
+	MaxSize int64 `json:"max_size,omitempty" toml:"max_size,omitempty"`
+
+	// Store holds the uploads, if nil a TusFileStore using Directory
+	// is used.
+	Store TusStore `json:"-" toml:"-"`
+	// OnComplete is called after the last byte of an upload is stored.
+	OnComplete func(upload *TusUpload) `json:"-" toml:"-"`
+
+	locks sync.Map
+}
+
+// newStore returns the Store, or a TusFileStore using Directory
+// if it is outside docRoot.
+func (t *TusService) newStore(docRoot string) (TusStore, error) {
+	if t.Store != nil {
+		return t.Store, nil
+	}
+	if t.Directory == "" {
+		return nil, fmt.Errorf("tus requires a directory")
+	}
+	if docRoot != "" {
+		root, err := filepath.Abs(docRoot)
+		if err != nil {
+			return nil, err
+		}
+		dir, err := filepath.Abs(t.Directory)
+		if err != nil {
+			return nil, err
+		}
+		if rel, err := filepath.Rel(root, dir); err == nil && rel != ".." && strings.HasPrefix(rel, ".."+string(filepath.Separator)) == false {
+			return nil, fmt.Errorf("tus directory %q is inside the document root, partial uploads would be served", t.Directory)
+		}
+	}
+	return &TusFileStore{Directory: t.Directory}, nil
+}
+
+// lock serializes requests for a single upload.
+func (t *TusService) lock(id string) func() {
+	v, _ := t.locks.LoadOrStore(id, new(sync.Mutex))
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// parseTusMetadata decodes an Upload-Metadata header.
+func parseTusMetadata(s string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, " ", 2)
+		if len(kv) == 1 {
+			m[kv[0]] = ""
+			continue
+		}
+		val, err := base64.StdEncoding.DecodeString(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata for %q", kv[0])
+		}
+		m[kv[0]] = string(val)
+	}
+	return m, nil
+}
+
+// encodeTusMetadata renders metadata for an Upload-Metadata header.
+func encodeTusMetadata(m map[string]string) string {
+	parts := []string{}
+	for k, v := range m {
+		parts = append(parts, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	return strings.Join(parts, ",")
+}
+
+// validTusID checks an upload id is one we could have generated.
+func validTusID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Handler serves the tus protocol below Prefix, other requests are
+// passed to next. docRoot is the document root Directory must be
+// outside of.
+func (t *TusService) Handler(docRoot string, next http.Handler) (http.Handler, error) {
+	if t == nil || t.Prefix == "" {
+		return next, nil
+	}
+	store, err := t.newStore(docRoot)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSuffix(t.Prefix, "/") + "/"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == strings.TrimSuffix(prefix, "/") {
+			http.Redirect(w, r, prefix, http.StatusPermanentRedirect)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, prefix) == false {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Tus-Resumable", TusVersion)
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Tus-Version", TusVersion)
+			w.Header().Set("Tus-Extension", "creation,termination")
+			if t.MaxSize > 0 {
+				w.Header().Set("Tus-Max-Size", strconv.FormatInt(t.MaxSize, 10))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Header.Get("Tus-Resumable") != TusVersion {
+			w.Header().Set("Tus-Version", TusVersion)
+			httpError(w, r, http.StatusPreconditionFailed, fmt.Errorf("unsupported tus version"))
+			return
+		}
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		if id == "" {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", "OPTIONS, POST")
+				httpError(w, r, http.StatusMethodNotAllowed, nil)
+				return
+			}
+			t.create(w, r, store, prefix)
+			return
+		}
+		if validTusID(id) == false {
+			httpError(w, r, http.StatusNotFound, nil)
+			return
+		}
+		unlock := t.lock(id)
+		defer unlock()
+		upload, err := store.Info(id)
+		if err != nil {
+			httpError(w, r, http.StatusNotFound, nil)
+			return
+		}
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+			if len(upload.Metadata) > 0 {
+				w.Header().Set("Upload-Metadata", encodeTusMetadata(upload.Metadata))
+			}
+			w.WriteHeader(http.StatusOK)
+		case http.MethodPatch:
+			t.patch(w, r, store, upload)
+		case http.MethodDelete:
+			if err := store.Terminate(id); err != nil {
+				httpError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			t.locks.Delete(id)
+			w.WriteHeader(http.StatusNoContent)
+			ResponseLogger(r, http.StatusNoContent, nil)
+		default:
+			w.Header().Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+			httpError(w, r, http.StatusMethodNotAllowed, nil)
+		}
+	}), nil
+}
+
+// create handles the creation extension's POST.
+func (t *TusService) create(w http.ResponseWriter, r *http.Request, store TusStore, prefix string) {
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		httpError(w, r, http.StatusBadRequest, fmt.Errorf("invalid Upload-Length"))
+		return
+	}
+	if t.MaxSize > 0 && size > t.MaxSize {
+		httpError(w, r, http.StatusRequestEntityTooLarge, fmt.Errorf("upload exceeds %d bytes", t.MaxSize))
+		return
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		httpError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	upload := &TusUpload{
+		ID:       hex.EncodeToString(buf),
+		Size:     size,
+		Metadata: metadata,
+		Created:  time.Now().UTC(),
+	}
+	if err := store.Create(upload); err != nil {
+		httpError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if upload.Complete() && t.OnComplete != nil {
+		t.OnComplete(upload)
+	}
+	w.Header().Set("Location", prefix+upload.ID)
+	w.WriteHeader(http.StatusCreated)
+	ResponseLogger(r, http.StatusCreated, nil)
+}
+
+// patch appends the request body to an upload.
+func (t *TusService) patch(w http.ResponseWriter, r *http.Request, store TusStore, upload *TusUpload) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		httpError(w, r, http.StatusUnsupportedMediaType, fmt.Errorf("expected application/offset+octet-stream"))
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		httpError(w, r, http.StatusBadRequest, fmt.Errorf("invalid Upload-Offset"))
+		return
+	}
+	if offset != upload.Offset {
+		httpError(w, r, http.StatusConflict, fmt.Errorf("Upload-Offset %d does not match %d", offset, upload.Offset))
+		return
+	}
+	n, err := store.WriteChunk(upload.ID, offset, r.Body)
+	upload.Offset = offset + n
+	if err != nil {
+		// The client will ask for the offset with HEAD and resume.
+		httpError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if upload.Complete() && t.OnComplete != nil {
+		t.OnComplete(upload)
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+	ResponseLogger(r, http.StatusNoContent, nil)
+}
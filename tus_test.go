@@ -0,0 +1,129 @@
+// tus_test.go test routines for tus.go
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTusResume(t *testing.T) {
+	store := &TusFileStore{Directory: t.TempDir()}
+	completed := ""
+	tus := &TusService{Prefix: "/deposits/", Store: store, OnComplete: func(u *TusUpload) {
+		completed = u.Metadata["filename"]
+	}}
+	h, err := tus.Handler("", http.NotFoundHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method string, p string, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, p, strings.NewReader(body))
+		req.Header.Set("Tus-Resumable", TusVersion)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("POST", "/deposits", "", nil); rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != "/deposits/" {
+		t.Errorf("expected the bare prefix redirected, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	rec := do("POST", "/deposits/", "", map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": "filename ZGF0YS5jc3Y=",
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rec.Code)
+	}
+	location := rec.Header().Get("Location")
+	patch := map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}
+	if rec = do("PATCH", location, "hello", patch); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	// Resuming from the wrong offset is a conflict.
+	if rec = do("PATCH", location, " world", patch); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d", rec.Code)
+	}
+	rec = do("HEAD", location, "", nil)
+	if offset := rec.Header().Get("Upload-Offset"); offset != "5" {
+		t.Fatalf("expected offset 5, got %q", offset)
+	}
+	patch["Upload-Offset"] = "5"
+	if rec = do("PATCH", location, " world", patch); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if completed != "data.csv" {
+		t.Errorf("expected OnComplete for data.csv, got %q", completed)
+	}
+	id := strings.TrimPrefix(location, "/deposits/")
+	src, err := os.ReadFile(store.Path(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != "hello world" {
+		t.Errorf("unexpected content %q", src)
+	}
+}
+
+func TestTusDirectory(t *testing.T) {
+	dir := t.TempDir()
+	next := http.NotFoundHandler()
+	if _, err := (&TusService{Prefix: "/deposits/"}).Handler(dir, next); err == nil {
+		t.Errorf("expected an error without a directory")
+	}
+	if _, err := (&TusService{Prefix: "/deposits/", Directory: filepath.Join(dir, "deposits")}).Handler(dir, next); err == nil {
+		t.Errorf("expected an error for a directory inside the document root")
+	}
+	if _, err := (&TusService{Prefix: "/deposits/", Directory: dir + "-deposits"}).Handler(dir, next); err != nil {
+		t.Errorf("expected a directory beside the document root to work, %s", err)
+	}
+}
+
+func TestTusAccess(t *testing.T) {
+	docRoot, deposits := t.TempDir(), t.TempDir()
+	ws := &WebService{
+		DocRoot: docRoot,
+		Access:  &Access{AuthType: "basic", Encryption: "argon2id", Routes: []string{"/deposits/"}},
+		Tus:     &TusService{Prefix: "/deposits/", Directory: deposits},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without its trailing slash the prefix is still protected.
+	for _, p := range []string{"/deposits", "/deposits/"} {
+		req := httptest.NewRequest("POST", p, nil)
+		req.Header.Set("Tus-Resumable", TusVersion)
+		req.Header.Set("Upload-Length", "0")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("POST %s, expected 401, got %d", p, rec.Code)
+		}
+	}
+	if entries, _ := os.ReadDir(deposits); len(entries) != 0 {
+		t.Errorf("expected no uploads, got %d files", len(entries))
+	}
+}
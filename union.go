@@ -0,0 +1,125 @@
+// union.go layers several http.FileSystem values into one so shared
+// assets (e.g. a theme directory) can sit beneath many site roots.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// UnionFileSystem searches its layers in order, the first layer
+// holding a path wins. Directory listings merge the entries of every
+// layer holding that directory.
+type UnionFileSystem []http.FileSystem
+
+// unionDir is a directory present in one or more layers.
+type unionDir struct {
+	http.File
+	others  []http.File
+	entries []fs.FileInfo
+	read    bool
+	pos     int
+}
+
+// Close closes the directory in every layer.
+func (d *unionDir) Close() error {
+	for _, f := range d.others {
+		f.Close()
+	}
+	return d.File.Close()
+}
+
+// Readdir returns the merged listing, earlier layers hide later
+// entries with the same name.
+func (d *unionDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if d.read == false {
+		seen := map[string]bool{}
+		for _, f := range append([]http.File{d.File}, d.others...) {
+			ls, err := f.Readdir(-1)
+			if err != nil {
+				return nil, err
+			}
+			for _, info := range ls {
+				if seen[info.Name()] == false {
+					seen[info.Name()] = true
+					d.entries = append(d.entries, info)
+				}
+			}
+		}
+		sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].Name() < d.entries[j].Name() })
+		d.read = true
+	}
+	entries := d.entries[d.pos:]
+	if count > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		if count < len(entries) {
+			entries = entries[0:count]
+		}
+	}
+	d.pos += len(entries)
+	return entries, nil
+}
+
+// Open implements http.FileSystem.
+func (u UnionFileSystem) Open(name string) (http.File, error) {
+	var (
+		found  http.File
+		others []http.File
+		first  error
+	)
+	for _, layer := range u {
+		f, err := layer.Open(name)
+		if err != nil {
+			if first == nil || errors.Is(first, os.ErrNotExist) {
+				first = err
+			}
+			continue
+		}
+		if found == nil {
+			info, err := f.Stat()
+			if err != nil || info.IsDir() == false {
+				return f, err
+			}
+			found = f
+			continue
+		}
+		// Only directories from later layers are kept for merging.
+		if info, err := f.Stat(); err == nil && info.IsDir() {
+			others = append(others, f)
+		} else {
+			f.Close()
+		}
+	}
+	if found == nil {
+		if first == nil {
+			first = os.ErrNotExist
+		}
+		return nil, first
+	}
+	if len(others) == 0 {
+		return found, nil
+	}
+	return &unionDir{File: found, others: others}, nil
+}
@@ -0,0 +1,71 @@
+// union_test.go test routines for union.go
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnionFileSystem(t *testing.T) {
+	site, theme := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		filepath.Join(site, "index.html"):          "site index",
+		filepath.Join(site, "css", "site.css"):     "site css",
+		filepath.Join(theme, "index.html"):         "theme index",
+		filepath.Join(theme, "css", "theme.css"):   "theme css",
+		filepath.Join(theme, "css", ".secret.css"): "hidden",
+	}
+	for fName, src := range files {
+		os.MkdirAll(filepath.Dir(fName), 0775)
+		if err := os.WriteFile(fName, []byte(src), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws := &WebService{DocRoot: site, DocRootLayers: []string{theme}}
+	sfs, err := ws.SafeFileSystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.FileServer(sfs)
+	expected := map[string]string{
+		"/":              "site index",
+		"/css/site.css":  "site css",
+		"/css/theme.css": "theme css",
+	}
+	for p, body := range expected {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+		if rec.Body.String() != body {
+			t.Errorf("%s expected %q, got %d %q", p, body, rec.Code, rec.Body.String())
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/css/", nil))
+	listing := rec.Body.String()
+	if strings.Contains(listing, "site.css") == false || strings.Contains(listing, "theme.css") == false {
+		t.Errorf("expected merged listing, got %q", listing)
+	}
+	if strings.Contains(listing, ".secret.css") {
+		t.Errorf("dot files should not be listed, got %q", listing)
+	}
+}
@@ -0,0 +1,210 @@
+// upload.go provides an optional file upload handler so staff can drop
+// files onto a site without shell access. Uploads must be covered by
+// the Access routes of the web service.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultUploadMaxSize is the upload size limit when MaxSize isn't set.
+const DefaultUploadMaxSize = 32 << 20
+
+// UploadService describes where uploads are accepted and stored.
+type UploadService struct {
+	// Prefix is the URL path prefix accepting PUT and POST, e.g. "/upload/"
+	Prefix string `json:"prefix" toml:"prefix"`
+	// Directory receives the uploaded files, it defaults to the
+	// document root.
+	Directory string `json:"directory,omitempty" toml:"directory,omitempty"`
+	// MaxSize is the largest upload in bytes.
+	MaxSize int64 `json:"max_size,omitempty" toml:"max_size,omitempty"`
+	// AllowedExtensions lists the file extensions accepted (e.g. ".pdf").
+	// If empty any extension is accepted.
+	AllowedExtensions []string `json:"allowed_extensions,omitempty" toml:"allowed_extensions,omitempty"`
+	// Overwrite allows replacing existing files.
+	Overwrite bool `json:"overwrite,omitempty" toml:"overwrite,omitempty"`
+}
+
+// UploadResult describes a stored file in the upload response.
+type UploadResult struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// allowedExtension checks name against AllowedExtensions.
+func (u *UploadService) allowedExtension(name string) bool {
+	if len(u.AllowedExtensions) == 0 {
+		return true
+	}
+	ext := strings.ToLower(path.Ext(name))
+	for _, allowed := range u.AllowedExtensions {
+		if strings.ToLower(allowed) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// target maps a URL path below the upload prefix to a file in the
+// upload directory, rejecting dot paths and disallowed extensions.
+func (u *UploadService) target(dir string, p string) (string, string, error) {
+	rel, err := SafePath(strings.TrimPrefix(p, u.Prefix))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid filename, %s", err)
+	}
+	if rel == "/" || strings.HasSuffix(p, "/") {
+		return "", "", fmt.Errorf("missing filename")
+	}
+	if u.allowedExtension(rel) == false {
+		return "", "", fmt.Errorf("%q is not an allowed file type", path.Ext(rel))
+	}
+	return filepath.Join(dir, filepath.FromSlash(rel)), path.Join(u.Prefix, rel), nil
+}
+
+// store writes src to fName via a temporary file so partial uploads
+// never replace existing content.
+func (u *UploadService) store(fName string, src io.Reader) (int64, error) {
+	if u.Overwrite == false {
+		if _, err := os.Stat(fName); err == nil {
+			return 0, os.ErrExist
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(fName), 0775); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fName), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	size, err := io.Copy(tmp, src)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(tmp.Name(), 0664); err != nil {
+		return 0, err
+	}
+	if u.Overwrite {
+		return size, os.Rename(tmp.Name(), fName)
+	}
+	// Link fails if another upload got there first, the stat above
+	// only saves reading the body.
+	return size, os.Link(tmp.Name(), fName)
+}
+
+// uploadError maps storage errors to a status code and writes them.
+func uploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxErr *http.MaxBytesError
+	switch {
+	case os.IsExist(err):
+		httpError(w, r, http.StatusConflict, err)
+	case errors.As(err, &maxErr):
+		httpError(w, r, http.StatusRequestEntityTooLarge, err)
+	default:
+		httpError(w, r, http.StatusBadRequest, err)
+	}
+}
+
+// Handler accepts PUT (request body is the file) and POST
+// (multipart/form-data, every file part is stored) requests below
+// Prefix, writing them into the upload directory. Other requests are
+// passed to next. docRoot is used when Directory isn't set.
+func (u *UploadService) Handler(docRoot string, next http.Handler) http.Handler {
+	if u == nil || u.Prefix == "" {
+		return next
+	}
+	dir := u.Directory
+	if dir == "" {
+		dir = docRoot
+	}
+	maxSize := u.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultUploadMaxSize
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, u.Prefix) == false ||
+			(r.Method != http.MethodPut && r.Method != http.MethodPost) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+		results := []*UploadResult{}
+		switch r.Method {
+		case http.MethodPut:
+			fName, urlPath, err := u.target(dir, r.URL.Path)
+			if err != nil {
+				httpError(w, r, http.StatusBadRequest, err)
+				return
+			}
+			size, err := u.store(fName, r.Body)
+			if err != nil {
+				uploadError(w, r, err)
+				return
+			}
+			results = append(results, &UploadResult{Path: urlPath, Size: size})
+		case http.MethodPost:
+			mr, err := r.MultipartReader()
+			if err != nil {
+				httpError(w, r, http.StatusBadRequest, err)
+				return
+			}
+			for {
+				part, err := mr.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					uploadError(w, r, err)
+					return
+				}
+				if part.FileName() == "" {
+					continue
+				}
+				fName, urlPath, err := u.target(dir, path.Join(r.URL.Path, path.Base(part.FileName())))
+				if err != nil {
+					httpError(w, r, http.StatusBadRequest, err)
+					return
+				}
+				size, err := u.store(fName, part)
+				if err != nil {
+					uploadError(w, r, err)
+					return
+				}
+				results = append(results, &UploadResult{Path: urlPath, Size: size})
+			}
+			if len(results) == 0 {
+				httpError(w, r, http.StatusBadRequest, fmt.Errorf("no files found in upload"))
+				return
+			}
+		}
+		JSONResponse(w, r, http.StatusCreated, results)
+	})
+}
@@ -0,0 +1,100 @@
+// upload_test.go test routines for upload.go
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadPut(t *testing.T) {
+	dir := t.TempDir()
+	u := &UploadService{Prefix: "/upload/", AllowedExtensions: []string{".txt"}, MaxSize: 16}
+	h := u.Handler(dir, http.NotFoundHandler())
+
+	tests := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/upload/notes/hello.txt", "hello world", http.StatusCreated},
+		{"/upload/notes/hello.txt", "hello again", http.StatusConflict},
+		{"/upload/script.sh", "rm -fR /", http.StatusBadRequest},
+		{"/upload/.htaccess.txt", "deny", http.StatusBadRequest},
+		{"/upload/big.txt", strings.Repeat("x", 32), http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("PUT", test.path, strings.NewReader(test.body)))
+		if rec.Code != test.status {
+			t.Errorf("PUT %s expected %d, got %d", test.path, test.status, rec.Code)
+		}
+	}
+	src, err := os.ReadFile(filepath.Join(dir, "notes", "hello.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != "hello world" {
+		t.Errorf("unexpected content %q", src)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "big.txt")); err == nil {
+		t.Errorf("partial upload should not have been stored")
+	}
+}
+
+// racingReader writes fName, as a concurrent upload would, once
+// the stat in store has passed.
+type racingReader struct {
+	fName string
+	done  bool
+}
+
+func (r *racingReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	r.done = true
+	os.WriteFile(r.fName, []byte("first"), 0664)
+	return copy(p, "second"), nil
+}
+
+func TestUploadRace(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "report.csv")
+	u := &UploadService{Prefix: "/uploads/"}
+	if _, err := u.store(fName, &racingReader{fName: fName}); os.IsExist(err) == false {
+		t.Errorf("expected the second upload to fail, got %v", err)
+	}
+	if src, _ := os.ReadFile(fName); string(src) != "first" {
+		t.Errorf("expected the first upload kept, got %q", src)
+	}
+	u.Overwrite = true
+	if _, err := u.store(fName, strings.NewReader("third")); err != nil {
+		t.Fatal(err)
+	}
+	if src, _ := os.ReadFile(fName); string(src) != "third" {
+		t.Errorf("expected an overwrite, got %q", src)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(fName)); len(entries) != 1 {
+		t.Errorf("expected the temporary files removed, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,155 @@
+// userinfo.go describes a user's account, without the password hash,
+// for troubleshooting.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// UserInfo describes a user's account leaving out the password hash.
+type UserInfo struct {
+	Username string `json:"username"`
+	// Encryption is the scheme the password is stored with.
+	Encryption string `json:"encryption"`
+	// PendingRehash is true when Encryption isn't the Access's and
+	// the password will be rehashed at the next login.
+	PendingRehash bool          `json:"pending_rehash,omitempty"`
+	Argon2        *Argon2Params `json:"argon2,omitempty"`
+	PBKDF2        *PBKDF2Params `json:"pbkdf2,omitempty"`
+	SaltLength    int           `json:"salt_length"`
+	KeyLength     int           `json:"key_length"`
+	CreatedAt     *time.Time    `json:"created_at,omitempty"`
+	UpdatedAt     *time.Time    `json:"updated_at,omitempty"`
+	LastLoginAt   *time.Time    `json:"last_login_at,omitempty"`
+	ExpiresAt     *time.Time    `json:"expires_at,omitempty"`
+	Disabled      bool          `json:"disabled,omitempty"`
+	Groups        []string      `json:"groups,omitempty"`
+	// MissingGroups are Groups the access file no longer defines,
+	// they grant no routes.
+	MissingGroups []string `json:"missing_groups,omitempty"`
+	// Restricted is true when the user may only use Routes.
+	Restricted bool `json:"restricted,omitempty"`
+	// Routes granted directly or through Groups, each listed once.
+	Routes []string `json:"routes,omitempty"`
+}
+
+// UserInfo returns the details of username's account.
+func (a *Access) UserInfo(username string) (*UserInfo, error) {
+	secret, err := a.store().Lookup(username)
+	if err != nil {
+		return nil, err
+	}
+	info := &UserInfo{
+		Username:      username,
+		Encryption:    secret.Encryption,
+		PendingRehash: a.needsRehash(secret),
+		Argon2:        secret.Argon2,
+		PBKDF2:        secret.PBKDF2,
+		SaltLength:    len(secret.Salt),
+		KeyLength:     len(secret.Key),
+		CreatedAt:     secret.CreatedAt,
+		UpdatedAt:     secret.UpdatedAt,
+		LastLoginAt:   secret.LastLoginAt,
+		ExpiresAt:     secret.ExpiresAt,
+		Disabled:      secret.Disabled,
+		Groups:        secret.Groups,
+		Restricted:    unrestricted(secret) == false,
+	}
+	seen := map[string]bool{}
+	addRoutes := func(routes []string) {
+		for _, route := range routes {
+			if seen[route] == false {
+				seen[route] = true
+				info.Routes = append(info.Routes, route)
+			}
+		}
+	}
+	addRoutes(secret.Routes)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if info.Encryption == "" {
+		info.Encryption = a.Encryption
+	}
+	for _, group := range secret.Groups {
+		routes, ok := a.Groups[group]
+		if ok == false {
+			info.MissingGroups = append(info.MissingGroups, group)
+		}
+		addRoutes(routes)
+	}
+	return info, nil
+}
+
+// Write writes the details as "name: value" lines.
+func (info *UserInfo) Write(w io.Writer) error {
+	timestamp := func(t *time.Time, unset string) string {
+		if t == nil {
+			return unset
+		}
+		return t.Format(time.RFC3339)
+	}
+	encryption := info.Encryption
+	switch {
+	case info.Argon2 != nil:
+		p := info.Argon2
+		encryption += fmt.Sprintf(" (time %d, memory %d KiB, threads %d)", p.Time, p.Memory, p.Threads)
+	case info.PBKDF2 != nil:
+		p := info.PBKDF2
+		encryption += fmt.Sprintf(" (%d iterations, %s)", p.Iterations, p.Hash)
+	}
+	if info.PendingRehash {
+		encryption += ", rehashed at next login"
+	}
+	groups := []string{}
+	for _, group := range info.Groups {
+		if _, missing := without(info.MissingGroups, group); missing {
+			group += " (no such group)"
+		}
+		groups = append(groups, group)
+	}
+	routes := strings.Join(info.Routes, " ")
+	switch {
+	case info.Restricted == false:
+		routes = "all protected routes"
+	case routes == "":
+		routes = "none"
+	}
+	lines := [][2]string{
+		{"username", info.Username},
+		{"encryption", encryption},
+		{"salt", fmt.Sprintf("%d bytes", info.SaltLength)},
+		{"key", fmt.Sprintf("%d bytes", info.KeyLength)},
+		{"created", timestamp(info.CreatedAt, "unknown")},
+		{"updated", timestamp(info.UpdatedAt, "unknown")},
+		{"last login", timestamp(info.LastLoginAt, "unknown")},
+		{"expires", timestamp(info.ExpiresAt, "never")},
+		{"disabled", fmt.Sprintf("%t", info.Disabled)},
+		{"groups", strings.Join(groups, ", ")},
+		{"routes", routes},
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", line[0], line[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// userinfo_test.go tests describing a user's account.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestUserInfo(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/intranet/", "/reports/"}}
+	if err := a.UpdateUser("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	created, _ := a.Lookup("Jane.Doe")
+	if created.CreatedAt == nil || created.UpdatedAt == nil {
+		t.Fatalf("expected created and updated times")
+	}
+	if err := a.Grant("@staff", "/intranet/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Grant("Jane.Doe", "@staff"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Grant("Jane.Doe", "/reports/"); err != nil {
+		t.Fatal(err)
+	}
+	// Routes the user has twice are listed once, groups the access
+	// file doesn't define grant nothing.
+	if err := a.Grant("@staff", "/reports/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Grant("Jane.Doe", "@archivists"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.UpdateUser("Jane.Doe", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := a.UserInfo("Jane.Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CreatedAt == nil || info.CreatedAt.Equal(*created.CreatedAt) == false {
+		t.Errorf("expected the creation time to be kept")
+	}
+	if info.Encryption != "md5" || info.KeyLength != 16 {
+		t.Errorf("unexpected encryption %q, key length %d", info.Encryption, info.KeyLength)
+	}
+	if strings.Join(info.Routes, " ") != "/reports/ /intranet/" {
+		t.Errorf("unexpected routes %q", info.Routes)
+	}
+	if strings.Join(info.MissingGroups, " ") != "archivists" {
+		t.Errorf("unexpected missing groups %q", info.MissingGroups)
+	}
+	buf := new(bytes.Buffer)
+	if err := info.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"username: Jane.Doe\n", "groups: staff, archivists (no such group)\n", "routes: /reports/ /intranet/\n", "expires: never\n"} {
+		if strings.Contains(buf.String(), expected) == false {
+			t.Errorf("expected %q in %s", expected, buf)
+		}
+	}
+	if _, err := a.UserInfo("John.Doe"); err == nil {
+		t.Errorf("expected an error for an unknown user")
+	}
+}
@@ -0,0 +1,229 @@
+// webhook.go posts notifications about lifecycle and error events to
+// Slack, Teams or generic JSON webhooks.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook event names.
+const (
+	EventStartup      = "startup"
+	EventShutdown     = "shutdown"
+	EventCertExpiry   = "cert_expiry"
+	EventServerErrors = "server_errors"
+	EventAuthLockout  = "auth_lockout"
+)
+
+var (
+	// WebhookTimeout limits each webhook request.
+	WebhookTimeout = 10 * time.Second
+	// ServerErrorThreshold 5xx responses within ServerErrorWindow
+	// send EventServerErrors (at most once per window).
+	ServerErrorThreshold = 10
+	ServerErrorWindow    = time.Minute
+	// AuthLockoutThreshold failed logins from one address within
+	// AuthLockoutWindow send EventAuthLockout.
+	AuthLockoutThreshold = 5
+	AuthLockoutWindow    = 5 * time.Minute
+)
+
+// Webhook describes where to send event notifications.
+type Webhook struct {
+	// URL to POST to.
+	URL string `json:"url" toml:"url"`
+	// Format is "slack", "teams" or "json" (the default) which posts
+	// a WebhookEvent.
+	Format string `json:"format,omitempty" toml:"format,omitempty"`
+	// Events limits the events sent, all events if empty.
+	Events []string `json:"events,omitempty" toml:"events,omitempty"`
+}
+
+// WebhookEvent is posted to "json" webhooks.
+type WebhookEvent struct {
+	Event   string    `json:"event"`
+	Message string    `json:"message"`
+	Host    string    `json:"host,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// wants reports if the webhook should receive event.
+func (hook *Webhook) wants(event string) bool {
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// payload returns the request body for ev in the webhook's format.
+func (hook *Webhook) payload(ev *WebhookEvent) ([]byte, error) {
+	switch strings.ToLower(hook.Format) {
+	case "slack", "teams":
+		text := fmt.Sprintf("[%s] %s: %s", ev.Host, ev.Event, ev.Message)
+		return json.Marshal(map[string]string{"text": text})
+	case "", "json":
+		return json.Marshal(ev)
+	default:
+		return nil, fmt.Errorf("unsupported webhook format %q", hook.Format)
+	}
+}
+
+// send posts ev to the webhook.
+func (hook *Webhook) send(client *http.Client, ev *WebhookEvent) error {
+	src, err := hook.payload(ev)
+	if err != nil {
+		return err
+	}
+	res, err := client.Post(hook.URL, "application/json", bytes.NewReader(src))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s, %s", hook.URL, res.Status)
+	}
+	return nil
+}
+
+// notifier sends events to the WebService's webhooks.
+type notifier struct {
+	wg     sync.WaitGroup
+	client *http.Client
+}
+
+// Notify sends event to the configured webhooks in the background.
+func (w *WebService) Notify(event string, message string) {
+	if len(w.Webhooks) == 0 {
+		return
+	}
+	w.notifyOnce.Do(func() {
+		w.notify = &notifier{client: &http.Client{Timeout: WebhookTimeout}}
+	})
+	host, _ := os.Hostname()
+	ev := &WebhookEvent{Event: event, Message: message, Host: host, Time: time.Now().UTC()}
+	for _, hook := range w.Webhooks {
+		if hook.wants(event) == false {
+			continue
+		}
+		w.notify.wg.Add(1)
+		go func(hook *Webhook) {
+			defer w.notify.wg.Done()
+			if err := hook.send(w.notify.client, ev); err != nil {
+				log.Printf("webhook %s, %s", event, err)
+			}
+		}(hook)
+	}
+}
+
+// waitNotify waits for webhooks in flight, e.g. before exiting.
+func (w *WebService) waitNotify() {
+	if w.notify != nil {
+		w.notify.wg.Wait()
+	}
+}
+
+// eventWatch counts events per key within a window, reporting when
+// the threshold is reached (once per window).
+type eventWatch struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	seen      map[string][]time.Time
+}
+
+func newEventWatch(threshold int, window time.Duration) *eventWatch {
+	return &eventWatch{threshold: threshold, window: window, seen: map[string][]time.Time{}}
+}
+
+// add records an event for key and reports if it reached the threshold.
+func (ew *eventWatch) add(key string) bool {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	now := time.Now()
+	times := ew.seen[key][:0]
+	for _, t := range ew.seen[key] {
+		if now.Sub(t) < ew.window {
+			times = append(times, t)
+		}
+	}
+	times = append(times, now)
+	ew.seen[key] = times
+	// Forget stale keys so the map doesn't grow without bound.
+	if len(ew.seen) > 10000 {
+		for k, v := range ew.seen {
+			if now.Sub(v[len(v)-1]) >= ew.window {
+				delete(ew.seen, k)
+			}
+		}
+	}
+	return len(times) == ew.threshold
+}
+
+// serverErrorHandler sends EventServerErrors when 5xx responses
+// reach ServerErrorThreshold within ServerErrorWindow.
+func (w *WebService) serverErrorHandler(next http.Handler) http.Handler {
+	ew := newEventWatch(ServerErrorThreshold, ServerErrorWindow)
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		sw := newStatusWriter(res)
+		next.ServeHTTP(sw, req)
+		if sw.Status() >= 500 && ew.add("") {
+			w.Notify(EventServerErrors, fmt.Sprintf("%d server errors in %s, last %d for %s", ServerErrorThreshold, ServerErrorWindow, sw.Status(), req.URL.Path))
+		}
+	})
+}
+
+// authLockout records a failed login from the request's address,
+// sending EventAuthLockout when AuthLockoutThreshold is reached.
+func (a *Access) authLockout(r *http.Request, username string) {
+	a.mu.RLock()
+	notify := a.notify
+	a.mu.RUnlock()
+	if notify == nil {
+		return
+	}
+	a.lockoutOnce.Do(func() {
+		a.lockouts = newEventWatch(AuthLockoutThreshold, AuthLockoutWindow)
+	})
+	ip := clientIP(r)
+	if a.lockouts.add(ip) {
+		notify(EventAuthLockout, fmt.Sprintf("%d failed logins from %s within %s, last for %q", AuthLockoutThreshold, ip, AuthLockoutWindow, username))
+	}
+}
+
+// SetNotify sets the function called with EventAuthLockout when an
+// address repeatedly fails to log in, WebService.Handler sets it to
+// WebService.Notify.
+func (a *Access) SetNotify(notify func(event string, message string)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.notify = notify
+}
@@ -0,0 +1,78 @@
+// webhook_test.go tests webhook notifications.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestWebhooks(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []map[string]interface{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&m)
+		mu.Lock()
+		received = append(received, m)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/private/"}, SessionSeconds: -1}
+	a.UpdateAccess("Jane.Doe", "secret")
+	ws, _ := NewWebService(WithDocRoot(t.TempDir()), WithAccess(a), WithHandler("/broken", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusBadGateway)
+	})))
+	ws.Webhooks = []*Webhook{
+		{URL: srv.URL},
+		{URL: srv.URL, Format: "slack", Events: []string{EventAuthLockout}},
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < ServerErrorThreshold+2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/broken", nil))
+	}
+	for i := 0; i < AuthLockoutThreshold; i++ {
+		req := httptest.NewRequest("GET", "/private/", nil)
+		req.SetBasicAuth("Jane.Doe", "wrong")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	ws.waitNotify()
+
+	events := map[string]int{}
+	slack := 0
+	for _, m := range received {
+		if event, ok := m["event"].(string); ok {
+			events[event]++
+		}
+		if _, ok := m["text"]; ok {
+			slack++
+		}
+	}
+	if events[EventServerErrors] != 1 || events[EventAuthLockout] != 1 || slack != 1 {
+		t.Errorf("expected one of each event, got %v and %d slack messages", events, slack)
+	}
+}
@@ -0,0 +1,416 @@
+// websocket.go provides minimal WebSocket (RFC 6455) support, an
+// upgrade helper, per connection context and a broadcast hub, so wsfn
+// services can push live updates without a second framework.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket message types (frame opcodes) used by ReadMessage and
+// WriteMessage.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	closeMessage  = 8
+	pingMessage   = 9
+	pongMessage   = 10
+)
+
+// DefaultMaxMessageSize is the largest message accepted from a client
+// unless WebSocketUpgrader.MaxMessageSize says otherwise.
+const DefaultMaxMessageSize = 1 << 20
+
+// websocketGUID is the fixed GUID from RFC 6455 section 1.3.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocketUpgrader holds the settings used to upgrade a request
+// to a WebSocket connection.
+type WebSocketUpgrader struct {
+	// CheckOrigin decides if a request's Origin is allowed. If nil
+	// the Origin header, when present, must match the request's Host.
+	CheckOrigin func(r *http.Request) bool
+	// MaxMessageSize limits the size of messages read from the client.
+	MaxMessageSize int64
+}
+
+// WebSocket is an upgraded connection. Writes are safe to call from
+// multiple go routines, reads should happen from a single go routine.
+type WebSocket struct {
+	// Request is the request that was upgraded.
+	Request *http.Request
+
+	conn    net.Conn
+	br      *bufio.Reader
+	wmu     sync.Mutex
+	maxSize int64
+	ctx     context.Context
+	cancel  context.CancelFunc
+	once    sync.Once
+}
+
+// headerHasToken checks a comma separated header for a token
+// ignoring case.
+func headerHasToken(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin allows requests without an Origin or whose Origin host
+// matches the Host header.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// UpgradeWebSocket upgrades the request using the default settings.
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
+	return new(WebSocketUpgrader).Upgrade(w, r)
+}
+
+// Upgrade performs the WebSocket handshake and returns the connection.
+// On failure an error response has already been written.
+func (u *WebSocketUpgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
+	if r.Method != http.MethodGet ||
+		headerHasToken(r.Header, "Connection", "upgrade") == false ||
+		headerHasToken(r.Header, "Upgrade", "websocket") == false {
+		err := fmt.Errorf("not a websocket handshake")
+		httpError(w, r, http.StatusBadRequest, err)
+		return nil, err
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		err := fmt.Errorf("unsupported websocket version")
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		httpError(w, r, http.StatusUpgradeRequired, err)
+		return nil, err
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		err := fmt.Errorf("missing Sec-WebSocket-Key")
+		httpError(w, r, http.StatusBadRequest, err)
+		return nil, err
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if checkOrigin(r) == false {
+		err := fmt.Errorf("origin %q not allowed", r.Header.Get("Origin"))
+		httpError(w, r, http.StatusForbidden, err)
+		return nil, err
+	}
+	hj, ok := w.(http.Hijacker)
+	if ok == false {
+		err := fmt.Errorf("response writer does not support hijacking")
+		httpError(w, r, http.StatusInternalServerError, err)
+		return nil, err
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, err)
+		return nil, err
+	}
+	h := sha1.New()
+	io.WriteString(h, key+websocketGUID)
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", accept)
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ResponseLogger(r, http.StatusSwitchingProtocols, nil)
+	ws := &WebSocket{
+		Request: r,
+		conn:    conn,
+		br:      brw.Reader,
+		maxSize: u.MaxMessageSize,
+	}
+	if ws.maxSize <= 0 {
+		ws.maxSize = DefaultMaxMessageSize
+	}
+	ws.ctx, ws.cancel = context.WithCancel(context.Background())
+	return ws, nil
+}
+
+// Context returns a context that is canceled when the connection closes.
+func (ws *WebSocket) Context() context.Context {
+	return ws.ctx
+}
+
+// readFrame reads a single frame returning fin, opcode and payload.
+func (ws *WebSocket) readFrame() (bool, int, []byte, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(ws.br, head); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := int(head[0] & 0x0f)
+	masked := head[1]&0x80 != 0
+	size := int64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(ws.br, buf); err != nil {
+			return false, 0, nil, err
+		}
+		size = int64(binary.BigEndian.Uint16(buf))
+	case 127:
+		buf := make([]byte, 8)
+		if _, err := io.ReadFull(ws.br, buf); err != nil {
+			return false, 0, nil, err
+		}
+		size = int64(binary.BigEndian.Uint64(buf))
+	}
+	if masked == false {
+		return false, 0, nil, fmt.Errorf("client frames must be masked")
+	}
+	if size < 0 || size > ws.maxSize {
+		return false, 0, nil, fmt.Errorf("websocket message too large")
+	}
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(ws.br, mask); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// ReadMessage returns the next text or binary message from the client.
+// Pings are answered automatically. When the client closes the
+// connection io.EOF is returned.
+func (ws *WebSocket) ReadMessage() (int, []byte, error) {
+	var (
+		messageType int
+		message     []byte
+	)
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			ws.Close()
+			return 0, nil, err
+		}
+		switch opcode {
+		case pingMessage:
+			if err := ws.writeFrame(pongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case pongMessage:
+			continue
+		case closeMessage:
+			ws.writeFrame(closeMessage, payload)
+			ws.Close()
+			return 0, nil, io.EOF
+		case 0:
+			if messageType == 0 {
+				ws.Close()
+				return 0, nil, fmt.Errorf("unexpected continuation frame")
+			}
+		case TextMessage, BinaryMessage:
+			messageType = opcode
+		default:
+			ws.Close()
+			return 0, nil, fmt.Errorf("unsupported opcode %d", opcode)
+		}
+		message = append(message, payload...)
+		if int64(len(message)) > ws.maxSize {
+			ws.Close()
+			return 0, nil, fmt.Errorf("websocket message too large")
+		}
+		if fin {
+			return messageType, message, nil
+		}
+	}
+}
+
+// writeFrame writes a single unmasked frame with FIN set.
+func (ws *WebSocket) writeFrame(opcode int, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	head := []byte{0x80 | byte(opcode)}
+	size := len(payload)
+	switch {
+	case size < 126:
+		head = append(head, byte(size))
+	case size <= 0xffff:
+		head = append(head, 126, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(size))
+	default:
+		head = append(head, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(head[2:], uint64(size))
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := ws.conn.Write(append(head, payload...)); err != nil {
+		ws.Close()
+		return err
+	}
+	return nil
+}
+
+// WriteMessage sends a TextMessage or BinaryMessage to the client.
+func (ws *WebSocket) WriteMessage(messageType int, data []byte) error {
+	if ws.ctx.Err() != nil {
+		return net.ErrClosed
+	}
+	return ws.writeFrame(messageType, data)
+}
+
+// WriteJSON sends v encoded as JSON in a TextMessage.
+func (ws *WebSocket) WriteJSON(v interface{}) error {
+	src, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.WriteMessage(TextMessage, src)
+}
+
+// Close closes the connection and cancels its context.
+func (ws *WebSocket) Close() error {
+	var err error
+	ws.once.Do(func() {
+		ws.cancel()
+		err = ws.conn.Close()
+	})
+	return err
+}
+
+// WebSocketHandler upgrades requests and calls fn with the connection,
+// closing it when fn returns.
+func WebSocketHandler(fn func(ws *WebSocket)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := UpgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		fn(ws)
+	})
+}
+
+// Hub tracks a set of WebSocket connections and broadcasts messages
+// to all of them, e.g. to push a reading room occupancy count to
+// every display.
+type Hub struct {
+	mu    sync.RWMutex
+	conns map[*WebSocket]bool
+}
+
+// NewHub returns an empty *Hub.
+func NewHub() *Hub {
+	return &Hub{conns: map[*WebSocket]bool{}}
+}
+
+// Join adds ws to the hub. It is removed automatically when closed.
+func (h *Hub) Join(ws *WebSocket) {
+	h.mu.Lock()
+	h.conns[ws] = true
+	h.mu.Unlock()
+	go func() {
+		<-ws.Context().Done()
+		h.Leave(ws)
+	}()
+}
+
+// Leave removes ws from the hub.
+func (h *Hub) Leave(ws *WebSocket) {
+	h.mu.Lock()
+	delete(h.conns, ws)
+	h.mu.Unlock()
+}
+
+// Len returns the number of connections in the hub.
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.conns)
+}
+
+// Broadcast sends a message to every connection in the hub. Connections
+// that fail to receive it are closed.
+func (h *Hub) Broadcast(messageType int, data []byte) {
+	h.mu.RLock()
+	conns := make([]*WebSocket, 0, len(h.conns))
+	for ws := range h.conns {
+		conns = append(conns, ws)
+	}
+	h.mu.RUnlock()
+	for _, ws := range conns {
+		ws.WriteMessage(messageType, data)
+	}
+}
+
+// BroadcastJSON sends v encoded as JSON to every connection in the hub.
+func (h *Hub) BroadcastJSON(v interface{}) error {
+	src, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	h.Broadcast(TextMessage, src)
+	return nil
+}
+
+// Handler upgrades requests, joins them to the hub and passes each
+// message received to onMessage (which may be nil) until the client
+// disconnects.
+func (h *Hub) Handler(onMessage func(ws *WebSocket, messageType int, data []byte)) http.Handler {
+	return WebSocketHandler(func(ws *WebSocket) {
+		h.Join(ws)
+		for {
+			messageType, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if onMessage != nil {
+				onMessage(ws, messageType, data)
+			}
+		}
+	})
+}
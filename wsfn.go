@@ -21,22 +21,35 @@ package wsfn
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"io/ioutil"
 	"log"
+	"math/big"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	// 3rd Party packages
 	"github.com/BurntSushi/toml"
@@ -44,54 +57,56 @@ import (
 	"golang.org/x/crypto/pbkdf2"
 )
 
-// IsDotPath checks to see if a path is requested with a dot file (e.g. docs/.git/* or docs/.htaccess)
-func IsDotPath(p string) bool {
-	for _, part := range strings.Split(path.Clean(p), "/") {
-		if strings.HasPrefix(part, "..") == false && strings.HasPrefix(part, ".") == true && len(part) > 1 {
-			return true
-		}
-	}
-	return false
-}
+var (
+	// ErrUnknownUser is returned when a username isn't in the AccessStore.
+	ErrUnknownUser = errors.New("unknown user")
+	// ErrBadPassword is returned when a password doesn't match.
+	ErrBadPassword = errors.New("password does not match")
+	// ErrUserDisabled is returned when a user's account is disabled.
+	ErrUserDisabled = errors.New("user is disabled")
+	// ErrUserExpired is returned when a user's account has expired.
+	ErrUserExpired = errors.New("user has expired")
+	// ErrUnsupportedScheme is returned for an unknown encryption or hash.
+	ErrUnsupportedScheme = errors.New("unsupported scheme")
+	// ErrRouteCollision is returned when redirect targets overlap.
+	ErrRouteCollision = errors.New("route collision")
+)
 
-// StaticRouter scans the request object to either add a .html extension
-// or prevent serving a dot file path
+// StaticRouter scans the request object to prevent serving a dot
+// file path and to set the Content-Type of static files. It doesn't
+// set CORS headers, wrap it with a CORSPolicy's Handler to allow
+// cross origin requests.
 func StaticRouter(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if origin := r.Header.Get("Origin"); origin != "" {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-			//w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-			w.Header().Set("Access-Control-Allow-Methods", "GET")
-			w.Header().Set("Access-Control-Allow-Headers",
-				"Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-		}
-		// Stop here if its Preflighted OPTIONS request
+		// Static files only support reads, a CORSPolicy in front
+		// of us answers preflight requests.
 		if r.Method == "OPTIONS" {
+			w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
-		// If given a dot file path, send forbidden
-		if IsDotPath(r.URL.Path) == true {
-			http.Error(w, "Forbidden", 403)
-			ResponseLogger(r, 403, fmt.Errorf("Forbidden, requested a dot path"))
+		// If given an unsafe path (e.g. a dot file), send forbidden
+		if _, err := SafePath(r.URL.Path); err != nil {
+			httpError(w, r, http.StatusForbidden, fmt.Errorf("Forbidden, %s", err))
 			return
 		}
-		// Check if we have a gzipped JSON file
-		if strings.HasSuffix(r.URL.Path, ".json.gz") || strings.HasSuffix(r.URL.Path, ".js.gz") {
+		// Check if we have a precompressed file (e.g. a gzipped JSON
+		// file), send it with the underlying type
+		if underlying, ok := precompressed(r.URL.Path, gzipExtensions(DefaultGzipExtensions)); ok {
 			w.Header().Set("Content-Encoding", "gzip")
+			if mimeType := mime.TypeByExtension(path.Ext(underlying)); mimeType != "" {
+				w.Header().Set("Content-Type", mimeType)
+			}
 		}
-		// Check to see if we have a *.mjs JavaScript module.
-		if ext := path.Ext(r.URL.Path); ext == ".mjs" {
-			w.Header().Set("Content-Type", "text/javascript")
-		}
-		// Check to see if we have a *.wasm file, then make sure
-		// we have the correct headers.
-		if ext := path.Ext(r.URL.Path); ext == ".wasm" {
-			w.Header().Set("Content-Type", "application/wasm")
+		// Check if we have a built in default type (e.g. .avif,
+		// .woff2, .wasm)
+		ext := strings.ToLower(path.Ext(r.URL.Path))
+		if mimeType, ok := DefaultContentTypes[ext]; ok {
+			w.Header().Set("Content-Type", mimeType)
 		}
-		// Check to see if we have a JS module file, then make sure
-		// we have the correct headers
-		if ext := path.Ext(r.URL.Path); (ext == ".mjs") || (ext == ".js") {
+		// JavaScript modules must be served as JavaScript.
+		if ext == ".mjs" || ext == ".js" {
 			w.Header().Set("Content-Type", "text/javascript")
 		}
 
@@ -106,15 +121,26 @@ func StaticRouter(next http.Handler) http.Handler {
 
 // RedirectService holds our redirect targets in an ordered list
 // and a map to our applied routes.
+//
+// The routes map is replaced (copy on write) rather than modified
+// so it is safe to add or remove routes while requests are served.
 type RedirectService struct {
+	mu sync.RWMutex
 	// Our map of redirect prefix to target replacement routes
 	routes map[string]string
 }
 
+// redirectRoutes returns the current routes map, it must not be modified.
+func (r *RedirectService) redirectRoutes() map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.routes
+}
+
 // HasRedirectRoutes returns true if redirects have been defined,
 // false if not.
 func (r *RedirectService) HasRedirectRoutes() bool {
-	if len(r.routes) > 0 {
+	if len(r.redirectRoutes()) > 0 {
 		return true
 	}
 	return false
@@ -122,13 +148,13 @@ func (r *RedirectService) HasRedirectRoutes() bool {
 
 // HasRoute returns true if the target route is defined
 func (r *RedirectService) HasRoute(key string) bool {
-	_, ok := r.routes[key]
+	_, ok := r.redirectRoutes()[key]
 	return ok
 }
 
 // Route takes a target and returns a destination and bool.
 func (r *RedirectService) Route(key string) (string, bool) {
-	destination, ok := r.routes[key]
+	destination, ok := r.redirectRoutes()[key]
 	return destination, ok
 }
 
@@ -178,12 +204,9 @@ func LoadRedirects(fName string) (map[string]string, error) {
 // It returns a new *RedirectService and error
 func MakeRedirectService(m map[string]string) (*RedirectService, error) {
 	r := new(RedirectService)
-	if r.routes == nil {
-		r.routes = make(map[string]string)
-	}
 	for k, v := range m {
 		if err := r.AddRedirectRoute(k, v); err != nil {
-			return r, err
+			return nil, err
 		}
 	}
 	return r, nil
@@ -193,9 +216,8 @@ func MakeRedirectService(m map[string]string) (*RedirectService, error) {
 // and populates the internal datastructures to handle
 // the redirecting target prefix to the destination prefix.
 func (r *RedirectService) AddRedirectRoute(target, destination string) error {
-	if r.routes == nil {
-		r.routes = make(map[string]string)
-	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	prefixes := []string{}
 	for key, _ := range r.routes {
 		prefixes = append(prefixes, key)
@@ -204,19 +226,42 @@ func (r *RedirectService) AddRedirectRoute(target, destination string) error {
 	// Make sure prefix has not been defined and don't collide
 	for _, p := range prefixes {
 		if strings.HasPrefix(p, target) || strings.HasPrefix(target, p) {
-			return fmt.Errorf("targets %q and %q collide", target, p)
+			return fmt.Errorf("%w, targets %q and %q", ErrRouteCollision, target, p)
 		}
 	}
-	r.routes[target] = destination
+	routes := make(map[string]string, len(r.routes)+1)
+	for k, v := range r.routes {
+		routes[k] = v
+	}
+	routes[target] = destination
+	r.routes = routes
 	return nil
 }
 
+// RemoveRedirectRoute removes a target prefix, returns true if
+// the target was defined.
+func (r *RedirectService) RemoveRedirectRoute(target string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.routes[target]; ok == false {
+		return false
+	}
+	routes := make(map[string]string, len(r.routes))
+	for k, v := range r.routes {
+		if k != target {
+			routes[k] = v
+		}
+	}
+	r.routes = routes
+	return true
+}
+
 // RedirectRouter handles redirect requests before passing on to the
 // handler.
 func (r *RedirectService) RedirectRouter(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		// Do we have a redirect prefix in r.URL.Path
-		for target, destination := range r.routes {
+		for target, destination := range r.redirectRoutes() {
 			if strings.HasPrefix(req.URL.Path, target) {
 				// Clone our existing Request URL ...
 				u, _ := url.Parse(req.URL.String())
@@ -225,6 +270,7 @@ func (r *RedirectService) RedirectRouter(next http.Handler) http.Handler {
 				// Update our new path.
 				u.Path = path.Join(destination, p)
 				log.Printf("Redirecting %q to %q", req.URL.String(), u.String())
+				traceNote(req, "redirect", target+" -> "+destination)
 				// Send our redirect on its way!
 				http.Redirect(w, req, u.String(), http.StatusMovedPermanently)
 				return
@@ -251,6 +297,9 @@ type CORSPolicy struct {
 	ExposedHeaders []string `json:"exposed_headers,omitempty" toml:"exposed_headers,omitempty"`
 	// AllowCredentials header handling in the policy either true or not set
 	AllowCredentials bool `json:"allow_credentials,omitempty" toml:"allow_credentials,omitempty"`
+	// Match limits the policy to matching requests (e.g. the "/api/"
+	// path), other requests get no CORS headers.
+	Match *Matcher `json:"match,omitempty" toml:"match,omitempty"`
 }
 
 // Handler accepts an http.Handler and returns a http.Handler. It
@@ -264,6 +313,10 @@ func (cors *CORSPolicy) Handler(next http.Handler) http.Handler {
 		})
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cors.Match.Match(r) == false {
+			next.ServeHTTP(w, r)
+			return
+		}
 		if cors.Origin != "" {
 			w.Header().Set("Access-Control-Allow-Origin", cors.Origin)
 		}
@@ -303,7 +356,9 @@ func (cors *CORSPolicy) Handler(next http.Handler) http.Handler {
 // See https://developer.mozilla.org/en-US/docs/Web/HTTP/Authentication
 // using Go's http.Request object.
 type Access struct {
-	// AuthType (e.g. Basic)
+	// AuthType (e.g. Basic). "remote_user" trusts the username in
+	// RemoteUserHeader set by one of RemoteUserProxies, e.g. Apache
+	// running a Shibboleth SP.
 	AuthType string `json:"auth_type" toml:"auth_type"`
 	// AuthName (e.g. string describing authorization, e.g. realm in basic auth)
 	AuthName string `json:"auth_name" toml:"auth_name"`
@@ -317,6 +372,94 @@ type Access struct {
 	// Routes is a list of URL path prefixes covered by
 	// this Access control object.
 	Routes []string `json:"routes" toml:"routes"`
+	// Groups maps a group name to the protected routes its members
+	// may use, see Secrets.Groups.
+	Groups map[string][]string `json:"groups,omitempty" toml:"groups,omitempty"`
+	// NoChallengeRoutes are protected route prefixes (e.g. "/api/")
+	// answered with a JSON 401 without WWW-Authenticate, so scripts
+	// and API clients don't trigger the browser's login dialog.
+	NoChallengeRoutes []string `json:"no_challenge_routes,omitempty" toml:"no_challenge_routes,omitempty"`
+	// LogoutPath (e.g. "/private/logout") signs the user out, the
+	// remembered session is cleared and the browser is sent a 401
+	// for a changed realm so it stops sending the credentials.
+	LogoutPath string `json:"logout_path,omitempty" toml:"logout_path,omitempty"`
+	// Argon2 holds the argon2id parameters used when setting passwords,
+	// DefaultArgon2Params if not set.
+	Argon2 *Argon2Params `json:"argon2,omitempty" toml:"argon2,omitempty"`
+	// PBKDF2 holds the pbkdf2 parameters used when setting passwords,
+	// DefaultPBKDF2Params if not set.
+	PBKDF2 *PBKDF2Params `json:"pbkdf2,omitempty" toml:"pbkdf2,omitempty"`
+	// SessionSeconds is how long verified credentials are remembered
+	// so the password hash isn't recomputed on every request. Zero
+	// uses DefaultSessionSeconds, a negative value turns caching off.
+	SessionSeconds int `json:"session_seconds,omitempty" toml:"session_seconds,omitempty"`
+	// FailureLog if set is a file where failed logins are appended
+	// using AuthFailureFormat (e.g. for fail2ban), otherwise they
+	// go to the standard log.
+	FailureLog string `json:"failure_log,omitempty" toml:"failure_log,omitempty"`
+	// AuthCommand if set checks passwords by running the command
+	// (program and arguments) with the username appended and the
+	// password on standard input, exit code zero accepts the login.
+	AuthCommand []string `json:"auth_command,omitempty" toml:"auth_command,omitempty"`
+	// AuthCommandSeconds limits how long AuthCommand may run,
+	// DefaultAuthCommandSeconds if not set.
+	AuthCommandSeconds int `json:"auth_command_seconds,omitempty" toml:"auth_command_seconds,omitempty"`
+	// RemoteUserHeader holds the username for the "remote_user"
+	// AuthType, DefaultRemoteUserHeader if empty.
+	RemoteUserHeader string `json:"remote_user_header,omitempty" toml:"remote_user_header,omitempty"`
+	// RemoteUserProxies lists the CIDRs (or addresses) of the proxies
+	// trusted to set RemoteUserHeader.
+	RemoteUserProxies []string `json:"remote_user_proxies,omitempty" toml:"remote_user_proxies,omitempty"`
+	// AuditLog if set is a file where logins, failures, user changes
+	// and reloads are appended as JSON lines (see AuditEvent).
+	AuditLog string `json:"audit_log,omitempty" toml:"audit_log,omitempty"`
+	// PasswordMaxAgeDays if set makes Check report passwords not
+	// changed within that many days.
+	PasswordMaxAgeDays int `json:"password_max_age_days,omitempty" toml:"password_max_age_days,omitempty"`
+	// DormantDays if set makes Check report users who haven't
+	// logged in within that many days.
+	DormantDays int `json:"dormant_days,omitempty" toml:"dormant_days,omitempty"`
+
+	// Store holds the user secrets. When nil the Access struct itself
+	// (the Map read from the access file) is used.
+	Store AccessStore `json:"-" toml:"-"`
+
+	// mu guards Map, Routes and Groups. They are replaced (copy on write)
+	// rather than modified so requests in flight see a consistent view.
+	mu        sync.RWMutex
+	cacheOnce sync.Once
+	cache     *authCache
+	failLog   appendLog
+	auditLog  appendLog
+
+	// notify is called with EventAuthLockout, see SetNotify.
+	notify      func(event string, message string)
+	lockoutOnce sync.Once
+	lockouts    *eventWatch
+
+	// logins counts logins, see LoginMetrics and SetLoginHook.
+	logins loginCounter
+
+	// source is the file LoadAccess read, rehashed passwords are
+	// saved to it. dumpMu serializes writing access files, loginMu
+	// the last login file beside it.
+	source  string
+	dumpMu  sync.Mutex
+	loginMu sync.Mutex
+}
+
+// AccessStore is implemented by credential backends. *Access
+// implements it using the Map read from an access file, other
+// backends (e.g. LDAP, SQL, htpasswd) can be assigned to Access.Store.
+type AccessStore interface {
+	// Lookup returns the secrets for username.
+	Lookup(username string) (*Secrets, error)
+	// Update adds or replaces the secrets for username.
+	Update(username string, secret *Secrets) error
+	// Remove deletes username.
+	Remove(username string) error
+	// List returns the known usernames in sorted order.
+	List() ([]string, error)
 }
 
 type Secrets struct {
@@ -326,132 +469,543 @@ type Secrets struct {
 	// If not you just gave away your system a cracker.
 	Salt []byte `json:"salt,omitempty" toml:"salt,omitempty"`
 	// Key holds the salted hash ...
-	Key []byte `json:"key, omitempty" toml:"key,omitempty"`
+	Key []byte `json:"key,omitempty" toml:"key,omitempty"`
+	// Argon2 records the parameters used to compute Key. Secrets
+	// without it were made with LegacyArgon2Params.
+	Argon2 *Argon2Params `json:"argon2,omitempty" toml:"argon2,omitempty"`
+	// PBKDF2 records the parameters used to compute Key. Secrets
+	// without it were made with LegacyPBKDF2Params.
+	PBKDF2 *PBKDF2Params `json:"pbkdf2,omitempty" toml:"pbkdf2,omitempty"`
+	// Encryption is the scheme Key was made with when it isn't
+	// Access.Encryption, see SetEncryption.
+	Encryption string `json:"encryption,omitempty" toml:"encryption,omitempty"`
+	// Routes if set limits the user to these protected routes
+	// (along with those of their Groups).
+	Routes []string `json:"routes,omitempty" toml:"routes,omitempty"`
+	// Groups the user belongs to, see Access.Groups.
+	Groups []string `json:"groups,omitempty" toml:"groups,omitempty"`
+	// Restricted limits the user to Routes and their Groups' routes,
+	// even when none are left. Grant sets it.
+	Restricted bool `json:"restricted,omitempty" toml:"restricted,omitempty"`
+	// Disabled suspends the account without losing its password
+	// or settings.
+	Disabled bool `json:"disabled,omitempty" toml:"disabled,omitempty"`
+	// ExpiresAt if set is when the account lapses, see Prune.
+	ExpiresAt *time.Time `json:"expires_at,omitempty" toml:"expires_at,omitempty"`
+	// CreatedAt is when the user was added.
+	CreatedAt *time.Time `json:"created_at,omitempty" toml:"created_at,omitempty"`
+	// UpdatedAt is when the password was last set.
+	UpdatedAt *time.Time `json:"updated_at,omitempty" toml:"updated_at,omitempty"`
+	// LastLoginAt is when the user last logged in, to within
+	// LastLoginResolution.
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" toml:"last_login_at,omitempty"`
 }
 
-// LoadAccess loads a TOML or JSON access file.
-func LoadAccess(fName string) (*Access, error) {
+// Argon2Params are the argon2id cost parameters.
+// See https://www.rfc-editor.org/rfc/rfc9106
+type Argon2Params struct {
+	// Time is the number of passes over memory.
+	Time uint32 `json:"time" toml:"time"`
+	// Memory is measured in KiB.
+	Memory uint32 `json:"memory" toml:"memory"`
+	// Threads is the degree of parallelism.
+	Threads uint8 `json:"threads" toml:"threads"`
+	// KeyLen is the length of the derived key in bytes.
+	KeyLen uint32 `json:"key_len" toml:"key_len"`
+}
+
+// PBKDF2Params are the pbkdf2 cost parameters.
+type PBKDF2Params struct {
+	// Iterations is the number of rounds.
+	Iterations int `json:"iterations" toml:"iterations"`
+	// Hash names the HMAC hash, "sha1", "sha256" or "sha512".
+	Hash string `json:"hash" toml:"hash"`
+	// KeyLen is the length of the derived key in bytes.
+	KeyLen int `json:"key_len" toml:"key_len"`
+}
+
+var (
+	// LegacyArgon2Params were hard coded before parameters were
+	// recorded with each secret.
+	LegacyArgon2Params = Argon2Params{Time: 1, Memory: 64 * 1024, Threads: 4, KeyLen: 32}
+	// DefaultArgon2Params are used for new argon2id secrets when
+	// Access.Argon2 isn't set.
+	DefaultArgon2Params = LegacyArgon2Params
+
+	// LegacyPBKDF2Params were hard coded before parameters were
+	// recorded with each secret.
+	LegacyPBKDF2Params = PBKDF2Params{Iterations: 4097, Hash: "sha1", KeyLen: 32}
+	// DefaultPBKDF2Params are used for new pbkdf2 secrets when
+	// Access.PBKDF2 isn't set, they follow the OWASP recommendation.
+	DefaultPBKDF2Params = PBKDF2Params{Iterations: 600000, Hash: "sha256", KeyLen: 32}
+)
+
+// hashFunc returns the hash constructor named by p.Hash.
+func (p *PBKDF2Params) hashFunc() (func() hash.Hash, error) {
+	switch strings.ToLower(p.Hash) {
+	case "", "sha1":
+		return sha1.New, nil
+	case "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("%w, pbkdf2 hash %q", ErrUnsupportedScheme, p.Hash)
+	}
+}
+
+// setParams records the current cost parameters for the Access
+// encryption in secret so they can be raised later without
+// breaking existing secrets.
+func (a *Access) setParams(secret *Secrets) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	switch a.Encryption {
+	case "argon2id":
+		p := DefaultArgon2Params
+		if a.Argon2 != nil {
+			p = *a.Argon2
+		}
+		secret.Argon2, secret.PBKDF2 = &p, nil
+	case "pbkdf2":
+		p := DefaultPBKDF2Params
+		if a.PBKDF2 != nil {
+			p = *a.PBKDF2
+		}
+		secret.Argon2, secret.PBKDF2 = nil, &p
+	}
+}
+
+// hashPassword computes the key for password using the salt and
+// parameters recorded in secret.
+func (a *Access) hashPassword(password string, secret *Secrets) ([]byte, error) {
+	encryption := secret.Encryption
+	if encryption == "" {
+		a.mu.RLock()
+		encryption = a.Encryption
+		a.mu.RUnlock()
+	}
+	switch encryption {
+	case "argon2id":
+		p := LegacyArgon2Params
+		if secret.Argon2 != nil {
+			p = *secret.Argon2
+		}
+		return argon2.IDKey([]byte(password), secret.Salt, p.Time, p.Memory, p.Threads, p.KeyLen), nil
+	case "pbkdf2":
+		p := LegacyPBKDF2Params
+		if secret.PBKDF2 != nil {
+			p = *secret.PBKDF2
+		}
+		h, err := p.hashFunc()
+		if err != nil {
+			return nil, err
+		}
+		return pbkdf2.Key([]byte(password), secret.Salt, p.Iterations, p.KeyLen, h), nil
+	case "md5":
+		h := md5.New()
+		io.WriteString(h, password)
+		return h.Sum(nil), nil
+	case "sha512":
+		h := sha512.New()
+		return h.Sum([]byte(password)), nil
+	}
+	// NOTE: We don't know the encryption scheme
+	// so we fail to authenticate.
+	return nil, fmt.Errorf("%w, encryption %q", ErrUnsupportedScheme, encryption)
+}
+
+// formatOf returns "toml" or "json" based on a filename extension
+// or format name, an empty string otherwise.
+func formatOf(name string) string {
+	name = strings.ToLower(name)
 	switch {
-	case strings.HasSuffix(fName, ".toml"):
-		return loadAccessTOML(fName)
-	case strings.HasSuffix(fName, ".json"):
-		return loadAccessJSON(fName)
+	case name == "toml" || strings.HasSuffix(name, ".toml"):
+		return "toml"
+	case name == "json" || strings.HasSuffix(name, ".json"):
+		return "json"
 	default:
-		return nil, fmt.Errorf("%q, unsupported format", fName)
+		return ""
 	}
 }
 
-// loadAccessTOML loads a TOML acces file.
-// and returns an Access struct and error.
-func loadAccessTOML(accessTOML string) (*Access, error) {
-	auth := new(Access)
-	src, err := ioutil.ReadFile(accessTOML)
+// LoadAccess loads a TOML or JSON access file.
+func LoadAccess(fName string) (*Access, error) {
+	format := formatOf(fName)
+	if format == "" {
+		return nil, fmt.Errorf("%q, unsupported format", fName)
+	}
+	fp, err := os.Open(fName)
 	if err != nil {
 		return nil, err
 	}
-	if _, err := toml.Decode(string(src), &auth); err != nil {
+	defer fp.Close()
+	a, err := LoadAccessFrom(fp, format)
+	if err != nil {
 		return nil, err
 	}
-	return auth, nil
+	a.source = fName
+	if err := a.loadLastLogins(); err != nil {
+		return nil, err
+	}
+	return a, nil
 }
 
-// loadAccessJSON loads a JSON access file.
-// and returns an Access struct and error.
-func loadAccessJSON(accessJSON string) (*Access, error) {
+// LoadAccessFrom reads an access configuration from r. Format
+// is "toml" or "json".
+func LoadAccessFrom(r io.Reader, format string) (*Access, error) {
 	auth := new(Access)
-	src, err := ioutil.ReadFile(accessJSON)
-	if err != nil {
-		return nil, err
-	}
-	if err := json.Unmarshal(src, &auth); err != nil {
-		return nil, err
+	switch formatOf(format) {
+	case "toml":
+		if _, err := toml.NewDecoder(r).Decode(&auth); err != nil {
+			return nil, err
+		}
+	case "json":
+		if err := json.NewDecoder(r).Decode(&auth); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%q, unsupported format", format)
 	}
 	return auth, nil
 }
 
-// DumpAccess writes a access file.
+// DumpAccess writes a access file, see WriteConfigFile.
 func (a *Access) DumpAccess(fName string) error {
-	switch {
-	case strings.HasSuffix(fName, ".toml"):
-		return a.dumpAccessTOML(fName)
-	case strings.HasSuffix(fName, ".json"):
-		return a.dumpAccessJSON(fName)
-	default:
+	format := formatOf(fName)
+	if format == "" {
 		return fmt.Errorf("%q, unsupported format", fName)
 	}
-}
-
-// dumpAccessTOML writes a TOML access file.
-func (a *Access) dumpAccessTOML(accessTOML string) error {
+	a.dumpMu.Lock()
+	defer a.dumpMu.Unlock()
 	buf := new(bytes.Buffer)
-	tomlEncoder := toml.NewEncoder(buf)
-	if err := tomlEncoder.Encode(a); err != nil {
+	if err := a.DumpTo(buf, format); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(accessTOML, buf.Bytes(), 0600)
+	return WriteConfigFile(fName, buf.Bytes(), 0600, func(src []byte) error {
+		return decodeConfig(src, format, new(Access))
+	})
 }
 
-// dumpAccessJSON writes an access.toml file.
-func (a *Access) dumpAccessJSON(accessJSON string) error {
-	src, err := json.MarshalIndent(a, "", "    ")
-	if err != nil {
+// DumpTo writes the access configuration to w. Format is
+// "toml" or "json".
+func (a *Access) DumpTo(w io.Writer, format string) error {
+	switch formatOf(format) {
+	case "toml":
+		return toml.NewEncoder(w).Encode(a)
+	case "json":
+		src, err := json.MarshalIndent(a, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(src)
 		return err
+	default:
+		return fmt.Errorf("%q, unsupported format", format)
 	}
-	return ioutil.WriteFile(accessJSON, src, 0600)
 }
 
 // UpdateAccess uses an *Access and username, password
 // generates a salt and then adds username, salt
 // and secret to .Map (creating one if needed)
 func (a *Access) UpdateAccess(username string, password string) bool {
-	if a.Map == nil {
-		a.Map = make(map[string]*Secrets)
-	}
+	return a.UpdateUser(username, password) == nil
+}
+
+// UpdateUser is UpdateAccess returning an error explaining
+// why the update failed.
+func (a *Access) UpdateUser(username string, password string) error {
 	// Pick the preferred encryption if not set.
+	a.mu.Lock()
 	if a.Encryption == "" {
 		a.Encryption = "argon2id"
 	}
-	secret := new(Secrets)
+	a.mu.Unlock()
+	// Keep the account's other settings when changing a password.
+	now := time.Now().UTC()
+	secret := &Secrets{CreatedAt: &now}
+	if old, err := a.store().Lookup(username); err == nil {
+		*secret = *old
+		secret.Argon2, secret.PBKDF2, secret.Encryption = nil, nil, ""
+	}
+	secret.UpdatedAt = &now
 	secret.Salt = make([]byte, 32)
 	_, err := rand.Read(secret.Salt)
 	if err != nil {
-		return false
+		return err
 	}
-	switch a.Encryption {
-	case "argon2id":
-		secret.Key = argon2.IDKey([]byte(password), secret.Salt, 1, 64*1024, 4, 32)
-		a.Map[username] = secret
-		return true
-	case "pbkdf2":
-		secret.Key = pbkdf2.Key([]byte(password), secret.Salt, 4097, 32, sha1.New)
-		a.Map[username] = secret
-		return true
-	case "md5":
-		h := md5.New()
-		io.WriteString(h, password)
-		secret.Key = h.Sum(nil)
-		a.Map[username] = secret
-		return true
-	case "sha512":
-		h := sha512.New()
-		secret.Key = h.Sum([]byte(password))
-		a.Map[username] = secret
-		return true
+	a.ClearSessions(username)
+	a.setParams(secret)
+	key, err := a.hashPassword(password, secret)
+	if err != nil {
+		return err
 	}
-	// NOTE: We don't know the encryption scheme
-	// so we fail to authenticate.
-	return false
+	secret.Key = key
+	return a.store().Update(username, secret)
+}
+
+// DefaultPasswordLength is the length of generated passwords.
+const DefaultPasswordLength = 24
+
+// passwordChars are used in generated passwords, they are safe to
+// paste into shells, URLs and configuration files.
+const passwordChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_."
+
+// GeneratePassword returns a random password of length characters
+// (at least 12), e.g. for service accounts.
+func GeneratePassword(length int) (string, error) {
+	if length < 12 {
+		return "", fmt.Errorf("generated passwords must be at least 12 characters")
+	}
+	buf := make([]byte, length)
+	max := big.NewInt(int64(len(passwordChars)))
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = passwordChars[n.Int64()]
+	}
+	return string(buf), nil
+}
+
+// store returns the AccessStore in use.
+func (a *Access) store() AccessStore {
+	if a.Store != nil {
+		return a.Store
+	}
+	return a
+}
+
+// Lookup implements AccessStore using Map.
+func (a *Access) Lookup(username string) (*Secrets, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if secret, ok := a.Map[username]; ok {
+		return secret, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownUser, username)
+}
+
+// Update implements AccessStore replacing the Map.
+func (a *Access) Update(username string, secret *Secrets) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m := make(map[string]*Secrets, len(a.Map)+1)
+	for k, v := range a.Map {
+		m[k] = v
+	}
+	m[username] = secret
+	a.Map = m
+	return nil
+}
+
+// Remove implements AccessStore replacing the Map.
+func (a *Access) Remove(username string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.Map[username]; ok == false {
+		return fmt.Errorf("%w %q", ErrUnknownUser, username)
+	}
+	m := make(map[string]*Secrets, len(a.Map))
+	for k, v := range a.Map {
+		if k != username {
+			m[k] = v
+		}
+	}
+	a.Map = m
+	return nil
+}
+
+// List implements AccessStore returning the usernames in Map.
+func (a *Access) List() ([]string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	usernames := make([]string, 0, len(a.Map))
+	for username := range a.Map {
+		if username != "" {
+			usernames = append(usernames, username)
+		}
+	}
+	sort.Strings(usernames)
+	return usernames, nil
+}
+
+// SetRoutes replaces the protected routes.
+func (a *Access) SetRoutes(routes []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Routes = append([]string{}, routes...)
+}
+
+// ListRoutes returns a copy of the protected routes.
+func (a *Access) ListRoutes() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]string{}, a.Routes...)
+}
+
+// AddRoute protects a path prefix. The route is given leading and
+// trailing slashes and may not overlap an existing route.
+func (a *Access) AddRoute(route string) error {
+	route = normalizeRoute(route)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, other := range a.Routes {
+		if strings.HasPrefix(route, other) || strings.HasPrefix(other, route) {
+			return fmt.Errorf("%q collides with %q", route, other)
+		}
+	}
+	routes := append(append([]string{}, a.Routes...), route)
+	sort.Strings(routes)
+	a.Routes = routes
+	return nil
+}
+
+// RemoveRoute stops protecting a path prefix, the trailing slash
+// is optional.
+func (a *Access) RemoveRoute(route string) error {
+	route = "/" + strings.Trim(route, "/")
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	routes := []string{}
+	for _, other := range a.Routes {
+		if other != route && other != route+"/" {
+			routes = append(routes, other)
+		}
+	}
+	if len(routes) == len(a.Routes) {
+		return fmt.Errorf("Could not find route %q", route)
+	}
+	a.Routes = routes
+	return nil
+}
+
+// Reload replaces the users, routes and settings with those read
+// from an access file. Remembered sessions are cleared.
+func (a *Access) Reload(fName string) error {
+	other, err := LoadAccess(fName)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.AuthType = other.AuthType
+	a.AuthName = other.AuthName
+	a.Encryption = other.Encryption
+	a.Argon2 = other.Argon2
+	a.PBKDF2 = other.PBKDF2
+	a.SessionSeconds = other.SessionSeconds
+	a.AuthCommand = other.AuthCommand
+	a.AuthCommandSeconds = other.AuthCommandSeconds
+	a.RemoteUserHeader = other.RemoteUserHeader
+	a.RemoteUserProxies = other.RemoteUserProxies
+	a.FailureLog = other.FailureLog
+	a.AuditLog = other.AuditLog
+	a.PasswordMaxAgeDays = other.PasswordMaxAgeDays
+	a.DormantDays = other.DormantDays
+	a.Map = other.Map
+	a.Routes = other.Routes
+	a.Groups = other.Groups
+	a.NoChallengeRoutes = other.NoChallengeRoutes
+	a.LogoutPath = other.LogoutPath
+	a.mu.Unlock()
+	a.ClearSessions("")
+	ev := NewAuditEvent(AuditReload, "", nil)
+	ev.Detail = fName
+	a.audit(ev)
+	return nil
+}
+
+// RenameUser moves oldname's password, grants and settings to
+// newname. With the Map the move is made in one step.
+func (a *Access) RenameUser(oldname string, newname string) error {
+	if newname == "" || newname == oldname {
+		return fmt.Errorf("invalid new username %q", newname)
+	}
+	if a.Store != nil {
+		secret, err := a.Store.Lookup(oldname)
+		if err != nil {
+			return err
+		}
+		if _, err := a.Store.Lookup(newname); err == nil {
+			return fmt.Errorf("%q already exists", newname)
+		}
+		if err := a.Store.Update(newname, secret); err != nil {
+			return err
+		}
+		if err := a.Store.Remove(oldname); err != nil {
+			a.Store.Remove(newname)
+			return err
+		}
+	} else {
+		a.mu.Lock()
+		secret, ok := a.Map[oldname]
+		if ok == false {
+			a.mu.Unlock()
+			return fmt.Errorf("%w %q", ErrUnknownUser, oldname)
+		}
+		if _, ok := a.Map[newname]; ok {
+			a.mu.Unlock()
+			return fmt.Errorf("%q already exists", newname)
+		}
+		m := make(map[string]*Secrets, len(a.Map))
+		for k, v := range a.Map {
+			if k != oldname {
+				m[k] = v
+			}
+		}
+		m[newname] = secret
+		a.Map = m
+		a.mu.Unlock()
+	}
+	a.ClearSessions(oldname)
+	return nil
+}
+
+// SetDisabled disables or enables username's account. Remembered
+// logins are forgotten.
+func (a *Access) SetDisabled(username string, disabled bool) error {
+	old, err := a.store().Lookup(username)
+	if err != nil {
+		return err
+	}
+	secret := new(Secrets)
+	*secret = *old
+	secret.Disabled = disabled
+	if err := a.store().Update(username, secret); err != nil {
+		return err
+	}
+	a.ClearSessions(username)
+	return nil
+}
+
+// checkAccount returns an error if username's account exists
+// but may not log in, e.g. ErrUserDisabled.
+func checkAccount(username string, secret *Secrets) error {
+	if secret.Disabled {
+		return fmt.Errorf("%w %q", ErrUserDisabled, username)
+	}
+	if secret.expired(time.Now()) {
+		return fmt.Errorf("%w %q", ErrUserExpired, username)
+	}
+	return nil
 }
 
 // RemoveAccess takes an *Access and username and
 // deletes the username from .Map
 // returns true if delete applied, false if user not found in map
 func (a *Access) RemoveAccess(username string) bool {
-	if _, ok := a.Map[username]; ok == true {
-		delete(a.Map, username)
-		return true
+	return a.RemoveUser(username) == nil
+}
+
+// RemoveUser is RemoveAccess returning ErrUnknownUser if the
+// username isn't found.
+func (a *Access) RemoveUser(username string) error {
+	if err := a.store().Remove(username); err != nil {
+		return err
 	}
-	return false
+	a.ClearSessions(username)
+	return nil
 }
 
 // Login accepts username, password and ok boolean.
@@ -465,49 +1019,81 @@ func (a *Access) RemoveAccess(username string) bool {
 // They are NOT considered secure anymore as they are breakable
 // with brute force using today's CPU/GPUs.
 func (a *Access) Login(username string, password string) bool {
-	var (
-		u      *Secrets
-		secret *Secrets
-	)
+	return a.VerifyLogin(username, password) == nil
+}
 
+// VerifyLogin is Login returning an error explaining why
+// authentication failed, e.g. ErrUnknownUser or ErrBadPassword.
+func (a *Access) VerifyLogin(username string, password string) error {
+	a.mu.RLock()
+	useCommand := len(a.AuthCommand) > 0
+	a.mu.RUnlock()
+	if useCommand {
+		// Accounts in the store can still be disabled.
+		if u, err := a.store().Lookup(username); err == nil {
+			if err := checkAccount(username, u); err != nil {
+				return err
+			}
+		}
+		return a.runAuthCommand(username, password)
+	}
 	// Make sure we know about the user, others we can't validate
-	if val, ok := a.Map[username]; ok {
-		u = val
-	} else {
-		return false
+	u, err := a.store().Lookup(username)
+	if err != nil {
+		return err
 	}
-	secret = new(Secrets)
-	switch a.Encryption {
-	case "argon2id":
-		secret.Key = argon2.IDKey([]byte(password), u.Salt, 1, 64*1024, 4, 32)
-	case "pbkdf2":
-		secret.Key = pbkdf2.Key([]byte(password), u.Salt, 4097, 32, sha1.New)
-	case "md5":
-		h := md5.New()
-		io.WriteString(h, password)
-		secret.Key = h.Sum(nil)
-	case "sha512":
-		h := sha512.New()
-		secret.Key = h.Sum([]byte(password))
-	default:
-		// NOTE: We don't know the encryption scheme
-		// so we fail to authenticate.
-		return false
+	if err := checkAccount(username, u); err != nil {
+		return err
 	}
-	if bytes.Compare(secret.Key, u.Key) == 0 {
-		return true
+	key, err := a.hashPassword(password, u)
+	if err != nil {
+		return err
 	}
-	return false
+	if subtle.ConstantTimeCompare(key, u.Key) == 1 {
+		if a.needsRehash(u) {
+			a.rehash(username, password, u)
+		}
+		a.recordLogin(username)
+		return nil
+	}
+	return ErrBadPassword
 }
 
-// Checks to see if we have a defined route.
-func (a *Access) isAccessRoute(p string) bool {
-	for _, route := range a.Routes {
-		if strings.HasPrefix(p, route) {
-			return true
+// LastLoginResolution is how often Secrets.LastLoginAt is updated,
+// limiting how often the last login file is rewritten.
+var LastLoginResolution = time.Hour
+
+// recordLogin updates username's LastLoginAt, saving it beside the
+// access file it was loaded from (see saveLastLogin). Failures are
+// logged, the login still succeeds.
+func (a *Access) recordLogin(username string) {
+	old, err := a.store().Lookup(username)
+	if err != nil {
+		return
+	}
+	now := time.Now().UTC()
+	if old.LastLoginAt != nil && now.Sub(*old.LastLoginAt) < LastLoginResolution {
+		return
+	}
+	secret := new(Secrets)
+	*secret = *old
+	secret.LastLoginAt = &now
+	if err := a.store().Update(username, secret); err != nil {
+		log.Printf("last login %q, %s", username, err)
+		return
+	}
+	if a.source != "" {
+		if err := a.saveLastLogin(username, now); err != nil {
+			log.Printf("last login %q, %s", username, err)
 		}
 	}
-	return false
+}
+
+// Checks to see if we have a defined route.
+func (a *Access) isAccessRoute(p string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return hasRoute(p, a.Routes)
 }
 
 // GetUsername takes an Request object, inspects the headers
@@ -520,6 +1106,8 @@ func (a *Access) GetUsername(r *http.Request) (string, error) {
 			return username, nil
 		}
 		return "", fmt.Errorf("No user info found")
+	case "remote_user":
+		return a.remoteUser(r)
 	default:
 		return "", fmt.Errorf("Unsupported Auth Type")
 	}
@@ -527,7 +1115,9 @@ func (a *Access) GetUsername(r *http.Request) (string, error) {
 
 // Handler takes a handler and returns handler. If
 // *Access is null it pass thru unchanged. Otherwise
-// it applies the access policy.
+// it applies the access policy. Verified credentials are
+// remembered for SessionSeconds so the password hash runs
+// once per session rather than once per request.
 func (a *Access) Handler(next http.Handler) http.Handler {
 	if a == nil {
 		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
@@ -535,49 +1125,62 @@ func (a *Access) Handler(next http.Handler) http.Handler {
 		})
 	}
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		if a.isAccessRoute(req.URL.Path) {
-			res.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, a.AuthName))
-			// Check to see if we've previously authenticated.
-			username, password, ok := req.BasicAuth()
+		if a.isRemoteUser() {
+			a.serveRemoteUser(res, req, next)
+			return
+		}
+		if a.isLogoutPath(req.URL.Path) {
+			a.logout(res, req)
+			return
+		}
+		if a.isAccessRoute(req.URL.Path) && signedURL(req) {
+			traceNote(req, "auth", "signed URL")
+		} else if a.isAccessRoute(req.URL.Path) {
+			username, ok := a.basicAuth(req)
 			if ok == false {
-				http.Error(res, "Unauthorized", http.StatusUnauthorized)
+				traceNote(req, "auth", fmt.Sprintf("%q not authenticated", username))
+				a.unauthorized(res, req, req.URL.Path)
 				return
 			}
-			if a.Login(username, password) == false {
-				http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			if a.allowed(username, req.URL.Path) == false {
+				traceNote(req, "auth", fmt.Sprintf("%q not allowed", username))
+				httpError(res, req, http.StatusForbidden, nil)
 				return
 			}
+			traceNote(req, "auth", fmt.Sprintf("%q allowed", username))
+			req = a.withIdentity(req, username)
 		}
 		next.ServeHTTP(res, req)
 	})
 }
 
+// basicAuth verifies the request's Basic auth credentials, logging
+// and auditing the outcome. It returns the username and true on
+// success.
+func (a *Access) basicAuth(req *http.Request) (string, bool) {
+	// Check to see if we've previously authenticated.
+	username, password, ok := req.BasicAuth()
+	if ok == false {
+		return "", false
+	}
+	ok, cached := a.verify(username, password)
+	if ok == false {
+		a.logAuthFailure(req, username)
+		a.login(username, req, false)
+		return username, false
+	}
+	if cached == false {
+		a.login(username, req, true)
+	}
+	return username, true
+}
+
 // AccessHandler is a wrapping handler that checks if
 // Access.Routes matches the req.URL.Path and if so
 // applies access contraints. If *Access is nil then
 // it just passes through to the next handler.
 func AccessHandler(next http.Handler, a *Access) http.Handler {
-	if a == nil {
-		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-			next.ServeHTTP(res, req)
-		})
-	}
-	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		if a.isAccessRoute(req.URL.Path) {
-			res.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, a.AuthName))
-			// Check to see if we've previously authenticated.
-			username, password, ok := req.BasicAuth()
-			if ok == false {
-				http.Error(res, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			if a.Login(username, password) == false {
-				http.Error(res, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-		}
-		next.ServeHTTP(res, req)
-	})
+	return a.Handler(next)
 }
 
 //
@@ -639,6 +1242,123 @@ htdocs = "htdocs"
 #
 #content_types_file = "content-types.csv"
 
+#
+# Publish a JSON document describing the running build
+# (version, release hash, start time) and configuration paths
+# (no secrets) for monitoring.
+# Uncomment to use.
+#
+#status_path = "/status"
+
+#
+# Layer additional document roots beneath htdocs, e.g. a shared
+# theme. The first root holding a path wins.
+# Uncomment to use.
+#
+#htdocs_layers = [ "../theme" ]
+
+#
+# If running behind a load balancer or reverse proxy list its
+# addresses (CIDRs) so the client address is taken from the
+# X-Forwarded-For or X-Real-IP headers it sets, and the scheme
+# (for redirects and cookies) from X-Forwarded-Proto.
+# Uncomment to use.
+#
+#trusted_proxies = [ "10.0.0.0/8", "127.0.0.1" ]
+
+#
+# Let other reverse proxies (nginx auth_request, Traefik forwardAuth)
+# check credentials against the access file. Responds 200 with an
+# X-Forwarded-User header or 401.
+# Uncomment to use.
+#
+#forward_auth_path = "/auth"
+
+#
+# Files with extensions that have no known content type are
+# sniffed from their content by default. Set to "reject" to answer
+# 415 or to a default type.
+# Uncomment to use.
+#
+#mime_fallback = "application/octet-stream"
+
+#
+# Precompressed files (e.g. "data.json.gz") are sent gzip encoded
+# with the underlying content type. Setting gzip_static answers a
+# request for "data.json" with "data.json.gz" when the browser
+# accepts gzip. gzip_extensions limits which types this applies to.
+# Uncomment to use.
+#
+#gzip_static = true
+#gzip_extensions = [ ".html", ".css", ".js", ".json", ".svg" ]
+
+#
+# Only serve files whose extension is listed in content_types,
+# others are answered "404 Not Found". Guards against stray
+# backups (.bak, .sql) or editor swap files in htdocs.
+# Uncomment to use.
+#
+#strict_mime = true
+
+#
+# Answer permanently retired URLs with "410 Gone" rather than
+# "404 Not Found". Entries are path prefixes or patterns using
+# "*". gone_page is an explanatory page in htdocs.
+# Uncomment to use.
+#
+#gone = [ "/old-catalog/", "/exhibits/*.php" ]
+#gone_page = "/gone.html"
+
+#
+# With [[hosts]], requests for a host that isn't configured are
+# served the site above. Set default_host to serve one of the
+# hosts instead, or unknown_host_page to answer "404 Not Found"
+# with a "no such site" page.
+# Uncomment to use.
+#
+#default_host = "library.example.edu"
+#unknown_host_page = "errors/no-such-site.html"
+
+#
+# Development aid, reload pages open in the browser when files
+# in htdocs change (same as "webserver start -livereload").
+# Uncomment to use.
+#
+#live_reload = true
+
+#
+# Show directories without an index.html as sortable listings with
+# file type icons, breadcrumbs and the directory's README.md.
+# Uncomment to use.
+#
+#directory_listing = true
+
+#
+# Request log format, "standard" or "dev" (compact, colorized
+# lines for development). If not set "dev" is used when the log
+# is written to a terminal, "standard" otherwise.
+# Uncomment to use.
+#
+#log_format = "standard"
+
+#
+# Send the authenticated user (and their groups) to the upstreams
+# of these reverse_proxy routes in the X-Authenticated-User and
+# X-Authenticated-Groups headers. The headers are removed from
+# client requests to every reverse_proxy route.
+# Uncomment to use.
+#
+#identity_headers = [ "/api/" ]
+
+#
+# Certificates are checked at startup and every 12 hours, those
+# expiring within cert_expiry_days (30 if not set) are logged and
+# sent to webhooks as cert_expiry. The days remaining are reported
+# by status_path.
+# Uncomment to use.
+#
+#cert_expiry_days = 21
+
 # Setting up standard http support
 [http]
 host = "localhost"
@@ -650,6 +1370,9 @@ port = "8000"
 #key_pem = "etc/certs/key_pem"
 #host = "localhost"
 #port = "8443"
+# Behind a TCP load balancer sending HAProxy PROXY protocol headers
+# (e.g. AWS NLB, HAProxy "send-proxy") uncomment to read them.
+#proxy_protocol = true
 
 #
 # CORS policy configuration example adpated from 
@@ -663,6 +1386,10 @@ port = "8000"
 #Access_Control_Methods = [ "POST", "GET" ]
 #Access_Control_Allow_Headers = [ "X-PINGPONG", "Content-Type" ]
 #Access_Control_Max_Age = 86400
+# Only send the CORS headers for matching requests (see
+# middleware_rules for the criteria).
+#[cors.match]
+#path = [ "/api/" ]
 
 #
 # Managing file extensions to mime types in the
@@ -688,6 +1415,443 @@ port = "8000"
 #[reverse_proxy]
 #"/api/" = "http://localhost:9000/"
 
+#
+# Accept file uploads (PUT or multipart POST) below a prefix.
+# The prefix MUST be one of the routes protected in your access file.
+# Directory defaults to htdocs.
+#
+# Uncomment to use.
+#[upload]
+#prefix = "/upload/"
+#directory = "staging"
+#max_size = 33554432
+#allowed_extensions = [ ".pdf", ".jpg", ".png" ]
+#overwrite = false
+
+#
+# Accept resumable uploads using the tus protocol (https://tus.io).
+# The prefix MUST be one of the routes protected in your access file.
+# Partial and completed uploads are kept in directory, which is
+# required and must be outside htdocs.
+#
+# Uncomment to use.
+#[tus]
+#prefix = "/deposits/"
+#directory = "deposits"
+#max_size = 107374182400
+
+#
+# Serve the site from an S3 compatible bucket instead of htdocs.
+# Credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+# without them requests are unsigned (public buckets).
+#
+# Uncomment to use.
+#[s3]
+#endpoint = "https://s3.us-west-2.amazonaws.com"
+#region = "us-west-2"
+#bucket = "library-sites"
+#prefix = "exhibits/"
+#cache_seconds = 60
+
+#
+# Serve ZIP archives (e.g. site snapshots) below a path prefix
+# without unpacking them. You can also set htdocs to a ".zip" file.
+#
+# Uncomment to use.
+#[zip_mounts]
+#"/snapshots/2019/" = "archives/site-2019.zip"
+
+#
+# Send a Content-Security-Policy header. "{nonce}" is replaced
+# with a new random value on each request for inline scripts
+# and styles rendered by Go handlers.
+#
+# Uncomment to use.
+#[csp]
+#policy = "default-src 'self'; script-src 'self' 'nonce-{nonce}'"
+#report_only = true
+
+#
+# Post notifications of startup, shutdown, certificate expiry,
+# repeated server errors and repeated failed logins. Format is
+# "slack", "teams" or "json". Events limits what is sent
+# (startup, shutdown, cert_expiry, server_errors, auth_lockout).
+#
+# Uncomment to use.
+#[[webhooks]]
+#url = "https://hooks.slack.com/services/T000/B000/XXXX"
+#format = "slack"
+#events = [ "shutdown", "cert_expiry", "server_errors", "auth_lockout" ]
+
+#
+# Serve a dataset collection (or a directory of JSON documents)
+# as a read only JSON API. GET the prefix to list keys (with page
+# and size parameters), GET prefix + key for a record. Add the
+# prefix to your access routes if the records aren't public.
+#
+# Uncomment to use.
+#[[datasets]]
+#prefix = "/api/people/"
+#path = "people.ds"
+
+#
+# Add a charset parameter to the Content-Type by type, e.g. for
+# legacy content that isn't UTF-8.
+#
+# Uncomment to use.
+#[charsets]
+#"text/*" = "utf-8"
+#"application/json" = "utf-8"
+
+#
+# Force a content type for everything below a path prefix,
+# e.g. so browsers download rather than render raw data.
+#
+# Uncomment to use.
+#[content_type_prefixes]
+#"/downloads/" = "application/octet-stream"
+
+#
+# Serve language variants of pages based on the browser's
+# Accept-Language, e.g. "index.es.html" for Spanish readers. Set
+# directories to use "/en/", "/es/" instead. Default is served
+# when no supported language is acceptable. Variants are files in
+# htdocs, access routes apply to the variant's path.
+#
+# Uncomment to use.
+#[languages]
+#supported = [ "en", "es" ]
+#default = "en"
+#directories = false
+
+#
+# Generate robots.txt (replacing any in htdocs) and send an
+# X-Robots-Tag header below prefixes. Set protected to also tag
+# the routes in your access file.
+#
+# Uncomment to use.
+#[robots]
+#sitemaps = [ "https://library.example.edu/sitemap.xml" ]
+#prefixes = [ "/drafts/" ]
+#protected = true
+#tag = "noindex, nofollow"
+#[[robots.agents]]
+#user_agent = "*"
+#disallow = [ "/private/" ]
+#crawl_delay = 10
+
+#
+# Only publish content below a prefix inside a time window (e.g.
+# an embargoed thesis). Outside the window requests get status
+# (404 by default, or 403). Either time may be left out.
+#
+# Uncomment to use.
+#[[schedule]]
+#prefix = "/theses/smith-2023/"
+#not_before = 2025-06-01T00:00:00-07:00
+#status = 404
+
+#
+# Copy a percentage of requests below a prefix to a secondary
+# upstream (e.g. a rewritten backend) in the background. Its
+# responses are discarded, clients are always answered as usual.
+# Authorization and Cookie headers are removed from the copies
+# unless send_credentials is true.
+#
+# Uncomment to use.
+#[[mirrors]]
+#prefix = "/api/"
+#upstream = "http://localhost:9001"
+#percent = 10
+#send_credentials = false
+
+#
+# Send a percentage of a reverse_proxy route's requests to a
+# canary upstream for a gradual rollout. Sticky keeps a browser
+# on the upstream it was first given. Request and error counts per
+# upstream are reported by status_path.
+#
+# Uncomment to use.
+#[canaries."/api/"]
+#upstream = "http://localhost:9100/"
+#percent = 5
+#sticky = true
+
+#
+# Strip tracking parameters (names may use "*") and sort the rest
+# before requests are logged and handled.
+#
+# Uncomment to use.
+#[query]
+#strip = [ "utm_*", "fbclid", "gclid" ]
+#sort = true
+
+#
+# Serve several host names from one process. Requests for aliases
+# (and, with www, the "www." or apex name) are redirected to the
+# canonical host keeping the path. A host may have its own htdocs,
+# redirects, TLS certificate (chosen by SNI), access file (requiring
+# its own htdocs) and [hosts.cors] policy and log file. Other requests
+# are served the site above.
+#
+# Uncomment to use.
+#[[hosts]]
+#host = "library.example.edu"
+#aliases = [ "lib.example.edu" ]
+#www = true
+#htdocs = "sites/library"
+#cert_pem = "etc/certs/library.pem"
+#key_pem = "etc/certs/library.key"
+#access_file = "library-access.toml"
+#log_file = "logs/library.log"
+#[hosts.redirects]
+#"/old-hours/" = "/hours/"
+
+#
+# Serve every subdomain from its own directory, "%s" in htdocs is
+# replaced by the subdomain (e.g. physics.example.edu is served
+# from sites/physics). Unknown subdomains get the site above.
+#
+# Uncomment to use.
+#[[hosts]]
+#host = "*.example.edu"
+#htdocs = "sites/%s"
+
+#
+# Render Go html/template pages at mapped paths, e.g. an hours
+# page. Data files (JSON or TOML) are available as .Data.<name>
+# and environment variables as .Env.<name>. Set reload while
+# developing templates.
+#
+# Uncomment to use.
+#[templates]
+#directory = "templates"
+#env = [ "LIBRARY_ALERT" ]
+#cache_seconds = 60
+#[templates.pages]
+#"/hours/" = "hours.html"
+#[templates.data]
+#hours = "data/hours.json"
+
+#
+# Record requests and responses to a HAR file (open it in browser
+# developer tools) while debugging. Recording stops after
+# max_entries requests or seconds, or on shutdown. Credentials
+# are redacted, bodies are kept up to max_body_size bytes. Without
+# enabled recordings are started and stopped with the admin API
+# ({prefix}har).
+#
+# Uncomment to use.
+#[har]
+#file = "debug.har"
+#enabled = true
+#max_entries = 500
+#seconds = 600
+#max_body_size = 65536
+
+#
+# Development aid, slow down or break responses below a prefix to
+# see how clients cope: a delay plus random jitter (milliseconds),
+# a bandwidth cap and a percentage of error responses.
+#
+# Uncomment to use.
+#[[faults]]
+#prefix = "/api/"
+#delay_ms = 500
+#jitter_ms = 250
+#bytes_per_second = 32768
+#error_percent = 5
+#error_status = 503
+
+#
+# Answer requests below prefix with canned JSON responses from the
+# fixture files (*.json) in directory, so front end work can start
+# before the backend exists. See MockAPI for the fixture format.
+# Set reload to pick up fixture changes without a restart.
+#
+# Uncomment to use.
+#[mock_api]
+#prefix = "/api/"
+#directory = "fixtures"
+#reload = true
+
+#
+# Limit how fast responses below a prefix are sent (bytes per
+# second) so bulk downloads don't starve other visitors. per_request
+# applies to each download, per_client is shared by all of a client
+# address's downloads.
+#
+# Uncomment to use.
+#[[bandwidth]]
+#prefix = "/masters/"
+#per_request = 2097152
+#per_client = 4194304
+
+#
+# Limit how many requests a reverse_proxy route sends to a slow
+# upstream at once. Up to max_queue more wait as long as
+# max_wait_ms for a turn, the rest are answered "503 Service
+# Unavailable". Queue depth is reported by status_path.
+#
+# Uncomment to use.
+#[proxy_queues."/api/"]
+#max_concurrent = 4
+#max_queue = 50
+#max_wait_ms = 5000
+
+#
+# Keep small files (up to max_file_size bytes, max_size in total)
+# in memory. Files are checked for changes after seconds. At
+# startup the warm paths (files or directories) and the warm_recent
+# most recently modified files are read so the first requests after
+# a deploy aren't slow.
+#
+# Uncomment to use.
+#[asset_cache]
+#warm = [ "/index.html", "/css/", "/js/" ]
+#warm_recent = 100
+#seconds = 10
+#max_file_size = 1048576
+#max_size = 67108864
+
+#
+# Serve files below prefixes at URLs holding a hash of their
+# content, e.g. "/assets/app.3f9ac2e1.css" for "/assets/app.css",
+# cached by browsers for a year (privately below protected
+# routes). Use {{asset "/assets/app.css"}} in templates or read
+# the manifest_path JSON for current URLs.
+#
+# Uncomment to use.
+#[fingerprint]
+#prefixes = [ "/assets/" ]
+#manifest_path = "/assets/manifest.json"
+
+#
+# Turn middleware off (or back on) below a prefix: logging, cors,
+# csp, bandwidth, faults or har. Longer prefixes apply after
+# shorter ones. Protected routes are set in the access file and
+# can't be turned off here.
+#
+# Uncomment to use.
+#[[middleware_rules]]
+#prefix = "/health/"
+#disable = [ "logging" ]
+#[[middleware_rules]]
+#prefix = "/masters/"
+#disable = [ "cors", "csp" ]
+# A match selects requests by host, path (prefix or pattern),
+# method, header or query parameter values (path.Match patterns).
+#[[middleware_rules]]
+#prefix = "/"
+#disable = [ "har" ]
+#[middleware_rules.match]
+#host = [ "*.library.example.edu" ]
+#method = [ "GET", "HEAD" ]
+#header = { "X-Monitor" = "" }
+
+#
+# Manage users and protected routes over a JSON API below prefix,
+# e.g. GET /admin/users. The prefix must be an access route and
+# only the listed admins may use it. Changes are saved to the
+# access file and recorded in its audit log.
+#
+# Uncomment to use.
+#[admin]
+#prefix = "/admin/"
+#admins = [ "Jane.Doe" ]
+
+#
+# Limit the HTTP methods allowed below a prefix, other methods are
+# answered "405 Method Not Allowed" with an Allow header. GET
+# implies HEAD. Static files always only allow GET and HEAD.
+#
+# Uncomment to use.
+#[methods]
+#"/api/" = [ "GET", "POST" ]
+#"/api/admin/" = [ "GET", "POST", "PUT", "DELETE" ]
+
+#
+# Dispatch requests explicitly, in order. The first route whose
+# prefix (and optional match) fits the request handles it, requests
+# no route fits are served by reverse_proxy routes and then static
+# files. type is "redirect" (to a path or URL, status defaults to
+# 301), "proxy" (to an upstream URL), "static" (root directory,
+# the document root if not set) or "gone" (410). Protected routes
+# in the access file are checked before any route.
+#
+# Uncomment to use.
+#[[route]]
+#type = "redirect"
+#prefix = "/old-exhibits/"
+#to = "/exhibits/"
+#[[route]]
+#type = "static"
+#prefix = "/api/docs/"
+#root = "/Sites/api-docs"
+#[[route]]
+#type = "proxy"
+#prefix = "/api/"
+#to = "http://localhost:8485"
+#[route.match]
+#method = [ "GET", "POST" ]
+
+#
+# Set, append or remove headers below a prefix (and optional
+# match). Request headers change before the request is handled,
+# response headers just before the response is written. Removals
+# apply first, then set and add.
+#
+# Uncomment to use.
+#[[headers]]
+#prefix = "/api/"
+#request_set = { "X-Forwarded-Prefix" = "/api" }
+#request_remove = [ "Cookie" ]
+#response_set = { "Cache-Control" = "no-store" }
+#response_remove = [ "Server", "X-Powered-By" ]
+#[[headers]]
+#prefix = "/"
+#response_add = { "Link" = "</about.html>; rel=\"author\"" }
+
+#
+# Ask browsers to fetch the critical assets of pages early with
+# Link preload headers, early_hints also sends them in a "103 Early
+# Hints" response before the page. Pages are path prefixes or
+# patterns, assets fingerprinted by [fingerprint] get their hashed
+# URLs.
+#
+# Uncomment to use.
+#[[preload]]
+#pages = [ "/exhibits/" ]
+#assets = [ "/css/exhibit.css", "/js/viewer.mjs", "/fonts/serif.woff2" ]
+#early_hints = true
+
+#
+# Answer reverse_proxy requests whose upstream can't be reached with
+# bad_gateway (502) and those not answered within timeout_seconds
+# with gateway_timeout (504). The pages are HTML files where
+# "{status}", "{title}", "{message}" and "{request_id}" are
+# replaced, a plain built in page is used if not set. Clients
+# accepting JSON get application/problem+json. The request ID is
+# sent to upstreams in X-Request-Id for matching up their logs.
+#
+# Uncomment to use.
+#[proxy_errors]
+#bad_gateway = "errors/502.html"
+#gateway_timeout = "errors/504.html"
+#timeout_seconds = 30
+
+#
+# Share protected files below prefixes with links that expire,
+# without creating accounts. Create a link with "webserver sign
+# webserver.toml localhost /private/report.pdf 72h", it only opens on
+# the host named. keys are base64 secrets of at least 32 bytes (e.g.
+# from "openssl rand -base64 32"), newest first, links signed with any
+# are accepted.
+#
+# Uncomment to use.
+#[signed_urls]
+#prefixes = [ "/private/reports/" ]
+#keys = [ "REPLACE WITH A BASE64 KEY" ]
 `)
 }
 
@@ -695,22 +1859,152 @@ port = "8000"
 // NOTE: merged from json.go into wsfn.go
 //
 
-// jsonResponse enforces a common JSON response write handling.
-// It takes a response writer and request plus a struct that can
-// be converted to JSON.
-func jsonResponse(w http.ResponseWriter, r *http.Request, data interface{}) {
-	src, err := json.MarshalIndent(data, "", "    ")
+// JSONOption adjusts how JSONResponse and JSONError encode a response.
+type JSONOption func(*jsonOptions)
+
+// jsonOptions holds the settings applied by JSONOption funcs.
+type jsonOptions struct {
+	prefix string
+	indent string
+	noETag bool
+}
+
+// newJSONOptions applies opts to the default settings.
+func newJSONOptions(opts ...JSONOption) *jsonOptions {
+	o := &jsonOptions{indent: "    "}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// JSONCompact encodes the response without indentation.
+func JSONCompact() JSONOption {
+	return func(o *jsonOptions) {
+		o.prefix, o.indent = "", ""
+	}
+}
+
+// JSONIndent encodes the response using the prefix and indent
+// provided (see json.MarshalIndent).
+func JSONIndent(prefix string, indent string) JSONOption {
+	return func(o *jsonOptions) {
+		o.prefix, o.indent = prefix, indent
+	}
+}
+
+// marshalJSON encodes data honoring the JSONOption values.
+func marshalJSON(data interface{}, opts ...JSONOption) ([]byte, error) {
+	o := newJSONOptions(opts...)
+	if o.prefix == "" && o.indent == "" {
+		return json.Marshal(data)
+	}
+	return json.MarshalIndent(data, o.prefix, o.indent)
+}
+
+// JSONNoETag turns off the ETag and If-None-Match handling of JSONResponse.
+func JSONNoETag() JSONOption {
+	return func(o *jsonOptions) {
+		o.noETag = true
+	}
+}
+
+// ContentETag returns a strong ETag computed from a hash of src.
+func ContentETag(src []byte) string {
+	sum := sha256.Sum256(src)
+	return fmt.Sprintf(`"%x"`, sum[0:16])
+}
+
+// etagMatches checks an If-None-Match header value against an ETag.
+// Weak comparison is used as described in RFC 9110 section 13.1.2.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	ifNoneMatch = strings.TrimSpace(ifNoneMatch)
+	if ifNoneMatch == "" {
+		return false
+	}
+	if ifNoneMatch == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeBody sends src with its Content-Length, leaving out the body
+// of HEAD responses so they carry the same headers as GET.
+func writeBody(w http.ResponseWriter, r *http.Request, status int, src []byte) error {
+	w.Header().Set("Content-Length", strconv.Itoa(len(src)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err := w.Write(src)
+	return err
+}
+
+// JSONResponse enforces a common JSON response write handling.
+// It takes a response writer, request, HTTP status code and a value that
+// can be converted to JSON. By default output is indented with four
+// spaces, pass JSONCompact() for compact output.
+//
+// Successful (200) GET and HEAD responses carry an ETag computed from
+// the encoded JSON. When the request's If-None-Match matches, a 304
+// is sent without a body. Pass JSONNoETag() to skip this.
+func JSONResponse(w http.ResponseWriter, r *http.Request, status int, data interface{}, opts ...JSONOption) {
+	o := newJSONOptions(opts...)
+	src, err := marshalJSON(data, opts...)
 	if err != nil {
 		log.Printf("json marshal error, %s %s", r.URL.Path, err)
-		http.Error(w, "Internal Server error", http.StatusInternalServerError)
+		WriteProblem(w, r, NewProblem(r, http.StatusInternalServerError, nil))
+		ResponseLogger(r, http.StatusInternalServerError, err)
+		return
+	}
+	if o.noETag == false && status == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		etag := ContentETag(src)
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			ResponseLogger(r, http.StatusNotModified, nil)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := writeBody(w, r, status, src); err != nil {
+		ResponseLogger(r, status, err)
 		return
 	}
-	w.Header().Add("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write(src); err != nil {
+	ResponseLogger(r, status, nil)
+}
+
+// JSONErrorMessage is the body written by JSONError.
+type JSONErrorMessage struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// JSONError writes an error as a JSON object holding the status code
+// and error message, e.g. `{"status": 404, "message": "Not Found"}`.
+// If err is nil the standard status text is used as the message.
+func JSONError(w http.ResponseWriter, r *http.Request, status int, err error, opts ...JSONOption) {
+	msg := &JSONErrorMessage{Status: status, Message: http.StatusText(status)}
+	if err != nil {
+		msg.Message = err.Error()
+	}
+	src, mErr := marshalJSON(msg, opts...)
+	if mErr != nil {
+		http.Error(w, msg.Message, status)
+		ResponseLogger(r, status, err)
 		return
 	}
-	log.Printf("FIXME: Log successful requests here ... %s", r.URL.Path)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	writeBody(w, r, status, src)
+	ResponseLogger(r, status, err)
 }
 
 //
@@ -718,23 +2012,41 @@ func jsonResponse(w http.ResponseWriter, r *http.Request, data interface{}) {
 //
 
 // RequestLogger logs the request based on the request object passed into
-// it.
+// it. Once handled a line in SentLogFormat records the status, bytes
+// sent and referrer.
 func RequestLogger(next http.Handler) http.Handler {
+	return RequestLoggerTo(log.Default(), next)
+}
+
+// RequestLoggerTo is RequestLogger writing to logger, e.g. a
+// virtual host's own log file.
+func RequestLoggerTo(logger *log.Logger, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		q := r.URL.Query()
 		if len(q) > 0 {
-			log.Printf("request Method: %s Path: %s RemoteAddr: %s UserAgent: %s Query: %+v\n", r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent(), q)
+			logger.Printf("request Method: %s Path: %s RemoteAddr: %s UserAgent: %s Query: %+v\n", r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent(), q)
 		} else {
-			log.Printf("request Method: %s Path: %s RemoteAddr: %s UserAgent: %s\n", r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+			logger.Printf("request Method: %s Path: %s RemoteAddr: %s UserAgent: %s\n", r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
 		}
-		next.ServeHTTP(w, r)
+		sw := newStatusWriter(w)
+		next.ServeHTTP(sw, r)
+		// The sent line is summarized by "webserver logstats".
+		logger.Printf(SentLogFormat, r.Method, normalizeHost(r.Host), r.URL.Path, r.RemoteAddr, r.UserAgent(), sw.Status(), sw.size, r.Referer())
 	})
 }
 
 // ResponseLogger logs the response based on a request, status and error
-// message
+// message. If err is nil only the status is logged.
 func ResponseLogger(r *http.Request, status int, err error) {
 	q := r.URL.Query()
+	if err == nil {
+		if len(q) > 0 {
+			log.Printf("response Method: %s Path: %s RemoteAddr: %s UserAgent: %s Query: %+v Status: %d, %s\n", r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent(), q, status, http.StatusText(status))
+		} else {
+			log.Printf("response Method: %s Path: %s RemoteAddr: %s UserAgent: %s Status: %d, %s\n", r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent(), status, http.StatusText(status))
+		}
+		return
+	}
 	if len(q) > 0 {
 		log.Printf("response Method: %s Path: %s RemoteAddr: %s UserAgent: %s Query: %+v Status: %d, %s %q\n", r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent(), q, status, http.StatusText(status), err)
 	} else {
@@ -752,18 +2064,6 @@ func ResponseLogger(r *http.Request, status int, err error) {
 // See https://golang.org/pkg/net/http/#example_FileServer_dotFileHiding
 //
 
-// hasDotPrefix checks a path for containing either ., .. prefixes
-// in a path.
-func hasDotPrefix(s string) bool {
-	parts := strings.Split(s, "/")
-	for _, p := range parts {
-		if strings.HasPrefix(p, ".") {
-			return true
-		}
-	}
-	return false
-}
-
 // SafeFile are ones that do NOT have a "." as a prefix
 // on the path.
 type SafeFile struct {
@@ -797,7 +2097,7 @@ func (sf SafeFile) Readdir(n int) ([]os.FileInfo, error) {
 // SafeFileSystem. It serves a 403 permision error when name has
 // a file or directory who's path parts is a dot file prefix.
 func (fs SafeFileSystem) Open(p string) (http.File, error) {
-	if hasDotPrefix(p) {
+	if _, err := SafePath(p); err != nil {
 		// If dot file setup to return a 403 response by
 		// passing an OS level file permission error
 		return nil, os.ErrPermission
@@ -807,12 +2107,18 @@ func (fs SafeFileSystem) Open(p string) (http.File, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Only directory listings need filtering, files are returned
+	// as is so an *os.File can be sent with sendfile.
+	if info, err := fp.Stat(); err == nil && info.IsDir() == false {
+		return fp, nil
+	}
 	return SafeFile{fp}, err
 }
 
 ///
 // SafeFileSystem returns a new safe file system using
-// the *WebService.DocRoot as the directory source.
+// the *WebService.DocRoot as the directory source (or the
+// S3 bucket when configured) layered over DocRootLayers.
 //
 // Example usage:
 //
@@ -825,15 +2131,33 @@ func (fs SafeFileSystem) Open(p string) (http.File, error) {
 // log.Fatal(http.ListenAndService(ws.Http.Hostname(), nil))
 //
 func (w *WebService) SafeFileSystem() (SafeFileSystem, error) {
-	if w.DocRoot == "" {
-		w.DocRoot = "."
+	var (
+		sfs SafeFileSystem
+		err error
+	)
+	switch {
+	case w.S3 != nil:
+		sfs = SafeFileSystem{w.S3}
+	default:
+		if w.DocRoot == "" {
+			w.DocRoot = "."
+		}
+		if sfs, err = MakeSafeFileSystem(w.DocRoot); err != nil {
+			return SafeFileSystem{}, err
+		}
 	}
-	if info, err := os.Stat(w.DocRoot); err != nil {
-		return SafeFileSystem{}, err
-	} else if info.IsDir() == false {
-		return SafeFileSystem{}, fmt.Errorf("%q is not a directory", w.DocRoot)
+	if len(w.DocRootLayers) == 0 {
+		return sfs, nil
+	}
+	union := UnionFileSystem{sfs.FileSystem}
+	for _, docRoot := range w.DocRootLayers {
+		layer, err := MakeSafeFileSystem(docRoot)
+		if err != nil {
+			return SafeFileSystem{}, err
+		}
+		union = append(union, layer.FileSystem)
 	}
-	return SafeFileSystem{http.Dir(w.DocRoot)}, nil
+	return SafeFileSystem{union}, nil
 }
 
 //
@@ -853,6 +2177,9 @@ func MakeSafeFileSystem(docRoot string) (SafeFileSystem, error) {
 	if docRoot == "" {
 		return SafeFileSystem{}, fmt.Errorf("document root not set")
 	}
+	if strings.HasSuffix(docRoot, ".zip") {
+		return MakeZipFileSystem(docRoot)
+	}
 	if info, err := os.Stat(docRoot); err != nil {
 		return SafeFileSystem{}, err
 	} else if info.IsDir() == false {
@@ -870,7 +2197,20 @@ func MakeSafeFileSystem(docRoot string) (SafeFileSystem, error) {
 type WebService struct {
 	// This is the document root for static file services
 	// If an empty string then assume current working directory.
+	// A path ending in ".zip" serves the contents of the archive.
 	DocRoot string `json:"htdocs" toml:"htdocs"`
+
+	// DocRootLayers are additional document roots searched in order
+	// after DocRoot, e.g. a shared theme directory. The first root
+	// holding a path wins.
+	DocRootLayers []string `json:"htdocs_layers,omitempty" toml:"htdocs_layers,omitempty"`
+
+	// ZipMounts maps a URL path prefix to a ZIP archive whose
+	// contents are served below that prefix.
+	ZipMounts map[string]string `json:"zip_mounts,omitempty" toml:"zip_mounts,omitempty"`
+	// S3 if set serves the static content from an S3 compatible
+	// bucket instead of DocRoot.
+	S3 *S3FileSystem `json:"s3,omitempty" toml:"s3,omitempty"`
 	// Https describes an Https service
 	Https *Service `json:"https,omitempty" toml:"https,omitempty"`
 	// Http describes an Http service
@@ -887,14 +2227,49 @@ type WebService struct {
 	// CORS describes the CORS policy for the web services
 	CORS *CORSPolicy `json:"cors,omitempty" toml:"cors,omitempty"`
 
+	// CSP sets a Content-Security-Policy with a per request nonce.
+	CSP *CSPPolicy `json:"csp,omitempty" toml:"csp,omitempty"`
+
 	// ContentTypes describes a file extension mapped to a single
 	// MimeType.
 	ContentTypes map[string]string `json:"content_types,omitempty" toml:"content_types,omitempty"`
 
+	// ContentTypePrefixes forces a type for every file below a path
+	// prefix regardless of extension, e.g. "/downloads/" =
+	// "application/octet-stream". The longest matching prefix wins.
+	ContentTypePrefixes map[string]string `json:"content_type_prefixes,omitempty" toml:"content_type_prefixes,omitempty"`
+
+	// GzipExtensions lists the extensions whose precompressed ".gz"
+	// files (e.g. "data.json.gz") are sent gzip encoded with the
+	// underlying type, DefaultGzipExtensions if not set.
+	GzipExtensions []string `json:"gzip_extensions,omitempty" toml:"gzip_extensions,omitempty"`
+
+	// GzipStatic answers requests for a GzipExtensions file (e.g.
+	// "data.json") with its ".gz" sibling when the client accepts gzip.
+	// Range requests are always answered from the file itself.
+	GzipStatic bool `json:"gzip_static,omitempty" toml:"gzip_static,omitempty"`
+
+	// StrictMime only serves files whose extension is in ContentTypes,
+	// others are answered 404 (e.g. stray .bak, .sql or swap files).
+	StrictMime bool `json:"strict_mime,omitempty" toml:"strict_mime,omitempty"`
+
+	// MimeFallback is used for extensions without a known type,
+	// "sniff" (default) detects it from the content, "reject" answers
+	// 415, otherwise it is the type to use (e.g. "application/octet-stream").
+	MimeFallback string `json:"mime_fallback,omitempty" toml:"mime_fallback,omitempty"`
+
+	// Charsets maps a type or wildcard (e.g. "text/*") to the charset
+	// parameter added to its Content-Type.
+	Charsets map[string]string `json:"charsets,omitempty" toml:"charsets,omitempty"`
+
 	// RedirectsCSV is the filename/path to a CSV file describing
 	// redirects.
 	RedirectsCSV string `json:"redirects_csv,omitempty" toml:"redirects_csv,omitempty"`
 
+	// Route is the ordered list of [[route]] rules, the first rule
+	// fitting a request handles it, see RouteRule.
+	Route []*RouteRule `json:"route,omitempty" toml:"route,omitempty"`
+
 	// Redirects describes a target path to destination path.
 	// Normally this is populated by a redirects.csv file.
 	Redirects map[string]string `json:"redirects,omitempty" toml:"redirects,omitempty"`
@@ -902,6 +2277,191 @@ type WebService struct {
 	// ReverseProxy descibes the path web paths that are sent
 	// to another proxied URL.
 	ReverseProxy map[string]string `json:"reverse_proxy,omitempty" toml:"reverse_proxy,omitempty"`
+
+	// Upload configures an optional upload handler. The upload prefix
+	// must be covered by the Access routes.
+	Upload *UploadService `json:"upload,omitempty" toml:"upload,omitempty"`
+
+	// Tus configures resumable uploads using the tus protocol. Like
+	// Upload the prefix must be covered by the Access routes.
+	Tus *TusService `json:"tus,omitempty" toml:"tus,omitempty"`
+
+	// ForwardAuthPath if set is the URL path (e.g. "/auth") where
+	// other reverse proxies can check credentials against Access.
+	ForwardAuthPath string `json:"forward_auth_path,omitempty" toml:"forward_auth_path,omitempty"`
+
+	// TrustedProxies lists the CIDRs (or addresses) of proxies, e.g.
+	// a load balancer, whose X-Forwarded-For or X-Real-IP headers
+	// are used for the client address and X-Forwarded-Proto for
+	// the scheme.
+	TrustedProxies []string `json:"trusted_proxies,omitempty" toml:"trusted_proxies,omitempty"`
+
+	// Webhooks are notified of startup, shutdown, certificate expiry,
+	// repeated server errors and repeated failed logins.
+	Webhooks []*Webhook `json:"webhooks,omitempty" toml:"webhooks,omitempty"`
+
+	// CertExpiryDays is how many days before a certificate expires
+	// warnings are logged and sent, 30 if not set.
+	CertExpiryDays int `json:"cert_expiry_days,omitempty" toml:"cert_expiry_days,omitempty"`
+
+	// Datasets mount dataset collections (or directories of JSON
+	// documents) as read only JSON APIs.
+	Datasets []*DatasetService `json:"datasets,omitempty" toml:"datasets,omitempty"`
+
+	// DirectoryListing replaces the plain directory listings with
+	// sortable ones showing file type icons, breadcrumbs and the
+	// directory's README.md.
+	DirectoryListing bool `json:"directory_listing,omitempty" toml:"directory_listing,omitempty"`
+
+	// Admin exposes user and route management as a JSON API, see
+	// AdminAPI.
+	Admin *AdminAPI `json:"admin,omitempty" toml:"admin,omitempty"`
+
+	// MockAPI answers requests below a prefix with canned JSON
+	// fixtures, for developing against an API that doesn't exist yet.
+	MockAPI *MockAPI `json:"mock_api,omitempty" toml:"mock_api,omitempty"`
+
+	// Languages serves language variants of static files based on
+	// Accept-Language.
+	Languages *Languages `json:"languages,omitempty" toml:"languages,omitempty"`
+
+	// Gone lists path prefixes or patterns (e.g. "/exhibits/*.php")
+	// of retired URLs answered with 410 Gone.
+	Gone []string `json:"gone,omitempty" toml:"gone,omitempty"`
+
+	// GonePage is a page in the document root sent with 410 responses.
+	GonePage string `json:"gone_page,omitempty" toml:"gone_page,omitempty"`
+
+	// Methods lists the HTTP methods allowed below a path prefix
+	// (e.g. "/api/" = ["GET", "POST"]), others are answered 405.
+	// Static files only allow StaticMethods.
+	Methods map[string][]string `json:"methods,omitempty" toml:"methods,omitempty"`
+
+	// Schedule limits content below a prefix to a publish window,
+	// e.g. embargoed theses.
+	Schedule []*PublishWindow `json:"schedule,omitempty" toml:"schedule,omitempty"`
+
+	// Mirrors copy a share of requests to a secondary upstream,
+	// discarding its responses.
+	Mirrors []*Mirror `json:"mirrors,omitempty" toml:"mirrors,omitempty"`
+
+	// Canaries send a share of a ReverseProxy route's requests to
+	// a canary upstream, keyed by the route's prefix.
+	Canaries map[string]*Canary `json:"canaries,omitempty" toml:"canaries,omitempty"`
+
+	// IdentityHeaders lists ReverseProxy prefixes whose upstreams
+	// are trusted with the authenticated user, sent in the
+	// X-Authenticated-User and X-Authenticated-Groups headers.
+	IdentityHeaders []string `json:"identity_headers,omitempty" toml:"identity_headers,omitempty"`
+
+	// ProxyQueues limit the concurrent requests sent by a
+	// ReverseProxy route, keyed by its prefix, queueing the rest.
+	ProxyQueues map[string]*ProxyQueue `json:"proxy_queues,omitempty" toml:"proxy_queues,omitempty"`
+
+	// SignedURLs lets expiring signed links open protected files.
+	SignedURLs *SignedURLs `json:"signed_urls,omitempty" toml:"signed_urls,omitempty"`
+
+	// ProxyErrors are the pages sent when a ReverseProxy (or proxy
+	// route) upstream fails or times out.
+	ProxyErrors *ProxyErrorPages `json:"proxy_errors,omitempty" toml:"proxy_errors,omitempty"`
+
+	// Query strips or sorts query parameters before requests are
+	// logged and handled.
+	Query *QueryRules `json:"query,omitempty" toml:"query,omitempty"`
+
+	// Headers set, append or remove request and response headers
+	// below a prefix, see HeaderRule.
+	Headers []*HeaderRule `json:"headers,omitempty" toml:"headers,omitempty"`
+
+	// Hosts are the virtual hosts served, requests for other hosts
+	// are served the site described by the WebService (see
+	// DefaultHost and UnknownHostPage).
+	Hosts []*VirtualHost `json:"hosts,omitempty" toml:"hosts,omitempty"`
+
+	// DefaultHost names the host whose site is served for requests
+	// to hosts that aren't configured.
+	DefaultHost string `json:"default_host,omitempty" toml:"default_host,omitempty"`
+
+	// UnknownHostPage is a page sent with 404 for requests to hosts
+	// that aren't configured, if DefaultHost isn't set.
+	UnknownHostPage string `json:"unknown_host_page,omitempty" toml:"unknown_host_page,omitempty"`
+
+	// Fingerprint serves assets at URLs holding a hash of their
+	// content so they can be cached for good.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty" toml:"fingerprint,omitempty"`
+
+	// Preload sends Link preload headers (and optionally 103 Early
+	// Hints) for the critical assets of pages.
+	Preload []*Preload `json:"preload,omitempty" toml:"preload,omitempty"`
+
+	// Templates renders html/template pages at mapped paths.
+	Templates *Templates `json:"templates,omitempty" toml:"templates,omitempty"`
+
+	// Faults inject latency, bandwidth limits and errors, for
+	// testing clients.
+	Faults []*Fault `json:"faults,omitempty" toml:"faults,omitempty"`
+
+	// AssetCache keeps small files in memory, optionally reading
+	// hot paths at startup.
+	AssetCache *AssetCache `json:"asset_cache,omitempty" toml:"asset_cache,omitempty"`
+
+	// Bandwidth limits how fast responses below a prefix are sent,
+	// per request or per client address.
+	Bandwidth []*Bandwidth `json:"bandwidth,omitempty" toml:"bandwidth,omitempty"`
+
+	// HAR records requests and responses for debugging.
+	HAR *HARRecorder `json:"har,omitempty" toml:"har,omitempty"`
+
+	// LogFormat is "standard" or "dev" (compact, colorized lines for
+	// development). If not set "dev" is used when the log is a
+	// terminal.
+	LogFormat string `json:"log_format,omitempty" toml:"log_format,omitempty"`
+
+	// Open launches the default web browser at the first service
+	// once it is listening, for previewing a site.
+	Open bool `json:"-" toml:"-"`
+
+	// LiveReload reloads browsers when files in the document root
+	// change. It is meant for development.
+	LiveReload bool `json:"live_reload,omitempty" toml:"live_reload,omitempty"`
+
+	// MiddlewareRules turn middleware off, or back on, below path
+	// prefixes.
+	MiddlewareRules []*MiddlewareRule `json:"middleware_rules,omitempty" toml:"middleware_rules,omitempty"`
+
+	// Robots generates robots.txt and X-Robots-Tag headers.
+	Robots *Robots `json:"robots,omitempty" toml:"robots,omitempty"`
+
+	// StatusPath if set is the URL path where a JSON document describing
+	// the running build and configuration is served (e.g. "/status").
+	StatusPath string `json:"status_path,omitempty" toml:"status_path,omitempty"`
+
+	// started records when Run() was called.
+	started time.Time
+
+	// middleware and handlers are set with WithMiddleware and
+	// WithHandler.
+	middleware []Middleware
+	handlers   map[string]http.Handler
+
+	// liveReload notifies browsers of changes, see LiveReload.
+	liveReload *liveReload
+
+	// upstreams records the metrics of ReverseProxy upstreams.
+	upstreams upstreamMetrics
+
+	// notify sends webhooks, see Notify.
+	notifyOnce sync.Once
+	notify     *notifier
+
+	// tracer captures request traces for the AdminAPI.
+	tracer Tracer
+
+	// served are the certificates tlsConfig loaded, certs the last
+	// check of them, see checkCertExpiry.
+	certMu sync.Mutex
+	served []*servedCert
+	certs  []*CertStatus
 }
 
 // Service holds the description needed to startup a service
@@ -912,12 +2472,16 @@ type Service struct {
 	// Host is the hostname to use, if empty "localhost" is assumed"
 	Host string `json:"host,omitempty" toml:"host,omitempty"`
 	// Port is a string holding the port number to listen on
-	// An empty strings defaults port to 8000
+	// An empty strings defaults port to 8000. "0" or "auto" listens
+	// on a free port, see the "Serving" log line for which.
 	Port string `json:"port,omitempty" toml:"port,omitempty"`
 	// CertPEM describes the location of cert.pem used for TLS support
 	CertPEM string `json:"cert_pem,omitempty" toml:"cert_pem,omitempty"`
 	// KeyPEM describes the location of the key.pem used for TLS support
 	KeyPEM string `json:"key_pem,omitempty" toml:"key_pem,omitempty"`
+	// ProxyProtocol requires connections to start with a HAProxy
+	// PROXY protocol (v1 or v2) header, e.g. behind a TCP load balancer.
+	ProxyProtocol bool `json:"proxy_protocol,omitempty" toml:"proxy_protocol,omitempty"`
 }
 
 // String renders an URL version of *Service.
@@ -930,6 +2494,23 @@ func (s *Service) String() string {
 	return strings.Join(r, "")
 }
 
+// Listen opens a TCP listener for the service, requiring PROXY
+// protocol headers when ProxyProtocol is set.
+func (s *Service) Listen() (net.Listener, error) {
+	addr := s.Hostname()
+	if s.Port == "auto" {
+		addr = net.JoinHostPort(s.Host, "0")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.ProxyProtocol {
+		return NewProxyProtocolListener(ln), nil
+	}
+	return ln, nil
+}
+
 // Hostname returns a host+port from a *Service
 func (s *Service) Hostname() string {
 	r := []string{}
@@ -944,38 +2525,34 @@ func (s *Service) Hostname() string {
 
 // LoadWebService loads a configuration file of *WebService
 func LoadWebService(setup string) (*WebService, error) {
-	var (
-		ws  *WebService
-		err error
-	)
-
-	switch {
-	case strings.HasSuffix(setup, ".toml"):
-		ws, err = loadWebServiceTOML(setup)
-	case strings.HasSuffix(setup, ".json"):
-		ws, err = loadWebServiceJSON(setup)
-	default:
-		err = fmt.Errorf("%q, unknown format.", setup)
+	format := formatOf(setup)
+	if format == "" {
+		return nil, fmt.Errorf("%q, unknown format.", setup)
 	}
+	fp, err := os.Open(setup)
 	if err != nil {
 		return nil, err
 	}
-	// If AccessFile set is set overwrite .Access ...
-	if ws.AccessFile != "" {
-		ws.Access, err = LoadAccess(ws.AccessFile)
-	}
-	return ws, err
+	defer fp.Close()
+	return LoadWebServiceFrom(fp, format)
 }
 
-// loadWebServiceTOML loads a *WebService from a TOML file.
-func loadWebServiceTOML(setup string) (*WebService, error) {
-	src, err := ioutil.ReadFile(setup)
-	if err != nil {
-		return nil, err
-	}
+// LoadWebServiceFrom reads a *WebService configuration from r.
+// Format is "toml" or "json". If AccessFile is set it is loaded
+// into .Access.
+func LoadWebServiceFrom(r io.Reader, format string) (*WebService, error) {
 	w := new(WebService)
-	if _, err := toml.Decode(string(src), &w); err != nil {
-		return nil, err
+	switch formatOf(format) {
+	case "toml":
+		if _, err := toml.NewDecoder(r).Decode(&w); err != nil {
+			return nil, err
+		}
+	case "json":
+		if err := json.NewDecoder(r).Decode(&w); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%q, unknown format.", format)
 	}
 	if w.DocRoot == "" {
 		w.DocRoot = "."
@@ -986,72 +2563,100 @@ func loadWebServiceTOML(setup string) (*WebService, error) {
 	if w.Https != nil {
 		w.Https.Scheme = "https"
 	}
+	// If AccessFile set is set overwrite .Access ...
+	if w.AccessFile != "" {
+		access, err := LoadAccess(w.AccessFile)
+		if err != nil {
+			return nil, err
+		}
+		w.Access = access
+	}
 	return w, nil
 }
 
-// loadWebServiceJSON loads a *WebService from a JSON file.
-func loadWebServiceJSON(setup string) (*WebService, error) {
-	src, err := ioutil.ReadFile(setup)
-	if err != nil {
-		return nil, err
-	}
-	w := new(WebService)
-	if err := json.Unmarshal(src, &w); err != nil {
-		return nil, err
-	}
-	if w.DocRoot == "" {
-		w.DocRoot = "."
-	}
-	if w.Http != nil {
-		w.Http.Scheme = "http"
+// DumpWebService writes a configuration file, see WriteConfigFile.
+func (ws *WebService) DumpWebService(fName string) error {
+	format := formatOf(fName)
+	if format == "" {
+		return fmt.Errorf("%q, unsupported format", fName)
 	}
-	if w.Https != nil {
-		w.Https.Scheme = "https"
+	buf := new(bytes.Buffer)
+	if err := ws.DumpTo(buf, format); err != nil {
+		return err
 	}
-	return w, nil
+	return WriteConfigFile(fName, buf.Bytes(), 0600, func(src []byte) error {
+		return decodeConfig(src, format, new(WebService))
+	})
 }
 
-// DumpWebService writes a access file.
-func (ws *WebService) DumpWebService(fName string) error {
-	var (
-		access *Access
-		err    error
-	)
-	if ws.AccessFile != "" && ws.Access != nil {
-		access = ws.Access
-		ws.Access = nil
+// settings returns a copy of the exported fields of ws, leaving out
+// the state (locks, counters) of the running service.
+func (ws *WebService) settings() *WebService {
+	c := new(WebService)
+	src, dst := reflect.ValueOf(ws).Elem(), reflect.ValueOf(c).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if src.Type().Field(i).IsExported() {
+			dst.Field(i).Set(src.Field(i))
+		}
 	}
-	switch {
-	case strings.HasSuffix(fName, ".toml"):
-		err = ws.dumpWebServiceTOML(fName)
-	case strings.HasSuffix(fName, ".json"):
-		err = ws.dumpWebServiceJSON(fName)
+	return c
+}
+
+// DumpTo writes the configuration to w. Format is "toml" or "json".
+// When AccessFile is set the access settings are left to that file.
+func (ws *WebService) DumpTo(w io.Writer, format string) error {
+	c := ws.settings()
+	if c.AccessFile != "" {
+		c.Access = nil
+	}
+	switch formatOf(format) {
+	case "toml":
+		return toml.NewEncoder(w).Encode(c)
+	case "json":
+		src, err := json.MarshalIndent(c, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(src)
+		return err
 	default:
-		err = fmt.Errorf("%q, unsupported format", fName)
+		return fmt.Errorf("%q, unsupported format", format)
 	}
-	if access != nil {
-		ws.Access = access
-	}
-	return err
 }
 
-// dumpWebServiceTOML writes a TOML file.
-func (ws *WebService) dumpWebServiceTOML(fName string) error {
-	buf := new(bytes.Buffer)
-	tomlEncoder := toml.NewEncoder(buf)
-	if err := tomlEncoder.Encode(ws); err != nil {
-		return err
+// ShutdownTimeout is how long Run waits for requests in flight
+// when interrupted.
+var ShutdownTimeout = 10 * time.Second
+
+// serviceNames describes the configured services for notifications.
+func (w *WebService) serviceNames() string {
+	names := []string{}
+	if w.Http != nil {
+		names = append(names, w.Http.String())
 	}
-	return ioutil.WriteFile(fName, buf.Bytes(), 0600)
+	if w.Https != nil {
+		names = append(names, w.Https.String())
+	}
+	if len(names) == 0 {
+		return "webserver"
+	}
+	return strings.Join(names, ", ")
 }
 
-// dumpWebServiceJSON writes a JSON file.
-func (ws *WebService) dumpWebServiceJSON(fName string) error {
-	src, err := json.MarshalIndent(ws, "", "    ")
-	if err != nil {
-		return err
+// services returns the configured services, http on port 8000 if
+// none are.
+func (w *WebService) services() []*Service {
+	services := []*Service{}
+	if w.Http != nil {
+		services = append(services, w.Http)
+	}
+	if w.Https != nil {
+		services = append(services, w.Https)
+	}
+	if len(services) == 0 {
+		services = append(services, &Service{Scheme: "http", Port: "8000"})
 	}
-	return ioutil.WriteFile(fName, src, 0600)
+	return services
 }
 
 // Run() starts a web service(s) described in the *WebService struct.
@@ -1063,7 +2668,11 @@ func (w *WebService) Run() error {
 			return err
 		}
 	}
-	log.Printf("Document root %s", w.DocRoot)
+	if w.S3 != nil {
+		log.Printf("Document root s3 bucket %s%s at %s", w.S3.Bucket, path.Join("/", w.S3.Prefix), w.S3.Endpoint)
+	} else {
+		log.Printf("Document root %s", w.DocRoot)
+	}
 	if w.Http != nil {
 		log.Printf("Listening for %s", w.Http.String())
 	}
@@ -1071,30 +2680,325 @@ func (w *WebService) Run() error {
 		log.Printf("Listening for %s", w.Https.String())
 	}
 
+	if err := w.diagnose(); err != nil {
+		return err
+	}
+
+	w.started = time.Now()
+
+	handler, err := w.Handler()
+	if err != nil {
+		return err
+	}
+
+	// Run the configured services.
+	services := w.services()
+	tlsConfig, err := w.tlsConfig()
+	if err != nil {
+		return err
+	}
+	servers := []*http.Server{}
+	// shutdown stops the servers started, waiting up to
+	// ShutdownTimeout for requests in flight.
+	shutdown := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		for _, srv := range servers {
+			srv.Shutdown(ctx)
+		}
+		if w.HAR != nil {
+			if err := w.HAR.Stop(); err != nil {
+				log.Printf("har %s, %s", w.HAR.File, err)
+			}
+		}
+	}
+	errc := make(chan error, len(services))
+	for _, s := range services {
+		ln, err := s.Listen()
+		if err != nil {
+			shutdown()
+			return err
+		}
+		urls := ListenURLs(s.Scheme, s.Host, ln.Addr())
+		for _, u := range urls {
+			log.Printf("Serving %s", u)
+		}
+		if w.Open && len(servers) == 0 {
+			if err := OpenBrowser(urls[0]); err != nil {
+				log.Printf("open %s, %s", urls[0], err)
+			}
+		}
+		srv := &http.Server{Handler: handler}
+		servers = append(servers, srv)
+		go func(s *Service, ln net.Listener) {
+			if s.Scheme == "https" && tlsConfig != nil {
+				// The certificates checkCertExpiry checks.
+				srv.TLSConfig = tlsConfig
+				errc <- srv.ServeTLS(ln, "", "")
+			} else if s.Scheme == "https" {
+				errc <- srv.ServeTLS(ln, s.CertPEM, s.KeyPEM)
+			} else {
+				errc <- srv.Serve(ln)
+			}
+		}(s, ln)
+	}
+	w.Notify(EventStartup, fmt.Sprintf("%s started", w.serviceNames()))
+	w.checkCertExpiry()
+	done := make(chan struct{})
+	defer close(done)
+	go w.watchCertExpiry(done)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	select {
+	case err := <-errc:
+		// One service failing stops the others.
+		w.Notify(EventShutdown, fmt.Sprintf("%s stopped, %s", w.serviceNames(), err))
+		shutdown()
+		w.waitNotify()
+		return err
+	case s := <-sig:
+		log.Printf("Received %s, shutting down", s)
+		w.Notify(EventShutdown, fmt.Sprintf("%s stopping, %s", w.serviceNames(), s))
+		shutdown()
+		w.waitNotify()
+		return nil
+	}
+}
+
+// Handler assembles the http.Handler described by the *WebService,
+// the static file service wrapped by the configured middleware.
+func (w *WebService) Handler() (http.Handler, error) {
 	// Setup our Safe file system handler.
 	fs, err := w.SafeFileSystem()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if w.LogFormat != "" && w.LogFormat != LogFormatStandard && w.LogFormat != LogFormatDev {
+		return nil, fmt.Errorf("unsupported log_format %q", w.LogFormat)
+	}
+	if w.LiveReload && w.liveReload == nil {
+		w.liveReload = newLiveReload(w.liveReloadDirs())
+	}
+	if w.ProxyErrors != nil {
+		if err := w.ProxyErrors.load(); err != nil {
+			return nil, err
+		}
+	}
+	if w.SignedURLs != nil {
+		if err := w.SignedURLs.load(); err != nil {
+			return nil, err
+		}
+	}
+	var files http.FileSystem = fs
+	if w.AssetCache != nil {
+		files = w.AssetCache.FileSystem(fs)
+		w.AssetCache.WarmUp(files)
+	}
+	handler, err := w.siteHandler(files, w.Access, w.CORS)
+	if err != nil {
+		return nil, err
+	}
+	// Virtual hosts may log to their own files.
+	if len(w.Hosts) > 0 {
+		if handler, err = w.hostHandler(handler); err != nil {
+			return nil, err
+		}
+	} else {
+		handler = traceLayer("logging", "", w.requestLogger(handler))
+	}
+	if w.HAR != nil {
+		handler = skippable(MiddlewareHAR, traceLayer("har", "", w.HAR.Handler(handler)), handler)
+	}
+	tp, err := ParseTrustedProxies(w.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if len(w.Headers) > 0 {
+		if handler, err = HeadersHandler(w.Headers, handler); err != nil {
+			return nil, err
+		}
+		handler = traceLayer("headers", "", handler)
+	}
+	if w.Query != nil {
+		handler = w.Query.Handler(handler)
 	}
+	if len(w.MiddlewareRules) > 0 {
+		if handler, err = MiddlewareRulesHandler(w.MiddlewareRules, handler); err != nil {
+			return nil, err
+		}
+	}
+	if w.Admin != nil {
+		// Requests reading the traces aren't traced themselves.
+		w.tracer.skip = "/" + strings.Trim(w.Admin.Prefix, "/") + "/"
+		handler = w.tracer.Handler(handler)
+	}
+	// Everything after this sees the path that is served.
+	return tp.Handler(CleanPathHandler(handler)), nil
+}
 
-	//FIXME: Figure out a better way to stack up handlers...
+// siteHandler assembles the handler of a site serving fs, the
+// static files wrapped by the configured middleware, restricted by
+// access and cors (either may be nil).
+func (w *WebService) siteHandler(fs http.FileSystem, access *Access, cors *CORSPolicy) (http.Handler, error) {
+	static, err := w.staticHandler(fs)
+	if err != nil {
+		return nil, err
+	}
 	mux := http.NewServeMux()
-	mux.Handle("/", http.FileServer(fs))
-
-	// Run the configured services.
-	switch {
-	case w.Http != nil && w.Https != nil:
-		// Run our http service in a go routine
-		go func() {
-			http.ListenAndServe(w.Http.Hostname(), RequestLogger(AccessHandler(mux, w.Access)))
-		}()
-		// Return our primary https service routine
-		return http.ListenAndServeTLS(w.Https.Hostname(), w.Https.CertPEM, w.Https.KeyPEM, RequestLogger(AccessHandler(mux, w.Access)))
-	case w.Https != nil:
-		return http.ListenAndServeTLS(w.Https.Hostname(), w.Https.CertPEM, w.Https.KeyPEM, RequestLogger(AccessHandler(mux, w.Access)))
-	case w.Http != nil:
-		return http.ListenAndServe(w.Http.Hostname(), RequestLogger(AccessHandler(mux, w.Access)))
-	default:
-		return http.ListenAndServe(":8000", RequestLogger(AccessHandler(mux, w.Access)))
+	if w.LiveReload {
+		static = injectLiveReload(static)
+		mux.Handle(LiveReloadPath, w.liveReload)
+	}
+	mux.Handle("/", traceLayer("static", "", static))
+	if w.StatusPath != "" {
+		mux.Handle(w.StatusPath, w.StatusHandler())
+	}
+	for prefix, fName := range w.ZipMounts {
+		zfs, err := MakeZipFileSystem(fName)
+		if err != nil {
+			return nil, err
+		}
+		prefix = "/" + strings.Trim(prefix, "/") + "/"
+		zipStatic, err := w.staticHandler(zfs)
+		if err != nil {
+			return nil, err
+		}
+		mux.Handle(prefix, traceLayer("zip", fName, http.StripPrefix(strings.TrimSuffix(prefix, "/"), zipStatic)))
+	}
+	if err := w.proxyRoutes(mux); err != nil {
+		return nil, err
+	}
+	if w.ForwardAuthPath != "" {
+		if access == nil {
+			return nil, fmt.Errorf("forward auth path %q requires access to be configured", w.ForwardAuthPath)
+		}
+		mux.Handle(w.ForwardAuthPath, access.ForwardAuthHandler())
+	}
+	if w.Admin != nil {
+		w.Admin.tracer = &w.tracer
+		w.Admin.har = w.HAR
+		admin, err := w.Admin.Handler(access, w.accessFile(access))
+		if err != nil {
+			return nil, err
+		}
+		mux.Handle("/"+strings.Trim(w.Admin.Prefix, "/")+"/", traceLayer("admin", "", admin))
+	}
+	for pattern, h := range w.handlers {
+		mux.Handle(pattern, h)
+	}
+	var handler http.Handler = mux
+	if w.Upload != nil {
+		if access == nil || access.isAccessRoute(w.Upload.Prefix) == false {
+			return nil, fmt.Errorf("upload prefix %q must be protected by an access route", w.Upload.Prefix)
+		}
+		handler = w.Upload.Handler(w.DocRoot, handler)
+	}
+	if w.Tus != nil {
+		if access == nil || access.isAccessRoute(w.Tus.Prefix) == false || access.isAccessRoute(strings.TrimSuffix(w.Tus.Prefix, "/")) == false {
+			return nil, fmt.Errorf("tus prefix %q must be protected by an access route", w.Tus.Prefix)
+		}
+		if handler, err = w.Tus.Handler(w.DocRoot, handler); err != nil {
+			return nil, err
+		}
+	}
+	for _, ds := range w.Datasets {
+		if ds.Prefix == "" || ds.Path == "" {
+			return nil, fmt.Errorf("datasets require a prefix and path")
+		}
+		handler = traceLayer("dataset", ds.Prefix, ds.Handler(handler))
+	}
+	if w.MockAPI != nil {
+		if handler, err = w.MockAPI.Handler(handler); err != nil {
+			return nil, err
+		}
+	}
+	if w.Fingerprint != nil {
+		if handler, err = w.Fingerprint.Handler(fs, access, handler); err != nil {
+			return nil, err
+		}
+	}
+	if w.Templates != nil {
+		w.Templates.assets = w.Fingerprint
+		if handler, err = w.Templates.Handler(handler); err != nil {
+			return nil, err
+		}
+		handler = traceLayer("templates", "", handler)
+	}
+	if len(w.Preload) > 0 {
+		if handler, err = w.PreloadHandler(handler); err != nil {
+			return nil, err
+		}
+	}
+	if len(w.Route) > 0 {
+		if handler, err = w.RoutesHandler(fs, handler); err != nil {
+			return nil, err
+		}
+		handler = traceLayer("routes", "", handler)
+	}
+	if len(w.Schedule) > 0 {
+		if handler, err = ScheduleHandler(w.Schedule, handler); err != nil {
+			return nil, err
+		}
+	}
+	if len(w.Mirrors) > 0 {
+		if handler, err = MirrorHandler(w.Mirrors, handler); err != nil {
+			return nil, err
+		}
+	}
+	if len(w.Gone) > 0 {
+		handler = traceLayer("gone", "", GoneHandler(w.Gone, fs, w.GonePage, handler))
+	}
+	if len(w.Methods) > 0 {
+		if handler, err = MethodsHandler(w.Methods, handler); err != nil {
+			return nil, err
+		}
+		handler = traceLayer("methods", "", handler)
+	}
+	if len(w.Faults) > 0 {
+		faults, err := FaultHandler(w.Faults, handler)
+		if err != nil {
+			return nil, err
+		}
+		handler = skippable(MiddlewareFaults, traceLayer("faults", "", faults), handler)
+	}
+	if len(w.Bandwidth) > 0 {
+		bandwidth, err := BandwidthHandler(w.Bandwidth, handler)
+		if err != nil {
+			return nil, err
+		}
+		handler = skippable(MiddlewareBandwidth, traceLayer("bandwidth", "", bandwidth), handler)
+	}
+	for i := len(w.middleware) - 1; i >= 0; i-- {
+		handler = w.middleware[i](handler)
+	}
+	if access != nil && len(w.Webhooks) > 0 {
+		access.SetNotify(w.Notify)
+	}
+	handler = traceLayer("access", "", AccessHandler(handler, access))
+	if w.Languages != nil {
+		// Access checks the variant that is served.
+		handler = w.Languages.Handler(fs, handler)
+	}
+	if w.SignedURLs != nil {
+		handler = w.SignedURLs.Handler(handler)
+	}
+	if w.Robots != nil {
+		// robots.txt is public even when the site is protected.
+		handler = w.Robots.Handler(handler, access)
+	}
+	if w.CSP != nil {
+		handler = skippable(MiddlewareCSP, traceLayer("csp", "", w.CSP.Handler(handler)), handler)
+	}
+	if cors != nil {
+		if err := cors.Match.Validate(); err != nil {
+			return nil, fmt.Errorf("cors, %s", err)
+		}
+		handler = skippable(MiddlewareCORS, traceLayer("cors", "", cors.Handler(handler)), handler)
+	}
+	if len(w.Webhooks) > 0 {
+		handler = w.serverErrorHandler(handler)
 	}
+	return handler, nil
 }
@@ -19,6 +19,16 @@
 package wsfn
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -45,3 +55,438 @@ func TestIsDotPath(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadDumpFrom(t *testing.T) {
+	src := `htdocs = "htdocs"
+
+[http]
+host = "localhost"
+port = "8000"
+`
+	ws, err := LoadWebServiceFrom(strings.NewReader(src), "toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.DocRoot != "htdocs" || ws.Http == nil || ws.Http.Scheme != "http" || ws.Http.Port != "8000" {
+		t.Errorf("unexpected web service %+v", ws)
+	}
+	for _, format := range []string{"toml", "json"} {
+		buf := new(bytes.Buffer)
+		if err := ws.DumpTo(buf, format); err != nil {
+			t.Fatal(err)
+		}
+		c, err := LoadWebServiceFrom(buf, format)
+		if err != nil {
+			t.Fatalf("%s, %s", format, err)
+		}
+		if c.DocRoot != ws.DocRoot || c.Http.Hostname() != ws.Http.Hostname() {
+			t.Errorf("%s round trip, expected %+v, got %+v", format, ws, c)
+		}
+	}
+
+	// Dumping leaves the running service's Access alone.
+	ws.AccessFile, ws.Access = "access.toml", &Access{Routes: []string{"/private/"}}
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if ws.Access == nil {
+				t.Errorf("expected Access to stay set while dumping")
+				return
+			}
+		}
+	}()
+	buf := new(bytes.Buffer)
+	if err := ws.DumpTo(buf, "toml"); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if strings.Contains(buf.String(), "/private/") {
+		t.Errorf("expected the access settings left to the access file, got\n%s", buf)
+	}
+
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/private/"}}
+	a.UpdateAccess("Jane.Doe", "secret")
+	buf.Reset()
+	if err := a.DumpTo(buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadAccessFrom(buf, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Login("Jane.Doe", "secret") == false {
+		t.Errorf("expected login after round trip")
+	}
+	if _, err := LoadAccessFrom(buf, "yaml"); err == nil {
+		t.Errorf("expected unsupported format error")
+	}
+}
+
+func TestJSONResponse(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/item", nil)
+	rec := httptest.NewRecorder()
+	JSONResponse(rec, req, http.StatusCreated, map[string]int{"id": 1}, JSONCompact())
+	if rec.Code != http.StatusCreated {
+		t.Errorf("expected %d, got %d", http.StatusCreated, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if body := rec.Body.String(); body != `{"id":1}` {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	rec = httptest.NewRecorder()
+	JSONError(rec, req, http.StatusNotFound, fmt.Errorf("no such item"), JSONCompact())
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	if body := rec.Body.String(); body != `{"status":404,"message":"no such item"}` {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestJSONResponseETag(t *testing.T) {
+	data := map[string]string{"name": "Jane.Doe"}
+	req := httptest.NewRequest("GET", "/api/person", nil)
+	rec := httptest.NewRecorder()
+	JSONResponse(rec, req, http.StatusOK, data)
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("expected an ETag header")
+	}
+
+	req = httptest.NewRequest("GET", "/api/person", nil)
+	req.Header.Set("If-None-Match", `"stale", `+etag)
+	rec = httptest.NewRecorder()
+	JSONResponse(rec, req, http.StatusOK, data)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected %d, got %d", http.StatusNotModified, rec.Code)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected an empty body, got %q", rec.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/person", nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	rec = httptest.NewRecorder()
+	JSONResponse(rec, req, http.StatusOK, data)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestHashParameters(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "pbkdf2", SessionSeconds: -1}
+	a.PBKDF2 = &PBKDF2Params{Iterations: 1000, Hash: "sha512", KeyLen: 64}
+	if a.UpdateAccess("Jane.Doe", "secret") == false {
+		t.Fatal("failed to add user")
+	}
+	if p := a.Map["Jane.Doe"].PBKDF2; p == nil || p.Iterations != 1000 || p.Hash != "sha512" {
+		t.Errorf("expected parameters recorded with the secret, got %+v", p)
+	}
+	// Raising the cost doesn't break existing secrets.
+	a.PBKDF2 = &PBKDF2Params{Iterations: 2000, Hash: "sha256", KeyLen: 32}
+	if a.Login("Jane.Doe", "secret") == false {
+		t.Errorf("expected login with recorded parameters to succeed")
+	}
+	// Secrets without parameters use the legacy values.
+	legacy := &Secrets{Salt: []byte("salt")}
+	if legacy.Key, _ = a.hashPassword("old-secret", legacy); len(legacy.Key) != 32 {
+		t.Fatalf("unexpected legacy key length %d", len(legacy.Key))
+	}
+	a.Map["John.Doe"] = legacy
+	if a.Login("John.Doe", "old-secret") == false {
+		t.Errorf("expected legacy login to succeed")
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "md5", SessionSeconds: -1}
+	if err := a.UpdateUser("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyLogin("John.Doe", "secret"); errors.Is(err, ErrUnknownUser) == false {
+		t.Errorf("expected ErrUnknownUser, got %v", err)
+	}
+	if err := a.VerifyLogin("Jane.Doe", "wrong"); errors.Is(err, ErrBadPassword) == false {
+		t.Errorf("expected ErrBadPassword, got %v", err)
+	}
+	if err := a.RemoveUser("John.Doe"); errors.Is(err, ErrUnknownUser) == false {
+		t.Errorf("expected ErrUnknownUser, got %v", err)
+	}
+	a.Encryption = "rot13"
+	if err := a.UpdateUser("Jane.Doe", "secret"); errors.Is(err, ErrUnsupportedScheme) == false {
+		t.Errorf("expected ErrUnsupportedScheme, got %v", err)
+	}
+	rs, err := MakeRedirectService(map[string]string{"/a/": "/b/", "/a/c/": "/d/"})
+	if errors.Is(err, ErrRouteCollision) == false || rs != nil {
+		t.Errorf("expected ErrRouteCollision and nil service, got %v, %v", rs, err)
+	}
+}
+
+func TestDisabledUser(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/private/"}}
+	if err := a.UpdateUser("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	login := func() int {
+		req := httptest.NewRequest("GET", "/private/", nil)
+		req.SetBasicAuth("Jane.Doe", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if status := login(); status != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, status)
+	}
+	// Disabling forgets the remembered login.
+	if err := a.SetDisabled("Jane.Doe", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyLogin("Jane.Doe", "secret"); errors.Is(err, ErrUserDisabled) == false {
+		t.Errorf("expected ErrUserDisabled, got %v", err)
+	}
+	if status := login(); status != http.StatusUnauthorized {
+		t.Errorf("expected %d, got %d", http.StatusUnauthorized, status)
+	}
+	// A password change keeps the account disabled.
+	if err := a.UpdateUser("Jane.Doe", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyLogin("Jane.Doe", "secret"); errors.Is(err, ErrUserDisabled) == false {
+		t.Errorf("expected ErrUserDisabled after password change, got %v", err)
+	}
+	if err := a.SetDisabled("Jane.Doe", false); err != nil {
+		t.Fatal(err)
+	}
+	if status := login(); status != http.StatusOK {
+		t.Errorf("expected %d once enabled, got %d", http.StatusOK, status)
+	}
+	if err := a.SetDisabled("John.Doe", true); errors.Is(err, ErrUnknownUser) == false {
+		t.Errorf("expected ErrUnknownUser, got %v", err)
+	}
+}
+
+func TestAuthFailureLog(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "auth-failures.log")
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/private/"}, FailureLog: fName}
+	a.UpdateAccess("Jane.Doe", "secret")
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest("GET", "/private/index.html", nil)
+	req.RemoteAddr = "192.0.2.7:4321"
+	req.SetBasicAuth("Jane.Doe", "wrong")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+	src, err := os.ReadFile(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fail2ban failregex with <HOST> expanded.
+	re := regexp.MustCompile(`^\S+ wsfn: authentication failure; ip=192\.0\.2\.7 user="Jane\.Doe" path="/private/index\.html"\n$`)
+	if re.Match(src) == false {
+		t.Errorf("unexpected failure log %q", src)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	fName := filepath.Join(dir, "audit.log")
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/private/"}, AuditLog: fName}
+	a.UpdateAccess("Jane.Doe", "secret")
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, password := range []string{"wrong", "secret", "secret"} {
+		req := httptest.NewRequest("GET", "/private/", nil)
+		req.SetBasicAuth("Jane.Doe", password)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	accessFile := filepath.Join(dir, "access.json")
+	if err := a.DumpAccess(accessFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reload(accessFile); err != nil {
+		t.Fatal(err)
+	}
+	fp, err := os.Open(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	events, err := ReadAuditLog(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second successful login comes from the session cache.
+	expected := []string{AuditLoginFailure, AuditLogin, AuditReload}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+	for i, ev := range events {
+		if ev.Event != expected[i] || ev.Time.IsZero() {
+			t.Errorf("event %d, expected %q, got %+v", i, expected[i], ev)
+		}
+	}
+	if events[0].Username != "Jane.Doe" || events[0].Path != "/private/" || events[0].IP == "" {
+		t.Errorf("unexpected login failure event %+v", events[0])
+	}
+}
+
+func TestAccessConcurrency(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/private/"}}
+	a.UpdateAccess("Jane.Doe", "secret")
+	rs, _ := MakeRedirectService(map[string]string{"/old/": "/new/"})
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			username := fmt.Sprintf("user%d", i)
+			a.UpdateAccess(username, "password")
+			a.RemoveAccess(username)
+			a.SetRoutes([]string{"/private/", fmt.Sprintf("/%s/", username)})
+			target := fmt.Sprintf("/moved%d/", i)
+			rs.AddRedirectRoute(target, "/here/")
+			rs.RemoveRedirectRoute(target)
+		}(i)
+		go func() {
+			defer wg.Done()
+			if a.Login("Jane.Doe", "secret") == false {
+				t.Errorf("expected login to succeed")
+			}
+			a.isAccessRoute("/private/page.html")
+			rs.HasRoute("/old/")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRenameUser(t *testing.T) {
+	a := &Access{AuthType: "basic", Encryption: "md5", Routes: []string{"/reports/"}}
+	for _, username := range []string{"Jane.Doe", "John.Doe"} {
+		if err := a.UpdateUser(username, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Grant("Jane.Doe", "/reports/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RenameUser("Jane.Doe", "John.Doe"); err == nil {
+		t.Errorf("expected an error renaming to an existing user")
+	}
+	if err := a.RenameUser("Nobody", "Somebody"); errors.Is(err, ErrUnknownUser) == false {
+		t.Errorf("expected ErrUnknownUser, got %v", err)
+	}
+	if err := a.RenameUser("Jane.Doe", "Jane.Smith"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyLogin("Jane.Smith", "secret"); err != nil {
+		t.Errorf("expected the password to be kept, %s", err)
+	}
+	if err := a.VerifyLogin("Jane.Doe", "secret"); errors.Is(err, ErrUnknownUser) == false {
+		t.Errorf("expected ErrUnknownUser for the old name, got %v", err)
+	}
+	if secret, _ := a.Lookup("Jane.Smith"); len(secret.Routes) != 1 {
+		t.Errorf("expected the grants to be kept, got %q", secret.Routes)
+	}
+}
+
+func TestGeneratePassword(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		password, err := GeneratePassword(DefaultPasswordLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(password) != DefaultPasswordLength || strings.Trim(password, passwordChars) != "" {
+			t.Errorf("unexpected password %q", password)
+		}
+		if seen[password] {
+			t.Errorf("repeated password %q", password)
+		}
+		seen[password] = true
+	}
+	if _, err := GeneratePassword(8); err == nil {
+		t.Errorf("expected an error for a short password")
+	}
+}
+
+func TestStaticRouterCORS(t *testing.T) {
+	files := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	req := httptest.NewRequest("GET", "/app.mjs", nil)
+	req.Header.Set("Origin", "https://evil.example.org")
+	rec := httptest.NewRecorder()
+	StaticRouter(files).ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected no CORS headers without a policy, got %q", got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/javascript" {
+		t.Errorf("expected text/javascript, got %q", got)
+	}
+
+	cors := &CORSPolicy{Origin: "https://library.example.edu", Options: []string{"GET"}}
+	rec = httptest.NewRecorder()
+	cors.Handler(StaticRouter(files)).ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != cors.Origin {
+		t.Errorf("expected the policy's origin %q, got %q", cors.Origin, got)
+	}
+
+	rec = httptest.NewRecorder()
+	StaticRouter(files).ServeHTTP(rec, httptest.NewRequest("OPTIONS", "/app.mjs", nil))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Allow") == "" {
+		t.Errorf("expected 204 with Allow, got %d %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestHeadMatchesGet(t *testing.T) {
+	docRoot := t.TempDir()
+	dataDir := filepath.Join(t.TempDir(), "people")
+	os.MkdirAll(filepath.Join(docRoot, "docs"), 0755)
+	os.MkdirAll(dataDir, 0755)
+	os.WriteFile(filepath.Join(docRoot, "index.html"), []byte("<html><body>hello</body></html>"), 0644)
+	os.WriteFile(filepath.Join(docRoot, "docs", "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(docRoot, "gone.html"), []byte("<p>gone</p>"), 0644)
+	// Large enough that net/http can't work out the length itself.
+	big := `{"name": "` + strings.Repeat("x", 8192) + `"}`
+	os.WriteFile(filepath.Join(dataDir, "jane.json"), []byte(big), 0644)
+	ws := &WebService{
+		DocRoot:          docRoot,
+		DirectoryListing: true,
+		Datasets:         []*DatasetService{{Prefix: "/api/people/", Path: dataDir}},
+		Gone:             []string{"/old/"},
+		GonePage:         "gone.html",
+	}
+	h, err := ws.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	for _, p := range []string{"/", "/docs/", "/api/people/jane", "/api/people/", "/old/page", "/missing.html"} {
+		get, err := http.Get(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		get.Body.Close()
+		head, err := http.Head(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head.Body.Close()
+		if head.StatusCode != get.StatusCode {
+			t.Errorf("%s, HEAD status %d, GET status %d", p, head.StatusCode, get.StatusCode)
+		}
+		if head.ContentLength < 0 || head.ContentLength != get.ContentLength {
+			t.Errorf("%s, HEAD Content-Length %d, GET Content-Length %d", p, head.ContentLength, get.ContentLength)
+		}
+		for _, k := range []string{"Content-Type", "ETag"} {
+			if head.Header.Get(k) != get.Header.Get(k) {
+				t.Errorf("%s, HEAD %s %q, GET %q", p, k, head.Header.Get(k), get.Header.Get(k))
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// zipfs.go provides a read only http.FileSystem backed by a ZIP archive
+// so archived site snapshots can be served without unpacking them.
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// ZipBufferMaxBytes is the largest compressed entry decompressed
+// into memory when opened. Larger entries are decompressed as they
+// are read.
+var ZipBufferMaxBytes int64 = 1 << 20
+
+// ZipFileSystem serves the entries of a ZIP archive. Entries stored
+// without compression are read directly from the archive so range
+// requests don't need to decompress or buffer the whole file.
+type ZipFileSystem struct {
+	fName  string
+	fp     *os.File
+	reader *zip.Reader
+	files  map[string]*zip.File
+}
+
+// OpenZipFileSystem opens a ZIP archive for serving.
+func OpenZipFileSystem(fName string) (*ZipFileSystem, error) {
+	fp, err := os.Open(fName)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	reader, err := zip.NewReader(fp, info.Size())
+	if err != nil {
+		fp.Close()
+		return nil, fmt.Errorf("%s, %s", fName, err)
+	}
+	z := &ZipFileSystem{fName: fName, fp: fp, reader: reader, files: map[string]*zip.File{}}
+	for _, f := range reader.File {
+		z.files[strings.TrimSuffix(f.Name, "/")] = f
+	}
+	return z, nil
+}
+
+// Close closes the underlying archive.
+func (z *ZipFileSystem) Close() error {
+	return z.fp.Close()
+}
+
+// zipFile is an http.File for a ZIP entry or directory.
+type zipFile struct {
+	io.ReadSeeker
+	info fs.FileInfo
+	dir  fs.ReadDirFile
+}
+
+// Close releases the directory handle or decompressor if there is one.
+func (f *zipFile) Close() error {
+	if f.dir != nil {
+		return f.dir.Close()
+	}
+	if c, ok := f.ReadSeeker.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// zipStream reads a compressed entry too large to buffer. Seeking
+// forward decompresses and discards, seeking back starts over.
+type zipStream struct {
+	zf   *zip.File
+	rc   io.ReadCloser
+	size int64
+	// pos is the decompressor's position, off the next read's.
+	pos int64
+	off int64
+}
+
+func (s *zipStream) Read(p []byte) (int, error) {
+	if s.off >= s.size {
+		return 0, io.EOF
+	}
+	if s.rc == nil || s.off < s.pos {
+		if s.rc != nil {
+			s.rc.Close()
+		}
+		rc, err := s.zf.Open()
+		if err != nil {
+			s.rc = nil
+			return 0, err
+		}
+		s.rc, s.pos = rc, 0
+	}
+	if s.off > s.pos {
+		n, err := io.CopyN(io.Discard, s.rc, s.off-s.pos)
+		s.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.rc.Read(p)
+	s.pos += int64(n)
+	s.off = s.pos
+	return n, err
+}
+
+func (s *zipStream) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.off
+	case io.SeekEnd:
+		offset += s.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("%s, negative position", s.zf.Name)
+	}
+	s.off = offset
+	return offset, nil
+}
+
+func (s *zipStream) Close() error {
+	if s.rc != nil {
+		return s.rc.Close()
+	}
+	return nil
+}
+
+// Stat returns the entry's info.
+func (f *zipFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Readdir lists a directory entry.
+func (f *zipFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if f.dir == nil {
+		return nil, fmt.Errorf("%s is not a directory", f.info.Name())
+	}
+	entries, err := f.dir.ReadDir(count)
+	infoList := []fs.FileInfo{}
+	for _, entry := range entries {
+		if info, iErr := entry.Info(); iErr == nil {
+			infoList = append(infoList, info)
+		}
+	}
+	return infoList, err
+}
+
+// Open implements http.FileSystem.
+func (z *ZipFileSystem) Open(name string) (http.File, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	info, err := fs.Stat(z.reader, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		f, err := z.reader.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		dir, _ := f.(fs.ReadDirFile)
+		return &zipFile{ReadSeeker: bytes.NewReader(nil), info: info, dir: dir}, nil
+	}
+	zf, ok := z.files[name]
+	if ok == false {
+		return nil, os.ErrNotExist
+	}
+	if zf.Method == zip.Store {
+		offset, err := zf.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		return &zipFile{ReadSeeker: io.NewSectionReader(z.fp, offset, int64(zf.UncompressedSize64)), info: info}, nil
+	}
+	if int64(zf.UncompressedSize64) > ZipBufferMaxBytes {
+		return &zipFile{ReadSeeker: &zipStream{zf: zf, size: int64(zf.UncompressedSize64)}, info: info}, nil
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	// archive/zip fails entries longer than their header's size.
+	src, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return &zipFile{ReadSeeker: bytes.NewReader(src), info: info}, nil
+}
+
+// MakeZipFileSystem opens a ZIP archive and returns it as a
+// SafeFileSystem so dot paths inside the archive stay hidden.
+func MakeZipFileSystem(fName string) (SafeFileSystem, error) {
+	z, err := OpenZipFileSystem(fName)
+	if err != nil {
+		return SafeFileSystem{}, err
+	}
+	return SafeFileSystem{z}, nil
+}
@@ -0,0 +1,122 @@
+// zipfs_test.go test routines for zipfs.go
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2023, Caltech
+// All rights not granted herein are expressly reserved by Caltech
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wsfn
+
+import (
+	"archive/zip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestZipFileSystem(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "site.zip")
+	fp, err := os.Create(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(fp)
+	entries := []struct {
+		name   string
+		method uint16
+		body   string
+	}{
+		{"index.html", zip.Deflate, "<h1>Snapshot</h1>"},
+		{"data/records.csv", zip.Store, "id,title\n1,Caltech\n"},
+		{".htaccess", zip.Store, "deny from all"},
+		{"data/large.txt", zip.Deflate, strings.Repeat("0123456789", 1000)},
+	}
+	for _, e := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.body))
+	}
+	zw.Close()
+	fp.Close()
+
+	zfs, err := MakeZipFileSystem(fName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.FileServer(zfs)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "<h1>Snapshot</h1>" {
+		t.Errorf("unexpected index %d %q", rec.Code, rec.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/data/records.csv", nil)
+	req.Header.Set("Range", "bytes=9-17")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "1,Caltech" {
+		t.Errorf("unexpected range response %d %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/.htaccess", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a dot path, got %d", rec.Code)
+	}
+
+	// Entries over ZipBufferMaxBytes are decompressed as they're read.
+	defer func(limit int64) { ZipBufferMaxBytes = limit }(ZipBufferMaxBytes)
+	ZipBufferMaxBytes = 100
+	f, err := zfs.Open("/data/large.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.(*zipFile).ReadSeeker.(*zipStream); ok == false {
+		t.Errorf("expected a streamed entry")
+	}
+	buf := make([]byte, 5)
+	for _, offset := range []int64{5005, 10} {
+		f.Seek(offset, io.SeekStart)
+		if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "0123456789"[offset%10:offset%10+5] {
+			t.Errorf("unexpected read at %d, %q %v", offset, buf, err)
+		}
+	}
+	f.Close()
+	for _, r := range []struct{ header, body string }{
+		{"bytes=5005-5009", "56789"},
+		{"bytes=9995-", "56789"},
+	} {
+		req := httptest.NewRequest("GET", "/data/large.txt", nil)
+		req.Header.Set("Range", r.header)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusPartialContent || rec.Body.String() != r.body {
+			t.Errorf("Range %s, unexpected response %d %q", r.header, rec.Code, rec.Body.String())
+		}
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/data/large.txt", nil))
+	if rec.Body.String() != strings.Repeat("0123456789", 1000) {
+		t.Errorf("expected the whole entry, got %d bytes", rec.Body.Len())
+	}
+}